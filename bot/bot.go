@@ -0,0 +1,327 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	controller "github.com/xvv6u577/logv2fs/controllers"
+	helper "github.com/xvv6u577/logv2fs/helpers"
+	"github.com/xvv6u577/logv2fs/notify"
+)
+
+// 长轮询等待时间（秒）
+const pollTimeout = 30
+
+// 查询缴费记录时返回的条数
+const paymentLimit = 5
+
+const helpText = `可用命令：
+/link <绑定码> - 绑定账号，绑定码在用户面板生成
+/usage - 查询流量使用情况和到期时间
+/sub - 获取订阅链接
+/payments - 查询最近的缴费记录
+/unlink - 解除绑定
+
+管理员命令：
+/disable <用户> - 停用用户
+/enable <用户> - 启用用户`
+
+// Bot 通过 Telegram Bot API 长轮询接收消息并回复
+type Bot struct {
+	api *notify.Telegram
+	// 订阅链接的前缀，例如 https://sub.example.com
+	subscriptionURL string
+	offset          int64
+}
+
+type update struct {
+	UpdateID int64    `json:"update_id"`
+	Message  *message `json:"message"`
+}
+
+type message struct {
+	Chat chat   `json:"chat"`
+	Text string `json:"text"`
+}
+
+type chat struct {
+	ID   int64  `json:"id"`
+	Type string `json:"type"`
+}
+
+type updatesResponse struct {
+	OK          bool     `json:"ok"`
+	Description string   `json:"description"`
+	Result      []update `json:"result"`
+}
+
+// New 创建 Bot，apiBase 为空时使用官方 Bot API 地址
+func New(token, apiBase, subscriptionURL string) *Bot {
+	return &Bot{
+		api:             &notify.Telegram{Token: token, APIBase: apiBase},
+		subscriptionURL: strings.TrimRight(subscriptionURL, "/"),
+	}
+}
+
+// Run 持续拉取消息直到 ctx 结束
+func (b *Bot) Run(ctx context.Context) error {
+	log.Printf("Telegram bot 已启动")
+	for {
+		updates, err := b.getUpdates(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			log.Printf("拉取 Telegram 消息失败: %v", err)
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(5 * time.Second):
+			}
+			continue
+		}
+
+		for _, u := range updates {
+			b.offset = u.UpdateID + 1
+			if u.Message == nil || u.Message.Text == "" {
+				continue
+			}
+			b.handle(ctx, *u.Message)
+		}
+	}
+}
+
+func (b *Bot) getUpdates(ctx context.Context) ([]update, error) {
+	ctx, cancel := context.WithTimeout(ctx, (pollTimeout+10)*time.Second)
+	defer cancel()
+
+	var resp updatesResponse
+	err := b.api.Call(ctx, "getUpdates", map[string]interface{}{
+		"offset":          b.offset,
+		"timeout":         pollTimeout,
+		"allowed_updates": []string{"message"},
+	}, &resp)
+	return resp.Result, err
+}
+
+func (b *Bot) reply(ctx context.Context, chatID, text string) {
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+
+	if err := b.api.SendMessage(ctx, chatID, text); err != nil {
+		log.Printf("回复 Telegram chat %s 失败: %v", chatID, err)
+	}
+}
+
+// handle 解析命令并回复，只处理私聊消息
+func (b *Bot) handle(ctx context.Context, msg message) {
+	chatID := strconv.FormatInt(msg.Chat.ID, 10)
+
+	fields := strings.Fields(msg.Text)
+	if msg.Chat.Type != "private" {
+		// 群组中只回应命令，避免打扰普通聊天
+		if len(fields) > 0 && strings.HasPrefix(fields[0], "/") {
+			b.reply(ctx, chatID, "请在私聊中使用")
+		}
+		return
+	}
+	if len(fields) == 0 || !strings.HasPrefix(fields[0], "/") {
+		b.reply(ctx, chatID, helpText)
+		return
+	}
+	// 命令可能带有 @botname 后缀
+	command, _, _ := strings.Cut(fields[0], "@")
+	args := fields[1:]
+
+	var text string
+	switch command {
+	case "/start":
+		// t.me/<bot>?start=<绑定码> 打开时会带上绑定码
+		if len(args) > 0 {
+			text = b.link(chatID, args[0])
+		} else {
+			text = helpText
+		}
+	case "/link":
+		if len(args) == 0 {
+			text = "用法：/link <绑定码>"
+		} else {
+			text = b.link(chatID, args[0])
+		}
+	case "/unlink":
+		text = b.unlink(chatID)
+	case "/usage":
+		text = b.usage(chatID)
+	case "/sub":
+		text = b.subscription(chatID)
+	case "/payments":
+		text = b.payments(chatID)
+	case "/disable":
+		text = b.setStatus(chatID, args, "deleted")
+	case "/enable":
+		text = b.setStatus(chatID, args, "plain")
+	default:
+		text = helpText
+	}
+
+	b.reply(ctx, chatID, text)
+}
+
+func (b *Bot) link(chatID, code string) string {
+	account, err := controller.LinkTelegramChat(strings.ToUpper(code), chatID)
+	if err != nil {
+		return errorText(err)
+	}
+	return fmt.Sprintf("已绑定账号 %s\n\n%s", displayName(account), helpText)
+}
+
+func (b *Bot) unlink(chatID string) string {
+	if err := controller.UnlinkTelegramChat(chatID); err != nil {
+		return errorText(err)
+	}
+	return "已解除绑定"
+}
+
+func (b *Bot) usage(chatID string) string {
+	account, err := controller.FindTelegramAccount(chatID)
+	if err != nil {
+		return errorText(err)
+	}
+
+	lines := []string{
+		"账号: " + displayName(account),
+		"状态: " + statusText(account.Status),
+	}
+	if account.Credit > 0 {
+		remaining := account.Credit - account.Used
+		if remaining < 0 {
+			remaining = 0
+		}
+		lines = append(lines, fmt.Sprintf("已用流量: %s / %s（剩余 %s）",
+			helper.FormatBytes(account.Used), helper.FormatBytes(account.Credit), helper.FormatBytes(remaining)))
+	} else {
+		lines = append(lines, fmt.Sprintf("已用流量: %s（不限流量）", helper.FormatBytes(account.Used)))
+	}
+	lines = append(lines, expireText(account.Expire))
+
+	return strings.Join(lines, "\n")
+}
+
+func (b *Bot) subscription(chatID string) string {
+	account, err := controller.FindTelegramAccount(chatID)
+	if err != nil {
+		return errorText(err)
+	}
+
+	token, err := controller.EnsureSubscriptionToken(account)
+	if err != nil {
+		return errorText(err)
+	}
+
+	if b.subscriptionURL == "" {
+		return "订阅令牌: " + token + "\n请在用户面板复制完整的订阅链接"
+	}
+	return strings.Join([]string{
+		"通用订阅（自动识别客户端）:\n" + b.subscriptionURL + "/sub/" + token,
+		"Shadowrocket:\n" + b.subscriptionURL + "/static/" + token,
+		"sing-box:\n" + b.subscriptionURL + "/singbox/" + token,
+		"Clash Verge:\n" + b.subscriptionURL + "/verge/" + token,
+		"请勿分享订阅链接，泄露后可在用户面板重置",
+	}, "\n\n")
+}
+
+func (b *Bot) payments(chatID string) string {
+	account, err := controller.FindTelegramAccount(chatID)
+	if err != nil {
+		return errorText(err)
+	}
+
+	payments, err := controller.RecentPayments(account.EmailAsId, paymentLimit)
+	if err != nil {
+		return errorText(err)
+	}
+	if len(payments) == 0 {
+		return "没有缴费记录"
+	}
+
+	lines := []string{expireText(account.Expire), "", "最近的缴费记录:"}
+	for _, payment := range payments {
+		lines = append(lines, fmt.Sprintf("%s 至 %s  金额 %.2f",
+			payment.StartDate.Format("2006-01-02"), payment.EndDate.Format("2006-01-02"), payment.Amount))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// setStatus 管理员停用或启用用户，复用管理后台的逻辑并推送到节点
+func (b *Bot) setStatus(chatID string, args []string, status string) string {
+	account, err := controller.FindTelegramAccount(chatID)
+	if err != nil {
+		return errorText(err)
+	}
+	if account.Role != "admin" {
+		return "只有管理员可以使用此命令"
+	}
+	if len(args) == 0 {
+		return "用法：/disable <用户> 或 /enable <用户>"
+	}
+
+	name, err := controller.SetUserStatusByName(args[0], status)
+	if err != nil {
+		return errorText(err)
+	}
+
+	log.Printf("管理员 %s 通过 Telegram 将用户 %s 的状态设为 %s", account.EmailAsId, args[0], status)
+	if status == "plain" {
+		return "已启用用户 " + name
+	}
+	return "已停用用户 " + name
+}
+
+func displayName(account controller.TelegramAccount) string {
+	if account.Name == "" || account.Name == account.EmailAsId {
+		return account.EmailAsId
+	}
+	return fmt.Sprintf("%s (%s)", account.Name, account.EmailAsId)
+}
+
+func statusText(status string) string {
+	switch status {
+	case "plain":
+		return "正常"
+	case "overdue":
+		return "已暂停（缴费到期或流量用完）"
+	case "deleted":
+		return "已停用"
+	}
+	return status
+}
+
+func expireText(expire int64) string {
+	if expire <= 0 {
+		return "服务到期: 没有缴费记录"
+	}
+
+	end := time.Unix(expire, 0)
+	days := int(time.Until(end).Hours() / 24)
+	if days < 0 {
+		return fmt.Sprintf("服务到期: %s（已到期）", end.Format("2006-01-02"))
+	}
+	return fmt.Sprintf("服务到期: %s（剩余 %d 天）", end.Format("2006-01-02"), days)
+}
+
+// errorText 回复给用户的错误提示，非预期的错误同时记录日志
+func errorText(err error) string {
+	switch {
+	case errors.Is(err, controller.ErrTelegramNotLinked):
+		return err.Error() + "，请先在用户面板生成绑定码，再发送 /link <绑定码>"
+	case errors.Is(err, controller.ErrTelegramLinkCode):
+		return err.Error()
+	}
+	log.Printf("Telegram bot 处理失败: %v", err)
+	return "操作失败: " + err.Error()
+}
@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/spf13/cobra"
+	"github.com/xvv6u577/logv2fs/bot"
+)
+
+// botCmd 启动 Telegram bot
+var botCmd = &cobra.Command{
+	Use:   "bot",
+	Short: "启动 Telegram bot，供用户查询流量、订阅链接和缴费记录",
+	Long: `启动 Telegram bot，通过长轮询接收消息。
+
+用户在面板生成一次性绑定码后，向 bot 发送 /link <绑定码> 绑定账号，
+之后可以查询流量、订阅链接和缴费记录。管理员账号可以停用、启用用户。
+
+环境变量:
+  TELEGRAM_BOT_TOKEN      Bot token（必填）
+  TELEGRAM_API_BASE       Bot API 地址，默认 https://api.telegram.org
+  SUBSCRIPTION_BASE_URL   订阅链接前缀，例如 https://sub.example.com
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		token := os.Getenv("TELEGRAM_BOT_TOKEN")
+		if token == "" {
+			log.Fatalln("未设置 TELEGRAM_BOT_TOKEN")
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		b := bot.New(token, os.Getenv("TELEGRAM_API_BASE"), os.Getenv("SUBSCRIPTION_BASE_URL"))
+		if err := b.Run(ctx); err != nil && err != context.Canceled {
+			log.Fatalf("Telegram bot 退出: %v", err)
+		}
+		log.Println("Telegram bot 已停止")
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(botCmd)
+}
@@ -11,7 +11,11 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/spf13/cobra"
+	controller "github.com/xvv6u577/logv2fs/controllers"
+	_cron "github.com/xvv6u577/logv2fs/cron"
 	"github.com/xvv6u577/logv2fs/middleware"
+	"github.com/xvv6u577/logv2fs/notify"
+	"github.com/xvv6u577/logv2fs/notify/recipient"
 	routers "github.com/xvv6u577/logv2fs/routers"
 	"github.com/xvv6u577/logv2fs/websocket"
 )
@@ -50,6 +54,16 @@ var httpserverCmd = &cobra.Command{
 			websocket.HandleWebSocket(c.Writer, c.Request)
 		})
 
+		// 通知用户时同时发送到绑定的 Telegram
+		notify.SetUserResolver(recipient.Resolve)
+
+		// 缴费到期的用户设为 overdue 后通知节点移除
+		_cron.Cron_paymentExpiryJobs(cronInstance, controller.RemoveUsersFromNodes)
+		// 定期检查域名证书并保存结果
+		_cron.Cron_certExpiryJobs(cronInstance)
+		// 定期探测节点的可用性，订阅可按 NODE_HEALTH_POLICY 降级或去掉不可用的节点
+		_cron.Cron_nodeHealthJobs(cronInstance)
+
 		routers.PublicRoutes(router)
 		routers.AuthorizedRoutes(router)
 
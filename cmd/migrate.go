@@ -122,6 +122,11 @@ func migrateSchema() error {
 		log.Printf("⚠️  启用PostgreSQL扩展失败: %v", err)
 	}
 
+	// 节点类型的检查约束只在不存在时创建，先删除旧约束以便加入新的节点类型
+	if err := db.Exec("ALTER TABLE IF EXISTS subscription_nodes DROP CONSTRAINT IF EXISTS chk_subscription_nodes_type").Error; err != nil {
+		log.Printf("⚠️  删除节点类型约束失败: %v", err)
+	}
+
 	// 自动迁移表结构
 	err = db.AutoMigrate(
 		&model.NodeTrafficLogsPG{},
@@ -130,6 +135,14 @@ func migrateSchema() error {
 		&model.SubscriptionNodePG{},
 		&model.PaymentRecordPG{},          // 新增：缴费记录表
 		&model.DailyPaymentAllocationPG{}, // 新增：每日费用分摊表
+		&model.NodeUserSetPG{},            // 新增：节点用户集合表
+		&model.TrafficSampleAckPG{},       // 新增：流量样本去重表
+		&model.TrafficSamplePG{},          // 新增：流量时间序列表
+		&model.NodePlanPG{},               // 新增：节点套餐表
+		&model.NodeInventoryVersionPG{},   // 新增：节点列表历史版本表
+		&model.NodeHealthPG{},             // 新增：节点健康状态表
+		&model.NodeHealthSamplePG{},       // 新增：节点探测记录表
+		&model.NodeHeartbeatPG{},          // 新增：节点心跳表
 	)
 	if err != nil {
 		return fmt.Errorf("自动迁移失败: %v", err)
@@ -141,6 +154,12 @@ func migrateSchema() error {
 		return fmt.Errorf("创建索引失败: %v", err)
 	}
 
+	// 创建流量时间序列的汇总视图
+	err = createTrafficSampleViews(db)
+	if err != nil {
+		return fmt.Errorf("创建流量汇总视图失败: %v", err)
+	}
+
 	log.Println("✅ PostgreSQL表结构创建完成")
 	return nil
 }
@@ -242,6 +261,10 @@ func migrateExpiryCheckDomainsData(batchSize int, skipExisting bool, stats *mode
 			Remark:       mongoDomain.Remark,
 			ExpiredDate:  mongoDomain.ExpiredDate,
 			DaysToExpire: mongoDomain.DaysToExpire,
+			Reachable:    mongoDomain.Reachable,
+			LastError:    mongoDomain.LastError,
+			AlertState:   mongoDomain.AlertState,
+			CheckedAt:    mongoDomain.CheckedAt,
 			CreatedAt:    time.Now(),
 			UpdatedAt:    time.Now(),
 		}
@@ -252,6 +275,10 @@ func migrateExpiryCheckDomainsData(batchSize int, skipExisting bool, stats *mode
 				"remark":         pgDomain.Remark,
 				"expired_date":   pgDomain.ExpiredDate,
 				"days_to_expire": pgDomain.DaysToExpire,
+				"reachable":      pgDomain.Reachable,
+				"last_error":     pgDomain.LastError,
+				"alert_state":    pgDomain.AlertState,
+				"checked_at":     pgDomain.CheckedAt,
 				"updated_at":     pgDomain.UpdatedAt,
 			}).Error; err != nil {
 				stats.Errors = append(stats.Errors, fmt.Sprintf("更新PostgreSQL ExpiryCheckDomain失败: %v", err))
@@ -318,38 +345,50 @@ func migrateSubscriptionNodesData(batchSize int, skipExisting bool, stats *model
 
 		// 创建PostgreSQL记录
 		pgNode := model.SubscriptionNodePG{
-			ID:           uuid.New(),
-			Type:         mongoNode.Type,
-			Remark:       mongoNode.Remark,
-			Domain:       mongoNode.Domain,
-			IP:           mongoNode.IP,
-			SNI:          mongoNode.SNI,
-			UUID:         mongoNode.UUID,
-			Path:         mongoNode.PATH,
-			ServerPort:   mongoNode.SERVER_PORT,
-			Password:     mongoNode.PASSWORD,
-			PublicKey:    mongoNode.PUBLIC_KEY,
-			ShortID:      mongoNode.SHORT_ID,
-			EnableOpenai: mongoNode.EnableOpenai,
-			CreatedAt:    time.Now(),
-			UpdatedAt:    time.Now(),
+			ID:               uuid.New(),
+			Type:             mongoNode.Type,
+			Remark:           mongoNode.Remark,
+			Domain:           mongoNode.Domain,
+			IP:               mongoNode.IP,
+			SNI:              mongoNode.SNI,
+			UUID:             mongoNode.UUID,
+			Path:             mongoNode.PATH,
+			ServerPort:       mongoNode.SERVER_PORT,
+			Password:         mongoNode.PASSWORD,
+			PublicKey:        mongoNode.PUBLIC_KEY,
+			ShortID:          mongoNode.SHORT_ID,
+			PendingPublicKey: mongoNode.PendingPublicKey,
+			PendingShortID:   mongoNode.PendingShortID,
+			EnableOpenai:     mongoNode.EnableOpenai,
+			Region:           mongoNode.Region,
+			Position:         mongoNode.Position,
+			Fingerprint:      mongoNode.Fingerprint,
+			VerifyCert:       mongoNode.VerifyCert,
+			CreatedAt:        time.Now(),
+			UpdatedAt:        time.Now(),
 		}
 
 		// 插入或更新记录
 		if existingCount > 0 {
 			if err := postgresDB.Model(&model.SubscriptionNodePG{}).Where("remark = ?", mongoNode.Remark).Updates(map[string]interface{}{
-				"type":          pgNode.Type,
-				"domain":        pgNode.Domain,
-				"ip":            pgNode.IP,
-				"sni":           pgNode.SNI,
-				"uuid":          pgNode.UUID,
-				"path":          pgNode.Path,
-				"server_port":   pgNode.ServerPort,
-				"password":      pgNode.Password,
-				"public_key":    pgNode.PublicKey,
-				"short_id":      pgNode.ShortID,
-				"enable_openai": pgNode.EnableOpenai,
-				"updated_at":    pgNode.UpdatedAt,
+				"type":               pgNode.Type,
+				"domain":             pgNode.Domain,
+				"ip":                 pgNode.IP,
+				"sni":                pgNode.SNI,
+				"uuid":               pgNode.UUID,
+				"path":               pgNode.Path,
+				"server_port":        pgNode.ServerPort,
+				"password":           pgNode.Password,
+				"public_key":         pgNode.PublicKey,
+				"short_id":           pgNode.ShortID,
+				"pending_public_key": pgNode.PendingPublicKey,
+				"pending_short_id":   pgNode.PendingShortID,
+				"enable_openai":      pgNode.EnableOpenai,
+				"region":             pgNode.Region,
+				"position":           pgNode.Position,
+				"fingerprint":        pgNode.Fingerprint,
+				"verify_cert":        pgNode.VerifyCert,
+				"updated_at":         pgNode.UpdatedAt,
 			}).Error; err != nil {
 				stats.Errors = append(stats.Errors, fmt.Sprintf("更新PostgreSQL SubscriptionNode失败: %v", err))
 				continue
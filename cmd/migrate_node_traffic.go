@@ -5,7 +5,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
-	"time"
 
 	"github.com/google/uuid"
 	"github.com/xvv6u577/logv2fs/database"
@@ -200,16 +199,14 @@ func linkDomainToNodeTrafficLogs(pgNodeLog *model.NodeTrafficLogsPG, db *gorm.DB
 }
 
 // convertNodeHourlyLogsToJSON 转换节点小时级别日志为JSON
-func convertNodeHourlyLogsToJSON(hourlyLogs []struct {
-	Timestamp time.Time `json:"timestamp" bson:"timestamp"`
-	Traffic   int64     `json:"traffic" bson:"traffic"`
-}) (datatypes.JSON, error) {
+func convertNodeHourlyLogsToJSON(hourlyLogs []model.TrafficLogEntry) (datatypes.JSON, error) {
 	var logs []model.TrafficLogEntry
 
 	for _, log := range hourlyLogs {
 		logs = append(logs, model.TrafficLogEntry{
-			Timestamp: log.Timestamp,
-			Traffic:   log.Traffic,
+			Timestamp:        log.Timestamp,
+			Traffic:          log.Traffic,
+			TrafficBreakdown: log.TrafficBreakdown,
 		})
 	}
 
@@ -222,16 +219,14 @@ func convertNodeHourlyLogsToJSON(hourlyLogs []struct {
 }
 
 // convertNodeDailyLogsToJSON 转换节点日级别日志为JSON
-func convertNodeDailyLogsToJSON(dailyLogs []struct {
-	Date    string `json:"date" bson:"date"`
-	Traffic int64  `json:"traffic" bson:"traffic"`
-}) (datatypes.JSON, error) {
+func convertNodeDailyLogsToJSON(dailyLogs []model.DailyLogEntry) (datatypes.JSON, error) {
 	var logs []model.DailyLogEntry
 
 	for _, log := range dailyLogs {
 		logs = append(logs, model.DailyLogEntry{
-			Date:    log.Date,
-			Traffic: log.Traffic,
+			Date:             log.Date,
+			Traffic:          log.Traffic,
+			TrafficBreakdown: log.TrafficBreakdown,
 		})
 	}
 
@@ -244,16 +239,14 @@ func convertNodeDailyLogsToJSON(dailyLogs []struct {
 }
 
 // convertNodeMonthlyLogsToJSON 转换节点月级别日志为JSON
-func convertNodeMonthlyLogsToJSON(monthlyLogs []struct {
-	Month   string `json:"month" bson:"month"`
-	Traffic int64  `json:"traffic" bson:"traffic"`
-}) (datatypes.JSON, error) {
+func convertNodeMonthlyLogsToJSON(monthlyLogs []model.MonthlyLogEntry) (datatypes.JSON, error) {
 	var logs []model.MonthlyLogEntry
 
 	for _, log := range monthlyLogs {
 		logs = append(logs, model.MonthlyLogEntry{
-			Month:   log.Month,
-			Traffic: log.Traffic,
+			Month:            log.Month,
+			Traffic:          log.Traffic,
+			TrafficBreakdown: log.TrafficBreakdown,
 		})
 	}
 
@@ -266,16 +259,14 @@ func convertNodeMonthlyLogsToJSON(monthlyLogs []struct {
 }
 
 // convertNodeYearlyLogsToJSON 转换节点年级别日志为JSON
-func convertNodeYearlyLogsToJSON(yearlyLogs []struct {
-	Year    string `json:"year" bson:"year"`
-	Traffic int64  `json:"traffic" bson:"traffic"`
-}) (datatypes.JSON, error) {
+func convertNodeYearlyLogsToJSON(yearlyLogs []model.YearlyLogEntry) (datatypes.JSON, error) {
 	var logs []model.YearlyLogEntry
 
 	for _, log := range yearlyLogs {
 		logs = append(logs, model.YearlyLogEntry{
-			Year:    log.Year,
-			Traffic: log.Traffic,
+			Year:             log.Year,
+			Traffic:          log.Traffic,
+			TrafficBreakdown: log.TrafficBreakdown,
 		})
 	}
 
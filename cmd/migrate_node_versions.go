@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/xvv6u577/logv2fs/database"
+	"github.com/xvv6u577/logv2fs/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// migrateNodeVersionsCmd 为节点列表历史版本号创建唯一索引
+var migrateNodeVersionsCmd = &cobra.Command{
+	Use:   "node-versions",
+	Short: "为节点列表历史版本号创建唯一索引",
+	Long: `为 NODE_INVENTORY_VERSIONS.version 创建唯一索引 idx_node_inventory_version。
+并发修改节点列表时，两个事务写入同一个版本号会发生冲突，后提交的事务读取最新列表后重试，
+因此修改节点前必须先创建该索引。PostgreSQL 的 node_inventory_versions.version 由 AutoMigrate 创建唯一索引，无需执行。
+
+使用示例:
+  ./logv2fs migrate node-versions
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if database.IsUsingPostgres() {
+			log.Println("PostgreSQL 由 AutoMigrate 创建 node_inventory_versions.version 唯一索引，无需执行")
+			return
+		}
+
+		if err := createNodeVersionIndexMongo(); err != nil {
+			log.Fatalf("创建节点列表版本索引失败: %v", err)
+		}
+		log.Println("✅ NODE_INVENTORY_VERSIONS.version 唯一索引已创建")
+	},
+}
+
+func init() {
+	migrateCmd.AddCommand(migrateNodeVersionsCmd)
+}
+
+// createNodeVersionIndexMongo 创建 version 的唯一索引。已有重复版本号时创建失败，需要先删除重复的记录
+func createNodeVersionIndexMongo() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	index := mongo.IndexModel{
+		Keys:    bson.D{{Key: "version", Value: 1}},
+		Options: options.Index().SetName("idx_node_inventory_version").SetUnique(true),
+	}
+
+	if _, err := database.GetCollection(model.NodeInventoryVersion{}).Indexes().CreateOne(ctx, index); err != nil {
+		return fmt.Errorf("failed to create version index: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/xvv6u577/logv2fs/database"
+	"github.com/xvv6u577/logv2fs/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// migrateSubscriptionTokenCmd 为订阅令牌创建唯一索引
+var migrateSubscriptionTokenCmd = &cobra.Command{
+	Use:   "subscription-token",
+	Short: "为用户的订阅令牌创建唯一索引",
+	Long: `为 subscription_token 创建唯一索引，保证一个令牌只对应一个用户。
+MongoDB 创建稀疏唯一索引 idx_subscription_token，还没有令牌的老用户不受影响；
+PostgreSQL 创建 user_traffic_logs.subscription_token 上的唯一索引。
+
+使用示例:
+  ./logv2fs migrate subscription-token
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if database.IsUsingPostgres() {
+			db := database.GetPostgresDB()
+			if db == nil {
+				log.Fatalf("PostgreSQL 连接不可用")
+			}
+			if !db.Migrator().HasIndex(&model.UserTrafficLogsPG{}, "SubscriptionToken") {
+				if err := db.Migrator().CreateIndex(&model.UserTrafficLogsPG{}, "SubscriptionToken"); err != nil {
+					log.Fatalf("创建订阅令牌索引失败: %v", err)
+				}
+			}
+			log.Println("✅ user_traffic_logs.subscription_token 唯一索引已创建")
+			return
+		}
+
+		if err := createSubscriptionTokenIndexMongo(); err != nil {
+			log.Fatalf("创建订阅令牌索引失败: %v", err)
+		}
+		log.Println("✅ USER_TRAFFIC_LOGS.subscription_token 唯一索引已创建")
+	},
+}
+
+func init() {
+	migrateCmd.AddCommand(migrateSubscriptionTokenCmd)
+}
+
+// createSubscriptionTokenIndexMongo 创建 subscription_token 的稀疏唯一索引。
+// 令牌字段使用 omitempty，没有令牌的用户不写入该字段，不会因为空值冲突。
+func createSubscriptionTokenIndexMongo() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	index := mongo.IndexModel{
+		Keys:    bson.D{{Key: "subscription_token", Value: 1}},
+		Options: options.Index().SetName("idx_subscription_token").SetUnique(true).SetSparse(true),
+	}
+
+	if _, err := database.GetCollection(model.UserTrafficLogs{}).Indexes().CreateOne(ctx, index); err != nil {
+		return fmt.Errorf("failed to create subscription_token index: %v", err)
+	}
+	return nil
+}
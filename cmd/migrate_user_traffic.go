@@ -5,7 +5,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
-	"time"
 
 	"github.com/google/uuid"
 	"github.com/xvv6u577/logv2fs/database"
@@ -181,16 +180,14 @@ func convertUserTrafficLogsToPG(mongoUserLog model.UserTrafficLogs) (model.UserT
 }
 
 // convertUserHourlyLogsToJSON 转换用户小时级别日志为JSON
-func convertUserHourlyLogsToJSON(hourlyLogs []struct {
-	Timestamp time.Time `json:"timestamp" bson:"timestamp"`
-	Traffic   int64     `json:"traffic" bson:"traffic"`
-}) (datatypes.JSON, error) {
+func convertUserHourlyLogsToJSON(hourlyLogs []model.TrafficLogEntry) (datatypes.JSON, error) {
 	var logs []model.TrafficLogEntry
 
 	for _, log := range hourlyLogs {
 		logs = append(logs, model.TrafficLogEntry{
-			Timestamp: log.Timestamp,
-			Traffic:   log.Traffic,
+			Timestamp:        log.Timestamp,
+			Traffic:          log.Traffic,
+			TrafficBreakdown: log.TrafficBreakdown,
 		})
 	}
 
@@ -203,16 +200,14 @@ func convertUserHourlyLogsToJSON(hourlyLogs []struct {
 }
 
 // convertUserDailyLogsToJSON 转换用户日级别日志为JSON
-func convertUserDailyLogsToJSON(dailyLogs []struct {
-	Date    string `json:"date" bson:"date"`
-	Traffic int64  `json:"traffic" bson:"traffic"`
-}) (datatypes.JSON, error) {
+func convertUserDailyLogsToJSON(dailyLogs []model.DailyLogEntry) (datatypes.JSON, error) {
 	var logs []model.DailyLogEntry
 
 	for _, log := range dailyLogs {
 		logs = append(logs, model.DailyLogEntry{
-			Date:    log.Date,
-			Traffic: log.Traffic,
+			Date:             log.Date,
+			Traffic:          log.Traffic,
+			TrafficBreakdown: log.TrafficBreakdown,
 		})
 	}
 
@@ -225,16 +220,14 @@ func convertUserDailyLogsToJSON(dailyLogs []struct {
 }
 
 // convertUserMonthlyLogsToJSON 转换用户月级别日志为JSON
-func convertUserMonthlyLogsToJSON(monthlyLogs []struct {
-	Month   string `json:"month" bson:"month"`
-	Traffic int64  `json:"traffic" bson:"traffic"`
-}) (datatypes.JSON, error) {
+func convertUserMonthlyLogsToJSON(monthlyLogs []model.MonthlyLogEntry) (datatypes.JSON, error) {
 	var logs []model.MonthlyLogEntry
 
 	for _, log := range monthlyLogs {
 		logs = append(logs, model.MonthlyLogEntry{
-			Month:   log.Month,
-			Traffic: log.Traffic,
+			Month:            log.Month,
+			Traffic:          log.Traffic,
+			TrafficBreakdown: log.TrafficBreakdown,
 		})
 	}
 
@@ -247,16 +240,14 @@ func convertUserMonthlyLogsToJSON(monthlyLogs []struct {
 }
 
 // convertUserYearlyLogsToJSON 转换用户年级别日志为JSON
-func convertUserYearlyLogsToJSON(yearlyLogs []struct {
-	Year    string `json:"year" bson:"year"`
-	Traffic int64  `json:"traffic" bson:"traffic"`
-}) (datatypes.JSON, error) {
+func convertUserYearlyLogsToJSON(yearlyLogs []model.YearlyLogEntry) (datatypes.JSON, error) {
 	var logs []model.YearlyLogEntry
 
 	for _, log := range yearlyLogs {
 		logs = append(logs, model.YearlyLogEntry{
-			Year:    log.Year,
-			Traffic: log.Traffic,
+			Year:             log.Year,
+			Traffic:          log.Traffic,
+			TrafficBreakdown: log.TrafficBreakdown,
 		})
 	}
 
@@ -13,9 +13,13 @@ import (
 	"github.com/robfig/cron"
 	"github.com/spf13/cobra"
 	_cron "github.com/xvv6u577/logv2fs/cron"
+	_grpc "github.com/xvv6u577/logv2fs/grpc"
+	"github.com/xvv6u577/logv2fs/notify"
+	"github.com/xvv6u577/logv2fs/notify/recipient"
 	thirdparty "github.com/xvv6u577/logv2fs/pkg"
 
 	box "github.com/sagernet/sing-box"
+	"github.com/sagernet/sing/service"
 )
 
 var (
@@ -41,19 +45,27 @@ var singboxCmd = &cobra.Command{
 		}
 		log.SetOutput(logFile)
 
+		// 流量预警同时发送到用户绑定的 Telegram
+		notify.SetUserResolver(recipient.Resolve)
+
 		go func() {
 			var instance *box.Box
 			ctx, cancel := context.WithCancel(context.Background())
+			// 用户管理器重建 inbound 时需要使用与实例相同的服务注册表
+			ctx = service.ContextWithDefaultRegistry(ctx)
 
-			options, err := thirdparty.InitOptionsFromConfig(configFile)
+			template, err := thirdparty.InitOptionsFromConfig(configFile)
 			if err != nil {
 				log.Fatal("error initializing options from config: ", err)
 			}
 
-			options, err = thirdparty.UpdateOptionsFromDB(options)
+			users, err := thirdparty.LoadActiveUsers()
 			if err != nil {
 				log.Printf("error updating options from db: %v\n", err)
 			}
+			options := thirdparty.ApplyUsersToOptions(template, users)
+			// 通过 Clash API 统计活动连接，供控制面查看和关闭
+			options = thirdparty.EnableConnectionTracking(options)
 
 			instance, err = box.New(box.Options{
 				Context: ctx,
@@ -62,15 +74,45 @@ var singboxCmd = &cobra.Command{
 			if err != nil {
 				log.Fatalf("error initializing box instance: %v\n", err)
 			}
+			// 需要在启动前替换 Clash API 服务，才能记录每条连接所属的用户
+			connections, err := thirdparty.NewConnectionTracker(instance)
+			if err != nil {
+				log.Printf("连接统计未启用: %v", err)
+			}
 			err = instance.Start()
 			if err != nil {
 				log.Fatalf("error starting box instance: %v\n", err)
 			}
 
-			_cron.Cron_loggingJobs(cronInstance, instance)
+			// 用户管理器负责在运行期间热更新用户，无需重启节点
+			userManager := thirdparty.NewUserManager(ctx, instance, template, users)
+			_cron.ReportNodeUserSet(userManager)
+
+			// 控制面通过 gRPC 推送用户变更，未配置认证时只依赖定时同步
+			grpcServer, err := _grpc.Serve(userManager, instance, connections)
+			if err != nil {
+				log.Printf("gRPC 服务未启动: %v", err)
+			}
+
+			_cron.Cron_loggingJobs(cronInstance, instance, userManager)
+			_cron.Cron_userSyncJobs(cronInstance, userManager)
+			// 定期上报版本、运行时间、用户数和 inbound，控制面据此判断节点是否在线
+			_cron.Cron_heartbeatJobs(cronInstance, userManager, _cron.NodeBuildInfo{
+				Version:  Version,
+				Inbounds: thirdparty.InboundSummaries(options),
+			})
 			for {
 				osSignal := <-osSignals
+				if osSignal == syscall.SIGHUP {
+					// SIGHUP 触发一次立即同步
+					go _cron.SyncNodeUsers(userManager)
+					continue
+				}
 				if osSignal == syscall.SIGINT || osSignal == syscall.SIGTERM || osSignal == syscall.SIGTSTP {
+					if grpcServer != nil {
+						grpcServer.Stop()
+					}
+					userManager.Close()
 					instance.Close()
 					cronInstance.Stop()
 					cancel()
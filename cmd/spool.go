@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/spf13/cobra"
+	_cron "github.com/xvv6u577/logv2fs/cron"
+)
+
+// spoolCmd 查看和重放本地流量缓存
+var spoolCmd = &cobra.Command{
+	Use:   "spool",
+	Short: "查看或重放本地缓存的流量样本",
+	Long: `节点写入流量数据失败时，样本会缓存到本地文件（默认 ./logs/traffic_spool.jsonl，
+可通过 TRAFFIC_SPOOL_FILE 修改），singbox 进程在每次流量记录任务开始时自动重放。
+
+每个样本带有唯一 ID，数据库中记录已写入的样本，重复重放不会重复累加流量。
+
+使用示例:
+  # 查看待写入的样本
+  ./logv2fs spool
+
+  # 立即重放所有待写入的样本
+  ./logv2fs spool --flush
+
+  # 重放后压缩缓存文件（仅在 singbox 进程未运行时使用）
+  ./logv2fs spool --flush --compact
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		flush, _ := cmd.Flags().GetBool("flush")
+		compact, _ := cmd.Flags().GetBool("compact")
+
+		samples, err := _cron.PendingSamples()
+		if err != nil {
+			log.Fatalf("读取流量缓存 %s 失败: %v", _cron.SpoolFile(), err)
+		}
+
+		fmt.Printf("缓存文件: %s\n", _cron.SpoolFile())
+		fmt.Printf("待写入样本: %d\n", len(samples))
+		for _, sample := range samples {
+			fmt.Printf("  %s  %-4s  %-20s  %12d  缓存于 %s\n",
+				sample.Timestamp.Format("2006-01-02 15:04:05"), sample.Kind, sample.Traffic.Name,
+				sample.Traffic.Total, sample.SpooledAt.Format("2006-01-02 15:04:05"))
+		}
+
+		if !flush || len(samples) == 0 {
+			return
+		}
+
+		if compact {
+			log.Printf("⚠️ 压缩缓存文件时 singbox 进程不应同时写入缓存")
+		}
+
+		replayed, failed, err := _cron.ReplaySpool(compact)
+		if err != nil {
+			log.Fatalf("重放流量缓存失败: %v", err)
+		}
+		fmt.Printf("重放完成: 成功 %d, 失败 %d\n", replayed, failed)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(spoolCmd)
+
+	spoolCmd.Flags().BoolP("flush", "f", false, "立即重放待写入的样本")
+	spoolCmd.Flags().Bool("compact", false, "重放后只保留未写入的样本重写缓存文件")
+}
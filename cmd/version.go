@@ -6,9 +6,13 @@ package cmd
 import (
 	"fmt"
 
+	C "github.com/sagernet/sing-box/constant"
 	"github.com/spf13/cobra"
 )
 
+// Version 构建版本，通过 -ldflags "-X github.com/xvv6u577/logv2fs/cmd.Version=..." 设置，节点心跳会上报该值
+var Version = "dev"
+
 // versionCmd represents the version command
 var versionCmd = &cobra.Command{
 	Use:   "version",
@@ -20,7 +24,7 @@ Cobra is a CLI library for Go that empowers applications.
 This application is a tool to generate the needed files
 to quickly create a Cobra application.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		fmt.Println("version called：2025-06-19 22:39:47")
+		fmt.Printf("logv2fs %s (sing-box %s)\n", Version, C.Version)
 	},
 }
 
@@ -0,0 +1,342 @@
+package controllers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	helper "github.com/xvv6u577/logv2fs/helpers"
+	"github.com/xvv6u577/logv2fs/model"
+	"github.com/xvv6u577/logv2fs/subscription"
+	"gopkg.in/yaml.v2"
+)
+
+// 规则集配置文件路径，默认 config/clash_rule_providers.yaml
+var clashRuleProvidersFile = os.Getenv("CLASH_RULE_PROVIDERS_FILE")
+
+const (
+	clashSelectGroup      = subscription.SelectGroup
+	clashURLTestGroup     = subscription.URLTestGroup
+	clashFallbackGroup    = "故障转移"
+	clashLoadBalanceGroup = "负载均衡"
+
+	clashHealthCheckURL      = "https://www.gstatic.com/generate_204"
+	clashHealthCheckInterval = 300
+	clashURLTestTolerance    = 50
+)
+
+// clashMetaProxy 将节点转换为 Clash Meta 代理，不支持的类型返回 false
+func clashMetaProxy(node Domain, uuid, userID string) (interface{}, bool) {
+	port, _ := strconv.Atoi(node.SERVER_PORT)
+
+	switch node.Type {
+	case "reality":
+		proxy := RealityYAML{
+			Name:              node.Remark,
+			Type:              "vless",
+			Server:            helper.FormatIPForURL(node.IP),
+			Port:              port,
+			UUID:              uuid,
+			Network:           "tcp",
+			UDP:               true,
+			TLS:               true,
+			Flow:              "xtls-rprx-vision",
+			Servername:        node.TLSServerName(),
+			ClientFingerprint: node.ClientFingerprint(),
+		}
+		proxy.RealityOpts.PublicKey = node.PUBLIC_KEY
+		proxy.RealityOpts.ShortID = node.ClientShortID()
+		return proxy, true
+
+	case "hysteria2":
+		return Hysteria2YAML{
+			Name:           node.Remark,
+			Type:           "hysteria2",
+			Server:         helper.FormatIPForURL(node.IP),
+			Port:           port,
+			Password:       userID,
+			Sni:            node.TLSServerName(),
+			SkipCertVerify: node.SkipCertVerify(),
+			Alpn:           []string{"h3"},
+		}, true
+
+	case "vlessCDN":
+		proxy := CFVlessYAML{
+			Name:              node.Remark,
+			Type:              "vless",
+			Server:            helper.FormatIPForURL(node.IP),
+			Port:              port,
+			UUID:              node.UUID,
+			Network:           "ws",
+			TLS:               true,
+			UDP:               false,
+			Servername:        node.Domain,
+			ClientFingerprint: "chrome",
+		}
+		proxy.WsOpts.Path = node.PATH
+		proxy.WsOpts.Headers.Host = node.Domain
+		return proxy, true
+
+	case "tuic":
+		return TUICYAML{
+			Name:                 node.Remark,
+			Type:                 "tuic",
+			Server:               helper.FormatIPForURL(node.IP),
+			Port:                 port,
+			UUID:                 uuid,
+			Password:             userID,
+			Sni:                  node.TLSServerName(),
+			SkipCertVerify:       node.SkipCertVerify(),
+			Alpn:                 []string{"h3"},
+			CongestionController: "bbr",
+			UDPRelayMode:         "native",
+		}, true
+
+	case "trojan":
+		return TrojanYAML{
+			Name:     node.Remark,
+			Type:     "trojan",
+			Server:   helper.FormatIPForURL(node.IP),
+			Port:     port,
+			Password: userID,
+			Sni:      node.TLSServerName(),
+			UDP:      true,
+		}, true
+
+	case "ss2022":
+		method, password, ok := subscription.SS2022Credentials(node, uuid)
+		if !ok {
+			return nil, false
+		}
+		return ShadowsocksYAML{
+			Name:     node.Remark,
+			Type:     "ss",
+			Server:   helper.FormatIPForURL(node.IP),
+			Port:     port,
+			Cipher:   method,
+			Password: password,
+			UDP:      true,
+		}, true
+	}
+
+	return nil, false
+}
+
+// newClashTestGroup 生成带健康检查的代理组，groupType 为 url-test、fallback 或 load-balance
+func newClashTestGroup(name, groupType string, proxies []string) model.ClashProxyGroup {
+	group := model.ClashProxyGroup{
+		Name:     name,
+		Type:     groupType,
+		Proxies:  proxies,
+		URL:      clashHealthCheckURL,
+		Interval: clashHealthCheckInterval,
+		Lazy:     true,
+	}
+	switch groupType {
+	case "url-test":
+		group.Tolerance = clashURLTestTolerance
+	case "load-balance":
+		group.Strategy = "consistent-hashing"
+	}
+	return group
+}
+
+// clashMetaProxyGroups 生成全部节点和各地区的 url-test、fallback、load-balance 代理组，
+// 以及包含所有代理组和节点的"节点选择"
+func clashMetaProxyGroups(nodes []Domain, names []string) []model.ClashProxyGroup {
+	if len(names) == 0 {
+		names = []string{"DIRECT"}
+	}
+
+	var regions []string
+	regionNodes := map[string][]string{}
+	for _, node := range nodes {
+		region := node.RegionName()
+		if _, ok := regionNodes[region]; !ok {
+			regions = append(regions, region)
+		}
+		regionNodes[region] = append(regionNodes[region], node.Remark)
+	}
+
+	groups := []model.ClashProxyGroup{
+		newClashTestGroup(clashURLTestGroup, "url-test", names),
+		newClashTestGroup(clashFallbackGroup, "fallback", names),
+		newClashTestGroup(clashLoadBalanceGroup, "load-balance", names),
+	}
+	selectProxies := []string{clashURLTestGroup, clashFallbackGroup, clashLoadBalanceGroup}
+
+	for _, region := range regions {
+		for _, groupType := range []struct{ suffix, kind string }{
+			{"自动", "url-test"},
+			{"故障转移", "fallback"},
+			{"负载均衡", "load-balance"},
+		} {
+			name := region + " " + groupType.suffix
+			groups = append(groups, newClashTestGroup(name, groupType.kind, regionNodes[region]))
+			selectProxies = append(selectProxies, name)
+		}
+	}
+
+	selectProxies = append(selectProxies, names...)
+	if names[0] != "DIRECT" {
+		selectProxies = append(selectProxies, "DIRECT")
+	}
+
+	return append([]model.ClashProxyGroup{{Name: clashSelectGroup, Type: "select", Proxies: selectProxies}}, groups...)
+}
+
+// loadClashRuleProviders 读取规则集配置，生成 rule-providers 和对应的 RULE-SET 规则。
+// 配置文件不存在时不生成规则集。
+func loadClashRuleProviders() (map[string]model.ClashRuleProvider, []string, error) {
+	path := clashRuleProvidersFile
+	if path == "" {
+		path = helper.CurrentPath() + "/config/clash_rule_providers.yaml"
+	}
+
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var settings struct {
+		Providers []model.ClashRuleProviderSetting `yaml:"providers"`
+	}
+	if err := yaml.Unmarshal(content, &settings); err != nil {
+		return nil, nil, fmt.Errorf("解析规则集配置失败: %v", err)
+	}
+
+	providers := map[string]model.ClashRuleProvider{}
+	var rules []string
+	for _, setting := range settings.Providers {
+		if setting.Name == "" || setting.URL == "" {
+			return nil, nil, fmt.Errorf("规则集缺少 name 或 url")
+		}
+		if _, ok := providers[setting.Name]; ok {
+			return nil, nil, fmt.Errorf("规则集名称重复: %s", setting.Name)
+		}
+		switch setting.Behavior {
+		case "domain", "ipcidr", "classical":
+		default:
+			return nil, nil, fmt.Errorf("规则集 %s 的 behavior 只支持 domain、ipcidr、classical", setting.Name)
+		}
+
+		extension := "yaml"
+		switch setting.Format {
+		case "", "yaml":
+		case "text":
+			extension = "txt"
+		case "mrs":
+			extension = "mrs"
+		default:
+			return nil, nil, fmt.Errorf("规则集 %s 的 format 只支持 yaml、text、mrs", setting.Name)
+		}
+		if setting.Interval <= 0 {
+			setting.Interval = 86400
+		}
+		if setting.Policy == "" {
+			setting.Policy = clashSelectGroup
+		}
+
+		providers[setting.Name] = model.ClashRuleProvider{
+			Type:     "http",
+			Behavior: setting.Behavior,
+			Format:   setting.Format,
+			URL:      setting.URL,
+			Path:     "./ruleset/" + setting.Name + "." + extension,
+			Interval: setting.Interval,
+		}
+
+		rule := "RULE-SET," + setting.Name + "," + setting.Policy
+		if setting.NoResolve {
+			rule += ",no-resolve"
+		}
+		rules = append(rules, rule)
+	}
+
+	return providers, rules, nil
+}
+
+// buildClashMetaConfig 根据模板、节点和规则集生成用户的 Clash Meta 配置
+func buildClashMetaConfig(nodes []Domain, uuid, userID string) (model.ClashMetaYAML, error) {
+	var config model.ClashMetaYAML
+
+	content, err := os.ReadFile(helper.CurrentPath() + "/config/template_clash_meta.yaml")
+	if err != nil {
+		return config, err
+	}
+	if err := yaml.Unmarshal(content, &config); err != nil {
+		return config, err
+	}
+
+	var proxyNodes []Domain
+	var names []string
+	config.Proxies = nil
+	for _, node := range nodes {
+		proxy, ok := clashMetaProxy(node, uuid, userID)
+		if !ok {
+			continue
+		}
+		config.Proxies = append(config.Proxies, proxy)
+		proxyNodes = append(proxyNodes, node)
+		names = append(names, node.Remark)
+	}
+	if config.Proxies == nil {
+		config.Proxies = []interface{}{}
+	}
+	config.ProxyGroups = clashMetaProxyGroups(proxyNodes, names)
+
+	providers, providerRules, err := loadClashRuleProviders()
+	if err != nil {
+		return config, err
+	}
+	config.RuleProviders = providers
+	config.Rules = append(providerRules, config.Rules...)
+
+	return config, nil
+}
+
+// returnClashMetaError 为非正常状态的用户返回提示配置
+func returnClashMetaError(c *gin.Context) {
+	content, err := os.ReadFile(helper.CurrentPath() + "/config/error_clash.yaml")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		log.Printf("ReturnClashMetaYAML error: %v", err)
+		return
+	}
+	c.Data(http.StatusOK, "application/x-yaml; charset=utf-8", content)
+}
+
+// clashMetaHandler 查找用户和节点后生成 Clash Meta 配置
+func clashMetaHandler(load func(c *gin.Context) (subscriptionTarget, bool)) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		target, ok := load(c)
+		if !ok {
+			return
+		}
+
+		if target.Status != "plain" {
+			returnClashMetaError(c)
+			return
+		}
+
+		config, err := buildClashMetaConfig(target.Nodes, target.UUID, target.UserID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			log.Printf("ReturnClashMetaYAML error: %v", err)
+			return
+		}
+
+		c.YAML(http.StatusOK, config)
+	}
+}
+
+// ReturnClashMetaYAML 返回 Clash Meta (mihomo) 订阅配置
+func ReturnClashMetaYAML() gin.HandlerFunc {
+	return clashMetaHandler(loadSubscriptionTarget)
+}
@@ -0,0 +1,10 @@
+package controllers
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+// ReturnClashMetaYAMLPG 返回 Clash Meta (mihomo) 订阅配置 - PostgreSQL版本
+func ReturnClashMetaYAMLPG() gin.HandlerFunc {
+	return clashMetaHandler(loadSubscriptionTargetPG)
+}
@@ -0,0 +1,211 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	_grpc "github.com/xvv6u577/logv2fs/grpc"
+	helper "github.com/xvv6u577/logv2fs/helpers"
+	pb "github.com/xvv6u577/logv2fs/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	// 同一用户同时连接的来源 IP 超过该数量时标记为疑似共享账号，默认 3
+	sharedAccountIPLimit = os.Getenv("SHARED_ACCOUNT_IP_LIMIT")
+
+	errConnectionNotFound = errors.New("connection not found")
+)
+
+// UserConnectionIPs 用户在所有节点上的活动连接来源 IP
+type UserConnectionIPs struct {
+	User        string   `json:"user"`
+	IPs         []string `json:"ips"`
+	IPCount     int      `json:"ip_count"`
+	Connections int      `json:"connections"`
+	Nodes       []string `json:"nodes"`
+	Shared      bool     `json:"shared"` // IP 数超过 SHARED_ACCOUNT_IP_LIMIT
+}
+
+// SharedAccountIPLimit 返回判定疑似共享账号的 IP 数量
+func SharedAccountIPLimit() int {
+	limit, err := strconv.Atoi(sharedAccountIPLimit)
+	if err != nil || limit < 1 {
+		return 3
+	}
+	return limit
+}
+
+// connectionSourceIP 返回连接来源地址中的 IP
+func connectionSourceIP(source string) string {
+	host, _, err := net.SplitHostPort(source)
+	if err != nil {
+		return source
+	}
+	return host
+}
+
+// summarizeConnectionIPs 按用户合并各节点的连接，IP 数多的用户排在前面
+func summarizeConnectionIPs(connections map[string][]*pb.Connection) []UserConnectionIPs {
+	type userIPs struct {
+		ips         map[string]bool
+		nodes       map[string]bool
+		connections int
+	}
+
+	users := map[string]*userIPs{}
+	for domain, conns := range connections {
+		for _, conn := range conns {
+			entry, ok := users[conn.GetUser()]
+			if !ok {
+				entry = &userIPs{ips: map[string]bool{}, nodes: map[string]bool{}}
+				users[conn.GetUser()] = entry
+			}
+			entry.ips[connectionSourceIP(conn.GetSource())] = true
+			entry.nodes[domain] = true
+			entry.connections++
+		}
+	}
+
+	limit := SharedAccountIPLimit()
+	results := make([]UserConnectionIPs, 0, len(users))
+	for user, entry := range users {
+		result := UserConnectionIPs{User: user, IPCount: len(entry.ips), Connections: entry.connections}
+		for ip := range entry.ips {
+			result.IPs = append(result.IPs, ip)
+		}
+		for node := range entry.nodes {
+			result.Nodes = append(result.Nodes, node)
+		}
+		sort.Strings(result.IPs)
+		sort.Strings(result.Nodes)
+		result.Shared = result.IPCount > limit
+		results = append(results, result)
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].IPCount != results[j].IPCount {
+			return results[i].IPCount > results[j].IPCount
+		}
+		return results[i].User < results[j].User
+	})
+	return results
+}
+
+// connectionIPs 查询所有节点的活动连接并按用户统计来源 IP
+func connectionIPs(c *gin.Context, domains []string) {
+	if !_grpc.Enabled() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "gRPC is not configured"})
+		return
+	}
+
+	var mu sync.Mutex
+	connections := make(map[string][]*pb.Connection, len(domains))
+
+	results := _grpc.CallNodes(domains, func(ctx context.Context, domain string, client pb.ManageV2RayUserBygRPCClient) error {
+		reply, err := client.ListConnections(ctx, &pb.ConnectionsRequest{Name: c.Query("name")})
+		if err != nil {
+			return err
+		}
+
+		mu.Lock()
+		connections[domain] = reply.GetConnections()
+		mu.Unlock()
+		return nil
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"limit": SharedAccountIPLimit(),
+		"users": summarizeConnectionIPs(connections),
+		"nodes": results,
+	})
+}
+
+// closeNodeConnection 关闭节点上的一条连接，只允许操作节点列表中的域名
+func closeNodeConnection(c *gin.Context, domains []string) {
+	if !_grpc.Enabled() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "gRPC is not configured"})
+		return
+	}
+
+	domain := helper.SanitizeStr(c.Param("domain"))
+	id := helper.SanitizeStr(c.Param("id"))
+
+	known := false
+	for _, d := range domains {
+		if d == domain {
+			known = true
+			break
+		}
+	}
+	if !known {
+		c.JSON(http.StatusNotFound, gin.H{"error": "node not found"})
+		return
+	}
+
+	results := _grpc.CallNodes([]string{domain}, func(ctx context.Context, domain string, client pb.ManageV2RayUserBygRPCClient) error {
+		_, err := client.CloseConnection(ctx, &pb.CloseConnectionRequest{Id: id})
+		if status.Code(err) == codes.NotFound {
+			return errConnectionNotFound
+		}
+		return err
+	})
+	if results[0].Error != "" {
+		code := http.StatusInternalServerError
+		if results[0].Error == errConnectionNotFound.Error() {
+			code = http.StatusNotFound
+		}
+		c.JSON(code, gin.H{"error": results[0].Error})
+		return
+	}
+
+	log.Printf("已关闭节点 %s 上的连接 %s", domain, id)
+	c.JSON(http.StatusOK, gin.H{"message": "连接已关闭"})
+}
+
+// GetConnectionIPs 按用户统计所有节点上活动连接的来源 IP，用于发现共享账号 - MongoDB版本
+func GetConnectionIPs() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckUserType(c, "admin"); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		domains, err := agentDomains()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			log.Printf("查询节点列表失败: %v", err)
+			return
+		}
+
+		connectionIPs(c, domains)
+	}
+}
+
+// CloseNodeConnection 关闭节点上的一条连接 - MongoDB版本
+func CloseNodeConnection() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckUserType(c, "admin"); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		domains, err := agentDomains()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			log.Printf("查询节点列表失败: %v", err)
+			return
+		}
+
+		closeNodeConnection(c, domains)
+	}
+}
@@ -0,0 +1,47 @@
+package controllers
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	helper "github.com/xvv6u577/logv2fs/helpers"
+)
+
+// GetConnectionIPsPG 按用户统计所有节点上活动连接的来源 IP - PostgreSQL版本
+func GetConnectionIPsPG() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckUserType(c, "admin"); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		domains, err := agentDomainsPG()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			log.Printf("查询节点列表失败: %v", err)
+			return
+		}
+
+		connectionIPs(c, domains)
+	}
+}
+
+// CloseNodeConnectionPG 关闭节点上的一条连接 - PostgreSQL版本
+func CloseNodeConnectionPG() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckUserType(c, "admin"); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		domains, err := agentDomainsPG()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			log.Printf("查询节点列表失败: %v", err)
+			return
+		}
+
+		closeNodeConnection(c, domains)
+	}
+}
@@ -2,8 +2,7 @@ package controllers
 
 import (
 	"context"
-	b64 "encoding/base64"
-	"encoding/json"
+	"errors"
 	"log"
 	"os"
 	"strconv"
@@ -14,7 +13,6 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/go-playground/validator/v10"
 	uuid "github.com/nu7hatch/gouuid"
-	"gopkg.in/yaml.v2"
 
 	"github.com/xvv6u577/logv2fs/database"
 
@@ -36,6 +34,7 @@ var (
 	nodeTrafficLogsCol                     = database.GetCollection(model.NodeTrafficLogs{})
 	userTrafficLogsCol                     = database.GetCollection(model.UserTrafficLogs{})
 	customDatesCol                         = database.GetCollection(model.CustomDate{})
+	nodeUserSetsCol                        = database.GetCollection(model.NodeUserSet{})
 	validate                               = validator.New()
 	CURRENT_DOMAIN                         = os.Getenv("CURRENT_DOMAIN")
 	CREDIT                                 = os.Getenv("CREDIT")
@@ -49,12 +48,12 @@ type (
 	Domain          = model.SubscriptionNode
 	SingboxYAML     = model.SingboxYAML
 	SingboxJSON     = model.SingboxJSON
-	RealityJSON     = model.RealityJSON
-	Hysteria2JSON   = model.Hysteria2JSON
 	RealityYAML     = model.RealityYAML
 	Hysteria2YAML   = model.Hysteria2YAML
-	CFVlessJSON     = model.CFVlessJSON
 	CFVlessYAML     = model.CFVlessYAML
+	TUICYAML        = model.TUICYAML
+	TrojanYAML      = model.TrojanYAML
+	ShadowsocksYAML = model.ShadowsocksYAML
 	UserTrafficLogs = model.UserTrafficLogs
 	NodeTrafficLogs = model.NodeTrafficLogs
 )
@@ -189,22 +188,17 @@ func SignUp() gin.HandlerFunc {
 		user.Token = &token
 		user.Refresh_token = &refreshToken
 
-		user.HourlyLogs = []struct {
-			Timestamp time.Time `json:"timestamp" bson:"timestamp"`
-			Traffic   int64     `json:"traffic" bson:"traffic"`
-		}{}
-		user.DailyLogs = []struct {
-			Date    string `json:"date" bson:"date"`
-			Traffic int64  `json:"traffic" bson:"traffic"`
-		}{}
-		user.MonthlyLogs = []struct {
-			Month   string `json:"month" bson:"month"`
-			Traffic int64  `json:"traffic" bson:"traffic"`
-		}{}
-		user.YearlyLogs = []struct {
-			Year    string `json:"year" bson:"year"`
-			Traffic int64  `json:"traffic" bson:"traffic"`
-		}{}
+		user.SubscriptionToken, err = helper.GenerateSubscriptionToken()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			log.Printf("error occured while generating subscription token: %v", err)
+			return
+		}
+
+		user.HourlyLogs = []model.TrafficLogEntry{}
+		user.DailyLogs = []model.DailyLogEntry{}
+		user.MonthlyLogs = []model.MonthlyLogEntry{}
+		user.YearlyLogs = []model.YearlyLogEntry{}
 
 		_, err = userTrafficLogsCol.InsertOne(context.Background(), user)
 		if err != nil {
@@ -213,6 +207,8 @@ func SignUp() gin.HandlerFunc {
 			return
 		}
 
+		pushUserToNodesMongo(user, user.Status == "plain")
+
 		c.JSON(http.StatusOK, gin.H{"message": "user " + user.Name + " created successfully"})
 	}
 }
@@ -394,6 +390,8 @@ func DeleteUserByUserName() gin.HandlerFunc {
 			return
 		}
 
+		pushUserToNodesMongo(user, false)
+
 		log.Printf("Delete user %s successfully!", user.Name)
 		c.JSON(http.StatusOK, gin.H{"message": "Delete user " + user.Name + " successfully!"})
 	}
@@ -419,6 +417,7 @@ func GetAllUsers() gin.HandlerFunc {
 				{Key: "status", Value: 1},
 				{Key: "used", Value: 1},
 				{Key: "remark", Value: 1},
+				{Key: "subscription_token", Value: 1},
 				{Key: "updated_at", Value: 1},
 				{Key: "daily_logs", Value: bson.D{
 					{Key: "$slice", Value: bson.A{
@@ -496,6 +495,7 @@ func GetUserByName() gin.HandlerFunc {
 			{Key: "role", Value: 1},
 			{Key: "remark", Value: 1},
 			{Key: "credit", Value: 1},
+			{Key: "subscription_token", Value: 1},
 			{Key: "daily_logs", Value: 1},
 			{Key: "monthly_logs", Value: 1},
 			{Key: "yearly_logs", Value: 1},
@@ -511,505 +511,95 @@ func GetUserByName() gin.HandlerFunc {
 			return
 		}
 
+		// 老用户首次查看时生成订阅令牌
+		if token, err := ensureSubscriptionToken(user.Email_As_Id, user.SubscriptionToken); err != nil {
+			log.Printf("GetUserByName: 生成订阅令牌失败: %v", err)
+		} else {
+			user.SubscriptionToken = token
+		}
+
 		c.JSON(http.StatusOK, user)
 	}
 }
 
 func GetSubscripionURL() gin.HandlerFunc {
-	return func(c *gin.Context) {
-
-		var subscription []byte
-		var err error
-		name := helper.SanitizeStr(c.Param("name"))
-
-		var activeGlobalNodes []Domain
-
-		cur, err := subNodesCol.Find(context.TODO(), bson.D{})
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while getting active global nodes"})
-			log.Printf("Getting active global nodes error: %s", err.Error())
-			return
-		}
-		defer cur.Close(context.Background())
-
-		cur.All(context.Background(), &activeGlobalNodes)
-
-		// projections include status, user_id, uuid,
-		var projections = bson.D{
-			{Key: "status", Value: 1},
-			{Key: "user_id", Value: 1},
-			{Key: "uuid", Value: 1},
-		}
-		var user UserTrafficLogs
-		err = userTrafficLogsCol.FindOne(context.TODO(), bson.M{"email_as_id": name}, options.FindOne().SetProjection(projections)).Decode(&user)
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-			log.Printf("GetSubscripionURL error: %v", err)
-			return
-		}
-
-		if user.Status == "plain" {
-			var sub string
-			for _, node := range activeGlobalNodes {
-
-				// 格式化IP地址以支持IPv6
-				formattedIP := helper.FormatIPForURL(node.IP)
-
-				if node.Type == "reality" {
-					if len(sub) == 0 {
-						sub = "vless://" + user.UUID + "@" + formattedIP + ":" + node.SERVER_PORT + "?encryption=none&flow=xtls-rprx-vision&security=reality&sni=itunes.apple.com&fp=chrome&pbk=" + node.PUBLIC_KEY + "&sid=" + node.SHORT_ID + "&type=tcp&headerType=none#" + node.Remark
-					} else {
-						sub = sub + "\n" + "vless://" + user.UUID + "@" + formattedIP + ":" + node.SERVER_PORT + "?encryption=none&flow=xtls-rprx-vision&security=reality&sni=itunes.apple.com&fp=chrome&pbk=" + node.PUBLIC_KEY + "&sid=" + node.SHORT_ID + "&type=tcp&headerType=none#" + node.Remark
-					}
-				}
-
-				if node.Type == "hysteria2" {
-					if len(sub) == 0 {
-						sub = "hysteria2://" + user.User_id + "@" + formattedIP + ":" + node.SERVER_PORT + "?insecure=1&sni=bing.com#" + node.Remark
-					} else {
-						sub = sub + "\n" + "hysteria2://" + user.User_id + "@" + formattedIP + ":" + node.SERVER_PORT + "?insecure=1&sni=bing.com#" + node.Remark
-					}
-				}
-
-				if node.Type == "vlessCDN" {
-					if len(sub) == 0 {
-						sub = "vless://" + node.UUID + "@" + formattedIP + ":" + node.SERVER_PORT + "?encryption=none&security=tls&sni=" + node.Domain + "&fp=randomized&type=ws&host=" + node.Domain + "&path=%2F%3Fed%3D2048#" + node.Remark
-					} else {
-						sub = sub + "\n" + "vless://" + node.UUID + "@" + formattedIP + ":" + node.SERVER_PORT + "?encryption=none&security=tls&sni=" + node.Domain + "&fp=randomized&type=ws&host=" + node.Domain + "&path=%2F%3Fed%3D2048#" + node.Remark
-					}
-				}
-			}
-
-			subscription = []byte(b64.StdEncoding.EncodeToString([]byte(sub)))
-		} else {
-			subscription, err = os.ReadFile(helper.CurrentPath() + "/config/error.txt")
-			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-				log.Printf("GetSubscripionURL error: %v", err)
-				// return
-			}
-		}
-
-		c.Data(http.StatusOK, "text/plain", subscription)
-	}
+	return base64SubscriptionHandler(loadLegacySubscriptionTarget)
 }
 
 // ReturnSingboxJson
 func ReturnSingboxJson() gin.HandlerFunc {
-	return func(c *gin.Context) {
-
-		name := helper.SanitizeStr(c.Param("name"))
-
-		var err error
-		var jsonFile []byte
-		var singboxJSON = SingboxJSON{}
-		var user UserTrafficLogs
-
-		var projections = bson.D{
-			{Key: "status", Value: 1},
-			{Key: "user_id", Value: 1},
-			{Key: "uuid", Value: 1},
-		}
-		err = userTrafficLogsCol.FindOne(context.TODO(), bson.M{"email_as_id": name}, options.FindOne().SetProjection(projections)).Decode(&user)
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-			log.Printf("ReturnSingboxJson failed: %s", err.Error())
-			return
-		}
-
-		var activeGlobalNodes []Domain
-
-		cur, err := subNodesCol.Find(context.TODO(), bson.D{})
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while getting active global nodes"})
-			log.Printf("Getting active global nodes error: %s", err.Error())
-			return
-		}
-		defer cur.Close(context.Background())
-
-		cur.All(context.Background(), &activeGlobalNodes)
-
-		if user.Status == "plain" {
-
-			jsonFile, err = os.ReadFile(helper.CurrentPath() + "/config/template_singbox.json")
-			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-				log.Printf("error: %v", err)
-				return
-			}
-
-			err = json.Unmarshal(jsonFile, &singboxJSON)
-			if err != nil {
-				log.Printf("error: %v", err)
-				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-				return
-			}
-
-			// append reality and hysteria2 nodes to outbounds in jsonfile.
-			for _, node := range activeGlobalNodes {
-
-				server_port, _ := strconv.Atoi(node.SERVER_PORT)
-				var outboundTags = []string{
-					"manual-select",
-					"auto",
-					"WeChat",
-					"Apple",
-					"Microsoft",
-				}
-
-				if node.Type == "reality" {
-
-					for i, outbound := range singboxJSON.Outbounds {
-						if outboundMap, ok := outbound.(map[string]interface{}); ok {
-							if Contains(outboundTags, outboundMap["tag"].(string)) || (node.EnableOpenai) && outboundMap["tag"] == "Openai" {
-								if outbounds, ok := singboxJSON.Outbounds[i].(map[string]interface{}); ok {
-									if outboundsList, ok := outbounds["outbounds"].([]interface{}); ok {
-										singboxJSON.Outbounds[i].(map[string]interface{})["outbounds"] = append(outboundsList, node.Remark)
-									}
-								}
-							}
-						}
-					}
-
-					singboxJSON.Outbounds = append(singboxJSON.Outbounds, RealityJSON{
-						Tag:            node.Remark,
-						Type:           "vless",
-						UUID:           user.UUID,
-						ServerPort:     server_port,
-						Flow:           "xtls-rprx-vision",
-						PacketEncoding: "xudp",
-						Server:         helper.FormatIPForURL(node.IP),
-						TLS: struct {
-							Enabled    bool   `json:"enabled"`
-							ServerName string `json:"server_name"`
-							Utls       struct {
-								Enabled     bool   `json:"enabled"`
-								Fingerprint string `json:"fingerprint"`
-							} `json:"utls"`
-							Reality struct {
-								Enabled   bool   `json:"enabled"`
-								PublicKey string `json:"public_key"`
-								ShortID   string `json:"short_id"`
-							} `json:"reality"`
-						}{
-							Enabled:    true,
-							ServerName: "itunes.apple.com",
-							Utls: struct {
-								Enabled     bool   `json:"enabled"`
-								Fingerprint string `json:"fingerprint"`
-							}{
-								Enabled:     true,
-								Fingerprint: "chrome",
-							},
-							Reality: struct {
-								Enabled   bool   `json:"enabled"`
-								PublicKey string `json:"public_key"`
-								ShortID   string `json:"short_id"`
-							}{
-								Enabled:   true,
-								PublicKey: node.PUBLIC_KEY,
-								ShortID:   node.SHORT_ID,
-							},
-						},
-					})
-				}
-
-				if node.Type == "hysteria2" {
-
-					for i, outbound := range singboxJSON.Outbounds {
-						if outboundMap, ok := outbound.(map[string]interface{}); ok {
-							if Contains(outboundTags, outboundMap["tag"].(string)) || (node.EnableOpenai) && outboundMap["tag"] == "Openai" {
-								if outbounds, ok := singboxJSON.Outbounds[i].(map[string]interface{}); ok {
-									if outboundsList, ok := outbounds["outbounds"].([]interface{}); ok {
-										singboxJSON.Outbounds[i].(map[string]interface{})["outbounds"] = append(outboundsList, node.Remark)
-									}
-								}
-							}
-						}
-					}
-
-					singboxJSON.Outbounds = append(singboxJSON.Outbounds, Hysteria2JSON{
-						Tag:        node.Remark,
-						Type:       "hysteria2",
-						Server:     helper.FormatIPForURL(node.IP),
-						ServerPort: server_port,
-						UpMbps:     100,
-						DownMbps:   100,
-						Password:   user.User_id,
-						TLS: struct {
-							Enabled    bool     `json:"enabled"`
-							ServerName string   `json:"server_name"`
-							Insecure   bool     `json:"insecure"`
-							Alpn       []string `json:"alpn"`
-						}{
-							Enabled:    true,
-							ServerName: "bing.com",
-							Insecure:   true,
-							Alpn:       []string{"h3"},
-						},
-					})
-				}
-
-				if node.Type == "vlessCDN" {
-
-					for i, outbound := range singboxJSON.Outbounds {
-						if outboundMap, ok := outbound.(map[string]interface{}); ok {
-							if Contains(outboundTags, outboundMap["tag"].(string)) || (node.EnableOpenai) && outboundMap["tag"] == "Openai" {
-								if outbounds, ok := singboxJSON.Outbounds[i].(map[string]interface{}); ok {
-									if outboundsList, ok := outbounds["outbounds"].([]interface{}); ok {
-										singboxJSON.Outbounds[i].(map[string]interface{})["outbounds"] = append(outboundsList, node.Remark)
-									}
-								}
-							}
-						}
-					}
-
-					singboxJSON.Outbounds = append(singboxJSON.Outbounds, CFVlessJSON{
-						Tag:        node.Remark,
-						Type:       "vless",
-						Server:     helper.FormatIPForURL(node.IP),
-						ServerPort: server_port,
-						UUID:       node.UUID,
-						Flow:       "",
-						TLS: struct {
-							Enabled    bool   `json:"enabled"`
-							ServerName string `json:"server_name"`
-							Insecure   bool   `json:"insecure"`
-							Utls       struct {
-								Enabled     bool   `json:"enabled"`
-								Fingerprint string `json:"fingerprint"`
-							} `json:"utls"`
-						}{
-							Enabled:    true,
-							ServerName: node.Domain,
-							Insecure:   false,
-							Utls: struct {
-								Enabled     bool   `json:"enabled"`
-								Fingerprint string `json:"fingerprint"`
-							}{
-								Enabled:     true,
-								Fingerprint: "chrome",
-							},
-						},
-						Multiplex: struct {
-							Enabled    bool   `json:"enabled"`
-							Protocol   string `json:"protocol"`
-							MaxStreams int    `json:"max_streams"`
-						}{
-							Enabled:    false,
-							Protocol:   "smux",
-							MaxStreams: 32,
-						},
-						PacketEncoding: "xudp",
-						Transport: struct {
-							Type    string `json:"type"`
-							Path    string `json:"path"`
-							Headers struct {
-								Host string `json:"Host"`
-							} `json:"headers"`
-						}{
-							Type: "ws",
-							Path: "/?ed=2048",
-							Headers: struct {
-								Host string `json:"Host"`
-							}{
-								Host: node.Domain,
-							},
-						},
-					})
+	return singboxHandler(loadLegacySubscriptionTarget)
+}
 
-				}
-			}
+// ReturnVergeYAML: return yaml file
+func ReturnVergeYAML() gin.HandlerFunc {
+	return vergeHandler(loadLegacySubscriptionTarget)
+}
 
-		} else {
-			jsonFile, err = os.ReadFile(helper.CurrentPath() + "/config/error.json")
-			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-				log.Printf("error: %v", err)
-				return
-			}
-
-			err = json.Unmarshal(jsonFile, &singboxJSON)
-			if err != nil {
-				log.Printf("error: %v", err)
-				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-				return
-			}
-		}
+var (
+	errUserNotFound = errors.New("user not found")
+	errDisableAdmin = errors.New("cannot disable admin user")
+)
 
-		c.JSON(http.StatusOK, singboxJSON)
+// userStatusErrorCode 修改用户状态失败时返回的状态码
+func userStatusErrorCode(err error) int {
+	if errors.Is(err, errDisableAdmin) {
+		return http.StatusBadRequest
 	}
+	return http.StatusInternalServerError
 }
 
-// ReturnVergeYAML: return yaml file
-func ReturnVergeYAML() gin.HandlerFunc {
-	return func(c *gin.Context) {
+// setUserStatus 修改用户状态并推送到节点，不允许禁用管理员
+func setUserStatus(name, status string) (UserTrafficLogs, error) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
 
-		name := helper.SanitizeStr(c.Param("name"))
+	var foundUser UserTrafficLogs
+	err := userTrafficLogsCol.FindOne(ctx, bson.M{"email_as_id": helper.SanitizeStr(name)}).Decode(&foundUser)
+	if err != nil {
+		log.Printf("user not found: %s", name)
+		return foundUser, errUserNotFound
+	}
 
-		var err error
-		var yamlFile []byte
-		var singboxYAML = SingboxYAML{}
+	// 不允许禁用管理员账户
+	if status == "deleted" && foundUser.Role == "admin" {
+		log.Printf("attempted to disable admin user: %s", name)
+		return foundUser, errDisableAdmin
+	}
 
-		var projections = bson.D{
-			{Key: "status", Value: 1},
-			{Key: "user_id", Value: 1},
-			{Key: "uuid", Value: 1},
-		}
-		var user UserTrafficLogs
-		err = userTrafficLogsCol.FindOne(context.TODO(), bson.M{"email_as_id": name}, options.FindOne().SetProjection(projections)).Decode(&user)
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-			log.Printf("ReturnVergeYAML failed: %s", err.Error())
-			return
-		}
+	// 管理员手动修改状态时清除 overdue 原因
+	updateData := bson.M{
+		"status":         status,
+		"overdue_reason": "",
+		"updated_at":     time.Now(),
+	}
 
-		var activeGlobalNodes []Domain
-		cur, err := subNodesCol.Find(context.TODO(), bson.D{})
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while getting active global nodes"})
-			log.Printf("Getting active global nodes error: %s", err.Error())
-			return
-		}
-		defer cur.Close(context.Background())
-
-		cur.All(context.Background(), &activeGlobalNodes)
-
-		if user.Status == "plain" {
-			yamlFile, err = os.ReadFile(helper.CurrentPath() + "/config/template_verge.yaml")
-			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-				log.Printf("error: %v", err)
-				return
-			}
-
-			err = yaml.Unmarshal(yamlFile, &singboxYAML)
-			if err != nil {
-				log.Printf("error: %v", err)
-				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-				return
-			}
-
-			// append reality and hysteria2 nodes to outbounds in yamlfile.
-			for _, node := range activeGlobalNodes {
-
-				server_port, _ := strconv.Atoi(node.SERVER_PORT)
-				if node.Type == "reality" {
-
-					for i, proxy := range singboxYAML.ProxyGroups {
-						if proxy.Type == "select" || proxy.Type == "url-test" {
-							singboxYAML.ProxyGroups[i].Proxies = append(singboxYAML.ProxyGroups[i].Proxies, node.Remark)
-						}
-					}
-
-					singboxYAML.Proxies = append(singboxYAML.Proxies, RealityYAML{
-						Name:              node.Remark,
-						Type:              "vless",
-						Server:            helper.FormatIPForURL(node.IP),
-						Port:              server_port,
-						UUID:              user.UUID,
-						Network:           "tcp",
-						UDP:               true,
-						TLS:               true,
-						Flow:              "xtls-rprx-vision",
-						Servername:        "itunes.apple.com",
-						ClientFingerprint: "chrome",
-						RealityOpts: struct {
-							PublicKey string `yaml:"public-key"`
-							ShortID   string `yaml:"short-id"`
-						}{
-							PublicKey: node.PUBLIC_KEY,
-							ShortID:   node.SHORT_ID,
-						},
-					})
-				}
-
-				if node.Type == "hysteria2" {
-
-					for i, proxy := range singboxYAML.ProxyGroups {
-						if proxy.Type == "select" || proxy.Type == "url-test" {
-							singboxYAML.ProxyGroups[i].Proxies = append(singboxYAML.ProxyGroups[i].Proxies, node.Remark)
-						}
-					}
-
-					singboxYAML.Proxies = append(singboxYAML.Proxies, Hysteria2YAML{
-						Name:           node.Remark,
-						Type:           "hysteria2",
-						Server:         helper.FormatIPForURL(node.IP),
-						Port:           server_port,
-						Password:       user.User_id,
-						Sni:            "bing.com",
-						SkipCertVerify: true,
-						Alpn:           []string{"h3"},
-					})
-				}
-
-				if node.Type == "vlessCDN" {
-
-					for i, proxy := range singboxYAML.ProxyGroups {
-						if proxy.Type == "select" || proxy.Type == "url-test" {
-							singboxYAML.ProxyGroups[i].Proxies = append(singboxYAML.ProxyGroups[i].Proxies, node.Remark)
-						}
-					}
-
-					singboxYAML.Proxies = append(singboxYAML.Proxies, CFVlessYAML{
-						Name:              node.Remark,
-						Type:              "vless",
-						Server:            helper.FormatIPForURL(node.IP),
-						Port:              server_port,
-						UUID:              node.UUID,
-						Network:           "ws",
-						TLS:               true,
-						UDP:               false,
-						Servername:        node.Domain,
-						ClientFingerprint: "chrome",
-						WsOpts: struct {
-							Path    string `yaml:"path"`
-							Headers struct {
-								Host string `yaml:"Host"`
-							} `yaml:"headers"`
-						}{
-							Path: node.PATH,
-							Headers: struct {
-								Host string `yaml:"Host"`
-							}{
-								Host: node.Domain,
-							},
-						},
-					})
-				}
-			}
-
-			// if DIRECT type is not at the end of singboxYAML.ProxyGroups at select type, set it to the end.
-			for i, proxy := range singboxYAML.ProxyGroups {
-				if proxy.Type == "select" {
-					for j, p := range proxy.Proxies {
-						if p == "DIRECT" {
-							singboxYAML.ProxyGroups[i].Proxies = append(singboxYAML.ProxyGroups[i].Proxies[:j], singboxYAML.ProxyGroups[i].Proxies[j+1:]...)
-							singboxYAML.ProxyGroups[i].Proxies = append(singboxYAML.ProxyGroups[i].Proxies, "DIRECT")
-						}
-					}
-				}
-			}
+	var updatedUser UserTrafficLogs
+	err = userTrafficLogsCol.FindOneAndUpdate(
+		ctx,
+		bson.M{"email_as_id": helper.SanitizeStr(name)},
+		bson.M{"$set": updateData},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&updatedUser)
+	if err != nil {
+		log.Printf("error setting user %s status to %s: %v", name, status, err)
+		return foundUser, err
+	}
 
-		} else {
-			yamlFile, err = os.ReadFile(helper.CurrentPath() + "/config/error.yaml")
-			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-				log.Printf("error: %v", err)
-				return
-			}
-
-			err = yaml.Unmarshal(yamlFile, &singboxYAML)
-			if err != nil {
-				log.Fatalf("error: %v", err)
-				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-				return
-			}
-		}
+	pushUserToNodesMongo(updatedUser, status == "plain")
+
+	log.Printf("User %s status set to %s", updatedUser.Name, status)
+	return updatedUser, nil
+}
 
-		c.YAML(http.StatusOK, singboxYAML)
+// SetUserStatusByName 修改用户状态（plain / deleted）并推送到节点，返回用户名称
+func SetUserStatusByName(name, status string) (string, error) {
+	if database.IsUsingPostgres() {
+		user, err := setUserStatusPG(database.GetPostgresDB(), name, status)
+		return user.Name, err
 	}
+	user, err := setUserStatus(name, status)
+	return user.Name, err
 }
 
 // DisableUser 禁用用户 - 将用户状态设为deleted
@@ -1020,50 +610,18 @@ func DisableUser() gin.HandlerFunc {
 			return
 		}
 
-		var ctx, cancel = context.WithTimeout(context.Background(), 60*time.Second)
-		defer cancel()
-
 		name := c.Param("name")
 		if name == "" {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "user name is required"})
 			return
 		}
 
-		var foundUser UserTrafficLogs
-		err := userTrafficLogsCol.FindOne(ctx, bson.M{"email_as_id": helper.SanitizeStr(name)}).Decode(&foundUser)
+		updatedUser, err := setUserStatus(name, "deleted")
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "user not found"})
-			log.Printf("user not found: %s", name)
+			c.JSON(userStatusErrorCode(err), gin.H{"error": err.Error()})
 			return
 		}
 
-		// 不允许禁用管理员账户
-		if foundUser.Role == "admin" {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "cannot disable admin user"})
-			log.Printf("attempted to disable admin user: %s", name)
-			return
-		}
-
-		// 更新用户状态为deleted
-		updateData := bson.M{
-			"status":     "deleted",
-			"updated_at": time.Now(),
-		}
-
-		var updatedUser UserTrafficLogs
-		err = userTrafficLogsCol.FindOneAndUpdate(
-			ctx,
-			bson.M{"email_as_id": helper.SanitizeStr(name)},
-			bson.M{"$set": updateData},
-			options.FindOneAndUpdate().SetReturnDocument(options.After),
-		).Decode(&updatedUser)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			log.Printf("error disabling user: %v", err)
-			return
-		}
-
-		log.Printf("User %s disabled successfully", updatedUser.Name)
 		c.JSON(http.StatusOK, gin.H{"message": "User " + updatedUser.Name + " disabled successfully"})
 	}
 }
@@ -1076,43 +634,18 @@ func EnableUser() gin.HandlerFunc {
 			return
 		}
 
-		var ctx, cancel = context.WithTimeout(context.Background(), 60*time.Second)
-		defer cancel()
-
 		name := c.Param("name")
 		if name == "" {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "user name is required"})
 			return
 		}
 
-		var foundUser UserTrafficLogs
-		err := userTrafficLogsCol.FindOne(ctx, bson.M{"email_as_id": helper.SanitizeStr(name)}).Decode(&foundUser)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "user not found"})
-			log.Printf("user not found: %s", name)
-			return
-		}
-
-		// 更新用户状态为plain
-		updateData := bson.M{
-			"status":     "plain",
-			"updated_at": time.Now(),
-		}
-
-		var updatedUser UserTrafficLogs
-		err = userTrafficLogsCol.FindOneAndUpdate(
-			ctx,
-			bson.M{"email_as_id": helper.SanitizeStr(name)},
-			bson.M{"$set": updateData},
-			options.FindOneAndUpdate().SetReturnDocument(options.After),
-		).Decode(&updatedUser)
+		updatedUser, err := setUserStatus(name, "plain")
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			log.Printf("error enabling user: %v", err)
+			c.JSON(userStatusErrorCode(err), gin.H{"error": err.Error()})
 			return
 		}
 
-		log.Printf("User %s enabled successfully", updatedUser.Name)
 		c.JSON(http.StatusOK, gin.H{"message": "User " + updatedUser.Name + " enabled successfully"})
 	}
 }
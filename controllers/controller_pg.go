@@ -116,6 +116,14 @@ func SignUpPG() gin.HandlerFunc {
 		pgUser.Token = &token
 		pgUser.RefreshToken = &refreshToken
 
+		subscriptionToken, err := helper.GenerateSubscriptionToken()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			log.Printf("error occured while generating subscription token: %v", err)
+			return
+		}
+		pgUser.SubscriptionToken = &subscriptionToken
+
 		// 初始化空的日志数组
 		hourlyLogs, _ := json.Marshal([]model.TrafficLogEntry{})
 		dailyLogs, _ := json.Marshal([]model.DailyLogEntry{})
@@ -134,6 +142,8 @@ func SignUpPG() gin.HandlerFunc {
 			return
 		}
 
+		pushUserToNodesPG(pgUser, true)
+
 		c.JSON(http.StatusOK, gin.H{"message": "user " + pgUser.Name + " created successfully"})
 	}
 }
@@ -306,6 +316,8 @@ func DeleteUserByUserNamePG() gin.HandlerFunc {
 			return
 		}
 
+		pushUserToNodesPG(pgUser, false)
+
 		log.Printf("Delete user %s successfully!", pgUser.Name)
 		c.JSON(http.StatusOK, gin.H{"message": "Delete user " + pgUser.Name + " successfully!"})
 	}
@@ -323,7 +335,7 @@ func GetAllUsersPG() gin.HandlerFunc {
 		var users []model.UserTrafficLogsPG
 
 		// 查询所有用户，只选择需要的字段
-		query := `SELECT email_as_id, uuid, name, role, status, used, remark, updated_at, daily_logs, monthly_logs, yearly_logs 
+		query := `SELECT email_as_id, uuid, name, role, status, used, remark, subscription_token, updated_at, daily_logs, monthly_logs, yearly_logs 
 				  FROM user_traffic_logs`
 
 		if err := db.Raw(query).Scan(&users).Error; err != nil {
@@ -341,6 +353,7 @@ func GetAllUsersPG() gin.HandlerFunc {
 			Status      string         `json:"status"`
 			Used        int64          `json:"used"`
 			Remark      string         `json:"remark"`
+			Token       *string        `json:"subscription_token"`
 			UpdatedAt   time.Time      `json:"updated_at"`
 			DailyLogs   datatypes.JSON `json:"daily_logs"`
 			MonthlyLogs datatypes.JSON `json:"monthly_logs"`
@@ -362,6 +375,7 @@ func GetAllUsersPG() gin.HandlerFunc {
 				Status:      user.Status,
 				Used:        user.Used,
 				Remark:      user.Remark,
+				Token:       user.SubscriptionToken,
 				UpdatedAt:   user.UpdatedAt,
 				DailyLogs:   dailyLogs,
 				MonthlyLogs: monthlyLogs,
@@ -387,7 +401,7 @@ func GetUserByNamePG() gin.HandlerFunc {
 		db := database.GetPostgresDB()
 		var user model.UserTrafficLogsPG
 
-		query := `SELECT email_as_id, used, uuid, name, status, role, remark, credit, daily_logs, monthly_logs, yearly_logs, created_at, updated_at
+		query := `SELECT email_as_id, used, uuid, name, status, role, remark, credit, subscription_token, daily_logs, monthly_logs, yearly_logs, created_at, updated_at
 				  FROM user_traffic_logs
 				  WHERE email_as_id = ?`
 
@@ -398,6 +412,31 @@ func GetUserByNamePG() gin.HandlerFunc {
 			return
 		}
 
+		// 老用户首次查看时生成订阅令牌
+		if user.EmailAsId != "" {
+			if token, err := ensureSubscriptionTokenPG(db, user.EmailAsId, user.SubscriptionToken); err != nil {
+				log.Printf("GetUserByName: 生成订阅令牌失败: %v", err)
+			} else {
+				user.SubscriptionToken = &token
+			}
+		}
+
+		// 使用 traffic_samples 汇总的流量，尚未回填的时间段使用 JSONB 日志
+		rollups, err := queryTrafficRollupsPG(db, "user", []string{name})
+		if err != nil {
+			log.Printf("GetUserByName: 查询流量汇总失败: %v", err)
+		} else {
+			set, ok := rollups[name]
+			if !ok {
+				set = &TrafficLogSet{}
+			}
+			if err := set.MergeLogs(user.DailyLogs, user.MonthlyLogs, user.YearlyLogs); err != nil {
+				log.Printf("GetUserByName: 合并流量日志失败: %v", err)
+			} else {
+				user.DailyLogs, user.MonthlyLogs, user.YearlyLogs = set.JSON()
+			}
+		}
+
 		c.JSON(http.StatusOK, user)
 	}
 }
@@ -430,6 +469,43 @@ func limitJsonLogs(jsonData datatypes.JSON, limit int) datatypes.JSON {
 	return datatypes.JSON(result)
 }
 
+// setUserStatusPG 修改用户状态并推送到节点，不允许禁用管理员 - PostgreSQL版本
+func setUserStatusPG(db *gorm.DB, name, status string) (model.UserTrafficLogsPG, error) {
+	var pgUser model.UserTrafficLogsPG
+
+	// 查找用户
+	if err := db.Where("email_as_id = ?", helper.SanitizeStr(name)).First(&pgUser).Error; err != nil {
+		log.Printf("setUserStatus - user not found: %s, error: %s", name, err.Error())
+		return pgUser, errUserNotFound
+	}
+
+	// 不允许禁用管理员账户
+	if status == "deleted" && pgUser.Role == "admin" {
+		log.Printf("attempted to disable admin user: %s", name)
+		return pgUser, errDisableAdmin
+	}
+
+	// 管理员手动修改状态时清除 overdue 原因
+	updates := map[string]interface{}{
+		"status":         status,
+		"overdue_reason": "",
+		"updated_at":     time.Now(),
+	}
+
+	if err := db.Model(&model.UserTrafficLogsPG{}).Where("email_as_id = ?", helper.SanitizeStr(name)).Updates(updates).Error; err != nil {
+		log.Printf("error setting user %s status to %s: %v", name, status, err)
+		return pgUser, err
+	}
+
+	// 获取更新后的用户
+	db.Where("email_as_id = ?", helper.SanitizeStr(name)).First(&pgUser)
+
+	pushUserToNodesPG(pgUser, status == "plain")
+
+	log.Printf("User %s status set to %s", pgUser.Name, status)
+	return pgUser, nil
+}
+
 // DisableUserPG 禁用用户 - PostgreSQL版本
 func DisableUserPG() gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -438,7 +514,6 @@ func DisableUserPG() gin.HandlerFunc {
 			return
 		}
 
-		db := database.GetPostgresDB()
 		name := c.Param("name")
 		log.Printf("Attempting to disable user: %s", name)
 
@@ -447,38 +522,12 @@ func DisableUserPG() gin.HandlerFunc {
 			return
 		}
 
-		var pgUser model.UserTrafficLogsPG
-
-		// 查找用户
-		if err := db.Where("email_as_id = ?", helper.SanitizeStr(name)).First(&pgUser).Error; err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "user not found"})
-			log.Printf("DisableUser - user not found: %s, error: %s", name, err.Error())
-			return
-		}
-
-		// 不允许禁用管理员账户
-		if pgUser.Role == "admin" {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "cannot disable admin user"})
-			log.Printf("attempted to disable admin user: %s", name)
+		pgUser, err := setUserStatusPG(database.GetPostgresDB(), name, "deleted")
+		if err != nil {
+			c.JSON(userStatusErrorCode(err), gin.H{"error": err.Error()})
 			return
 		}
 
-		// 更新用户状态为deleted
-		updates := map[string]interface{}{
-			"status":     "deleted",
-			"updated_at": time.Now(),
-		}
-
-		if err := db.Model(&model.UserTrafficLogsPG{}).Where("email_as_id = ?", helper.SanitizeStr(name)).Updates(updates).Error; err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			log.Printf("error disabling user: %v", err)
-			return
-		}
-
-		// 获取更新后的用户
-		db.Where("email_as_id = ?", helper.SanitizeStr(name)).First(&pgUser)
-
-		log.Printf("User %s disabled successfully", pgUser.Name)
 		c.JSON(http.StatusOK, gin.H{"message": "User " + pgUser.Name + " disabled successfully"})
 	}
 }
@@ -491,7 +540,6 @@ func EnableUserPG() gin.HandlerFunc {
 			return
 		}
 
-		db := database.GetPostgresDB()
 		name := c.Param("name")
 		log.Printf("Attempting to enable user: %s", name)
 
@@ -500,31 +548,12 @@ func EnableUserPG() gin.HandlerFunc {
 			return
 		}
 
-		var pgUser model.UserTrafficLogsPG
-
-		// 查找用户
-		if err := db.Where("email_as_id = ?", helper.SanitizeStr(name)).First(&pgUser).Error; err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "user not found"})
-			log.Printf("EnableUser - user not found: %s, error: %s", name, err.Error())
+		pgUser, err := setUserStatusPG(database.GetPostgresDB(), name, "plain")
+		if err != nil {
+			c.JSON(userStatusErrorCode(err), gin.H{"error": err.Error()})
 			return
 		}
 
-		// 更新用户状态为plain
-		updates := map[string]interface{}{
-			"status":     "plain",
-			"updated_at": time.Now(),
-		}
-
-		if err := db.Model(&model.UserTrafficLogsPG{}).Where("email_as_id = ?", helper.SanitizeStr(name)).Updates(updates).Error; err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			log.Printf("error enabling user: %v", err)
-			return
-		}
-
-		// 获取更新后的用户
-		db.Where("email_as_id = ?", helper.SanitizeStr(name)).First(&pgUser)
-
-		log.Printf("User %s enabled successfully", pgUser.Name)
 		c.JSON(http.StatusOK, gin.H{"message": "User " + pgUser.Name + " enabled successfully"})
 	}
 }
@@ -3,6 +3,7 @@ package controllers
 import (
 	"context"
 	"crypto/tls"
+	"fmt"
 	"log"
 	"net"
 	"net/http"
@@ -10,8 +11,12 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/xvv6u577/logv2fs/database"
+	_grpc "github.com/xvv6u577/logv2fs/grpc"
 	helper "github.com/xvv6u577/logv2fs/helpers"
 	"github.com/xvv6u577/logv2fs/model"
+	thirdparty "github.com/xvv6u577/logv2fs/pkg"
+	pb "github.com/xvv6u577/logv2fs/proto"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo/options"
@@ -57,6 +62,7 @@ func removeDuplicateDomains(domains []Domain) []Domain {
 	return result
 }
 
+// AddNode 保存后台提交的完整节点列表：先校验全部节点，再按 ID 写入并记录历史版本 - MongoDB版本
 func AddNode() gin.HandlerFunc {
 	return func(c *gin.Context) {
 
@@ -65,107 +71,33 @@ func AddNode() gin.HandlerFunc {
 			return
 		}
 
-		var current = time.Now().Local()
-		var nodeFromWebForm, dataCollectableNodes []Domain
-
+		var nodeFromWebForm []Domain
 		if err := c.BindJSON(&nodeFromWebForm); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			log.Printf("BindJSON error: %v", err)
 			return
 		}
 
-		// remove duplicated domains in nodeFromWebForm
-		dataCollectableNodes = removeDuplicateDomains(nodeFromWebForm)
-
-		// types: reality, hysteria2, vlessCDN! if type is reality, reassgin public_key and short_id.
-		for i, domain := range nodeFromWebForm {
-			if domain.Type == "reality" {
-				nodeFromWebForm[i].PUBLIC_KEY = PUBLIC_KEY
-				nodeFromWebForm[i].SHORT_ID = SHORT_ID
-			}
-
-			// set remark as filter, check if node is in subNodesCol. if no, insert it. if yes, update it.
-			filter := bson.M{"remark": domain.Remark}
-			update := bson.M{"$set": nodeFromWebForm[i]}
-			opts := options.Update().SetUpsert(true)
-			_, err := subNodesCol.UpdateOne(context.TODO(), filter, update, opts)
-			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-				log.Printf("UpdateOne error: %v", err)
-				return
-			}
-
-		}
-
-		remarks := make([]string, len(nodeFromWebForm))
-		for i, domain := range nodeFromWebForm {
-			remarks[i] = domain.Remark
-		}
-		filter := bson.M{"remark": bson.M{"$nin": remarks}}
-		_, err := subNodesCol.DeleteMany(context.TODO(), filter)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			log.Printf("DeleteMany error: %v", err)
+		// 空列表多半是提交出错，删除节点请使用 DELETE /v1/nodes/:id
+		if len(nodeFromWebForm) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "节点列表为空"})
 			return
 		}
-
-		for _, domain := range dataCollectableNodes {
-
-			// check if domain is in nodeTrafficLogsCol. if no, insert it. if yes, update it.
-			filter := bson.M{"domain_as_id": domain.Domain}
-			update := bson.M{
-				"$set": bson.M{
-					"remark":     domain.Remark,
-					"status":     "active",
-					"updated_at": current,
-				},
-				"$setOnInsert": bson.M{
-					"_id":          primitive.NewObjectID(),
-					"domain_as_id": domain.Domain,
-					"created_at":   current,
-					"hourly_logs": []struct {
-						Timestamp time.Time `json:"timestamp" bson:"timestamp"`
-						Traffic   int64     `json:"traffic" bson:"traffic"`
-					}{},
-					"daily_logs": []struct {
-						Date    string `json:"date" bson:"date"`
-						Traffic int64  `json:"traffic" bson:"traffic"`
-					}{},
-					"monthly_logs": []struct {
-						Month   string `json:"month" bson:"month"`
-						Traffic int64  `json:"traffic" bson:"traffic"`
-					}{},
-					"yearly_logs": []struct {
-						Year    string `json:"year" bson:"year"`
-						Traffic int64  `json:"traffic" bson:"traffic"`
-					}{},
-				},
-			}
-			opts := options.Update().SetUpsert(true)
-			_, err = nodeTrafficLogsCol.UpdateOne(context.TODO(), filter, update, opts)
-			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-				log.Printf("UpdateOne in nodeTrafficLogsCol error: %v", err)
-				return
-			}
-
+		if err := normalizeNodeList(nodeFromWebForm); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
 		}
 
-		// Set status to "inactive" for NodeTrafficLogs entries not in dataCollectableNodes
-		domainAsIds := make([]string, len(dataCollectableNodes))
-		for i, domain := range dataCollectableNodes {
-			domainAsIds[i] = domain.Domain
-		}
-		inactiveFilter := bson.M{"domain_as_id": bson.M{"$nin": domainAsIds}}
-		inactiveUpdate := bson.M{"$set": bson.M{"status": "inactive"}}
-		_, err = nodeTrafficLogsCol.UpdateMany(context.TODO(), inactiveFilter, inactiveUpdate)
+		version, err := commitNodeInventory(context.TODO(), c.GetString("email"), func([]Domain) ([]Domain, string, error) {
+			return nodeFromWebForm, "bulk save", nil
+		})
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			log.Printf("UpdateMany in nodeTrafficLogsCol error: %v", err)
+			log.Printf("保存节点列表失败: %v", err)
 			return
 		}
 
-		c.JSON(http.StatusOK, gin.H{"message": "Congrats! Nodes updated in success!"})
+		c.JSON(http.StatusOK, gin.H{"message": "Congrats! Nodes updated in success!", "version": version})
 	}
 }
 
@@ -180,7 +112,7 @@ func GetActiveGlobalNodes() gin.HandlerFunc {
 		var activeNodes []Domain
 		// type is not "work"
 		var filter = bson.D{{Key: "type", Value: bson.D{{Key: "$ne", Value: "work"}}}}
-		cur, err := subNodesCol.Find(context.TODO(), filter)
+		cur, err := subNodesCol.Find(context.TODO(), filter, options.Find().SetSort(bson.D{{Key: "position", Value: 1}, {Key: "remark", Value: 1}}))
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			log.Printf("Find error: %v", err)
@@ -448,3 +380,221 @@ func GetCustomDates() gin.HandlerFunc {
 		c.JSON(http.StatusOK, customDates)
 	}
 }
+
+// GetNodeUserSets 获取各节点当前正在服务的用户集合 - MongoDB版本
+func GetNodeUserSets() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckUserType(c, "admin"); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 60*time.Second)
+		defer cancel()
+
+		cur, err := nodeUserSetsCol.Find(ctx, bson.D{}, options.Find().SetSort(bson.D{{Key: "domain_as_id", Value: 1}}))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			log.Printf("查询节点用户集合失败: %v", err)
+			return
+		}
+
+		var userSets []model.NodeUserSet
+		if err := cur.All(ctx, &userSets); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			log.Printf("查询节点用户集合失败: %v", err)
+			return
+		}
+
+		var activeUsers []planUser
+		cur, err = userTrafficLogsCol.Find(ctx, bson.M{"status": "plain"},
+			options.Find().SetProjection(bson.D{{Key: "email_as_id", Value: 1}, {Key: "plan", Value: 1}}))
+		if err == nil {
+			err = cur.All(ctx, &activeUsers)
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			log.Printf("查询活跃用户失败: %v", err)
+			return
+		}
+
+		plans, err := loadNodePlans(ctx)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			log.Printf("查询套餐失败: %v", err)
+			return
+		}
+		nodes, err := agentNodes()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			log.Printf("查询节点列表失败: %v", err)
+			return
+		}
+
+		// 每个节点只应加载套餐包含该节点的用户
+		results := []NodeUserSetStatus{}
+		for _, userSet := range userSets {
+			expected := expectedDomainUsers(userSet.Domain_As_Id, activeUsers, plans, nodes)
+			results = append(results, buildNodeUserSetStatus(userSet.Domain_As_Id, userSet.Users, userSet.Checksum, userSet.SyncedAt, expected))
+		}
+
+		c.JSON(http.StatusOK, results)
+	}
+}
+
+// agentNodeTypes 运行 sing-box 并提供 gRPC 服务的节点类型
+var agentNodeTypes = []string{"reality", "hysteria2", "tuic", "trojan", "ss2022"}
+
+// agentDomains 返回 subscription_nodes 中运行 sing-box 的节点域名 - MongoDB版本
+func agentDomains() ([]string, error) {
+	values, err := subNodesCol.Distinct(context.TODO(), "domain", bson.M{"type": bson.M{"$in": agentNodeTypes}})
+	if err != nil {
+		return nil, err
+	}
+
+	var domains []string
+	for _, value := range values {
+		if domain, ok := value.(string); ok && domain != "" {
+			domains = append(domains, domain)
+		}
+	}
+	return domains, nil
+}
+
+// pushUserToNodesMongo 将用户变更推送到所有节点 - MongoDB版本
+func pushUserToNodesMongo(user model.UserTrafficLogs, enabled bool) {
+	if !_grpc.Enabled() {
+		return
+	}
+
+	nodes, err := agentNodes()
+	if err != nil {
+		log.Printf("查询节点列表失败: %v", err)
+		return
+	}
+	plan, err := findNodePlan(user.Plan)
+	if err != nil {
+		log.Printf("查询用户 %s 的套餐失败: %v", user.Email_As_Id, err)
+		return
+	}
+
+	allowed, denied := planDomains(plan, nodes)
+	go pushUserToNodes(allowed, denied, thirdparty.ProvisionedUser{
+		EmailAsId: user.Email_As_Id,
+		UUID:      user.UUID,
+		UserID:    user.User_id,
+	}, enabled)
+}
+
+// pushUserToNodes 通过 gRPC 在节点上添加或移除用户。
+// 启用时按套餐允许的节点类型添加到节点 allowed，并从其余节点 denied 移除；禁用时从所有节点移除。
+// 推送失败的节点会在下一次定时同步时从数据库追平。
+func pushUserToNodes(allowed map[string][]string, denied []string, user thirdparty.ProvisionedUser, enabled bool) {
+	var results []_grpc.NodeResult
+	if enabled {
+		results = _grpc.AddUserToNodes(allowed, user)
+		results = append(results, _grpc.DeleteUserFromNodes(denied, user.EmailAsId)...)
+	} else {
+		for domain := range allowed {
+			denied = append(denied, domain)
+		}
+		results = _grpc.DeleteUserFromNodes(denied, user.EmailAsId)
+	}
+
+	for _, result := range results {
+		if result.Error != "" {
+			log.Printf("推送用户 %s 到节点 %s 失败: %s", user.EmailAsId, result.Domain, result.Error)
+		}
+	}
+}
+
+// callNodeAgents 对所有节点执行 users/traffic/reload/connections 操作，返回每个节点的结果
+func callNodeAgents(c *gin.Context, action string, domains []string) {
+	if !_grpc.Enabled() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "gRPC is not configured"})
+		return
+	}
+
+	type nodeReply struct {
+		_grpc.NodeResult
+		Data interface{} `json:"data,omitempty"`
+	}
+
+	var mu sync.Mutex
+	data := make(map[string]interface{}, len(domains))
+
+	results := _grpc.CallNodes(domains, func(ctx context.Context, domain string, client pb.ManageV2RayUserBygRPCClient) error {
+		var reply interface{}
+		var err error
+
+		switch action {
+		case "users":
+			reply, err = client.ListUsers(ctx, &pb.ListUsersRequest{})
+		case "traffic":
+			reply, err = client.QueryTraffic(ctx, &pb.TrafficRequest{Name: c.Query("name")})
+		case "reload":
+			reply, err = client.ReloadConfig(ctx, &pb.ReloadRequest{})
+		case "connections":
+			reply, err = client.ListConnections(ctx, &pb.ConnectionsRequest{Name: c.Query("name")})
+		default:
+			err = fmt.Errorf("unknown action %s", action)
+		}
+		if err != nil {
+			return err
+		}
+
+		mu.Lock()
+		data[domain] = reply
+		mu.Unlock()
+		return nil
+	})
+
+	replies := make([]nodeReply, 0, len(results))
+	for _, result := range results {
+		replies = append(replies, nodeReply{NodeResult: result, Data: data[result.Domain]})
+	}
+
+	c.JSON(http.StatusOK, replies)
+}
+
+// CallNodeAgents 调用所有节点的 gRPC 服务 - MongoDB版本
+func CallNodeAgents(action string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckUserType(c, "admin"); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		domains, err := agentDomains()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			log.Printf("查询节点列表失败: %v", err)
+			return
+		}
+
+		callNodeAgents(c, action, domains)
+	}
+}
+
+// RemoveUsersFromNodes 通过 gRPC 从所有节点移除用户，供定时任务在用户失效后调用
+func RemoveUsersFromNodes(emails []string) {
+	if !_grpc.Enabled() || len(emails) == 0 {
+		return
+	}
+
+	var domains []string
+	var err error
+	if database.IsUsingPostgres() {
+		domains, err = agentDomainsPG()
+	} else {
+		domains, err = agentDomains()
+	}
+	if err != nil {
+		log.Printf("查询节点列表失败: %v", err)
+		return
+	}
+
+	for _, email := range emails {
+		pushUserToNodes(nil, domains, thirdparty.ProvisionedUser{EmailAsId: email}, false)
+	}
+}
@@ -0,0 +1,190 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xvv6u577/logv2fs/database"
+	helper "github.com/xvv6u577/logv2fs/helpers"
+	"github.com/xvv6u577/logv2fs/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// 订阅如何处理探测为不可用的节点：
+// off（默认）不处理，demote 移到列表末尾，exclude 从订阅中去掉
+const (
+	NodeHealthPolicyOff     = "off"
+	NodeHealthPolicyDemote  = "demote"
+	NodeHealthPolicyExclude = "exclude"
+)
+
+var (
+	nodeHealthPolicy     = os.Getenv("NODE_HEALTH_POLICY")
+	nodeHealthCol        = database.GetCollection(model.NodeHealth{})
+	nodeHealthSamplesCol = database.GetCollection(model.NodeHealthSample{})
+)
+
+// NodeHealthSummary 一段时间内的探测统计
+type NodeHealthSummary struct {
+	Samples      int     `json:"samples"`
+	Availability float64 `json:"availability"` // 成功次数占比，0-100
+	AvgLatencyMs int64   `json:"avg_latency_ms"`
+}
+
+// applyNodeHealth 按 NODE_HEALTH_POLICY 处理不可用的节点，其余节点保持原有顺序。
+// exclude 时如果所有节点都不可用则保留原列表，避免下发空订阅
+func applyNodeHealth(nodes []Domain, unhealthy map[string]bool) []Domain {
+	if len(unhealthy) == 0 {
+		return nodes
+	}
+
+	healthy := make([]Domain, 0, len(nodes))
+	var down []Domain
+	for _, node := range nodes {
+		if unhealthy[node.ID] {
+			down = append(down, node)
+		} else {
+			healthy = append(healthy, node)
+		}
+	}
+
+	switch nodeHealthPolicy {
+	case NodeHealthPolicyDemote:
+		return append(healthy, down...)
+	case NodeHealthPolicyExclude:
+		if len(healthy) == 0 {
+			return nodes
+		}
+		return healthy
+	}
+	return nodes
+}
+
+// nodeHealthEnabled 订阅是否需要根据节点健康状态调整
+func nodeHealthEnabled() bool {
+	return nodeHealthPolicy == NodeHealthPolicyDemote || nodeHealthPolicy == NodeHealthPolicyExclude
+}
+
+// unhealthyNodeIDs 返回当前不可用的节点 ID，查询失败时返回空集合，订阅不受影响
+func unhealthyNodeIDs() map[string]bool {
+	unhealthy := map[string]bool{}
+	if !nodeHealthEnabled() {
+		return unhealthy
+	}
+
+	var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	cur, err := nodeHealthCol.Find(ctx, bson.M{"healthy": false})
+	if err != nil {
+		log.Printf("查询节点健康状态失败: %v", err)
+		return unhealthy
+	}
+	var records []model.NodeHealth
+	if err := cur.All(ctx, &records); err != nil {
+		log.Printf("查询节点健康状态失败: %v", err)
+		return unhealthy
+	}
+	for _, record := range records {
+		unhealthy[record.NodeID] = true
+	}
+	return unhealthy
+}
+
+// summarizeNodeHealth 统计探测记录的可用率和平均延迟
+func summarizeNodeHealth(samples []model.NodeHealthSample) NodeHealthSummary {
+	summary := NodeHealthSummary{Samples: len(samples)}
+	if len(samples) == 0 {
+		return summary
+	}
+
+	var ok, latency int64
+	for _, sample := range samples {
+		if sample.OK {
+			ok++
+			latency += sample.LatencyMs
+		}
+	}
+	summary.Availability = float64(ok) * 100 / float64(len(samples))
+	if ok > 0 {
+		summary.AvgLatencyMs = latency / ok
+	}
+	return summary
+}
+
+// nodeHealthHistorySince 解析 hours 参数，默认查询最近 24 小时
+func nodeHealthHistorySince(c *gin.Context) time.Time {
+	hours, err := strconv.Atoi(c.DefaultQuery("hours", "24"))
+	if err != nil || hours <= 0 {
+		hours = 24
+	}
+	return time.Now().Add(-time.Duration(hours) * time.Hour)
+}
+
+// GetNodeHealth 返回所有节点当前的健康状态
+func GetNodeHealth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckUserType(c, "admin"); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		cur, err := nodeHealthCol.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "remark", Value: 1}}))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			log.Printf("GetNodeHealth: %s", err.Error())
+			return
+		}
+		records := []model.NodeHealth{}
+		if err := cur.All(ctx, &records); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			log.Printf("GetNodeHealth: %s", err.Error())
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"policy": nodeHealthPolicy, "nodes": records})
+	}
+}
+
+// GetNodeHealthHistory 返回节点一段时间内的探测记录和统计，hours 参数指定时间范围
+func GetNodeHealthHistory() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckUserType(c, "admin"); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		id := helper.SanitizeStr(c.Param("id"))
+		since := nodeHealthHistorySince(c)
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		cur, err := nodeHealthSamplesCol.Find(ctx,
+			bson.M{"node_id": id, "checked_at": bson.M{"$gte": since}},
+			options.Find().SetSort(bson.D{{Key: "checked_at", Value: 1}}),
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			log.Printf("GetNodeHealthHistory: %s", err.Error())
+			return
+		}
+		samples := []model.NodeHealthSample{}
+		if err := cur.All(ctx, &samples); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			log.Printf("GetNodeHealthHistory: %s", err.Error())
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"node_id": id, "summary": summarizeNodeHealth(samples), "samples": samples})
+	}
+}
@@ -0,0 +1,87 @@
+package controllers
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xvv6u577/logv2fs/database"
+	helper "github.com/xvv6u577/logv2fs/helpers"
+	"github.com/xvv6u577/logv2fs/model"
+	"gorm.io/gorm"
+)
+
+// unhealthyNodeIDsPG 返回当前不可用的节点 ID，查询失败时返回空集合 - PostgreSQL版本
+func unhealthyNodeIDsPG(db *gorm.DB) map[string]bool {
+	unhealthy := map[string]bool{}
+	if !nodeHealthEnabled() {
+		return unhealthy
+	}
+
+	var ids []string
+	if err := db.Model(&model.NodeHealthPG{}).Where("healthy = ?", false).Pluck("node_id", &ids).Error; err != nil {
+		log.Printf("查询节点健康状态失败: %v", err)
+		return unhealthy
+	}
+	for _, id := range ids {
+		unhealthy[id] = true
+	}
+	return unhealthy
+}
+
+// GetNodeHealthPG 返回所有节点当前的健康状态 - PostgreSQL版本
+func GetNodeHealthPG() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckUserType(c, "admin"); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		records := []model.NodeHealthPG{}
+		if err := database.GetPostgresDB().Order("remark").Find(&records).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			log.Printf("GetNodeHealth: %s", err.Error())
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"policy": nodeHealthPolicy, "nodes": records})
+	}
+}
+
+// GetNodeHealthHistoryPG 返回节点一段时间内的探测记录和统计 - PostgreSQL版本
+func GetNodeHealthHistoryPG() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckUserType(c, "admin"); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		id := helper.SanitizeStr(c.Param("id"))
+		since := nodeHealthHistorySince(c)
+
+		var records []model.NodeHealthSamplePG
+		if err := database.GetPostgresDB().
+			Where("node_id = ? AND checked_at >= ?", id, since).
+			Order("checked_at").
+			Find(&records).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			log.Printf("GetNodeHealthHistory: %s", err.Error())
+			return
+		}
+
+		samples := make([]model.NodeHealthSample, 0, len(records))
+		for _, record := range records {
+			samples = append(samples, model.NodeHealthSample{
+				NodeID:    record.NodeID,
+				Remark:    record.Remark,
+				Probe:     record.Probe,
+				OK:        record.OK,
+				LatencyMs: record.LatencyMs,
+				Error:     record.Error,
+				CheckedAt: record.CheckedAt,
+			})
+		}
+
+		c.JSON(http.StatusOK, gin.H{"node_id": id, "summary": summarizeNodeHealth(samples), "samples": samples})
+	}
+}
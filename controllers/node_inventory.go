@@ -0,0 +1,573 @@
+package controllers
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/xvv6u577/logv2fs/database"
+	helper "github.com/xvv6u577/logv2fs/helpers"
+	"github.com/xvv6u577/logv2fs/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	nodeVersionsCol = database.GetCollection(model.NodeInventoryVersion{})
+
+	// 保留的节点列表历史版本数量，默认 50
+	nodeVersionLimitEnv = os.Getenv("NODE_VERSION_LIMIT")
+)
+
+// nodeTypes 订阅节点支持的类型
+var nodeTypes = []string{"reality", "hysteria2", "vlessCDN", "tuic", "trojan", "ss2022"}
+
+// nodeOrderRequest 调整节点顺序的请求，ids 为全部节点 ID 的新顺序
+type nodeOrderRequest struct {
+	IDs []string `json:"ids" binding:"required"`
+}
+
+// nodeVersionLimit 返回保留的历史版本数量
+func nodeVersionLimit() int {
+	limit, err := strconv.Atoi(nodeVersionLimitEnv)
+	if err != nil || limit <= 0 {
+		return 50
+	}
+	return limit
+}
+
+// normalizeNode 去掉字段首尾空白，检查节点类型和各类型的必填字段
+func normalizeNode(node *Domain) error {
+	for _, field := range []*string{
+		&node.Type, &node.Remark, &node.Domain, &node.IP, &node.SNI, &node.UUID, &node.PATH,
+		&node.SERVER_PORT, &node.PASSWORD, &node.PUBLIC_KEY, &node.SHORT_ID, &node.Region, &node.Fingerprint,
+	} {
+		*field = strings.TrimSpace(*field)
+	}
+	*node = withDefaultRealityKey(*node)
+
+	if !Contains(nodeTypes, node.Type) {
+		return fmt.Errorf("不支持的节点类型: %q", node.Type)
+	}
+	if node.Remark == "" {
+		return fmt.Errorf("节点备注不能为空")
+	}
+	if err := validate.Struct(node); err != nil {
+		return fmt.Errorf("节点 %s 的域名无效: %v", node.Remark, err)
+	}
+	if node.IP == "" {
+		return fmt.Errorf("节点 %s 缺少 IP 地址", node.Remark)
+	}
+	if port, err := strconv.Atoi(node.SERVER_PORT); err != nil || port < 1 || port > 65535 {
+		return fmt.Errorf("节点 %s 的端口无效: %q", node.Remark, node.SERVER_PORT)
+	}
+
+	switch node.Type {
+	case "reality":
+		if key, err := base64.RawURLEncoding.DecodeString(node.PUBLIC_KEY); err != nil || len(key) != 32 {
+			return fmt.Errorf("节点 %s 的 Reality 公钥无效", node.Remark)
+		}
+		for _, id := range node.ShortIDs() {
+			if _, err := hex.DecodeString(id); err != nil || len(id) > 16 {
+				return fmt.Errorf("节点 %s 的 short id 无效: %s", node.Remark, id)
+			}
+		}
+	case "vlessCDN":
+		if _, err := uuid.Parse(node.UUID); err != nil {
+			return fmt.Errorf("节点 %s 的 UUID 无效", node.Remark)
+		}
+		if node.PATH == "" {
+			return fmt.Errorf("节点 %s 缺少路径", node.Remark)
+		}
+	case "ss2022":
+		if _, err := helper.SS2022Method(node.PASSWORD); err != nil {
+			return fmt.Errorf("节点 %s: %v", node.Remark, err)
+		}
+	}
+	return nil
+}
+
+// normalizeNodeList 检查整个节点列表：每个节点有效且备注不重复，没有 ID 的节点分配新 ID，顺序按列表顺序重排
+func normalizeNodeList(nodes []Domain) error {
+	remarks := make(map[string]bool, len(nodes))
+	ids := make(map[string]bool, len(nodes))
+	for i := range nodes {
+		if err := normalizeNode(&nodes[i]); err != nil {
+			return err
+		}
+		if remarks[nodes[i].Remark] {
+			return fmt.Errorf("节点备注重复: %s", nodes[i].Remark)
+		}
+		remarks[nodes[i].Remark] = true
+
+		if _, err := uuid.Parse(nodes[i].ID); err != nil || ids[nodes[i].ID] {
+			nodes[i].ID = uuid.New().String()
+		}
+		ids[nodes[i].ID] = true
+		nodes[i].Position = i
+	}
+	return nil
+}
+
+// findNodeIndex 返回节点在列表中的位置，找不到时返回 -1
+func findNodeIndex(nodes []Domain, id string) int {
+	for i, node := range nodes {
+		if node.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// remarkTaken 判断备注是否已被 ID 不同的节点使用
+func remarkTaken(nodes []Domain, remark, id string) bool {
+	for _, node := range nodes {
+		if node.Remark == remark && node.ID != id {
+			return true
+		}
+	}
+	return false
+}
+
+// reorderNodes 按 ids 的顺序重排节点，ids 必须正好包含所有节点
+func reorderNodes(nodes []Domain, ids []string) ([]Domain, error) {
+	if len(ids) != len(nodes) {
+		return nil, fmt.Errorf("需要提供全部 %d 个节点的 ID，实际为 %d 个", len(nodes), len(ids))
+	}
+	result := make([]Domain, 0, len(nodes))
+	seen := make(map[string]bool, len(ids))
+	for i, id := range ids {
+		index := findNodeIndex(nodes, id)
+		if index < 0 || seen[id] {
+			return nil, fmt.Errorf("节点 ID 无效或重复: %s", id)
+		}
+		seen[id] = true
+		node := nodes[index]
+		node.Position = i
+		result = append(result, node)
+	}
+	return result, nil
+}
+
+// nodeInventoryChange 根据当前的节点列表计算修改后的节点列表和修改说明，在写入前重新读取的列表上执行
+type nodeInventoryChange func(nodes []Domain) ([]Domain, string, error)
+
+// nodeRequestError 节点修改请求本身的错误，例如节点不存在或备注重复，按 status 返回给客户端
+type nodeRequestError struct {
+	status  int
+	message string
+}
+
+func (e *nodeRequestError) Error() string {
+	return e.message
+}
+
+// respondNodeInventoryError 返回修改节点列表失败的响应，请求错误按其状态码返回，其余错误返回 500 并记录日志
+func respondNodeInventoryError(c *gin.Context, err error, action string) {
+	var reqErr *nodeRequestError
+	if errors.As(err, &reqErr) {
+		c.JSON(reqErr.status, gin.H{"error": reqErr.message})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	log.Printf("%s失败: %v", action, err)
+}
+
+// applyNodeInventoryChange 在当前节点列表的副本上执行修改，待启用的 Reality 密钥沿用当前的值
+func applyNodeInventoryChange(current []Domain, change nodeInventoryChange) ([]Domain, string, error) {
+	nodes, action, err := change(append([]Domain(nil), current...))
+	if err != nil {
+		return nil, "", err
+	}
+	model.KeepPendingRealityKeys(current, nodes)
+	return nodes, action, nil
+}
+
+// loadNodeInventory 按顺序读取所有订阅节点，为没有 ID 的旧节点补上 ID - MongoDB版本
+func loadNodeInventory(ctx context.Context) ([]Domain, error) {
+	cur, err := subNodesCol.Find(ctx, bson.M{"$or": []bson.M{{"node_id": bson.M{"$exists": false}}, {"node_id": ""}}})
+	if err != nil {
+		return nil, err
+	}
+	var missing []struct {
+		ObjectID primitive.ObjectID `bson:"_id"`
+	}
+	if err := cur.All(ctx, &missing); err != nil {
+		return nil, err
+	}
+	for _, doc := range missing {
+		if _, err := subNodesCol.UpdateByID(ctx, doc.ObjectID, bson.M{"$set": bson.M{"node_id": uuid.New().String()}}); err != nil {
+			return nil, err
+		}
+	}
+
+	cur, err = subNodesCol.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "position", Value: 1}, {Key: "remark", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	nodes := []Domain{}
+	err = cur.All(ctx, &nodes)
+	return nodes, err
+}
+
+// writeNodeInventory 按 ID 写入节点列表的变化：删除移除的节点，逐个写入新增或有变化的节点，
+// 没有变化的节点不写，不会覆盖其他请求对这些节点的修改 - MongoDB版本
+func writeNodeInventory(ctx context.Context, before, after []Domain) error {
+	changed, removed := model.DiffSubscriptionNodes(before, after)
+	if len(removed) > 0 {
+		if _, err := subNodesCol.DeleteMany(ctx, bson.M{"node_id": bson.M{"$in": removed}}); err != nil {
+			return err
+		}
+	}
+	for _, node := range changed {
+		if _, err := subNodesCol.ReplaceOne(ctx, bson.M{"node_id": node.ID}, node, options.Replace().SetUpsert(true)); err != nil {
+			return fmt.Errorf("写入节点 %s 失败: %v", node.Remark, err)
+		}
+	}
+	return nil
+}
+
+// syncNodeTrafficLogs 为节点列表中的域名创建或激活流量记录，其余域名设为 inactive - MongoDB版本
+func syncNodeTrafficLogs(ctx context.Context, nodes []Domain) error {
+	var current = time.Now().Local()
+	dataCollectableNodes := removeDuplicateDomains(nodes)
+
+	for _, domain := range dataCollectableNodes {
+		filter := bson.M{"domain_as_id": domain.Domain}
+		update := bson.M{
+			"$set": bson.M{
+				"remark":     domain.Remark,
+				"status":     "active",
+				"updated_at": current,
+			},
+			"$setOnInsert": bson.M{
+				"_id":          primitive.NewObjectID(),
+				"domain_as_id": domain.Domain,
+				"created_at":   current,
+				"hourly_logs":  []model.TrafficLogEntry{},
+				"daily_logs":   []model.DailyLogEntry{},
+				"monthly_logs": []model.MonthlyLogEntry{},
+				"yearly_logs":  []model.YearlyLogEntry{},
+			},
+		}
+		if _, err := nodeTrafficLogsCol.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true)); err != nil {
+			return fmt.Errorf("更新节点流量记录失败 (域名: %s): %v", domain.Domain, err)
+		}
+	}
+
+	domainAsIds := make([]string, len(dataCollectableNodes))
+	for i, domain := range dataCollectableNodes {
+		domainAsIds[i] = domain.Domain
+	}
+	inactiveFilter := bson.M{"domain_as_id": bson.M{"$nin": domainAsIds}}
+	inactiveUpdate := bson.M{"$set": bson.M{"status": "inactive"}}
+	if _, err := nodeTrafficLogsCol.UpdateMany(ctx, inactiveFilter, inactiveUpdate); err != nil {
+		return fmt.Errorf("设置节点流量记录为 inactive 失败: %v", err)
+	}
+	return nil
+}
+
+// saveNodeVersion 保存当前节点列表为新的历史版本，并删除超出数量限制的旧版本 - MongoDB版本
+func saveNodeVersion(ctx context.Context, nodes []Domain, action, operator string) (int, error) {
+	var latest model.NodeInventoryVersion
+	err := nodeVersionsCol.FindOne(ctx, bson.M{}, options.FindOne().SetSort(bson.D{{Key: "version", Value: -1}})).Decode(&latest)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return 0, err
+	}
+
+	version := model.NodeInventoryVersion{
+		Version:   latest.Version + 1,
+		Action:    action,
+		Operator:  operator,
+		NodeCount: len(nodes),
+		Nodes:     nodes,
+		CreatedAt: time.Now(),
+	}
+	if _, err := nodeVersionsCol.InsertOne(ctx, version); err != nil {
+		return 0, err
+	}
+	if _, err := nodeVersionsCol.DeleteMany(ctx, bson.M{"version": bson.M{"$lte": version.Version - nodeVersionLimit()}}); err != nil {
+		log.Printf("删除旧的节点列表版本失败: %v", err)
+	}
+	return version.Version, nil
+}
+
+// commitNodeInventory 在事务中重新读取节点列表并执行修改，按 ID 写入变化、同步流量记录并保存历史版本 - MongoDB版本。
+// 历史版本号有唯一索引，并发的修改会写入同一个版本号而发生写冲突，后提交的事务由驱动重试，
+// 重试时读取对方写入后的节点列表，所以修改依次生效，不会互相覆盖
+func commitNodeInventory(ctx context.Context, operator string, change nodeInventoryChange) (int, error) {
+	session, err := database.Client.StartSession()
+	if err != nil {
+		return 0, err
+	}
+	defer session.EndSession(ctx)
+
+	var action string
+	result, err := session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		before, err := loadNodeInventory(sc)
+		if err != nil {
+			return nil, err
+		}
+		var nodes []Domain
+		nodes, action, err = applyNodeInventoryChange(before, change)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := writeNodeInventory(sc, before, nodes); err != nil {
+			return nil, err
+		}
+		if err := syncNodeTrafficLogs(sc, nodes); err != nil {
+			return nil, err
+		}
+		return saveNodeVersion(sc, nodes, action, operator)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	version := result.(int)
+	log.Printf("节点列表已更新到版本 %d: %s (%s)", version, action, operator)
+	return version, nil
+}
+
+// ListNodes 按顺序返回所有订阅节点 - MongoDB版本
+func ListNodes() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckUserType(c, "admin"); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		nodes, err := loadNodeInventory(context.TODO())
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			log.Printf("查询节点失败: %v", err)
+			return
+		}
+		c.JSON(http.StatusOK, nodes)
+	}
+}
+
+// CreateNode 新建节点，添加到列表末尾 - MongoDB版本
+func CreateNode() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckUserType(c, "admin"); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var node Domain
+		if err := c.BindJSON(&node); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := normalizeNode(&node); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		node.ID = uuid.New().String()
+
+		version, err := commitNodeInventory(context.TODO(), c.GetString("email"), func(nodes []Domain) ([]Domain, string, error) {
+			if remarkTaken(nodes, node.Remark, "") {
+				return nil, "", &nodeRequestError{http.StatusBadRequest, "节点备注已存在: " + node.Remark}
+			}
+			node.Position = len(nodes)
+			return append(nodes, node), "create " + node.Remark, nil
+		})
+		if err != nil {
+			respondNodeInventoryError(c, err, "新建节点")
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "节点已创建", "node": node, "version": version})
+	}
+}
+
+// UpdateNode 按 ID 修改节点，顺序不变 - MongoDB版本
+func UpdateNode() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckUserType(c, "admin"); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		id := c.Param("id")
+		var node Domain
+		if err := c.BindJSON(&node); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := normalizeNode(&node); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		node.ID = id
+
+		version, err := commitNodeInventory(context.TODO(), c.GetString("email"), func(nodes []Domain) ([]Domain, string, error) {
+			index := findNodeIndex(nodes, id)
+			if index < 0 {
+				return nil, "", &nodeRequestError{http.StatusNotFound, "节点不存在"}
+			}
+			if remarkTaken(nodes, node.Remark, id) {
+				return nil, "", &nodeRequestError{http.StatusBadRequest, "节点备注已存在: " + node.Remark}
+			}
+			node.Position = nodes[index].Position
+			nodes[index] = node
+			return nodes, "update " + node.Remark, nil
+		})
+		if err != nil {
+			respondNodeInventoryError(c, err, "修改节点")
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "节点已修改", "node": node, "version": version})
+	}
+}
+
+// DeleteNode 按 ID 删除节点 - MongoDB版本
+func DeleteNode() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckUserType(c, "admin"); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		id := c.Param("id")
+		version, err := commitNodeInventory(context.TODO(), c.GetString("email"), func(nodes []Domain) ([]Domain, string, error) {
+			index := findNodeIndex(nodes, id)
+			if index < 0 {
+				return nil, "", &nodeRequestError{http.StatusNotFound, "节点不存在"}
+			}
+			remark := nodes[index].Remark
+			nodes = append(nodes[:index], nodes[index+1:]...)
+			for i := range nodes {
+				nodes[i].Position = i
+			}
+			return nodes, "delete " + remark, nil
+		})
+		if err != nil {
+			respondNodeInventoryError(c, err, "删除节点")
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "节点已删除", "version": version})
+	}
+}
+
+// ReorderNodes 按请求中的 ID 顺序重排节点 - MongoDB版本
+func ReorderNodes() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckUserType(c, "admin"); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var req nodeOrderRequest
+		if err := c.BindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		version, err := commitNodeInventory(context.TODO(), c.GetString("email"), func(nodes []Domain) ([]Domain, string, error) {
+			nodes, err := reorderNodes(nodes, req.IDs)
+			if err != nil {
+				return nil, "", &nodeRequestError{http.StatusBadRequest, err.Error()}
+			}
+			return nodes, "reorder", nil
+		})
+		if err != nil {
+			respondNodeInventoryError(c, err, "调整节点顺序")
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "节点顺序已更新", "version": version})
+	}
+}
+
+// GetNodeVersions 列出节点列表的历史版本，不含节点内容 - MongoDB版本
+func GetNodeVersions() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckUserType(c, "admin"); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		opts := options.Find().SetSort(bson.D{{Key: "version", Value: -1}}).SetProjection(bson.M{"nodes": 0})
+		cur, err := nodeVersionsCol.Find(context.TODO(), bson.M{}, opts)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			log.Printf("查询节点列表版本失败: %v", err)
+			return
+		}
+		versions := []model.NodeInventoryVersion{}
+		if err := cur.All(context.TODO(), &versions); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			log.Printf("查询节点列表版本失败: %v", err)
+			return
+		}
+		c.JSON(http.StatusOK, versions)
+	}
+}
+
+// GetNodeVersion 返回某个历史版本的完整节点列表 - MongoDB版本
+func GetNodeVersion() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckUserType(c, "admin"); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		number, err := strconv.Atoi(c.Param("version"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "版本号无效"})
+			return
+		}
+
+		var version model.NodeInventoryVersion
+		if err := nodeVersionsCol.FindOne(context.TODO(), bson.M{"version": number}).Decode(&version); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "版本不存在"})
+			return
+		}
+		c.JSON(http.StatusOK, version)
+	}
+}
+
+// RollbackNodeVersion 将节点列表恢复到某个历史版本，回滚本身也记录为新版本 - MongoDB版本
+func RollbackNodeVersion() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckUserType(c, "admin"); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		number, err := strconv.Atoi(c.Param("version"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "版本号无效"})
+			return
+		}
+
+		var target model.NodeInventoryVersion
+		if err := nodeVersionsCol.FindOne(context.TODO(), bson.M{"version": number}).Decode(&target); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "版本不存在"})
+			return
+		}
+
+		version, err := commitNodeInventory(context.TODO(), c.GetString("email"), func([]Domain) ([]Domain, string, error) {
+			return target.Nodes, fmt.Sprintf("rollback to %d", number), nil
+		})
+		if err != nil {
+			respondNodeInventoryError(c, err, "回滚节点列表")
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("节点列表已恢复到版本 %d", number), "version": version})
+	}
+}
@@ -0,0 +1,476 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/xvv6u577/logv2fs/database"
+	helper "github.com/xvv6u577/logv2fs/helpers"
+	"github.com/xvv6u577/logv2fs/model"
+	"gorm.io/gorm"
+)
+
+// nodeToPG 将节点转换为PostgreSQL模型，ID 无效时生成新的 ID
+func nodeToPG(node Domain) model.SubscriptionNodePG {
+	id, err := uuid.Parse(node.ID)
+	if err != nil {
+		id = uuid.New()
+	}
+	return model.SubscriptionNodePG{
+		ID:               id,
+		Type:             node.Type,
+		Remark:           node.Remark,
+		Domain:           node.Domain,
+		IP:               node.IP,
+		SNI:              node.SNI,
+		UUID:             node.UUID,
+		Path:             node.PATH,
+		ServerPort:       node.SERVER_PORT,
+		Password:         node.PASSWORD,
+		PublicKey:        node.PUBLIC_KEY,
+		ShortID:          node.SHORT_ID,
+		PendingPublicKey: node.PendingPublicKey,
+		PendingShortID:   node.PendingShortID,
+		EnableOpenai:     node.EnableOpenai,
+		Region:           node.Region,
+		Position:         node.Position,
+		Fingerprint:      node.Fingerprint,
+		VerifyCert:       node.VerifyCert,
+	}
+}
+
+// versionFromPG 将PostgreSQL历史版本转换为API响应格式
+func versionFromPG(pgVersion model.NodeInventoryVersionPG, withNodes bool) model.NodeInventoryVersion {
+	version := model.NodeInventoryVersion{
+		Version:   pgVersion.Version,
+		Action:    pgVersion.Action,
+		Operator:  pgVersion.Operator,
+		NodeCount: pgVersion.NodeCount,
+		CreatedAt: pgVersion.CreatedAt,
+	}
+	if withNodes {
+		if err := json.Unmarshal(pgVersion.Nodes, &version.Nodes); err != nil {
+			log.Printf("解析节点列表版本 %d 失败: %v", pgVersion.Version, err)
+		}
+	}
+	return version
+}
+
+// loadNodeInventoryPG 按顺序读取所有订阅节点 - PostgreSQL版本
+func loadNodeInventoryPG(db *gorm.DB) ([]Domain, error) {
+	var pgNodes []model.SubscriptionNodePG
+	if err := db.Order("position, created_at").Find(&pgNodes).Error; err != nil {
+		return nil, err
+	}
+
+	nodes := make([]Domain, 0, len(pgNodes))
+	for _, pgNode := range pgNodes {
+		nodes = append(nodes, domainFromPG(pgNode))
+	}
+	return nodes, nil
+}
+
+// writeNodeInventoryPG 按 ID 写入节点列表的变化：删除移除的节点，更新有变化的节点，插入新节点，
+// 没有变化的节点不写 - PostgreSQL版本
+func writeNodeInventoryPG(tx *gorm.DB, before, after []Domain) error {
+	changed, removed := model.DiffSubscriptionNodes(before, after)
+	if len(removed) > 0 {
+		if err := tx.Where("id IN ?", removed).Delete(&model.SubscriptionNodePG{}).Error; err != nil {
+			return err
+		}
+	}
+
+	existing := make(map[string]Domain, len(before))
+	for _, node := range before {
+		existing[node.ID] = node
+	}
+	// 备注有唯一索引，先把改名的节点的备注临时改为 ID，避免节点之间互换备注时冲突
+	for _, node := range changed {
+		if old, ok := existing[node.ID]; ok && old.Remark != node.Remark {
+			if err := tx.Model(&model.SubscriptionNodePG{}).Where("id = ?", node.ID).Update("remark", node.ID).Error; err != nil {
+				return err
+			}
+		}
+	}
+
+	current := time.Now()
+	for _, node := range changed {
+		pgNode := nodeToPG(node)
+		pgNode.UpdatedAt = current
+		if _, ok := existing[node.ID]; ok {
+			err := tx.Model(&pgNode).Select("*").Omit("id", "created_at").Updates(&pgNode).Error
+			if err != nil {
+				return fmt.Errorf("更新节点 %s 失败: %v", node.Remark, err)
+			}
+			continue
+		}
+		pgNode.CreatedAt = current
+		if err := tx.Create(&pgNode).Error; err != nil {
+			return fmt.Errorf("写入节点 %s 失败: %v", node.Remark, err)
+		}
+	}
+	return nil
+}
+
+// syncNodeTrafficLogsPG 为节点列表中的域名创建或激活流量记录，其余域名设为 inactive - PostgreSQL版本
+func syncNodeTrafficLogsPG(tx *gorm.DB, nodes []Domain) error {
+	var current = time.Now().Local()
+	dataCollectableNodes := removeDuplicateDomains(nodes)
+
+	domainAsIds := make([]string, 0, len(dataCollectableNodes))
+	for _, domain := range dataCollectableNodes {
+		domainAsIds = append(domainAsIds, domain.Domain)
+
+		var nodeTrafficLog model.NodeTrafficLogsPG
+		result := tx.Where("domain_as_id = ?", domain.Domain).First(&nodeTrafficLog)
+		if result.Error != nil && result.Error != gorm.ErrRecordNotFound {
+			return fmt.Errorf("查找节点流量记录失败: %v", result.Error)
+		}
+
+		if result.Error == gorm.ErrRecordNotFound {
+			emptyHourlyLogs, _ := json.Marshal([]model.TrafficLogEntry{})
+			emptyDailyLogs, _ := json.Marshal([]model.DailyLogEntry{})
+			emptyMonthlyLogs, _ := json.Marshal([]model.MonthlyLogEntry{})
+			emptyYearlyLogs, _ := json.Marshal([]model.YearlyLogEntry{})
+
+			newNodeTrafficLog := model.NodeTrafficLogsPG{
+				ID:          uuid.New(),
+				DomainAsId:  domain.Domain,
+				Remark:      domain.Remark,
+				Status:      "active",
+				CreatedAt:   current,
+				UpdatedAt:   current,
+				HourlyLogs:  emptyHourlyLogs,
+				DailyLogs:   emptyDailyLogs,
+				MonthlyLogs: emptyMonthlyLogs,
+				YearlyLogs:  emptyYearlyLogs,
+			}
+			if err := tx.Create(&newNodeTrafficLog).Error; err != nil {
+				return fmt.Errorf("创建节点流量记录失败 (域名: %s): %v", domain.Domain, err)
+			}
+			continue
+		}
+
+		if err := tx.Model(&nodeTrafficLog).Updates(map[string]interface{}{
+			"remark":     domain.Remark,
+			"status":     "active",
+			"updated_at": current,
+		}).Error; err != nil {
+			return fmt.Errorf("更新节点流量记录失败 (域名: %s): %v", domain.Domain, err)
+		}
+	}
+
+	inactive := tx.Model(&model.NodeTrafficLogsPG{})
+	if len(domainAsIds) > 0 {
+		inactive = inactive.Where("domain_as_id NOT IN ?", domainAsIds)
+	} else {
+		inactive = inactive.Where("1 = 1")
+	}
+	if err := inactive.Updates(map[string]interface{}{"status": "inactive", "updated_at": current}).Error; err != nil {
+		return fmt.Errorf("设置节点流量记录为 inactive 失败: %v", err)
+	}
+	return nil
+}
+
+// saveNodeVersionPG 保存节点列表为新的历史版本，并删除超出数量限制的旧版本 - PostgreSQL版本
+func saveNodeVersionPG(tx *gorm.DB, nodes []Domain, action, operator string) (int, error) {
+	var latest int
+	if err := tx.Model(&model.NodeInventoryVersionPG{}).Select("COALESCE(MAX(version), 0)").Scan(&latest).Error; err != nil {
+		return 0, err
+	}
+
+	data, err := json.Marshal(nodes)
+	if err != nil {
+		return 0, err
+	}
+	version := model.NodeInventoryVersionPG{
+		ID:        uuid.New(),
+		Version:   latest + 1,
+		Action:    action,
+		Operator:  operator,
+		NodeCount: len(nodes),
+		Nodes:     data,
+		CreatedAt: time.Now(),
+	}
+	if err := tx.Create(&version).Error; err != nil {
+		return 0, err
+	}
+	if err := tx.Where("version <= ?", version.Version-nodeVersionLimit()).Delete(&model.NodeInventoryVersionPG{}).Error; err != nil {
+		return 0, err
+	}
+	return version.Version, nil
+}
+
+// commitNodeInventoryPG 在同一事务中锁住节点表、重新读取并执行修改，按 ID 写入变化、同步流量记录并保存历史版本，
+// 任一步失败时全部回滚。并发的修改依次执行，每次都基于最新的节点列表 - PostgreSQL版本
+func commitNodeInventoryPG(operator string, change nodeInventoryChange) (int, error) {
+	var version int
+	var action string
+	err := database.GetPostgresDB().Transaction(func(tx *gorm.DB) error {
+		// EXCLUSIVE 锁只阻塞其他写入，订阅等读取不受影响；SELECT ... FOR UPDATE 锁不住并发新建的节点
+		if err := tx.Exec(`LOCK TABLE "subscription_nodes" IN EXCLUSIVE MODE`).Error; err != nil {
+			return err
+		}
+		before, err := loadNodeInventoryPG(tx)
+		if err != nil {
+			return err
+		}
+		nodes, desc, err := applyNodeInventoryChange(before, change)
+		if err != nil {
+			return err
+		}
+		action = desc
+
+		if err := writeNodeInventoryPG(tx, before, nodes); err != nil {
+			return err
+		}
+		if err := syncNodeTrafficLogsPG(tx, nodes); err != nil {
+			return err
+		}
+		version, err = saveNodeVersionPG(tx, nodes, action, operator)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	log.Printf("节点列表已更新到版本 %d: %s (%s)", version, action, operator)
+	return version, nil
+}
+
+// ListNodesPG 按顺序返回所有订阅节点 - PostgreSQL版本
+func ListNodesPG() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckUserType(c, "admin"); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		nodes, err := loadNodeInventoryPG(database.GetPostgresDB())
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			log.Printf("查询节点失败: %v", err)
+			return
+		}
+		c.JSON(http.StatusOK, nodes)
+	}
+}
+
+// CreateNodePG 新建节点，添加到列表末尾 - PostgreSQL版本
+func CreateNodePG() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckUserType(c, "admin"); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var node Domain
+		if err := c.BindJSON(&node); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := normalizeNode(&node); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		node.ID = uuid.New().String()
+
+		version, err := commitNodeInventoryPG(c.GetString("email"), func(nodes []Domain) ([]Domain, string, error) {
+			if remarkTaken(nodes, node.Remark, "") {
+				return nil, "", &nodeRequestError{http.StatusBadRequest, "节点备注已存在: " + node.Remark}
+			}
+			node.Position = len(nodes)
+			return append(nodes, node), "create " + node.Remark, nil
+		})
+		if err != nil {
+			respondNodeInventoryError(c, err, "新建节点")
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "节点已创建", "node": node, "version": version})
+	}
+}
+
+// UpdateNodePG 按 ID 修改节点，顺序不变 - PostgreSQL版本
+func UpdateNodePG() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckUserType(c, "admin"); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		id := c.Param("id")
+		var node Domain
+		if err := c.BindJSON(&node); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := normalizeNode(&node); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		node.ID = id
+
+		version, err := commitNodeInventoryPG(c.GetString("email"), func(nodes []Domain) ([]Domain, string, error) {
+			index := findNodeIndex(nodes, id)
+			if index < 0 {
+				return nil, "", &nodeRequestError{http.StatusNotFound, "节点不存在"}
+			}
+			if remarkTaken(nodes, node.Remark, id) {
+				return nil, "", &nodeRequestError{http.StatusBadRequest, "节点备注已存在: " + node.Remark}
+			}
+			node.Position = nodes[index].Position
+			nodes[index] = node
+			return nodes, "update " + node.Remark, nil
+		})
+		if err != nil {
+			respondNodeInventoryError(c, err, "修改节点")
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "节点已修改", "node": node, "version": version})
+	}
+}
+
+// DeleteNodePG 按 ID 删除节点 - PostgreSQL版本
+func DeleteNodePG() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckUserType(c, "admin"); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		id := c.Param("id")
+		version, err := commitNodeInventoryPG(c.GetString("email"), func(nodes []Domain) ([]Domain, string, error) {
+			index := findNodeIndex(nodes, id)
+			if index < 0 {
+				return nil, "", &nodeRequestError{http.StatusNotFound, "节点不存在"}
+			}
+			remark := nodes[index].Remark
+			nodes = append(nodes[:index], nodes[index+1:]...)
+			for i := range nodes {
+				nodes[i].Position = i
+			}
+			return nodes, "delete " + remark, nil
+		})
+		if err != nil {
+			respondNodeInventoryError(c, err, "删除节点")
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "节点已删除", "version": version})
+	}
+}
+
+// ReorderNodesPG 按请求中的 ID 顺序重排节点 - PostgreSQL版本
+func ReorderNodesPG() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckUserType(c, "admin"); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var req nodeOrderRequest
+		if err := c.BindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		version, err := commitNodeInventoryPG(c.GetString("email"), func(nodes []Domain) ([]Domain, string, error) {
+			nodes, err := reorderNodes(nodes, req.IDs)
+			if err != nil {
+				return nil, "", &nodeRequestError{http.StatusBadRequest, err.Error()}
+			}
+			return nodes, "reorder", nil
+		})
+		if err != nil {
+			respondNodeInventoryError(c, err, "调整节点顺序")
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "节点顺序已更新", "version": version})
+	}
+}
+
+// GetNodeVersionsPG 列出节点列表的历史版本，不含节点内容 - PostgreSQL版本
+func GetNodeVersionsPG() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckUserType(c, "admin"); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var pgVersions []model.NodeInventoryVersionPG
+		if err := database.GetPostgresDB().Omit("nodes").Order("version DESC").Find(&pgVersions).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			log.Printf("查询节点列表版本失败: %v", err)
+			return
+		}
+
+		versions := make([]model.NodeInventoryVersion, 0, len(pgVersions))
+		for _, pgVersion := range pgVersions {
+			versions = append(versions, versionFromPG(pgVersion, false))
+		}
+		c.JSON(http.StatusOK, versions)
+	}
+}
+
+// GetNodeVersionPG 返回某个历史版本的完整节点列表 - PostgreSQL版本
+func GetNodeVersionPG() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckUserType(c, "admin"); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		number, err := strconv.Atoi(c.Param("version"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "版本号无效"})
+			return
+		}
+
+		var pgVersion model.NodeInventoryVersionPG
+		if err := database.GetPostgresDB().Where("version = ?", number).First(&pgVersion).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "版本不存在"})
+			return
+		}
+		c.JSON(http.StatusOK, versionFromPG(pgVersion, true))
+	}
+}
+
+// RollbackNodeVersionPG 将节点列表恢复到某个历史版本，回滚本身也记录为新版本 - PostgreSQL版本
+func RollbackNodeVersionPG() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckUserType(c, "admin"); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		number, err := strconv.Atoi(c.Param("version"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "版本号无效"})
+			return
+		}
+
+		var pgVersion model.NodeInventoryVersionPG
+		if err := database.GetPostgresDB().Where("version = ?", number).First(&pgVersion).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "版本不存在"})
+			return
+		}
+		target := versionFromPG(pgVersion, true)
+		if len(target.Nodes) != pgVersion.NodeCount {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("版本 %d 的节点列表无法解析", number)})
+			return
+		}
+
+		version, err := commitNodeInventoryPG(c.GetString("email"), func([]Domain) ([]Domain, string, error) {
+			return target.Nodes, fmt.Sprintf("rollback to %d", number), nil
+		})
+		if err != nil {
+			respondNodeInventoryError(c, err, "回滚节点列表")
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("节点列表已恢复到版本 %d", number), "version": version})
+	}
+}
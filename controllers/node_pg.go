@@ -14,14 +14,15 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"github.com/xvv6u577/logv2fs/database"
+	_grpc "github.com/xvv6u577/logv2fs/grpc"
 	helper "github.com/xvv6u577/logv2fs/helpers"
 	"github.com/xvv6u577/logv2fs/model"
-	"gorm.io/gorm"
+	thirdparty "github.com/xvv6u577/logv2fs/pkg"
 )
 
 // PostgreSQL版本的节点操作函数
 
-// AddNodePG 添加节点 - PostgreSQL版本
+// AddNodePG 保存后台提交的完整节点列表：先校验全部节点，再在同一事务中替换节点列表并记录历史版本 - PostgreSQL版本
 func AddNodePG() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		if err := helper.CheckUserType(c, "admin"); err != nil {
@@ -29,166 +30,58 @@ func AddNodePG() gin.HandlerFunc {
 			return
 		}
 
-		var current = time.Now().Local()
 		var nodeFromWebForm []Domain
-		var dataCollectableNodes []Domain
-		db := database.GetPostgresDB()
-
 		if err := c.BindJSON(&nodeFromWebForm); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			log.Printf("BindJSON error: %v", err)
 			return
 		}
 
-		// 移除重复的域名
-		dataCollectableNodes = removeDuplicateDomains(nodeFromWebForm)
-
-		// 第一步：清空整个 subscription_nodes 表格
-		log.Printf("清空 subscription_nodes 表格...")
-		if err := db.Exec(`DELETE FROM "subscription_nodes"`).Error; err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			log.Printf("清空 subscription_nodes 表格失败: %v", err)
+		// 空列表多半是提交出错，删除节点请使用 DELETE /v1/nodes/:id
+		if len(nodeFromWebForm) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "节点列表为空"})
 			return
 		}
-		log.Printf("✅ subscription_nodes 表格已清空")
-
-		// 第二步：逐条插入新提交的数据
-		log.Printf("开始插入 %d 个新节点...", len(nodeFromWebForm))
-		for i, domain := range nodeFromWebForm {
-			// 如果是reality类型，重新分配public_key和short_id
-			if domain.Type == "reality" {
-				domain.PUBLIC_KEY = PUBLIC_KEY
-				domain.SHORT_ID = SHORT_ID
-			}
-
-			// 转换为PostgreSQL模型并生成新的UUID
-			pgDomain := model.SubscriptionNodePG{
-				ID:           uuid.New(), // 为每个节点生成新的UUID
-				Type:         domain.Type,
-				Remark:       domain.Remark,
-				Domain:       domain.Domain,
-				IP:           domain.IP,
-				SNI:          domain.SNI,
-				UUID:         domain.UUID,
-				Path:         domain.PATH,
-				ServerPort:   domain.SERVER_PORT,
-				Password:     domain.PASSWORD,
-				PublicKey:    domain.PUBLIC_KEY,
-				ShortID:      domain.SHORT_ID,
-				EnableOpenai: domain.EnableOpenai,
-				CreatedAt:    current,
-				UpdatedAt:    current,
-			}
-
-			// 直接插入新节点（不需要检查是否存在，因为表格已清空）
-			insertQuery := `INSERT INTO "subscription_nodes" 
-						(id, type, remark, domain, ip, sni, uuid, path, server_port, password, public_key, short_id, enable_openai, created_at, updated_at) 
-						VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)`
-			if err := db.Exec(insertQuery,
-				pgDomain.ID, pgDomain.Type, pgDomain.Remark, pgDomain.Domain, pgDomain.IP,
-				pgDomain.SNI, pgDomain.UUID, pgDomain.Path, pgDomain.ServerPort, pgDomain.Password,
-				pgDomain.PublicKey, pgDomain.ShortID, pgDomain.EnableOpenai, pgDomain.CreatedAt, pgDomain.UpdatedAt).Error; err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-				log.Printf("插入第 %d 个节点失败 (备注: %s): %v", i+1, domain.Remark, err)
-				return
-			}
-			log.Printf("✅ 成功插入节点 [%d/%d]: %s", i+1, len(nodeFromWebForm), domain.Remark)
-		}
-		log.Printf("✅ 所有 %d 个节点插入完成", len(nodeFromWebForm))
-
-		// 处理可收集数据的节点
-		for _, domain := range dataCollectableNodes {
-			// 查找或创建NodeTrafficLogs记录 - 使用GORM方式
-			var nodeTrafficLog model.NodeTrafficLogsPG
-			result := db.Where("domain_as_id = ?", domain.Domain).First(&nodeTrafficLog)
-
-			// 正确处理GORM的ErrRecordNotFound错误
-			if result.Error != nil && result.Error != gorm.ErrRecordNotFound {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": result.Error.Error()})
-				log.Printf("查找节点流量记录失败: %v", result.Error)
-				return
-			}
-
-			isNewRecord := result.Error == gorm.ErrRecordNotFound
-
-			if isNewRecord {
-				// 创建新的NodeTrafficLog记录
-				emptyHourlyLogs, _ := json.Marshal([]model.TrafficLogEntry{})
-				emptyDailyLogs, _ := json.Marshal([]model.DailyLogEntry{})
-				emptyMonthlyLogs, _ := json.Marshal([]model.MonthlyLogEntry{})
-				emptyYearlyLogs, _ := json.Marshal([]model.YearlyLogEntry{})
-
-				newNodeTrafficLog := model.NodeTrafficLogsPG{
-					ID:          uuid.New(),
-					DomainAsId:  domain.Domain,
-					Remark:      domain.Remark,
-					Status:      "active",
-					CreatedAt:   current,
-					UpdatedAt:   current,
-					HourlyLogs:  emptyHourlyLogs,
-					DailyLogs:   emptyDailyLogs,
-					MonthlyLogs: emptyMonthlyLogs,
-					YearlyLogs:  emptyYearlyLogs,
-				}
-
-				// 使用GORM创建新记录
-				if err := db.Create(&newNodeTrafficLog).Error; err != nil {
-					c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-					log.Printf("创建节点流量记录失败 (域名: %s): %v", domain.Domain, err)
-					return
-				}
-				log.Printf("✅ 成功创建节点流量记录: %s (%s)", domain.Domain, domain.Remark)
-			} else {
-				// 如果存在，则更新记录
-				if err := db.Model(&nodeTrafficLog).Updates(map[string]interface{}{
-					"remark":     domain.Remark,
-					"status":     "active",
-					"updated_at": current,
-				}).Error; err != nil {
-					c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-					log.Printf("更新节点流量记录失败 (域名: %s): %v", domain.Domain, err)
-					return
-				}
-				log.Printf("✅ 成功更新节点流量记录: %s (%s)", domain.Domain, domain.Remark)
-			}
+		if err := normalizeNodeList(nodeFromWebForm); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
 		}
 
-		// 将不在dataCollectableNodes中的NodeTrafficLogs状态设置为"inactive"
-		domainAsIds := make([]string, len(dataCollectableNodes))
-		for i, domain := range dataCollectableNodes {
-			domainAsIds[i] = domain.Domain
+		version, err := commitNodeInventoryPG(c.GetString("email"), func([]Domain) ([]Domain, string, error) {
+			return nodeFromWebForm, "bulk save", nil
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			log.Printf("保存节点列表失败: %v", err)
+			return
 		}
 
-		// 使用原始SQL更新不在列表中的节点状态
-		if len(domainAsIds) > 0 {
-			// 构建域名列表的字符串表示
-			placeholders := make([]string, len(domainAsIds))
-			args := make([]interface{}, len(domainAsIds))
-			for i, domain := range domainAsIds {
-				placeholders[i] = fmt.Sprintf("$%d", i+1)
-				args[i] = domain
-			}
-
-			// 使用原始SQL更新不在列表中的节点状态
-			query := fmt.Sprintf(`UPDATE "node_traffic_logs" SET status = 'inactive', updated_at = $%d WHERE domain_as_id NOT IN (%s)`,
-				len(args)+1, strings.Join(placeholders, ","))
-			args = append(args, current)
-
-			if err := db.Exec(query, args...).Error; err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-				log.Printf("Update inactive nodes error: %v", err)
-				return
-			}
-		} else {
-			// 如果没有域名要保留，则将所有节点设为inactive
-			if err := db.Exec(`UPDATE "node_traffic_logs" SET status = 'inactive', updated_at = $1`, current).Error; err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-				log.Printf("Update all nodes to inactive error: %v", err)
-				return
-			}
-		}
+		c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("Congrats! 已成功保存 %d 个节点!", len(nodeFromWebForm)), "version": version})
+	}
+}
 
-		c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("Congrats! 已成功清空表格并插入 %d 个节点!", len(nodeFromWebForm))})
+// domainFromPG 将PostgreSQL节点转换为API响应格式
+func domainFromPG(pgDomain model.SubscriptionNodePG) Domain {
+	return Domain{
+		ID:               pgDomain.ID.String(),
+		Position:         pgDomain.Position,
+		Type:             pgDomain.Type,
+		Remark:           pgDomain.Remark,
+		Domain:           pgDomain.Domain,
+		IP:               pgDomain.IP,
+		SNI:              pgDomain.SNI,
+		UUID:             pgDomain.UUID,
+		PATH:             pgDomain.Path,
+		SERVER_PORT:      pgDomain.ServerPort,
+		PASSWORD:         pgDomain.Password,
+		PUBLIC_KEY:       pgDomain.PublicKey,
+		SHORT_ID:         pgDomain.ShortID,
+		PendingPublicKey: pgDomain.PendingPublicKey,
+		PendingShortID:   pgDomain.PendingShortID,
+		EnableOpenai:     pgDomain.EnableOpenai,
+		Region:           pgDomain.Region,
+		Fingerprint:      pgDomain.Fingerprint,
+		VerifyCert:       pgDomain.VerifyCert,
 	}
 }
 
@@ -203,7 +96,7 @@ func GetActiveGlobalNodesPG() gin.HandlerFunc {
 		db := database.GetPostgresDB()
 		var pgDomains []model.SubscriptionNodePG
 
-		query := `SELECT * FROM "subscription_nodes" WHERE type != 'work'`
+		query := `SELECT * FROM "subscription_nodes" WHERE type != 'work' ORDER BY position, created_at`
 		if err := db.Raw(query).Scan(&pgDomains).Error; err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			log.Printf("Find domains error: %v", err)
@@ -213,20 +106,7 @@ func GetActiveGlobalNodesPG() gin.HandlerFunc {
 		// 转换为API响应格式
 		var activeNodes []Domain
 		for _, pgDomain := range pgDomains {
-			activeNodes = append(activeNodes, Domain{
-				Type:         pgDomain.Type,
-				Remark:       pgDomain.Remark,
-				Domain:       pgDomain.Domain,
-				IP:           pgDomain.IP,
-				SNI:          pgDomain.SNI,
-				UUID:         pgDomain.UUID,
-				PATH:         pgDomain.Path,
-				SERVER_PORT:  pgDomain.ServerPort,
-				PASSWORD:     pgDomain.Password,
-				PUBLIC_KEY:   pgDomain.PublicKey,
-				SHORT_ID:     pgDomain.ShortID,
-				EnableOpenai: pgDomain.EnableOpenai,
-			})
+			activeNodes = append(activeNodes, domainFromPG(pgDomain))
 		}
 
 		c.JSON(http.StatusOK, activeNodes)
@@ -438,9 +318,30 @@ func GetSingboxNodesPG() gin.HandlerFunc {
 			return
 		}
 
+		// 使用 traffic_samples 汇总的流量，尚未回填的时间段使用 JSONB 日志
+		domains := make([]string, 0, len(pgNodeTrafficLogs))
+		for _, pgNode := range pgNodeTrafficLogs {
+			domains = append(domains, pgNode.DomainAsId)
+		}
+		rollups, err := queryTrafficRollupsPG(db, "node", domains)
+		if err != nil {
+			log.Printf("查询节点流量汇总失败: %v", err)
+		}
+
 		// 转换为前端期望的MongoDB格式
 		var mongoFormatNodes []map[string]interface{}
 		for _, pgNode := range pgNodeTrafficLogs {
+			if err == nil {
+				set, ok := rollups[pgNode.DomainAsId]
+				if !ok {
+					set = &TrafficLogSet{}
+				}
+				if mergeErr := set.MergeLogs(pgNode.DailyLogs, pgNode.MonthlyLogs, pgNode.YearlyLogs); mergeErr != nil {
+					log.Printf("合并节点 %s 流量日志失败: %v", pgNode.DomainAsId, mergeErr)
+				} else {
+					pgNode.DailyLogs, pgNode.MonthlyLogs, pgNode.YearlyLogs = set.JSON()
+				}
+			}
 			node := map[string]interface{}{
 				"domain_as_id": pgNode.DomainAsId,
 				"remark":       pgNode.Remark,
@@ -616,3 +517,157 @@ func GetDomainsExpiryInfoPG() gin.HandlerFunc {
 		c.JSON(http.StatusOK, domainInfos)
 	}
 }
+
+// NodeUserSetStatus 节点用户集合状态，包含与数据库活跃用户的差异
+type NodeUserSetStatus struct {
+	DomainAsId   string    `json:"domain_as_id"`
+	Users        []string  `json:"users"`
+	UserCount    int       `json:"user_count"`
+	Checksum     string    `json:"checksum"`
+	SyncedAt     time.Time `json:"synced_at"`
+	InSync       bool      `json:"in_sync"`
+	MissingUsers []string  `json:"missing_users"`
+	ExtraUsers   []string  `json:"extra_users"`
+}
+
+// buildNodeUserSetStatus 比较节点上报的用户集合与数据库中的活跃用户
+func buildNodeUserSetStatus(domain string, served []string, checksum string, syncedAt time.Time, expected []string) NodeUserSetStatus {
+	expectedSet := make(map[string]bool, len(expected))
+	for _, email := range expected {
+		expectedSet[email] = true
+	}
+	servedSet := make(map[string]bool, len(served))
+	for _, email := range served {
+		servedSet[email] = true
+	}
+
+	status := NodeUserSetStatus{
+		DomainAsId:   domain,
+		Users:        served,
+		UserCount:    len(served),
+		Checksum:     checksum,
+		SyncedAt:     syncedAt,
+		MissingUsers: []string{},
+		ExtraUsers:   []string{},
+	}
+	for _, email := range expected {
+		if !servedSet[email] {
+			status.MissingUsers = append(status.MissingUsers, email)
+		}
+	}
+	for _, email := range served {
+		if !expectedSet[email] {
+			status.ExtraUsers = append(status.ExtraUsers, email)
+		}
+	}
+	status.InSync = len(status.MissingUsers) == 0 && len(status.ExtraUsers) == 0
+
+	return status
+}
+
+// GetNodeUserSetsPG 获取各节点当前正在服务的用户集合 - PostgreSQL版本
+func GetNodeUserSetsPG() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckUserType(c, "admin"); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		db := database.GetPostgresDB()
+
+		var userSets []model.NodeUserSetPG
+		if err := db.Order("domain_as_id").Find(&userSets).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			log.Printf("查询节点用户集合失败: %v", err)
+			return
+		}
+
+		var activeUsers []planUser
+		if err := db.Model(&model.UserTrafficLogsPG{}).Select("email_as_id AS email, plan").
+			Where("status = ?", "plain").Scan(&activeUsers).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			log.Printf("查询活跃用户失败: %v", err)
+			return
+		}
+
+		plans, err := loadNodePlansPG(db)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			log.Printf("查询套餐失败: %v", err)
+			return
+		}
+		nodes, err := agentNodesPG(db)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			log.Printf("查询节点列表失败: %v", err)
+			return
+		}
+
+		// 每个节点只应加载套餐包含该节点的用户
+		results := []NodeUserSetStatus{}
+		for _, userSet := range userSets {
+			var served []string
+			if err := json.Unmarshal(userSet.Users, &served); err != nil {
+				log.Printf("解析节点 %s 用户集合失败: %v", userSet.DomainAsId, err)
+			}
+			expected := expectedDomainUsers(userSet.DomainAsId, activeUsers, plans, nodes)
+			results = append(results, buildNodeUserSetStatus(userSet.DomainAsId, served, userSet.Checksum, userSet.SyncedAt, expected))
+		}
+
+		c.JSON(http.StatusOK, results)
+	}
+}
+
+// agentDomainsPG 返回 subscription_nodes 中运行 sing-box 的节点域名 - PostgreSQL版本
+func agentDomainsPG() ([]string, error) {
+	var domains []string
+	err := database.GetPostgresDB().Model(&model.SubscriptionNodePG{}).
+		Where("type IN ?", agentNodeTypes).
+		Distinct().Pluck("domain", &domains).Error
+	return domains, err
+}
+
+// pushUserToNodesPG 将用户变更推送到所有节点 - PostgreSQL版本
+func pushUserToNodesPG(user model.UserTrafficLogsPG, enabled bool) {
+	if !_grpc.Enabled() {
+		return
+	}
+
+	db := database.GetPostgresDB()
+	nodes, err := agentNodesPG(db)
+	if err != nil {
+		log.Printf("查询节点列表失败: %v", err)
+		return
+	}
+	plan, err := findNodePlanPG(db, user.Plan)
+	if err != nil {
+		log.Printf("查询用户 %s 的套餐失败: %v", user.EmailAsId, err)
+		return
+	}
+
+	allowed, denied := planDomains(plan, nodes)
+	go pushUserToNodes(allowed, denied, thirdparty.ProvisionedUser{
+		EmailAsId: user.EmailAsId,
+		UUID:      user.UUID,
+		UserID:    user.UserID,
+	}, enabled)
+}
+
+// CallNodeAgentsPG 调用所有节点的 gRPC 服务 - PostgreSQL版本
+func CallNodeAgentsPG(action string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckUserType(c, "admin"); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		domains, err := agentDomainsPG()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			log.Printf("查询节点列表失败: %v", err)
+			return
+		}
+
+		callNodeAgents(c, action, domains)
+	}
+}
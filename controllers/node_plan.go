@@ -0,0 +1,317 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xvv6u577/logv2fs/database"
+	helper "github.com/xvv6u577/logv2fs/helpers"
+	"github.com/xvv6u577/logv2fs/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var nodePlansCol = database.GetCollection(model.NodePlan{})
+
+// planUser 计算节点应加载用户时需要的用户信息
+type planUser struct {
+	Email string `bson:"email_as_id"`
+	Plan  string `bson:"plan"`
+}
+
+// userPlanRequest 设置用户套餐的请求，plan 为空表示取消套餐
+type userPlanRequest struct {
+	Plan string `json:"plan"`
+}
+
+// normalizeNodePlan 去掉空白和空值，检查名称和节点类型
+func normalizeNodePlan(plan *model.NodePlan) error {
+	clean := func(values []string) []string {
+		result := []string{}
+		for _, value := range values {
+			if value = strings.TrimSpace(value); value != "" {
+				result = append(result, value)
+			}
+		}
+		return result
+	}
+
+	plan.Name = strings.TrimSpace(plan.Name)
+	plan.Types = clean(plan.Types)
+	plan.Regions = clean(plan.Regions)
+	plan.Nodes = clean(plan.Nodes)
+
+	if err := validate.Struct(plan); err != nil {
+		return err
+	}
+	for _, nodeType := range plan.Types {
+		if !Contains(nodeTypes, nodeType) {
+			return fmt.Errorf("不支持的节点类型: %s", nodeType)
+		}
+	}
+	return nil
+}
+
+// planDomains 将运行 sing-box 的节点域名分为套餐包含和不包含的两组，
+// allowed 的值为该域名上套餐允许的节点类型，为空表示不限制
+func planDomains(plan *model.NodePlan, nodes []Domain) (allowed map[string][]string, denied []string) {
+	allowed = make(map[string][]string)
+	seen := make(map[string]bool)
+	for _, node := range nodes {
+		if node.Domain == "" || seen[node.Domain] || !Contains(agentNodeTypes, node.Type) {
+			continue
+		}
+		seen[node.Domain] = true
+
+		if plan == nil {
+			allowed[node.Domain] = nil
+			continue
+		}
+		var types []string
+		for _, nodeType := range plan.DomainTypes(node.Domain, nodes) {
+			if Contains(agentNodeTypes, nodeType) {
+				types = append(types, nodeType)
+			}
+		}
+		if len(types) > 0 {
+			allowed[node.Domain] = types
+		} else {
+			denied = append(denied, node.Domain)
+		}
+	}
+	return allowed, denied
+}
+
+// expectedDomainUsers 返回套餐包含该域名节点的活跃用户
+func expectedDomainUsers(domain string, users []planUser, plans model.NodePlans, nodes []Domain) []string {
+	expected := []string{}
+	for _, user := range users {
+		if plans.Lookup(user.Plan).AllowsDomain(domain, nodes) {
+			expected = append(expected, user.Email)
+		}
+	}
+	sort.Strings(expected)
+	return expected
+}
+
+// findNodePlan 按名称查找套餐，name 为空时返回 nil 表示不限制节点 - MongoDB版本
+func findNodePlan(name string) (*model.NodePlan, error) {
+	if name == "" {
+		return nil, nil
+	}
+
+	var plan model.NodePlan
+	err := nodePlansCol.FindOne(context.TODO(), bson.M{"name": name}).Decode(&plan)
+	if err == mongo.ErrNoDocuments {
+		log.Printf("套餐 %s 不存在，不返回任何节点", name)
+		return model.NodePlans{}.Lookup(name), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &plan, nil
+}
+
+// loadNodePlans 读取所有套餐 - MongoDB版本
+func loadNodePlans(ctx context.Context) (model.NodePlans, error) {
+	cur, err := nodePlansCol.Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+
+	var planList []model.NodePlan
+	if err := cur.All(ctx, &planList); err != nil {
+		return nil, err
+	}
+
+	plans := make(model.NodePlans, len(planList))
+	for _, plan := range planList {
+		plans[plan.Name] = plan
+	}
+	return plans, nil
+}
+
+// agentNodes 返回 subscription_nodes 中运行 sing-box 的节点 - MongoDB版本
+func agentNodes() ([]Domain, error) {
+	cur, err := subNodesCol.Find(context.TODO(), bson.M{"type": bson.M{"$in": agentNodeTypes}})
+	if err != nil {
+		return nil, err
+	}
+
+	var nodes []Domain
+	err = cur.All(context.TODO(), &nodes)
+	return nodes, err
+}
+
+// GetNodePlans 获取所有套餐及使用人数 - MongoDB版本
+func GetNodePlans() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckUserType(c, "admin"); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 60*time.Second)
+		defer cancel()
+
+		cur, err := nodePlansCol.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "name", Value: 1}}))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			log.Printf("查询套餐失败: %v", err)
+			return
+		}
+
+		plans := []model.NodePlan{}
+		if err := cur.All(ctx, &plans); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			log.Printf("查询套餐失败: %v", err)
+			return
+		}
+
+		type planWithUsers struct {
+			model.NodePlan
+			UserCount int64 `json:"user_count"`
+		}
+		results := make([]planWithUsers, 0, len(plans))
+		for _, plan := range plans {
+			count, err := userTrafficLogsCol.CountDocuments(ctx, bson.M{"plan": plan.Name})
+			if err != nil {
+				log.Printf("统计套餐 %s 用户数失败: %v", plan.Name, err)
+			}
+			results = append(results, planWithUsers{NodePlan: plan, UserCount: count})
+		}
+
+		c.JSON(http.StatusOK, results)
+	}
+}
+
+// SaveNodePlan 新建或更新套餐，按名称匹配 - MongoDB版本
+func SaveNodePlan() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckUserType(c, "admin"); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var plan model.NodePlan
+		if err := c.BindJSON(&plan); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := normalizeNodePlan(&plan); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		update := bson.M{
+			"$set": bson.M{
+				"name":        plan.Name,
+				"description": plan.Description,
+				"types":       plan.Types,
+				"regions":     plan.Regions,
+				"nodes":       plan.Nodes,
+				"updated_at":  time.Now(),
+			},
+			"$setOnInsert": bson.M{
+				"created_at": time.Now(),
+			},
+		}
+		if _, err := nodePlansCol.UpdateOne(context.TODO(), bson.M{"name": plan.Name}, update, options.Update().SetUpsert(true)); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			log.Printf("保存套餐失败: %v", err)
+			return
+		}
+
+		log.Printf("套餐 %s 已保存", plan.Name)
+		c.JSON(http.StatusOK, gin.H{"message": "套餐已保存", "plan": plan})
+	}
+}
+
+// DeleteNodePlan 删除套餐，仍有用户使用时拒绝删除 - MongoDB版本
+func DeleteNodePlan() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckUserType(c, "admin"); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		name := helper.SanitizeStr(c.Param("name"))
+
+		count, err := userTrafficLogsCol.CountDocuments(context.TODO(), bson.M{"plan": name})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			log.Printf("统计套餐用户数失败: %v", err)
+			return
+		}
+		if count > 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("仍有 %d 个用户使用套餐 %s", count, name)})
+			return
+		}
+
+		result, err := nodePlansCol.DeleteOne(context.TODO(), bson.M{"name": name})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			log.Printf("删除套餐失败: %v", err)
+			return
+		}
+		if result.DeletedCount == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "套餐不存在"})
+			return
+		}
+
+		log.Printf("套餐 %s 已删除", name)
+		c.JSON(http.StatusOK, gin.H{"message": "套餐已删除"})
+	}
+}
+
+// SetUserPlan 设置用户的套餐，立即推送到节点 - MongoDB版本
+func SetUserPlan() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckUserType(c, "admin"); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		name := helper.SanitizeStr(c.Param("name"))
+
+		var req userPlanRequest
+		if err := c.BindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		req.Plan = strings.TrimSpace(req.Plan)
+
+		if req.Plan != "" {
+			if err := nodePlansCol.FindOne(context.TODO(), bson.M{"name": req.Plan}).Err(); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "套餐不存在: " + req.Plan})
+				return
+			}
+		}
+
+		var updatedUser model.UserTrafficLogs
+		err := userTrafficLogsCol.FindOneAndUpdate(context.TODO(),
+			bson.M{"email_as_id": name},
+			bson.M{"$set": bson.M{"plan": req.Plan, "updated_at": time.Now()}},
+			options.FindOneAndUpdate().SetReturnDocument(options.After),
+		).Decode(&updatedUser)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "user not found"})
+			log.Printf("设置用户 %s 套餐失败: %v", name, err)
+			return
+		}
+
+		if updatedUser.Status == "plain" {
+			pushUserToNodesMongo(updatedUser, true)
+		}
+
+		log.Printf("用户 %s 的套餐已设置为 %q", name, req.Plan)
+		c.JSON(http.StatusOK, gin.H{"message": "套餐已更新", "plan": req.Plan})
+	}
+}
@@ -0,0 +1,229 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+	"github.com/xvv6u577/logv2fs/database"
+	helper "github.com/xvv6u577/logv2fs/helpers"
+	"github.com/xvv6u577/logv2fs/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// findNodePlanPG 按名称查找套餐，name 为空时返回 nil 表示不限制节点 - PostgreSQL版本
+func findNodePlanPG(db *gorm.DB, name string) (*model.NodePlan, error) {
+	if name == "" {
+		return nil, nil
+	}
+
+	var pgPlan model.NodePlanPG
+	err := db.Where("name = ?", name).First(&pgPlan).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		log.Printf("套餐 %s 不存在，不返回任何节点", name)
+		return model.NodePlans{}.Lookup(name), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	plan := pgPlan.ToNodePlan()
+	return &plan, nil
+}
+
+// loadNodePlansPG 读取所有套餐 - PostgreSQL版本
+func loadNodePlansPG(db *gorm.DB) (model.NodePlans, error) {
+	var pgPlans []model.NodePlanPG
+	if err := db.Find(&pgPlans).Error; err != nil {
+		return nil, err
+	}
+
+	plans := make(model.NodePlans, len(pgPlans))
+	for _, plan := range pgPlans {
+		plans[plan.Name] = plan.ToNodePlan()
+	}
+	return plans, nil
+}
+
+// agentNodesPG 返回 subscription_nodes 中运行 sing-box 的节点 - PostgreSQL版本
+func agentNodesPG(db *gorm.DB) ([]Domain, error) {
+	var pgNodes []model.SubscriptionNodePG
+	if err := db.Where("type IN ?", agentNodeTypes).Find(&pgNodes).Error; err != nil {
+		return nil, err
+	}
+
+	nodes := make([]Domain, 0, len(pgNodes))
+	for _, pgNode := range pgNodes {
+		nodes = append(nodes, domainFromPG(pgNode))
+	}
+	return nodes, nil
+}
+
+// GetNodePlansPG 获取所有套餐及使用人数 - PostgreSQL版本
+func GetNodePlansPG() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckUserType(c, "admin"); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		db := database.GetPostgresDB()
+
+		var pgPlans []model.NodePlanPG
+		if err := db.Order("name").Find(&pgPlans).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			log.Printf("查询套餐失败: %v", err)
+			return
+		}
+
+		var counts []struct {
+			Plan  string
+			Count int64
+		}
+		if err := db.Model(&model.UserTrafficLogsPG{}).Select("plan, COUNT(*) AS count").
+			Where("plan <> ''").Group("plan").Scan(&counts).Error; err != nil {
+			log.Printf("统计套餐用户数失败: %v", err)
+		}
+		userCounts := make(map[string]int64, len(counts))
+		for _, count := range counts {
+			userCounts[count.Plan] = count.Count
+		}
+
+		type planWithUsers struct {
+			model.NodePlan
+			UserCount int64 `json:"user_count"`
+		}
+		results := make([]planWithUsers, 0, len(pgPlans))
+		for _, pgPlan := range pgPlans {
+			results = append(results, planWithUsers{NodePlan: pgPlan.ToNodePlan(), UserCount: userCounts[pgPlan.Name]})
+		}
+
+		c.JSON(http.StatusOK, results)
+	}
+}
+
+// SaveNodePlanPG 新建或更新套餐，按名称匹配 - PostgreSQL版本
+func SaveNodePlanPG() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckUserType(c, "admin"); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var plan model.NodePlan
+		if err := c.BindJSON(&plan); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := normalizeNodePlan(&plan); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		pgPlan := model.NodePlanPG{
+			Name:        plan.Name,
+			Description: plan.Description,
+			Types:       pq.StringArray(plan.Types),
+			Regions:     pq.StringArray(plan.Regions),
+			Nodes:       pq.StringArray(plan.Nodes),
+		}
+		err := database.GetPostgresDB().Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "name"}},
+			DoUpdates: clause.AssignmentColumns([]string{"description", "types", "regions", "nodes", "updated_at"}),
+		}).Create(&pgPlan).Error
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			log.Printf("保存套餐失败: %v", err)
+			return
+		}
+
+		log.Printf("套餐 %s 已保存", plan.Name)
+		c.JSON(http.StatusOK, gin.H{"message": "套餐已保存", "plan": plan})
+	}
+}
+
+// DeleteNodePlanPG 删除套餐，仍有用户使用时拒绝删除 - PostgreSQL版本
+func DeleteNodePlanPG() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckUserType(c, "admin"); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		db := database.GetPostgresDB()
+		name := helper.SanitizeStr(c.Param("name"))
+
+		var count int64
+		if err := db.Model(&model.UserTrafficLogsPG{}).Where("plan = ?", name).Count(&count).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			log.Printf("统计套餐用户数失败: %v", err)
+			return
+		}
+		if count > 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("仍有 %d 个用户使用套餐 %s", count, name)})
+			return
+		}
+
+		result := db.Where("name = ?", name).Delete(&model.NodePlanPG{})
+		if result.Error != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": result.Error.Error()})
+			log.Printf("删除套餐失败: %v", result.Error)
+			return
+		}
+		if result.RowsAffected == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "套餐不存在"})
+			return
+		}
+
+		log.Printf("套餐 %s 已删除", name)
+		c.JSON(http.StatusOK, gin.H{"message": "套餐已删除"})
+	}
+}
+
+// SetUserPlanPG 设置用户的套餐，立即推送到节点 - PostgreSQL版本
+func SetUserPlanPG() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckUserType(c, "admin"); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		db := database.GetPostgresDB()
+		name := helper.SanitizeStr(c.Param("name"))
+
+		var req userPlanRequest
+		if err := c.BindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		req.Plan = strings.TrimSpace(req.Plan)
+
+		if req.Plan != "" {
+			if err := db.Where("name = ?", req.Plan).First(&model.NodePlanPG{}).Error; err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "套餐不存在: " + req.Plan})
+				return
+			}
+		}
+
+		result := db.Model(&model.UserTrafficLogsPG{}).Where("email_as_id = ?", name).Update("plan", req.Plan)
+		if result.Error != nil || result.RowsAffected == 0 {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "user not found"})
+			log.Printf("设置用户 %s 套餐失败: %v", name, result.Error)
+			return
+		}
+
+		var pgUser model.UserTrafficLogsPG
+		db.Where("email_as_id = ?", name).First(&pgUser)
+		if pgUser.Status == "plain" {
+			pushUserToNodesPG(pgUser, true)
+		}
+
+		log.Printf("用户 %s 的套餐已设置为 %q", name, req.Plan)
+		c.JSON(http.StatusOK, gin.H{"message": "套餐已更新", "plan": req.Plan})
+	}
+}
@@ -0,0 +1,127 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xvv6u577/logv2fs/database"
+	helper "github.com/xvv6u577/logv2fs/helpers"
+	"github.com/xvv6u577/logv2fs/model"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// 节点在线状态
+const (
+	NodeStatusOnline  = "online"
+	NodeStatusStale   = "stale"   // 超过 NODE_HEARTBEAT_STALE 没有心跳
+	NodeStatusMissing = "missing" // 节点列表中有该节点，但从未收到心跳
+)
+
+var (
+	// 超过该时间没有心跳的节点视为失联，默认 3 分钟
+	nodeHeartbeatStale = os.Getenv("NODE_HEARTBEAT_STALE")
+	nodeHeartbeatsCol  = database.GetCollection(model.NodeHeartbeat{})
+)
+
+// NodeStatus 节点的心跳和在线状态
+type NodeStatus struct {
+	model.NodeHeartbeat
+	Status          string `json:"status"`
+	LastSeenSeconds int64  `json:"last_seen_seconds"` // 距离上一次心跳的秒数，没有心跳时为 -1
+}
+
+// nodeHeartbeatStaleAfter 返回判定节点失联的时间
+func nodeHeartbeatStaleAfter() time.Duration {
+	d, err := time.ParseDuration(nodeHeartbeatStale)
+	if err != nil || d <= 0 {
+		return 3 * time.Minute
+	}
+	return d
+}
+
+// buildNodeStatuses 合并节点心跳和节点列表中运行 sing-box 的域名，按域名排序；now 为数据库的当前时间
+func buildNodeStatuses(heartbeats []model.NodeHeartbeat, domains []string, now time.Time) []NodeStatus {
+	staleAfter := nodeHeartbeatStaleAfter()
+
+	seen := make(map[string]bool, len(heartbeats))
+	statuses := make([]NodeStatus, 0, len(heartbeats)+len(domains))
+	for _, heartbeat := range heartbeats {
+		seen[heartbeat.Domain_As_Id] = true
+
+		lastSeen := now.Sub(heartbeat.ReportedAt)
+		status := NodeStatusOnline
+		if lastSeen > staleAfter {
+			status = NodeStatusStale
+		}
+		statuses = append(statuses, NodeStatus{
+			NodeHeartbeat:   heartbeat,
+			Status:          status,
+			LastSeenSeconds: int64(lastSeen.Seconds()),
+		})
+	}
+
+	for _, domain := range domains {
+		if seen[domain] {
+			continue
+		}
+		seen[domain] = true
+		statuses = append(statuses, NodeStatus{
+			NodeHeartbeat:   model.NodeHeartbeat{Domain_As_Id: domain, Inbounds: []model.NodeInbound{}},
+			Status:          NodeStatusMissing,
+			LastSeenSeconds: -1,
+		})
+	}
+
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Domain_As_Id < statuses[j].Domain_As_Id })
+	return statuses
+}
+
+// GetNodeStatus 返回各节点的心跳和在线状态，失联和从未上报的节点也会列出
+func GetNodeStatus() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckUserType(c, "admin"); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		cur, err := nodeHeartbeatsCol.Find(ctx, bson.M{})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			log.Printf("查询节点心跳失败: %v", err)
+			return
+		}
+		var heartbeats []model.NodeHeartbeat
+		if err := cur.All(ctx, &heartbeats); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			log.Printf("查询节点心跳失败: %v", err)
+			return
+		}
+
+		domains, err := agentDomains()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			log.Printf("查询节点列表失败: %v", err)
+			return
+		}
+
+		// reported_at 是数据库时间，与数据库的当前时间比较，不受控制面时钟影响
+		var server struct {
+			LocalTime time.Time `bson:"localTime"`
+		}
+		if err := nodeHeartbeatsCol.Database().RunCommand(ctx, bson.D{{Key: "isMaster", Value: 1}}).Decode(&server); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			log.Printf("查询数据库时间失败: %v", err)
+			return
+		}
+
+		c.JSON(http.StatusOK, buildNodeStatuses(heartbeats, domains, server.LocalTime))
+	}
+}
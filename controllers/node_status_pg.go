@@ -0,0 +1,77 @@
+package controllers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xvv6u577/logv2fs/database"
+	helper "github.com/xvv6u577/logv2fs/helpers"
+	"github.com/xvv6u577/logv2fs/model"
+)
+
+// nodeHeartbeatFromPG 将 PostgreSQL 的心跳记录转换为通用结构
+func nodeHeartbeatFromPG(record model.NodeHeartbeatPG) model.NodeHeartbeat {
+	inbounds := []model.NodeInbound{}
+	if len(record.Inbounds) > 0 {
+		if err := json.Unmarshal(record.Inbounds, &inbounds); err != nil {
+			log.Printf("解析节点 %s 的 inbound 列表失败: %v", record.DomainAsId, err)
+		}
+	}
+
+	return model.NodeHeartbeat{
+		Domain_As_Id:   record.DomainAsId,
+		Hostname:       record.Hostname,
+		Version:        record.Version,
+		CoreVersion:    record.CoreVersion,
+		StartedAt:      record.StartedAt,
+		UptimeSeconds:  record.UptimeSeconds,
+		UserCount:      record.UserCount,
+		Inbounds:       inbounds,
+		LastFlushAt:    record.LastFlushAt,
+		PendingSamples: record.PendingSamples,
+		OldestPending:  record.OldestPending,
+		ReportedAt:     record.ReportedAt,
+	}
+}
+
+// GetNodeStatusPG 返回各节点的心跳和在线状态 - PostgreSQL版本
+func GetNodeStatusPG() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckUserType(c, "admin"); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var records []model.NodeHeartbeatPG
+		if err := database.GetPostgresDB().Find(&records).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			log.Printf("查询节点心跳失败: %v", err)
+			return
+		}
+
+		heartbeats := make([]model.NodeHeartbeat, 0, len(records))
+		for _, record := range records {
+			heartbeats = append(heartbeats, nodeHeartbeatFromPG(record))
+		}
+
+		domains, err := agentDomainsPG()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			log.Printf("查询节点列表失败: %v", err)
+			return
+		}
+
+		// reported_at 是数据库时间，与数据库的当前时间比较，不受控制面时钟影响
+		var now time.Time
+		if err := database.GetPostgresDB().Raw("SELECT NOW()").Scan(&now).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			log.Printf("查询数据库时间失败: %v", err)
+			return
+		}
+
+		c.JSON(http.StatusOK, buildNodeStatuses(heartbeats, domains, now))
+	}
+}
@@ -9,9 +9,11 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	_cron "github.com/xvv6u577/logv2fs/cron"
 	"github.com/xvv6u577/logv2fs/database"
 	helper "github.com/xvv6u577/logv2fs/helpers"
 	"github.com/xvv6u577/logv2fs/model"
+	"github.com/xvv6u577/logv2fs/notify"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -119,15 +121,62 @@ func AddPaymentRecord() gin.HandlerFunc {
 			return
 		}
 
+		reactivated := reactivatePaidUser(paymentRecord)
+		notifyPaymentCreated(paymentRecord.UserEmailAsId, paymentRecord.Amount, paymentRecord.StartDate, paymentRecord.EndDate)
+
 		c.JSON(http.StatusOK, gin.H{
 			"message":      "缴费记录添加成功",
 			"payment_id":   paymentRecord.ID,
 			"service_days": serviceDays,
 			"daily_amount": dailyAmount,
+			"reactivated":  reactivated,
 		})
 	}
 }
 
+// reactivatePaidUser 新缴费覆盖今天时，将因缴费到期停用的用户恢复为 plain - MongoDB版本
+// 流量仍然超额的用户保持 overdue，原因改为 quota，充值流量后恢复
+func reactivatePaidUser(payment model.PaymentRecord) bool {
+	if !_cron.PaymentCovers(payment.StartDate, payment.EndDate, time.Now()) {
+		return false
+	}
+
+	var ctx, cancel = context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	var user model.UserTrafficLogs
+	if err := userTrafficLogsCol.FindOne(ctx, bson.M{"email_as_id": payment.UserEmailAsId}).Decode(&user); err != nil {
+		log.Printf("查找缴费用户失败: %v", err)
+		return false
+	}
+
+	if user.Status != "overdue" || user.OverdueReason != model.OverdueReasonPayment {
+		return false
+	}
+
+	if _cron.QuotaExceeded(user.Used, user.Credit) {
+		if _, err := userTrafficLogsCol.UpdateOne(ctx,
+			bson.M{"email_as_id": user.Email_As_Id},
+			bson.M{"$set": bson.M{"overdue_reason": model.OverdueReasonQuota, "updated_at": time.Now()}},
+		); err != nil {
+			log.Printf("更新用户 %s 的 overdue 原因失败: %v", user.Email_As_Id, err)
+		}
+		return false
+	}
+
+	if _, err := userTrafficLogsCol.UpdateOne(ctx,
+		bson.M{"email_as_id": user.Email_As_Id},
+		bson.M{"$set": bson.M{"status": "plain", "overdue_reason": "", "updated_at": time.Now()}},
+	); err != nil {
+		log.Printf("恢复缴费用户 %s 失败: %v", user.Email_As_Id, err)
+		return false
+	}
+
+	log.Printf("用户 %s 已缴费，状态恢复为 plain", user.Email_As_Id)
+	pushUserToNodesMongo(user, true)
+	return true
+}
+
 // GetUserPayments 获取用户缴费记录
 func GetUserPayments() gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -739,6 +788,16 @@ func getInt64(v interface{}) int64 {
 	}
 }
 
+// notifyPaymentCreated 新缴费记录通知用户和管理员
+func notifyPaymentCreated(user string, amount float64, startDate, endDate time.Time) {
+	go notify.Send(notify.Event{
+		Kind:    notify.EventPaymentCreated,
+		Subject: "新缴费记录: " + user,
+		Message: fmt.Sprintf("金额 %.2f，服务期 %s 至 %s", amount, startDate.Format("2006-01-02"), endDate.Format("2006-01-02")),
+		User:    user,
+	})
+}
+
 // 获取用户名
 func getUserNameByEmail(email string) string {
 	// 从users集合查询用户名
@@ -9,6 +9,7 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	_cron "github.com/xvv6u577/logv2fs/cron"
 	"github.com/xvv6u577/logv2fs/database"
 	helper "github.com/xvv6u577/logv2fs/helpers"
 	"github.com/xvv6u577/logv2fs/model"
@@ -126,15 +127,56 @@ func AddPaymentRecordPG() gin.HandlerFunc {
 			return
 		}
 
+		reactivated := reactivatePaidUserPG(paymentRecord)
+		notifyPaymentCreated(paymentRecord.UserEmailAsId, paymentRecord.Amount, paymentRecord.StartDate, paymentRecord.EndDate)
+
 		c.JSON(http.StatusOK, gin.H{
 			"message":      "缴费记录添加成功",
 			"payment_id":   paymentRecord.ID,
 			"service_days": serviceDays,
 			"daily_amount": dailyAmount,
+			"reactivated":  reactivated,
 		})
 	}
 }
 
+// reactivatePaidUserPG 新缴费覆盖今天时，将因缴费到期停用的用户恢复为 plain - PostgreSQL版本
+// 流量仍然超额的用户保持 overdue，原因改为 quota，充值流量后恢复
+func reactivatePaidUserPG(payment model.PaymentRecordPG) bool {
+	if !_cron.PaymentCovers(payment.StartDate, payment.EndDate, time.Now()) {
+		return false
+	}
+
+	db := database.GetPostgresDB()
+	var pgUser model.UserTrafficLogsPG
+	if err := db.Where("email_as_id = ?", payment.UserEmailAsId).First(&pgUser).Error; err != nil {
+		log.Printf("查找缴费用户失败: %v", err)
+		return false
+	}
+
+	if pgUser.Status != "overdue" || pgUser.OverdueReason != model.OverdueReasonPayment {
+		return false
+	}
+
+	if _cron.QuotaExceeded(pgUser.Used, pgUser.Credit) {
+		if err := db.Model(&model.UserTrafficLogsPG{}).Where("email_as_id = ?", pgUser.EmailAsId).
+			Updates(map[string]interface{}{"overdue_reason": model.OverdueReasonQuota, "updated_at": time.Now()}).Error; err != nil {
+			log.Printf("更新用户 %s 的 overdue 原因失败: %v", pgUser.EmailAsId, err)
+		}
+		return false
+	}
+
+	if err := db.Model(&model.UserTrafficLogsPG{}).Where("email_as_id = ?", pgUser.EmailAsId).
+		Updates(map[string]interface{}{"status": "plain", "overdue_reason": "", "updated_at": time.Now()}).Error; err != nil {
+		log.Printf("恢复缴费用户 %s 失败: %v", pgUser.EmailAsId, err)
+		return false
+	}
+
+	log.Printf("用户 %s 已缴费，状态恢复为 plain", pgUser.EmailAsId)
+	pushUserToNodesPG(pgUser, true)
+	return true
+}
+
 // 创建每日分摊记录 - PostgreSQL版本
 func CreateDailyAllocationsPG(tx *gorm.DB, paymentRecordID uuid.UUID, payment model.PaymentRecordPG) error {
 	// 生成从开始日期到结束日期的每日分摊记录
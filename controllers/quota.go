@@ -0,0 +1,156 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	_cron "github.com/xvv6u577/logv2fs/cron"
+	helper "github.com/xvv6u577/logv2fs/helpers"
+	"github.com/xvv6u577/logv2fs/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// CreditRequest 重置或充值用户流量配额的请求
+type CreditRequest struct {
+	Action string `json:"action" binding:"required,oneof=reset topup"` // reset: 清零已用流量; topup: 增加配额
+	Credit int64  `json:"credit" binding:"min=0"`                      // reset 时为新的配额（0 表示保持不变），topup 时为增加的流量
+}
+
+// validateCreditRequest 检查充值请求的流量是否有效
+func validateCreditRequest(req CreditRequest) error {
+	if req.Action == "topup" && req.Credit <= 0 {
+		return fmt.Errorf("credit must be greater than 0")
+	}
+	return nil
+}
+
+// creditUpdateMongo 生成重置或充值的原子更新：重置只把 used 清零，充值只增加 credit，不回写之前读到的值
+func creditUpdateMongo(req CreditRequest) bson.M {
+	if req.Action == "topup" {
+		return bson.M{
+			"$inc": bson.M{"credit": req.Credit},
+			"$set": bson.M{"updated_at": time.Now()},
+		}
+	}
+
+	set := bson.M{"used": int64(0), "quota_warned": 0, "updated_at": time.Now()}
+	if req.Credit > 0 {
+		set["credit"] = req.Credit
+	}
+	return bson.M{"$set": set}
+}
+
+// quotaRecoveredFilterMongo 匹配因流量超额停用且配额已恢复的用户，恢复时以此做条件更新
+func quotaRecoveredFilterMongo(name string) bson.M {
+	return bson.M{
+		"email_as_id":    name,
+		"status":         "overdue",
+		"overdue_reason": model.OverdueReasonQuota,
+		"$expr": bson.M{"$or": bson.A{
+			bson.M{"$lte": bson.A{"$credit", 0}},
+			bson.M{"$lt": bson.A{"$used", "$credit"}},
+		}},
+	}
+}
+
+// quotaRecovered 判断写入后的用户是否可能需要恢复；最终以条件更新的结果为准
+func quotaRecovered(status, reason string, used, credit int64) bool {
+	return status == "overdue" && reason == model.OverdueReasonQuota && !_cron.QuotaExceeded(used, credit)
+}
+
+// UpdateUserCredit 重置或充值用户流量配额 - MongoDB版本
+// 因流量超额而处于 overdue 的用户在配额恢复后自动启用
+func UpdateUserCredit() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckUserType(c, "admin"); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 60*time.Second)
+		defer cancel()
+
+		name := helper.SanitizeStr(c.Param("name"))
+
+		var req CreditRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := validateCreditRequest(req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var user model.UserTrafficLogs
+		err := userTrafficLogsCol.FindOneAndUpdate(ctx,
+			bson.M{"email_as_id": name},
+			creditUpdateMongo(req),
+			options.FindOneAndUpdate().SetReturnDocument(options.After),
+		).Decode(&user)
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			log.Printf("UpdateUserCredit - user not found: %s", name)
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			log.Printf("error updating credit: %v", err)
+			return
+		}
+
+		// 充值后预警等级可能降低，用 $min 只降不升，期间新增的流量由定时任务重新预警
+		if level := _cron.QuotaWarnLevel(user.Used, user.Credit); user.QuotaWarned > level {
+			if _, err := userTrafficLogsCol.UpdateOne(ctx,
+				bson.M{"email_as_id": name},
+				bson.M{"$min": bson.M{"quota_warned": level}},
+			); err != nil {
+				log.Printf("更新用户 %s 流量预警等级失败: %v", name, err)
+			}
+		}
+
+		// 只恢复因流量超额停用的用户，缴费到期的用户需要缴费后恢复
+		if quotaRecovered(user.Status, user.OverdueReason, user.Used, user.Credit) {
+			// 流量恢复后如果缴费已到期，改为按缴费到期停用，缴费后再恢复
+			lapsed, err := _cron.PaymentLapsed(name)
+			switch {
+			case err != nil:
+				log.Printf("查询用户 %s 缴费状态失败，保持 overdue: %v", name, err)
+			case lapsed:
+				if _, err := userTrafficLogsCol.UpdateOne(ctx,
+					quotaRecoveredFilterMongo(name),
+					bson.M{"$set": bson.M{"overdue_reason": model.OverdueReasonPayment}},
+				); err != nil {
+					log.Printf("更新用户 %s 停用原因失败: %v", name, err)
+				}
+			default:
+				var reactivated model.UserTrafficLogs
+				err := userTrafficLogsCol.FindOneAndUpdate(ctx,
+					quotaRecoveredFilterMongo(name),
+					bson.M{"$set": bson.M{"status": "plain", "overdue_reason": ""}},
+					options.FindOneAndUpdate().SetReturnDocument(options.After),
+				).Decode(&reactivated)
+				if err == nil {
+					user = reactivated
+					pushUserToNodesMongo(user, true)
+				} else if err != mongo.ErrNoDocuments {
+					log.Printf("恢复用户 %s 失败: %v", name, err)
+				}
+			}
+		}
+
+		log.Printf("User %s credit updated: action=%s used=%d credit=%d", name, req.Action, user.Used, user.Credit)
+		c.JSON(http.StatusOK, gin.H{
+			"message": "User " + user.Name + " credit updated successfully",
+			"used":    user.Used,
+			"credit":  user.Credit,
+			"status":  user.Status,
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package controllers
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	_cron "github.com/xvv6u577/logv2fs/cron"
+	"github.com/xvv6u577/logv2fs/database"
+	helper "github.com/xvv6u577/logv2fs/helpers"
+	"github.com/xvv6u577/logv2fs/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// UpdateUserCreditPG 重置或充值用户流量配额 - PostgreSQL版本
+// 因流量超额而处于 overdue 的用户在配额恢复后自动启用
+func UpdateUserCreditPG() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckUserType(c, "admin"); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		db := database.GetPostgresDB()
+		name := helper.SanitizeStr(c.Param("name"))
+
+		var req CreditRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := validateCreditRequest(req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		// 重置只把 used 清零，充值只增加 credit，不回写之前读到的值
+		updates := map[string]interface{}{"updated_at": time.Now()}
+		if req.Action == "topup" {
+			updates["credit"] = gorm.Expr("credit + ?", req.Credit)
+		} else {
+			updates["used"] = 0
+			updates["quota_warned"] = 0
+			if req.Credit > 0 {
+				updates["credit"] = req.Credit
+			}
+		}
+
+		var pgUser model.UserTrafficLogsPG
+		result := db.Model(&pgUser).Clauses(clause.Returning{}).Where("email_as_id = ?", name).Updates(updates)
+		if result.Error != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": result.Error.Error()})
+			log.Printf("error updating credit: %v", result.Error)
+			return
+		}
+		if result.RowsAffected == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			log.Printf("UpdateUserCreditPG - user not found: %s", name)
+			return
+		}
+
+		// 充值后预警等级可能降低，用 LEAST 只降不升，期间新增的流量由定时任务重新预警
+		if level := _cron.QuotaWarnLevel(pgUser.Used, pgUser.Credit); pgUser.QuotaWarned > level {
+			if err := db.Model(&model.UserTrafficLogsPG{}).Where("email_as_id = ?", name).
+				Update("quota_warned", gorm.Expr("LEAST(quota_warned, ?)", level)).Error; err != nil {
+				log.Printf("更新用户 %s 流量预警等级失败: %v", name, err)
+			}
+		}
+
+		// 只恢复因流量超额停用的用户，缴费到期的用户需要缴费后恢复
+		if quotaRecovered(pgUser.Status, pgUser.OverdueReason, pgUser.Used, pgUser.Credit) {
+			recovered := db.Model(&model.UserTrafficLogsPG{}).
+				Where("email_as_id = ? AND status = ? AND overdue_reason = ?", name, "overdue", model.OverdueReasonQuota).
+				Where("credit <= 0 OR used < credit")
+
+			// 流量恢复后如果缴费已到期，改为按缴费到期停用，缴费后再恢复
+			lapsed, err := _cron.PaymentLapsed(name)
+			switch {
+			case err != nil:
+				log.Printf("查询用户 %s 缴费状态失败，保持 overdue: %v", name, err)
+			case lapsed:
+				if err := recovered.Update("overdue_reason", model.OverdueReasonPayment).Error; err != nil {
+					log.Printf("更新用户 %s 停用原因失败: %v", name, err)
+				}
+			default:
+				result := recovered.Updates(map[string]interface{}{
+					"status":         "plain",
+					"overdue_reason": "",
+				})
+				if result.Error != nil {
+					log.Printf("恢复用户 %s 失败: %v", name, result.Error)
+				} else if result.RowsAffected > 0 {
+					db.Where("email_as_id = ?", name).First(&pgUser)
+					pushUserToNodesPG(pgUser, true)
+				}
+			}
+		}
+
+		log.Printf("User %s credit updated: action=%s used=%d credit=%d", name, req.Action, pgUser.Used, pgUser.Credit)
+		c.JSON(http.StatusOK, gin.H{
+			"message": "User " + pgUser.Name + " credit updated successfully",
+			"used":    pgUser.Used,
+			"credit":  pgUser.Credit,
+			"status":  pgUser.Status,
+		})
+	}
+}
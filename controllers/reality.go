@@ -0,0 +1,280 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xvv6u577/logv2fs/database"
+	helper "github.com/xvv6u577/logv2fs/helpers"
+	"github.com/xvv6u577/logv2fs/model"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// withDefaultRealityKey reality 节点没有设置公钥时使用环境变量 PUBLIC_KEY 和 SHORT_ID，兼容旧的全局配置
+func withDefaultRealityKey(node Domain) Domain {
+	if node.Type == "reality" && node.PUBLIC_KEY == "" {
+		node.PUBLIC_KEY = PUBLIC_KEY
+		if node.SHORT_ID == "" {
+			node.SHORT_ID = SHORT_ID
+		}
+	}
+	return node
+}
+
+// realityKeyResult 生成密钥的结果，私钥只在响应中返回，不保存到数据库，公钥和 short id 启用前只保存为待启用
+type realityKeyResult struct {
+	PrivateKey   string                 `json:"private_key"`
+	PublicKey    string                 `json:"public_key"`
+	ShortID      string                 `json:"short_id"`
+	ServerConfig map[string]interface{} `json:"server_config"`
+}
+
+// newRealityKey 为节点生成新的 x25519 密钥对，节点已有 short id 时保留，否则生成一个
+func newRealityKey(node Domain) (realityKeyResult, error) {
+	privateKey, publicKey, err := helper.GenerateRealityKeyPair()
+	if err != nil {
+		return realityKeyResult{}, err
+	}
+
+	shortID := node.SHORT_ID
+	if len(node.ShortIDs()) == 0 {
+		if shortID, err = helper.GenerateShortID(); err != nil {
+			return realityKeyResult{}, err
+		}
+	}
+
+	node.PUBLIC_KEY = publicKey
+	node.SHORT_ID = shortID
+	return realityKeyResult{
+		PrivateKey:   privateKey,
+		PublicKey:    publicKey,
+		ShortID:      shortID,
+		ServerConfig: realityInboundConfig(node, privateKey),
+	}, nil
+}
+
+// realityInboundConfig 生成与节点参数对应的 sing-box VLESS Reality inbound 配置，用户由程序写入
+func realityInboundConfig(node Domain, privateKey string) map[string]interface{} {
+	port, _ := strconv.Atoi(node.SERVER_PORT)
+	return map[string]interface{}{
+		"type":        "vless",
+		"tag":         "vless-in",
+		"listen":      "::",
+		"listen_port": port,
+		"users":       []interface{}{},
+		"tls": map[string]interface{}{
+			"enabled":     true,
+			"server_name": node.TLSServerName(),
+			"reality": map[string]interface{}{
+				"enabled": true,
+				"handshake": map[string]interface{}{
+					"server":      node.TLSServerName(),
+					"server_port": 443,
+				},
+				"private_key": privateKey,
+				"short_id":    node.ShortIDs(),
+			},
+		},
+	}
+}
+
+// realityActivateRequest 启用待启用密钥的请求，public_key 必须与生成时返回的公钥一致
+type realityActivateRequest struct {
+	PublicKey string `json:"public_key" binding:"required"`
+}
+
+// GenerateRealityKey 为 reality 节点生成新的密钥对，公钥保存为待启用，返回私钥和服务端配置 - MongoDB版本
+// 节点当前的公钥保持不变，节点换上新私钥后再调用 ActivateRealityKey 启用
+func GenerateRealityKey() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckUserType(c, "admin"); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		remark := c.Param("remark")
+
+		var node Domain
+		if err := subNodesCol.FindOne(context.TODO(), bson.M{"remark": remark}).Decode(&node); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "节点不存在: " + remark})
+			return
+		}
+		if node.Type != "reality" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "只有 reality 节点需要生成密钥"})
+			return
+		}
+
+		result, err := newRealityKey(node)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			log.Printf("生成 Reality 密钥失败: %v", err)
+			return
+		}
+
+		update := bson.M{"$set": bson.M{"pending_public_key": result.PublicKey, "pending_short_id": result.ShortID}}
+		if _, err := subNodesCol.UpdateOne(context.TODO(), bson.M{"remark": remark}, update); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			log.Printf("保存待启用的 Reality 公钥失败: %v", err)
+			return
+		}
+
+		log.Printf("节点 %s 已生成新的 Reality 密钥，等待启用", remark)
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+// ActivateRealityKey 启用节点待启用的 Reality 公钥和 short id - MongoDB版本
+func ActivateRealityKey() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckUserType(c, "admin"); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		remark := c.Param("remark")
+
+		var req realityActivateRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var node Domain
+		if err := subNodesCol.FindOne(context.TODO(), bson.M{"remark": remark}).Decode(&node); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "节点不存在: " + remark})
+			return
+		}
+		if node.PendingPublicKey == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "节点没有待启用的密钥"})
+			return
+		}
+		if node.PendingPublicKey != req.PublicKey {
+			c.JSON(http.StatusConflict, gin.H{"error": "公钥与待启用的密钥不一致，请重新生成"})
+			return
+		}
+
+		// 按待启用的公钥匹配，避免期间重新生成的密钥被误启用
+		filter := bson.M{"remark": remark, "pending_public_key": req.PublicKey}
+		update := bson.M{
+			"$set":   bson.M{"public_key": node.PendingPublicKey, "short_id": node.PendingShortID},
+			"$unset": bson.M{"pending_public_key": "", "pending_short_id": ""},
+		}
+		result, err := subNodesCol.UpdateOne(context.TODO(), filter, update)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			log.Printf("启用 Reality 公钥失败: %v", err)
+			return
+		}
+		if result.MatchedCount == 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "公钥与待启用的密钥不一致，请重新生成"})
+			return
+		}
+
+		log.Printf("节点 %s 已启用新的 Reality 密钥", remark)
+		c.JSON(http.StatusOK, gin.H{"message": "密钥已启用", "public_key": node.PendingPublicKey, "short_id": node.PendingShortID})
+	}
+}
+
+// GenerateRealityKeyPG 为 reality 节点生成新的密钥对，公钥保存为待启用，返回私钥和服务端配置 - PostgreSQL版本
+// 节点当前的公钥保持不变，节点换上新私钥后再调用 ActivateRealityKeyPG 启用
+func GenerateRealityKeyPG() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckUserType(c, "admin"); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		db := database.GetPostgresDB()
+		remark := c.Param("remark")
+
+		var pgNode model.SubscriptionNodePG
+		if err := db.Where("remark = ?", remark).First(&pgNode).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "节点不存在: " + remark})
+			return
+		}
+		if pgNode.Type != "reality" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "只有 reality 节点需要生成密钥"})
+			return
+		}
+
+		result, err := newRealityKey(domainFromPG(pgNode))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			log.Printf("生成 Reality 密钥失败: %v", err)
+			return
+		}
+
+		if err := db.Model(&pgNode).Updates(map[string]interface{}{
+			"pending_public_key": result.PublicKey,
+			"pending_short_id":   result.ShortID,
+			"updated_at":         time.Now(),
+		}).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			log.Printf("保存待启用的 Reality 公钥失败: %v", err)
+			return
+		}
+
+		log.Printf("节点 %s 已生成新的 Reality 密钥，等待启用", remark)
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+// ActivateRealityKeyPG 启用节点待启用的 Reality 公钥和 short id - PostgreSQL版本
+func ActivateRealityKeyPG() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckUserType(c, "admin"); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		db := database.GetPostgresDB()
+		remark := c.Param("remark")
+
+		var req realityActivateRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var pgNode model.SubscriptionNodePG
+		if err := db.Where("remark = ?", remark).First(&pgNode).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "节点不存在: " + remark})
+			return
+		}
+		if pgNode.PendingPublicKey == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "节点没有待启用的密钥"})
+			return
+		}
+		if pgNode.PendingPublicKey != req.PublicKey {
+			c.JSON(http.StatusConflict, gin.H{"error": "公钥与待启用的密钥不一致，请重新生成"})
+			return
+		}
+
+		// 按待启用的公钥匹配，避免期间重新生成的密钥被误启用
+		result := db.Model(&model.SubscriptionNodePG{}).
+			Where("remark = ? AND pending_public_key = ?", remark, req.PublicKey).
+			Updates(map[string]interface{}{
+				"public_key":         pgNode.PendingPublicKey,
+				"short_id":           pgNode.PendingShortID,
+				"pending_public_key": "",
+				"pending_short_id":   "",
+				"updated_at":         time.Now(),
+			})
+		if result.Error != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": result.Error.Error()})
+			log.Printf("启用 Reality 公钥失败: %v", result.Error)
+			return
+		}
+		if result.RowsAffected == 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "公钥与待启用的密钥不一致，请重新生成"})
+			return
+		}
+
+		log.Printf("节点 %s 已启用新的 Reality 密钥", remark)
+		c.JSON(http.StatusOK, gin.H{"message": "密钥已启用", "public_key": pgNode.PendingPublicKey, "short_id": pgNode.PendingShortID})
+	}
+}
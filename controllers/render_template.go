@@ -0,0 +1,175 @@
+package controllers
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/gin-gonic/gin"
+	helper "github.com/xvv6u577/logv2fs/helpers"
+	"github.com/xvv6u577/logv2fs/render"
+)
+
+// renderTemplateFiles 可在后台编辑的订阅模板，键为订阅格式
+var renderTemplateFiles = map[string]string{
+	subscriptionFormatSingbox: "render_singbox.json",
+}
+
+// renderTemplatePath 返回订阅模板文件路径
+func renderTemplatePath(format string) (string, bool) {
+	file, ok := renderTemplateFiles[format]
+	if !ok {
+		return "", false
+	}
+	return helper.CurrentPath() + "/config/" + file, true
+}
+
+// renderNodes 将节点转换为渲染用的节点信息，SNI 和指纹为空时按节点类型填入默认值，跳过密钥无效的 Shadowsocks 2022 节点
+func renderNodes(nodes []Domain) []render.Node {
+	result := make([]render.Node, 0, len(nodes))
+	for _, node := range nodes {
+		if node.Type == "ss2022" {
+			if _, err := helper.SS2022Method(node.PASSWORD); err != nil {
+				log.Printf("节点 %s 的 Shadowsocks 2022 密钥无效: %v", node.Remark, err)
+				continue
+			}
+		}
+
+		result = append(result, render.Node{
+			Type:         node.Type,
+			Remark:       node.Remark,
+			Server:       helper.FormatIPForURL(node.IP),
+			IP:           node.IP,
+			Domain:       node.Domain,
+			Port:         node.SERVER_PORT,
+			UUID:         node.UUID,
+			Path:         node.PATH,
+			SNI:          node.TLSServerName(),
+			Fingerprint:  node.ClientFingerprint(),
+			Insecure:     node.SkipCertVerify(),
+			PublicKey:    node.PUBLIC_KEY,
+			ShortID:      node.ClientShortID(),
+			ServerKey:    node.PASSWORD,
+			Region:       node.RegionName(),
+			EnableOpenai: node.EnableOpenai,
+		})
+	}
+	return result
+}
+
+// renderSingboxConfig 按 config/render_singbox.json 生成用户的 sing-box 配置
+func renderSingboxConfig(nodes []Domain, uuid, userID string) (map[string]interface{}, error) {
+	path, _ := renderTemplatePath(subscriptionFormatSingbox)
+	template, err := render.LoadTemplate(path)
+	if err != nil {
+		return nil, err
+	}
+	return template.Render(renderNodes(nodes), render.User{UUID: uuid, Password: userID})
+}
+
+// singboxHandler 查找用户和节点后按模板生成 sing-box 配置，非正常状态的用户返回 config/error.json
+func singboxHandler(load func(c *gin.Context) (subscriptionTarget, bool)) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		target, ok := load(c)
+		if !ok {
+			return
+		}
+
+		if target.Status != "plain" {
+			var singboxJSON = SingboxJSON{}
+			jsonFile, err := os.ReadFile(helper.CurrentPath() + "/config/error.json")
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				log.Printf("error: %v", err)
+				return
+			}
+			if err := json.Unmarshal(jsonFile, &singboxJSON); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				log.Printf("error: %v", err)
+				return
+			}
+			c.JSON(http.StatusOK, singboxJSON)
+			return
+		}
+
+		config, err := renderSingboxConfig(target.Nodes, target.UUID, target.UserID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			log.Printf("ReturnSingboxJson error: %v", err)
+			return
+		}
+
+		c.JSON(http.StatusOK, config)
+	}
+}
+
+// GetRenderTemplate 读取订阅模板，仅管理员可用
+func GetRenderTemplate() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckUserType(c, "admin"); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		path, ok := renderTemplatePath(c.Param("format"))
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": "没有该格式的订阅模板"})
+			return
+		}
+
+		content, err := os.ReadFile(path)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			log.Printf("GetRenderTemplate error: %v", err)
+			return
+		}
+
+		c.Data(http.StatusOK, "application/json; charset=utf-8", content)
+	}
+}
+
+// UpdateRenderTemplate 校验并保存订阅模板，保存后下一次订阅请求即生效，仅管理员可用
+func UpdateRenderTemplate() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckUserType(c, "admin"); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		format := c.Param("format")
+		path, ok := renderTemplatePath(format)
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": "没有该格式的订阅模板"})
+			return
+		}
+
+		content, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		// 校验失败时不覆盖原模板
+		if _, err := render.ParseTemplate(content, helper.CurrentPath()+"/config"); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		tmpPath := path + ".tmp"
+		if err := os.WriteFile(tmpPath, content, 0644); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			log.Printf("UpdateRenderTemplate error: %v", err)
+			return
+		}
+		if err := os.Rename(tmpPath, path); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			log.Printf("UpdateRenderTemplate error: %v", err)
+			return
+		}
+
+		log.Printf("订阅模板 %s 已更新", format)
+		c.JSON(http.StatusOK, gin.H{"message": "订阅模板已更新"})
+	}
+}
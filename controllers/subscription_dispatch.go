@@ -0,0 +1,113 @@
+package controllers
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 订阅格式
+const (
+	subscriptionFormatBase64  = "base64"
+	subscriptionFormatSingbox = "singbox"
+	subscriptionFormatVerge   = "verge"
+	subscriptionFormatClash   = "clash"
+	subscriptionFormatSurge   = "surge"
+	subscriptionFormatQuanX   = "quanx"
+	subscriptionFormatLoon    = "loon"
+)
+
+// userAgentFormats 按 User-Agent 关键字（小写）识别客户端，按顺序匹配，越具体的关键字越靠前
+var userAgentFormats = []struct {
+	keyword string
+	format  string
+}{
+	{"sing-box", subscriptionFormatSingbox},
+	{"clash-verge", subscriptionFormatVerge},
+	{"clash verge", subscriptionFormatVerge},
+	{"mihomo", subscriptionFormatClash},
+	{"clash.meta", subscriptionFormatClash},
+	{"clashmeta", subscriptionFormatClash},
+	{"stash", subscriptionFormatClash},
+	{"clash", subscriptionFormatClash},
+	{"shadowrocket", subscriptionFormatBase64},
+	{"quantumult", subscriptionFormatQuanX},
+	{"surge", subscriptionFormatSurge},
+	{"loon", subscriptionFormatLoon},
+	{"v2rayn", subscriptionFormatBase64}, // 同时匹配 v2rayNG
+}
+
+// subscriptionFormatAliases ?format= 参数支持的别名
+var subscriptionFormatAliases = map[string]string{
+	"base64":       subscriptionFormatBase64,
+	"static":       subscriptionFormatBase64,
+	"shadowrocket": subscriptionFormatBase64,
+	"v2rayn":       subscriptionFormatBase64,
+	"singbox":      subscriptionFormatSingbox,
+	"sing-box":     subscriptionFormatSingbox,
+	"verge":        subscriptionFormatVerge,
+	"clash":        subscriptionFormatClash,
+	"mihomo":       subscriptionFormatClash,
+	"meta":         subscriptionFormatClash,
+	"surge":        subscriptionFormatSurge,
+	"quanx":        subscriptionFormatQuanX,
+	"quantumultx":  subscriptionFormatQuanX,
+	"loon":         subscriptionFormatLoon,
+}
+
+// detectSubscriptionFormat 优先使用 format 参数，否则按 User-Agent 判断，都无法识别时返回 base64
+func detectSubscriptionFormat(format, userAgent string) (string, bool) {
+	if format != "" {
+		detected, ok := subscriptionFormatAliases[strings.ToLower(format)]
+		return detected, ok
+	}
+
+	userAgent = strings.ToLower(userAgent)
+	for _, item := range userAgentFormats {
+		if strings.Contains(userAgent, item.keyword) {
+			return item.format, true
+		}
+	}
+	return subscriptionFormatBase64, true
+}
+
+// subscriptionDispatcher 根据客户端选择订阅格式，交给对应的处理函数
+func subscriptionDispatcher(handlers map[string]gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		format, ok := detectSubscriptionFormat(c.Query("format"), c.GetHeader("User-Agent"))
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "不支持的订阅格式: " + c.Query("format")})
+			return
+		}
+
+		c.Header("Vary", "User-Agent")
+		handlers[format](c)
+	}
+}
+
+// ReturnSubscription 统一订阅入口，根据 User-Agent 或 format 参数返回对应格式，只接受订阅令牌
+func ReturnSubscription() gin.HandlerFunc {
+	return subscriptionDispatcher(map[string]gin.HandlerFunc{
+		subscriptionFormatBase64:  base64SubscriptionHandler(loadSubscriptionTarget),
+		subscriptionFormatSingbox: singboxHandler(loadSubscriptionTarget),
+		subscriptionFormatVerge:   vergeHandler(loadSubscriptionTarget),
+		subscriptionFormatClash:   ReturnClashMetaYAML(),
+		subscriptionFormatSurge:   ReturnSurgeConfig(),
+		subscriptionFormatQuanX:   ReturnQuantumultXConfig(),
+		subscriptionFormatLoon:    ReturnLoonConfig(),
+	})
+}
+
+// ReturnSubscriptionPG 统一订阅入口 - PostgreSQL版本
+func ReturnSubscriptionPG() gin.HandlerFunc {
+	return subscriptionDispatcher(map[string]gin.HandlerFunc{
+		subscriptionFormatBase64:  base64SubscriptionHandler(loadSubscriptionTargetPG),
+		subscriptionFormatSingbox: singboxHandler(loadSubscriptionTargetPG),
+		subscriptionFormatVerge:   vergeHandler(loadSubscriptionTargetPG),
+		subscriptionFormatClash:   ReturnClashMetaYAMLPG(),
+		subscriptionFormatSurge:   ReturnSurgeConfigPG(),
+		subscriptionFormatQuanX:   ReturnQuantumultXConfigPG(),
+		subscriptionFormatLoon:    ReturnLoonConfigPG(),
+	})
+}
@@ -0,0 +1,174 @@
+package controllers
+
+import (
+	"context"
+	b64 "encoding/base64"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	helper "github.com/xvv6u577/logv2fs/helpers"
+	"github.com/xvv6u577/logv2fs/subscription"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// subscriptionTarget 生成订阅所需的用户信息和节点列表
+type subscriptionTarget struct {
+	Status string
+	UUID   string
+	UserID string
+	Nodes  []Domain
+}
+
+// subscriptionRenderer 将节点列表渲染为客户端配置文本
+type subscriptionRenderer func(c *gin.Context, target subscriptionTarget) (string, error)
+
+// subscriptionURL 返回当前请求的完整地址，用于托管配置的更新链接
+func subscriptionURL(c *gin.Context) string {
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+	if proto := c.GetHeader("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	}
+	return scheme + "://" + c.Request.Host + c.Request.URL.RequestURI()
+}
+
+// renderSurgeProfile 用 config/template_surge.conf 生成 Surge 托管配置
+func renderSurgeProfile(c *gin.Context, target subscriptionTarget) (string, error) {
+	template, err := os.ReadFile(helper.CurrentPath() + "/config/template_surge.conf")
+	if err != nil {
+		return "", err
+	}
+	return subscription.SurgeProfile(string(template), subscriptionURL(c), profileUpdateInterval()*3600,
+		target.Nodes, target.UUID, target.UserID), nil
+}
+
+// renderQuantumultXServers 生成 Quantumult X 的节点资源 (server_remote)
+func renderQuantumultXServers(c *gin.Context, target subscriptionTarget) (string, error) {
+	return subscription.QuantumultXServers(target.Nodes, target.UUID, target.UserID), nil
+}
+
+// renderLoonNodes 生成 Loon 的节点订阅
+func renderLoonNodes(c *gin.Context, target subscriptionTarget) (string, error) {
+	return subscription.LoonNodes(target.Nodes, target.UUID, target.UserID), nil
+}
+
+// base64SubscriptionHandler 查找用户和节点后返回 base64 编码的节点链接，非正常状态的用户返回 config/error.txt
+func base64SubscriptionHandler(load func(c *gin.Context) (subscriptionTarget, bool)) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		target, ok := load(c)
+		if !ok {
+			return
+		}
+
+		var content []byte
+		if target.Status == "plain" {
+			var links []string
+			for _, node := range target.Nodes {
+				if link, ok := subscription.Base64Link(node, target.UUID, target.UserID); ok {
+					links = append(links, link)
+				}
+			}
+			content = []byte(b64.StdEncoding.EncodeToString([]byte(strings.Join(links, "\n"))))
+		} else {
+			var err error
+			content, err = os.ReadFile(helper.CurrentPath() + "/config/error.txt")
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				log.Printf("GetSubscripionURL error: %v", err)
+				return
+			}
+		}
+
+		c.Data(http.StatusOK, "text/plain", content)
+	}
+}
+
+// textSubscriptionHandler 查找用户和节点后用 render 生成文本订阅，非正常状态的用户得到空节点列表
+func textSubscriptionHandler(handlerName string, load func(c *gin.Context) (subscriptionTarget, bool), render subscriptionRenderer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		target, ok := load(c)
+		if !ok {
+			return
+		}
+		if target.Status != "plain" {
+			target.Nodes = nil
+		}
+
+		content, err := render(c, target)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			log.Printf("%s error: %v", handlerName, err)
+			return
+		}
+
+		c.Data(http.StatusOK, "text/plain; charset=utf-8", []byte(content))
+	}
+}
+
+// loadSubscriptionTarget 按订阅令牌查找用户和用户套餐中的节点，写入订阅响应头；失败时已写入错误响应
+func loadSubscriptionTarget(c *gin.Context) (subscriptionTarget, bool) {
+	return loadSubscriptionTargetBy(c, false)
+}
+
+// loadLegacySubscriptionTarget 旧版 /static、/singbox、/verge 路由使用，令牌找不到时在 LEGACY_SUBSCRIPTION_UNTIL 之前按邮箱查找
+func loadLegacySubscriptionTarget(c *gin.Context) (subscriptionTarget, bool) {
+	return loadSubscriptionTargetBy(c, true)
+}
+
+func loadSubscriptionTargetBy(c *gin.Context, legacy bool) (subscriptionTarget, bool) {
+	name := helper.SanitizeStr(c.Param("name"))
+
+	var projections = bson.D{
+		{Key: "status", Value: 1},
+		{Key: "user_id", Value: 1},
+		{Key: "uuid", Value: 1},
+		{Key: "plan", Value: 1},
+	}
+	user, err := findSubscriptionUser(name, append(projections, subscriptionUserinfoProjections...), legacy)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		log.Printf("loadSubscriptionTarget failed: %s", err.Error())
+		return subscriptionTarget{}, false
+	}
+	setSubscriptionHeaders(c, subscriptionUserinfo(user))
+
+	var activeGlobalNodes []Domain
+	cur, err := subNodesCol.Find(context.TODO(), bson.D{}, options.Find().SetSort(bson.D{{Key: "position", Value: 1}, {Key: "remark", Value: 1}}))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while getting active global nodes"})
+		log.Printf("Getting active global nodes error: %s", err.Error())
+		return subscriptionTarget{}, false
+	}
+	defer cur.Close(context.Background())
+	cur.All(context.Background(), &activeGlobalNodes)
+
+	plan, err := findNodePlan(user.Plan)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		log.Printf("Getting node plan error: %s", err.Error())
+		return subscriptionTarget{}, false
+	}
+
+	return subscriptionTarget{Status: user.Status, UUID: user.UUID, UserID: user.User_id, Nodes: applyNodeHealth(plan.FilterNodes(activeGlobalNodes), unhealthyNodeIDs())}, true
+}
+
+// ReturnSurgeConfig 返回 Surge 托管配置
+func ReturnSurgeConfig() gin.HandlerFunc {
+	return textSubscriptionHandler("ReturnSurgeConfig", loadSubscriptionTarget, renderSurgeProfile)
+}
+
+// ReturnQuantumultXConfig 返回 Quantumult X 节点资源
+func ReturnQuantumultXConfig() gin.HandlerFunc {
+	return textSubscriptionHandler("ReturnQuantumultXConfig", loadSubscriptionTarget, renderQuantumultXServers)
+}
+
+// ReturnLoonConfig 返回 Loon 节点订阅
+func ReturnLoonConfig() gin.HandlerFunc {
+	return textSubscriptionHandler("ReturnLoonConfig", loadSubscriptionTarget, renderLoonNodes)
+}
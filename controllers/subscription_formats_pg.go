@@ -0,0 +1,70 @@
+package controllers
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xvv6u577/logv2fs/database"
+	helper "github.com/xvv6u577/logv2fs/helpers"
+	"github.com/xvv6u577/logv2fs/model"
+)
+
+// loadSubscriptionTargetPG 按订阅令牌查找用户和用户套餐中的节点，写入订阅响应头；失败时已写入错误响应 - PostgreSQL版本
+func loadSubscriptionTargetPG(c *gin.Context) (subscriptionTarget, bool) {
+	return loadSubscriptionTargetByPG(c, false)
+}
+
+// loadLegacySubscriptionTargetPG 旧版 /static、/singbox、/verge 路由使用，令牌找不到时在 LEGACY_SUBSCRIPTION_UNTIL 之前按邮箱查找 - PostgreSQL版本
+func loadLegacySubscriptionTargetPG(c *gin.Context) (subscriptionTarget, bool) {
+	return loadSubscriptionTargetByPG(c, true)
+}
+
+func loadSubscriptionTargetByPG(c *gin.Context, legacy bool) (subscriptionTarget, bool) {
+	name := helper.SanitizeStr(c.Param("name"))
+	db := database.GetPostgresDB()
+
+	pgUser, err := findSubscriptionUserPG(db, name, "status, user_id, uuid, plan, "+subscriptionUserinfoColumnsPG, legacy)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		log.Printf("loadSubscriptionTarget failed: %s", err.Error())
+		return subscriptionTarget{}, false
+	}
+	setSubscriptionHeaders(c, subscriptionUserinfoPG(db, pgUser))
+
+	var pgNodes []model.SubscriptionNodePG
+	if err := db.Where("type != ?", "work").Order("position, created_at").Find(&pgNodes).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while getting active global nodes"})
+		log.Printf("Getting active global nodes error: %s", err.Error())
+		return subscriptionTarget{}, false
+	}
+
+	nodes := make([]Domain, 0, len(pgNodes))
+	for _, pgNode := range pgNodes {
+		nodes = append(nodes, domainFromPG(pgNode))
+	}
+
+	plan, err := findNodePlanPG(db, pgUser.Plan)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		log.Printf("Getting node plan error: %s", err.Error())
+		return subscriptionTarget{}, false
+	}
+
+	return subscriptionTarget{Status: pgUser.Status, UUID: pgUser.UUID, UserID: pgUser.UserID, Nodes: applyNodeHealth(plan.FilterNodes(nodes), unhealthyNodeIDsPG(db))}, true
+}
+
+// ReturnSurgeConfigPG 返回 Surge 托管配置 - PostgreSQL版本
+func ReturnSurgeConfigPG() gin.HandlerFunc {
+	return textSubscriptionHandler("ReturnSurgeConfig", loadSubscriptionTargetPG, renderSurgeProfile)
+}
+
+// ReturnQuantumultXConfigPG 返回 Quantumult X 节点资源 - PostgreSQL版本
+func ReturnQuantumultXConfigPG() gin.HandlerFunc {
+	return textSubscriptionHandler("ReturnQuantumultXConfig", loadSubscriptionTargetPG, renderQuantumultXServers)
+}
+
+// ReturnLoonConfigPG 返回 Loon 节点订阅 - PostgreSQL版本
+func ReturnLoonConfigPG() gin.HandlerFunc {
+	return textSubscriptionHandler("ReturnLoonConfig", loadSubscriptionTargetPG, renderLoonNodes)
+}
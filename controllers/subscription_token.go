@@ -0,0 +1,114 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	helper "github.com/xvv6u577/logv2fs/helpers"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// 旧版邮箱订阅链接的有效期：日期 (2006-01-02) 表示当天结束后停用，不设置或格式错误时不允许邮箱访问
+var legacySubscriptionUntil = os.Getenv("LEGACY_SUBSCRIPTION_UNTIL")
+
+var (
+	errSubscriptionNotFound      = errors.New("订阅链接无效，请在用户面板复制新的订阅链接")
+	errLegacySubscriptionExpired = errors.New("邮箱订阅链接已停用，请在用户面板复制新的订阅链接")
+)
+
+// legacySubscriptionAllowed 判断是否仍允许通过邮箱访问旧版订阅路由
+func legacySubscriptionAllowed(now time.Time) bool {
+	if legacySubscriptionUntil == "" {
+		return false
+	}
+
+	until, err := time.ParseInLocation("2006-01-02", legacySubscriptionUntil, time.Local)
+	if err != nil {
+		log.Printf("LEGACY_SUBSCRIPTION_UNTIL 格式错误，邮箱订阅链接停用: %s", legacySubscriptionUntil)
+		return false
+	}
+	return now.Before(until.AddDate(0, 0, 1))
+}
+
+// findSubscriptionUser 按订阅令牌查找用户。legacy 为 true 时（只有旧版 /static、/singbox、/verge 路由）
+// 令牌找不到后在允许的情况下按邮箱查找
+func findSubscriptionUser(key string, projections bson.D, legacy bool) (UserTrafficLogs, error) {
+	var user UserTrafficLogs
+	opts := options.FindOne().SetProjection(projections)
+
+	err := userTrafficLogsCol.FindOne(context.TODO(), bson.M{"subscription_token": key}, opts).Decode(&user)
+	if err != mongo.ErrNoDocuments {
+		return user, err
+	}
+
+	if !legacy {
+		return user, errSubscriptionNotFound
+	}
+	if !legacySubscriptionAllowed(time.Now()) {
+		return user, errLegacySubscriptionExpired
+	}
+
+	err = userTrafficLogsCol.FindOne(context.TODO(), bson.M{"email_as_id": key}, opts).Decode(&user)
+	if err == nil {
+		log.Printf("通过邮箱访问订阅: %s", key)
+	}
+	return user, err
+}
+
+// ensureSubscriptionToken 返回用户的订阅令牌，还没有令牌时生成一个
+func ensureSubscriptionToken(email, current string) (string, error) {
+	if current != "" {
+		return current, nil
+	}
+	return rotateSubscriptionToken(email)
+}
+
+// rotateSubscriptionToken 为用户生成新的订阅令牌，旧令牌立即失效
+func rotateSubscriptionToken(email string) (string, error) {
+	token, err := helper.GenerateSubscriptionToken()
+	if err != nil {
+		return "", err
+	}
+
+	result, err := userTrafficLogsCol.UpdateOne(context.TODO(),
+		bson.M{"email_as_id": email},
+		bson.M{"$set": bson.M{"subscription_token": token, "updated_at": time.Now()}},
+	)
+	if err != nil {
+		return "", err
+	}
+	if result.MatchedCount == 0 {
+		return "", mongo.ErrNoDocuments
+	}
+	return token, nil
+}
+
+// RotateSubscriptionToken 重置用户的订阅令牌，管理员或用户本人可操作
+func RotateSubscriptionToken() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		name := helper.SanitizeStr(c.Param("name"))
+
+		if err := helper.MatchUserTypeAndName(c, name); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			log.Printf("RotateSubscriptionToken: %s", err.Error())
+			return
+		}
+
+		token, err := rotateSubscriptionToken(name)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			log.Printf("RotateSubscriptionToken: %s", err.Error())
+			return
+		}
+
+		log.Printf("用户 %s 的订阅令牌已重置", name)
+		c.JSON(http.StatusOK, gin.H{"message": "订阅链接已重置", "subscription_token": token})
+	}
+}
@@ -0,0 +1,88 @@
+package controllers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xvv6u577/logv2fs/database"
+	helper "github.com/xvv6u577/logv2fs/helpers"
+	"github.com/xvv6u577/logv2fs/model"
+	"gorm.io/gorm"
+)
+
+// findSubscriptionUserPG 按订阅令牌查找用户，legacy 为 true 时令牌找不到后在允许的情况下按邮箱查找 - PostgreSQL版本
+func findSubscriptionUserPG(db *gorm.DB, key string, columns string, legacy bool) (model.UserTrafficLogsPG, error) {
+	var user model.UserTrafficLogsPG
+
+	err := db.Select(columns).Where("subscription_token = ?", key).First(&user).Error
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return user, err
+	}
+
+	if !legacy {
+		return user, errSubscriptionNotFound
+	}
+
+	if !legacySubscriptionAllowed(time.Now()) {
+		return user, errLegacySubscriptionExpired
+	}
+
+	err = db.Select(columns).Where("email_as_id = ?", key).First(&user).Error
+	if err == nil {
+		log.Printf("通过邮箱访问订阅: %s", key)
+	}
+	return user, err
+}
+
+// ensureSubscriptionTokenPG 返回用户的订阅令牌，还没有令牌时生成一个 - PostgreSQL版本
+func ensureSubscriptionTokenPG(db *gorm.DB, email string, current *string) (string, error) {
+	if current != nil && *current != "" {
+		return *current, nil
+	}
+	return rotateSubscriptionTokenPG(db, email)
+}
+
+// rotateSubscriptionTokenPG 为用户生成新的订阅令牌，旧令牌立即失效 - PostgreSQL版本
+func rotateSubscriptionTokenPG(db *gorm.DB, email string) (string, error) {
+	token, err := helper.GenerateSubscriptionToken()
+	if err != nil {
+		return "", err
+	}
+
+	result := db.Model(&model.UserTrafficLogsPG{}).
+		Where("email_as_id = ?", email).
+		Updates(map[string]interface{}{"subscription_token": token, "updated_at": time.Now()})
+	if result.Error != nil {
+		return "", result.Error
+	}
+	if result.RowsAffected == 0 {
+		return "", gorm.ErrRecordNotFound
+	}
+	return token, nil
+}
+
+// RotateSubscriptionTokenPG 重置用户的订阅令牌 - PostgreSQL版本
+func RotateSubscriptionTokenPG() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		name := helper.SanitizeStr(c.Param("name"))
+
+		if err := helper.MatchUserTypeAndName(c, name); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			log.Printf("RotateSubscriptionToken: %s", err.Error())
+			return
+		}
+
+		token, err := rotateSubscriptionTokenPG(database.GetPostgresDB(), name)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			log.Printf("RotateSubscriptionToken: %s", err.Error())
+			return
+		}
+
+		log.Printf("用户 %s 的订阅令牌已重置", name)
+		c.JSON(http.StatusOK, gin.H{"message": "订阅链接已重置", "subscription_token": token})
+	}
+}
@@ -0,0 +1,98 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xvv6u577/logv2fs/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// 客户端自动更新订阅的间隔（小时），默认 24
+var subscriptionUpdateInterval = os.Getenv("SUBSCRIPTION_UPDATE_INTERVAL")
+
+// subscriptionUserinfoProjections 订阅响应头需要的用户字段
+var subscriptionUserinfoProjections = bson.D{
+	{Key: "email_as_id", Value: 1},
+	{Key: "used", Value: 1},
+	{Key: "credit", Value: 1},
+	{Key: "yearly_logs", Value: 1},
+}
+
+// SubscriptionUserinfo subscription-userinfo 响应头的内容，流量单位为字节，expire 为 Unix 时间戳
+type SubscriptionUserinfo struct {
+	Upload   int64
+	Download int64
+	Total    int64
+	Expire   int64
+}
+
+// String 按客户端约定的格式输出，没有到期时间时省略 expire
+func (info SubscriptionUserinfo) String() string {
+	value := fmt.Sprintf("upload=%d; download=%d; total=%d", info.Upload, info.Download, info.Total)
+	if info.Expire > 0 {
+		value += fmt.Sprintf("; expire=%d", info.Expire)
+	}
+	return value
+}
+
+// newSubscriptionUserinfo 按流量日志中的上下行比例拆分已用流量，
+// 没有上下行记录时全部计为下行。credit 为 0 表示不限流量，total 也为 0。
+func newSubscriptionUserinfo(used, credit int64, yearly []model.YearlyLogEntry, lastEndDate time.Time) SubscriptionUserinfo {
+	var uplink, downlink int64
+	for _, entry := range yearly {
+		uplink += entry.Uplink
+		downlink += entry.Downlink
+	}
+
+	info := SubscriptionUserinfo{Download: used, Total: credit}
+	if uplink+downlink > 0 {
+		info.Upload = int64(float64(used) * float64(uplink) / float64(uplink+downlink))
+		info.Download = used - info.Upload
+	}
+
+	// 结束日期当天仍在服务期内，到期时间为当天结束
+	if !lastEndDate.IsZero() {
+		info.Expire = lastEndDate.AddDate(0, 0, 1).Unix() - 1
+	}
+	return info
+}
+
+// profileUpdateInterval 返回订阅更新间隔（小时）
+func profileUpdateInterval() int {
+	hours, err := strconv.Atoi(subscriptionUpdateInterval)
+	if err != nil || hours <= 0 {
+		return 24
+	}
+	return hours
+}
+
+// setSubscriptionHeaders 写入订阅相关的响应头，需在写入响应体之前调用
+func setSubscriptionHeaders(c *gin.Context, info SubscriptionUserinfo) {
+	c.Header("subscription-userinfo", info.String())
+	c.Header("profile-update-interval", strconv.Itoa(profileUpdateInterval()))
+}
+
+// subscriptionUserinfo 查询用户最近一次缴费的结束日期并生成响应头内容
+func subscriptionUserinfo(user UserTrafficLogs) SubscriptionUserinfo {
+	var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var lastPayment model.PaymentRecord
+	opts := options.FindOne().
+		SetSort(bson.D{{Key: "end_date", Value: -1}}).
+		SetProjection(bson.D{{Key: "end_date", Value: 1}})
+	err := paymentRecordsCol.FindOne(ctx, bson.M{"user_email_as_id": user.Email_As_Id}, opts).Decode(&lastPayment)
+	if err != nil && err != mongo.ErrNoDocuments {
+		log.Printf("查询用户 %s 的缴费结束日期失败: %v", user.Email_As_Id, err)
+	}
+
+	return newSubscriptionUserinfo(user.Used, user.Credit, user.YearlyLogs, lastPayment.EndDate)
+}
@@ -0,0 +1,33 @@
+package controllers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+
+	"github.com/xvv6u577/logv2fs/model"
+	"gorm.io/gorm"
+)
+
+// 订阅响应头需要的用户字段 - PostgreSQL版本
+const subscriptionUserinfoColumnsPG = "email_as_id, used, credit, yearly_logs"
+
+// subscriptionUserinfoPG 查询用户最近一次缴费的结束日期并生成响应头内容 - PostgreSQL版本
+func subscriptionUserinfoPG(db *gorm.DB, user model.UserTrafficLogsPG) SubscriptionUserinfo {
+	var yearly []model.YearlyLogEntry
+	if len(user.YearlyLogs) > 0 {
+		if err := json.Unmarshal(user.YearlyLogs, &yearly); err != nil {
+			log.Printf("解析用户 %s 的年度流量失败: %v", user.EmailAsId, err)
+		}
+	}
+
+	var lastEndDate sql.NullTime
+	if err := db.Model(&model.PaymentRecordPG{}).
+		Select("MAX(end_date)").
+		Where("user_email_as_id = ?", user.EmailAsId).
+		Row().Scan(&lastEndDate); err != nil {
+		log.Printf("查询用户 %s 的缴费结束日期失败: %v", user.EmailAsId, err)
+	}
+
+	return newSubscriptionUserinfo(user.Used, user.Credit, yearly, lastEndDate.Time)
+}
@@ -0,0 +1,255 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xvv6u577/logv2fs/database"
+	helper "github.com/xvv6u577/logv2fs/helpers"
+	"github.com/xvv6u577/logv2fs/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// 一次性绑定码的有效期和长度
+const (
+	telegramLinkCodeTTL    = 10 * time.Minute
+	telegramLinkCodeLength = 8
+)
+
+// Telegram bot 的用户名，用于在用户面板生成 t.me 链接
+var telegramBotUsername = os.Getenv("TELEGRAM_BOT_USERNAME")
+
+var (
+	ErrTelegramLinkCode  = errors.New("绑定码无效或已过期")
+	ErrTelegramNotLinked = errors.New("当前 Telegram 账号未绑定用户")
+)
+
+// TelegramAccount 绑定了 Telegram 的用户信息，Expire 为最近一次缴费的到期时间（Unix 时间戳，0 表示没有缴费记录）
+type TelegramAccount struct {
+	EmailAsId         string
+	Name              string
+	Role              string
+	Status            string
+	Used              int64
+	Credit            int64
+	Expire            int64
+	SubscriptionToken string
+}
+
+// TelegramPayment 缴费记录摘要
+type TelegramPayment struct {
+	Amount    float64
+	StartDate time.Time
+	EndDate   time.Time
+}
+
+// telegramAccount 由 MongoDB 用户记录生成 TelegramAccount
+func telegramAccount(user UserTrafficLogs) TelegramAccount {
+	return TelegramAccount{
+		EmailAsId:         user.Email_As_Id,
+		Name:              user.Name,
+		Role:              user.Role,
+		Status:            user.Status,
+		Used:              user.Used,
+		Credit:            user.Credit,
+		Expire:            subscriptionUserinfo(user).Expire,
+		SubscriptionToken: user.SubscriptionToken,
+	}
+}
+
+// LinkTelegramChat 使用一次性绑定码把 chat 绑定到用户，同一 chat 之前绑定的用户会被解绑
+func LinkTelegramChat(code, chatID string) (TelegramAccount, error) {
+	if database.IsUsingPostgres() {
+		return linkTelegramChatPG(database.GetPostgresDB(), code, chatID)
+	}
+
+	var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// 按绑定码原子地写入 chat 并清除绑定码，同一个绑定码只能使用一次
+	filter := bson.M{"telegram_link_code": code, "telegram_link_expires_at": bson.M{"$gt": time.Now()}}
+	var user UserTrafficLogs
+	err := userTrafficLogsCol.FindOneAndUpdate(ctx, filter,
+		bson.M{
+			"$set":   bson.M{"telegram_chat_id": chatID, "updated_at": time.Now()},
+			"$unset": bson.M{"telegram_link_code": "", "telegram_link_expires_at": ""},
+		},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		return TelegramAccount{}, ErrTelegramLinkCode
+	}
+	if err != nil {
+		return TelegramAccount{}, err
+	}
+
+	// 绑定成功后再解绑该 chat 之前绑定的其他用户，绑定码无效时不影响已有绑定
+	if _, err := userTrafficLogsCol.UpdateMany(ctx,
+		bson.M{"telegram_chat_id": chatID, "email_as_id": bson.M{"$ne": user.Email_As_Id}},
+		bson.M{"$unset": bson.M{"telegram_chat_id": ""}},
+	); err != nil {
+		return TelegramAccount{}, err
+	}
+
+	log.Printf("用户 %s 已绑定 Telegram chat %s", user.Email_As_Id, chatID)
+	return telegramAccount(user), nil
+}
+
+// UnlinkTelegramChat 解除 chat 与用户的绑定
+func UnlinkTelegramChat(chatID string) error {
+	if database.IsUsingPostgres() {
+		return unlinkTelegramChatPG(database.GetPostgresDB(), chatID)
+	}
+
+	result, err := userTrafficLogsCol.UpdateMany(context.TODO(),
+		bson.M{"telegram_chat_id": chatID},
+		bson.M{"$unset": bson.M{"telegram_chat_id": ""}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrTelegramNotLinked
+	}
+	return nil
+}
+
+// FindTelegramAccount 查找 chat 绑定的用户
+func FindTelegramAccount(chatID string) (TelegramAccount, error) {
+	if database.IsUsingPostgres() {
+		return findTelegramAccountPG(database.GetPostgresDB(), chatID)
+	}
+
+	var user UserTrafficLogs
+	err := userTrafficLogsCol.FindOne(context.TODO(), bson.M{"telegram_chat_id": chatID}).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		return TelegramAccount{}, ErrTelegramNotLinked
+	}
+	if err != nil {
+		return TelegramAccount{}, err
+	}
+	return telegramAccount(user), nil
+}
+
+// EnsureSubscriptionToken 返回用户的订阅令牌，还没有令牌时生成一个
+func EnsureSubscriptionToken(account TelegramAccount) (string, error) {
+	if database.IsUsingPostgres() {
+		return ensureSubscriptionTokenPG(database.GetPostgresDB(), account.EmailAsId, &account.SubscriptionToken)
+	}
+	return ensureSubscriptionToken(account.EmailAsId, account.SubscriptionToken)
+}
+
+// RecentPayments 按结束日期倒序返回用户最近的缴费记录
+func RecentPayments(user string, limit int) ([]TelegramPayment, error) {
+	if database.IsUsingPostgres() {
+		return recentPaymentsPG(database.GetPostgresDB(), user, limit)
+	}
+
+	var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "end_date", Value: -1}}).
+		SetLimit(int64(limit))
+	cur, err := paymentRecordsCol.Find(ctx, bson.M{"user_email_as_id": user}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var records []model.PaymentRecord
+	if err := cur.All(ctx, &records); err != nil {
+		return nil, err
+	}
+
+	payments := make([]TelegramPayment, 0, len(records))
+	for _, record := range records {
+		payments = append(payments, TelegramPayment{Amount: record.Amount, StartDate: record.StartDate, EndDate: record.EndDate})
+	}
+	return payments, nil
+}
+
+// newTelegramLinkCode 生成绑定码和过期时间
+func newTelegramLinkCode() (string, time.Time, error) {
+	code, err := helper.GenerateLinkCode(telegramLinkCodeLength)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return code, time.Now().Add(telegramLinkCodeTTL), nil
+}
+
+// telegramLinkResponse 生成绑定码接口的响应
+func telegramLinkResponse(code string, expiresAt time.Time) gin.H {
+	response := gin.H{"code": code, "expires_at": expiresAt}
+	if telegramBotUsername != "" {
+		response["bot_url"] = "https://t.me/" + telegramBotUsername + "?start=" + code
+	}
+	return response
+}
+
+// CreateTelegramLinkCode 为用户生成 Telegram 一次性绑定码，管理员或用户本人可操作
+func CreateTelegramLinkCode() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		name := helper.SanitizeStr(c.Param("name"))
+
+		if err := helper.MatchUserTypeAndName(c, name); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			log.Printf("CreateTelegramLinkCode: %s", err.Error())
+			return
+		}
+
+		code, expiresAt, err := newTelegramLinkCode()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			log.Printf("CreateTelegramLinkCode: %s", err.Error())
+			return
+		}
+
+		result, err := userTrafficLogsCol.UpdateOne(context.TODO(),
+			bson.M{"email_as_id": name},
+			bson.M{"$set": bson.M{"telegram_link_code": code, "telegram_link_expires_at": expiresAt}},
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			log.Printf("CreateTelegramLinkCode: %s", err.Error())
+			return
+		}
+		if result.MatchedCount == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			return
+		}
+
+		c.JSON(http.StatusOK, telegramLinkResponse(code, expiresAt))
+	}
+}
+
+// UnlinkTelegram 解除用户的 Telegram 绑定，管理员或用户本人可操作
+func UnlinkTelegram() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		name := helper.SanitizeStr(c.Param("name"))
+
+		if err := helper.MatchUserTypeAndName(c, name); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			log.Printf("UnlinkTelegram: %s", err.Error())
+			return
+		}
+
+		if _, err := userTrafficLogsCol.UpdateOne(context.TODO(),
+			bson.M{"email_as_id": name},
+			bson.M{"$unset": bson.M{"telegram_chat_id": "", "telegram_link_code": "", "telegram_link_expires_at": ""}},
+		); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			log.Printf("UnlinkTelegram: %s", err.Error())
+			return
+		}
+
+		log.Printf("用户 %s 已解除 Telegram 绑定", name)
+		c.JSON(http.StatusOK, gin.H{"message": "已解除 Telegram 绑定"})
+	}
+}
@@ -0,0 +1,185 @@
+package controllers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xvv6u577/logv2fs/database"
+	helper "github.com/xvv6u577/logv2fs/helpers"
+	"github.com/xvv6u577/logv2fs/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 绑定 Telegram 需要的用户字段 - PostgreSQL版本
+const telegramAccountColumnsPG = "email_as_id, name, role, status, used, credit, yearly_logs, subscription_token"
+
+// telegramAccountPG 由 PostgreSQL 用户记录生成 TelegramAccount
+func telegramAccountPG(db *gorm.DB, user model.UserTrafficLogsPG) TelegramAccount {
+	account := TelegramAccount{
+		EmailAsId: user.EmailAsId,
+		Name:      user.Name,
+		Role:      user.Role,
+		Status:    user.Status,
+		Used:      user.Used,
+		Credit:    user.Credit,
+		Expire:    subscriptionUserinfoPG(db, user).Expire,
+	}
+	if user.SubscriptionToken != nil {
+		account.SubscriptionToken = *user.SubscriptionToken
+	}
+	return account
+}
+
+// linkTelegramChatPG 使用一次性绑定码把 chat 绑定到用户 - PostgreSQL版本
+// 读取时锁住用户行，写入时再按绑定码匹配，同一个绑定码只能使用一次
+func linkTelegramChatPG(db *gorm.DB, code, chatID string) (TelegramAccount, error) {
+	var user model.UserTrafficLogsPG
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Select(telegramAccountColumnsPG).
+			Where("telegram_link_code = ? AND telegram_link_expires_at > ?", code, time.Now()).
+			First(&user).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrTelegramLinkCode
+			}
+			return err
+		}
+
+		// 一个 chat 只绑定一个用户
+		if err := tx.Model(&model.UserTrafficLogsPG{}).
+			Where("telegram_chat_id = ?", chatID).
+			Update("telegram_chat_id", nil).Error; err != nil {
+			return err
+		}
+
+		result := tx.Model(&model.UserTrafficLogsPG{}).
+			Where("email_as_id = ? AND telegram_link_code = ?", user.EmailAsId, code).
+			Updates(map[string]interface{}{
+				"telegram_chat_id":         chatID,
+				"telegram_link_code":       nil,
+				"telegram_link_expires_at": nil,
+				"updated_at":               time.Now(),
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		// 绑定码已被其他请求使用，回滚前面的解绑
+		if result.RowsAffected != 1 {
+			return ErrTelegramLinkCode
+		}
+		return nil
+	})
+	if err != nil {
+		return TelegramAccount{}, err
+	}
+
+	log.Printf("用户 %s 已绑定 Telegram chat %s", user.EmailAsId, chatID)
+	return telegramAccountPG(db, user), nil
+}
+
+// unlinkTelegramChatPG 解除 chat 与用户的绑定 - PostgreSQL版本
+func unlinkTelegramChatPG(db *gorm.DB, chatID string) error {
+	result := db.Model(&model.UserTrafficLogsPG{}).
+		Where("telegram_chat_id = ?", chatID).
+		Update("telegram_chat_id", nil)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrTelegramNotLinked
+	}
+	return nil
+}
+
+// findTelegramAccountPG 查找 chat 绑定的用户 - PostgreSQL版本
+func findTelegramAccountPG(db *gorm.DB, chatID string) (TelegramAccount, error) {
+	var user model.UserTrafficLogsPG
+	err := db.Select(telegramAccountColumnsPG).Where("telegram_chat_id = ?", chatID).First(&user).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return TelegramAccount{}, ErrTelegramNotLinked
+	}
+	if err != nil {
+		return TelegramAccount{}, err
+	}
+	return telegramAccountPG(db, user), nil
+}
+
+// recentPaymentsPG 按结束日期倒序返回用户最近的缴费记录 - PostgreSQL版本
+func recentPaymentsPG(db *gorm.DB, user string, limit int) ([]TelegramPayment, error) {
+	var payments []TelegramPayment
+	err := db.Model(&model.PaymentRecordPG{}).
+		Select("amount, start_date, end_date").
+		Where("user_email_as_id = ?", user).
+		Order("end_date DESC").
+		Limit(limit).
+		Scan(&payments).Error
+	return payments, err
+}
+
+// CreateTelegramLinkCodePG 为用户生成 Telegram 一次性绑定码 - PostgreSQL版本
+func CreateTelegramLinkCodePG() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		name := helper.SanitizeStr(c.Param("name"))
+
+		if err := helper.MatchUserTypeAndName(c, name); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			log.Printf("CreateTelegramLinkCode: %s", err.Error())
+			return
+		}
+
+		code, expiresAt, err := newTelegramLinkCode()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			log.Printf("CreateTelegramLinkCode: %s", err.Error())
+			return
+		}
+
+		result := database.GetPostgresDB().Model(&model.UserTrafficLogsPG{}).
+			Where("email_as_id = ?", name).
+			Updates(map[string]interface{}{"telegram_link_code": code, "telegram_link_expires_at": expiresAt})
+		if result.Error != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": result.Error.Error()})
+			log.Printf("CreateTelegramLinkCode: %s", result.Error.Error())
+			return
+		}
+		if result.RowsAffected == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			return
+		}
+
+		c.JSON(http.StatusOK, telegramLinkResponse(code, expiresAt))
+	}
+}
+
+// UnlinkTelegramPG 解除用户的 Telegram 绑定 - PostgreSQL版本
+func UnlinkTelegramPG() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		name := helper.SanitizeStr(c.Param("name"))
+
+		if err := helper.MatchUserTypeAndName(c, name); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			log.Printf("UnlinkTelegram: %s", err.Error())
+			return
+		}
+
+		if err := database.GetPostgresDB().Model(&model.UserTrafficLogsPG{}).
+			Where("email_as_id = ?", name).
+			Updates(map[string]interface{}{
+				"telegram_chat_id":         nil,
+				"telegram_link_code":       nil,
+				"telegram_link_expires_at": nil,
+			}).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			log.Printf("UnlinkTelegram: %s", err.Error())
+			return
+		}
+
+		log.Printf("用户 %s 已解除 Telegram 绑定", name)
+		c.JSON(http.StatusOK, gin.H{"message": "已解除 Telegram 绑定"})
+	}
+}
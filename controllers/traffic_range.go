@@ -0,0 +1,263 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xvv6u577/logv2fs/database"
+	helper "github.com/xvv6u577/logv2fs/helpers"
+	"github.com/xvv6u577/logv2fs/model"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+var trafficSamplesCol = database.GetCollection(model.TrafficSample{})
+
+// 小时粒度最多查询的天数
+const maxHourlyRangeDays = 31
+
+// TrafficRangeQuery 流量范围查询参数，时间范围为 [From, To)
+type TrafficRangeQuery struct {
+	From        time.Time
+	To          time.Time
+	Granularity string
+	// 可选过滤：查询用户时为节点，查询节点时为用户
+	Filter string
+}
+
+// TrafficRangePoint 一个时间段的流量
+type TrafficRangePoint struct {
+	PeriodStart string `json:"period_start"`
+	Traffic     int64  `json:"traffic"`
+	Uplink      int64  `json:"uplink"`
+	Downlink    int64  `json:"downlink"`
+}
+
+// TrafficRangeResult 流量范围查询结果
+type TrafficRangeResult struct {
+	Name        string              `json:"name"`
+	Node        string              `json:"node,omitempty"`
+	User        string              `json:"user,omitempty"`
+	From        string              `json:"from"`
+	To          string              `json:"to"`
+	Granularity string              `json:"granularity"`
+	Traffic     int64               `json:"traffic"`
+	Uplink      int64               `json:"uplink"`
+	Downlink    int64               `json:"downlink"`
+	Series      []TrafficRangePoint `json:"series"`
+}
+
+// parseRangeTime 解析时间参数，支持日期和日期时间，按服务器本地时区解析。
+// 只有日期的结束时间包含当天。
+func parseRangeTime(value string, end bool) (time.Time, error) {
+	if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		if end {
+			return t.AddDate(0, 0, 1), nil
+		}
+		return t, nil
+	}
+	for _, layout := range []string{"2006-01-02 15:04", "2006-01-02T15:04", "2006-01-02 15:04:05", "2006-01-02T15:04:05"} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t.Local(), nil
+	}
+	return time.Time{}, fmt.Errorf("无法解析时间: %s", value)
+}
+
+// parseTrafficRangeQuery 解析 from、to、granularity 参数，filterKey 为可选过滤参数名
+func parseTrafficRangeQuery(c *gin.Context, filterKey string) (TrafficRangeQuery, error) {
+	query := TrafficRangeQuery{
+		Granularity: c.DefaultQuery("granularity", "day"),
+		Filter:      c.Query(filterKey),
+	}
+
+	switch query.Granularity {
+	case "hour", "day", "month":
+	default:
+		return query, fmt.Errorf("granularity 只支持 hour、day、month")
+	}
+
+	from := c.Query("from")
+	if from == "" {
+		return query, fmt.Errorf("缺少 from 参数")
+	}
+	var err error
+	if query.From, err = parseRangeTime(from, false); err != nil {
+		return query, err
+	}
+
+	query.To = time.Now()
+	if to := c.Query("to"); to != "" {
+		if query.To, err = parseRangeTime(to, true); err != nil {
+			return query, err
+		}
+	}
+
+	if !query.To.After(query.From) {
+		return query, fmt.Errorf("to 必须晚于 from")
+	}
+	if query.Granularity == "hour" && query.To.Sub(query.From) > maxHourlyRangeDays*24*time.Hour {
+		return query, fmt.Errorf("小时粒度最多查询 %d 天", maxHourlyRangeDays)
+	}
+	return query, nil
+}
+
+// periodStart 返回 t 所在时间段的起点
+func periodStart(t time.Time, granularity string) time.Time {
+	switch granularity {
+	case "hour":
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, t.Location())
+	case "month":
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+// formatPeriod 按粒度格式化时间段起点
+func formatPeriod(t time.Time, granularity string) string {
+	switch granularity {
+	case "hour":
+		return t.Format("2006-01-02 15:04")
+	case "month":
+		return t.Format("2006-01")
+	}
+	return t.Format("2006-01-02")
+}
+
+// newTrafficRangeResult 汇总各时间段的流量
+func newTrafficRangeResult(name string, query TrafficRangeQuery, series []TrafficRangePoint) TrafficRangeResult {
+	result := TrafficRangeResult{
+		Name:        name,
+		From:        query.From.Format("2006-01-02 15:04:05"),
+		To:          query.To.Format("2006-01-02 15:04:05"),
+		Granularity: query.Granularity,
+		Series:      series,
+	}
+	if result.Series == nil {
+		result.Series = []TrafficRangePoint{}
+	}
+	for _, point := range series {
+		result.Traffic += point.Traffic
+		result.Uplink += point.Uplink
+		result.Downlink += point.Downlink
+	}
+	return result
+}
+
+// queryTrafficRange MongoDB版本的范围查询：先按小时汇总，再在本地按粒度合并
+func queryTrafficRange(filter bson.M, query TrafficRangeQuery) ([]TrafficRangePoint, error) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	filter["bucket_start"] = bson.M{"$gte": query.From, "$lt": query.To}
+	cur, err := trafficSamplesCol.Aggregate(ctx, []bson.M{
+		{"$match": filter},
+		{"$group": bson.M{
+			"_id":      "$bucket_start",
+			"traffic":  bson.M{"$sum": "$traffic"},
+			"uplink":   bson.M{"$sum": "$uplink"},
+			"downlink": bson.M{"$sum": "$downlink"},
+		}},
+		{"$sort": bson.M{"_id": 1}},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []struct {
+		BucketStart time.Time `bson:"_id"`
+		Traffic     int64     `bson:"traffic"`
+		Uplink      int64     `bson:"uplink"`
+		Downlink    int64     `bson:"downlink"`
+	}
+	if err := cur.All(ctx, &rows); err != nil {
+		return nil, err
+	}
+
+	var series []TrafficRangePoint
+	for _, row := range rows {
+		period := formatPeriod(periodStart(row.BucketStart.Local(), query.Granularity), query.Granularity)
+		if len(series) == 0 || series[len(series)-1].PeriodStart != period {
+			series = append(series, TrafficRangePoint{PeriodStart: period})
+		}
+		point := &series[len(series)-1]
+		point.Traffic += row.Traffic
+		point.Uplink += row.Uplink
+		point.Downlink += row.Downlink
+	}
+	return series, nil
+}
+
+// GetUserTrafficRange 查询用户在时间范围内的流量，可用 node 参数只统计某个节点
+func GetUserTrafficRange() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		name := c.Param("name")
+
+		if err := helper.MatchUserTypeAndName(c, name); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			log.Printf("GetUserTrafficRange: %s", err.Error())
+			return
+		}
+
+		query, err := parseTrafficRangeQuery(c, "node")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		filter := bson.M{"user_email": name}
+		if query.Filter != "" {
+			filter["node"] = query.Filter
+		}
+
+		series, err := queryTrafficRange(filter, query)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			log.Printf("GetUserTrafficRange: %s", err.Error())
+			return
+		}
+
+		result := newTrafficRangeResult(name, query, series)
+		result.Node = query.Filter
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+// GetNodeTrafficRange 查询节点在时间范围内的流量，可用 user 参数只统计某个用户
+func GetNodeTrafficRange() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckUserType(c, "admin"); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		domain := c.Param("domain")
+		query, err := parseTrafficRangeQuery(c, "user")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		filter := bson.M{"node": domain}
+		if query.Filter != "" {
+			filter["user_email"] = query.Filter
+		}
+
+		series, err := queryTrafficRange(filter, query)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			log.Printf("GetNodeTrafficRange: %s", err.Error())
+			return
+		}
+
+		result := newTrafficRangeResult(domain, query, series)
+		result.User = query.Filter
+		c.JSON(http.StatusOK, result)
+	}
+}
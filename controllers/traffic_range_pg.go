@@ -0,0 +1,115 @@
+package controllers
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xvv6u577/logv2fs/database"
+	helper "github.com/xvv6u577/logv2fs/helpers"
+)
+
+// queryTrafficRangePG PostgreSQL版本的范围查询，conditions 为 traffic_samples 上的过滤条件
+func queryTrafficRangePG(conditions map[string]string, query TrafficRangeQuery) ([]TrafficRangePoint, error) {
+	db := database.GetPostgresDB()
+
+	// traffic_samples 的时间为节点本地时间，不带时区。范围换算为服务器时区的字符串传入再转为 timestamp，
+	// 避免驱动按 timestamptz 传参后被数据库会话时区换算
+	const wallClock = "2006-01-02 15:04:05"
+	tx := db.Table("traffic_samples").
+		Select("date_trunc(?, bucket_start) AS period_start, SUM(traffic)::BIGINT AS traffic, SUM(uplink)::BIGINT AS uplink, SUM(downlink)::BIGINT AS downlink", query.Granularity).
+		Where("bucket_start >= ?::timestamp AND bucket_start < ?::timestamp", query.From.In(time.Local).Format(wallClock), query.To.In(time.Local).Format(wallClock))
+	for column, value := range conditions {
+		tx = tx.Where(column+" = ?", value)
+	}
+
+	var rows []struct {
+		PeriodStart time.Time
+		Traffic     int64
+		Uplink      int64
+		Downlink    int64
+	}
+	if err := tx.Group("1").Order("1").Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	series := make([]TrafficRangePoint, 0, len(rows))
+	for _, row := range rows {
+		series = append(series, TrafficRangePoint{
+			PeriodStart: formatPeriod(row.PeriodStart, query.Granularity),
+			Traffic:     row.Traffic,
+			Uplink:      row.Uplink,
+			Downlink:    row.Downlink,
+		})
+	}
+	return series, nil
+}
+
+// GetUserTrafficRangePG 查询用户在时间范围内的流量 - PostgreSQL版本
+func GetUserTrafficRangePG() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		name := c.Param("name")
+
+		if err := helper.MatchUserTypeAndName(c, name); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			log.Printf("GetUserTrafficRange: %s", err.Error())
+			return
+		}
+
+		query, err := parseTrafficRangeQuery(c, "node")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		conditions := map[string]string{"user_email": name}
+		if query.Filter != "" {
+			conditions["node"] = query.Filter
+		}
+
+		series, err := queryTrafficRangePG(conditions, query)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			log.Printf("GetUserTrafficRange: %s", err.Error())
+			return
+		}
+
+		result := newTrafficRangeResult(name, query, series)
+		result.Node = query.Filter
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+// GetNodeTrafficRangePG 查询节点在时间范围内的流量 - PostgreSQL版本
+func GetNodeTrafficRangePG() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckUserType(c, "admin"); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		domain := c.Param("domain")
+		query, err := parseTrafficRangeQuery(c, "user")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		conditions := map[string]string{"node": domain}
+		if query.Filter != "" {
+			conditions["user_email"] = query.Filter
+		}
+
+		series, err := queryTrafficRangePG(conditions, query)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			log.Printf("GetNodeTrafficRange: %s", err.Error())
+			return
+		}
+
+		result := newTrafficRangeResult(domain, query, series)
+		result.User = query.Filter
+		c.JSON(http.StatusOK, result)
+	}
+}
@@ -0,0 +1,146 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/xvv6u577/logv2fs/model"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+// trafficRollupRow 汇总视图中的一行
+type trafficRollupRow struct {
+	Name        string
+	PeriodStart time.Time
+	Traffic     int64
+	Uplink      int64
+	Downlink    int64
+	Protocols   datatypes.JSON
+}
+
+// TrafficLogSet 从 traffic_samples 汇总出的日/月/年流量，格式与 JSONB 日志相同
+type TrafficLogSet struct {
+	DailyLogs   []model.DailyLogEntry
+	MonthlyLogs []model.MonthlyLogEntry
+	YearlyLogs  []model.YearlyLogEntry
+}
+
+// queryTrafficRollupsPG 从汇总视图读取流量，scope 为 user 或 node，返回以用户或节点为键的结果。
+// 没有任何记录的键不会出现在结果中。
+func queryTrafficRollupsPG(db *gorm.DB, scope string, names []string) (map[string]*TrafficLogSet, error) {
+	column := "user_email"
+	if scope == "node" {
+		column = "node"
+	}
+
+	result := map[string]*TrafficLogSet{}
+	if len(names) == 0 {
+		return result, nil
+	}
+
+	for _, period := range []string{"daily", "monthly", "yearly"} {
+		var rows []trafficRollupRow
+		query := fmt.Sprintf(`SELECT %s AS name, period_start, traffic, uplink, downlink, protocols
+			FROM %s_traffic_%s WHERE %s IN ? ORDER BY period_start`, column, scope, period, column)
+		if err := db.Raw(query, names).Scan(&rows).Error; err != nil {
+			return nil, err
+		}
+
+		for _, row := range rows {
+			set, ok := result[row.Name]
+			if !ok {
+				set = &TrafficLogSet{}
+				result[row.Name] = set
+			}
+
+			breakdown := model.TrafficBreakdown{Uplink: row.Uplink, Downlink: row.Downlink}
+			if err := json.Unmarshal(row.Protocols, &breakdown.Protocols); err != nil {
+				return nil, fmt.Errorf("解析 %s 的协议流量失败: %v", row.Name, err)
+			}
+			if len(breakdown.Protocols) == 0 {
+				breakdown.Protocols = nil
+			}
+			switch period {
+			case "daily":
+				set.DailyLogs = append(set.DailyLogs, model.DailyLogEntry{Date: row.PeriodStart.Format("20060102"), Traffic: row.Traffic, TrafficBreakdown: breakdown})
+			case "monthly":
+				set.MonthlyLogs = append(set.MonthlyLogs, model.MonthlyLogEntry{Month: row.PeriodStart.Format("200601"), Traffic: row.Traffic, TrafficBreakdown: breakdown})
+			case "yearly":
+				set.YearlyLogs = append(set.YearlyLogs, model.YearlyLogEntry{Year: row.PeriodStart.Format("2006"), Traffic: row.Traffic, TrafficBreakdown: breakdown})
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// MergeLogs 将 JSONB 日志合并到汇总结果中：汇总里没有的时间段，以及日志流量更大（尚未回填）的时间段
+// 使用日志中的记录，结果按时间段排序
+func (set *TrafficLogSet) MergeLogs(daily, monthly, yearly datatypes.JSON) error {
+	var dailyLogs []model.DailyLogEntry
+	var monthlyLogs []model.MonthlyLogEntry
+	var yearlyLogs []model.YearlyLogEntry
+	for _, field := range []struct {
+		data   datatypes.JSON
+		target interface{}
+	}{{daily, &dailyLogs}, {monthly, &monthlyLogs}, {yearly, &yearlyLogs}} {
+		if len(field.data) == 0 {
+			continue
+		}
+		if err := json.Unmarshal(field.data, field.target); err != nil {
+			return err
+		}
+	}
+
+	dates := map[string]int{}
+	for i, entry := range set.DailyLogs {
+		dates[entry.Date] = i
+	}
+	for _, entry := range dailyLogs {
+		if i, ok := dates[entry.Date]; !ok {
+			set.DailyLogs = append(set.DailyLogs, entry)
+		} else if entry.Traffic > set.DailyLogs[i].Traffic {
+			set.DailyLogs[i] = entry
+		}
+	}
+	sort.Slice(set.DailyLogs, func(i, j int) bool { return set.DailyLogs[i].Date < set.DailyLogs[j].Date })
+
+	months := map[string]int{}
+	for i, entry := range set.MonthlyLogs {
+		months[entry.Month] = i
+	}
+	for _, entry := range monthlyLogs {
+		if i, ok := months[entry.Month]; !ok {
+			set.MonthlyLogs = append(set.MonthlyLogs, entry)
+		} else if entry.Traffic > set.MonthlyLogs[i].Traffic {
+			set.MonthlyLogs[i] = entry
+		}
+	}
+	sort.Slice(set.MonthlyLogs, func(i, j int) bool { return set.MonthlyLogs[i].Month < set.MonthlyLogs[j].Month })
+
+	years := map[string]int{}
+	for i, entry := range set.YearlyLogs {
+		years[entry.Year] = i
+	}
+	for _, entry := range yearlyLogs {
+		if i, ok := years[entry.Year]; !ok {
+			set.YearlyLogs = append(set.YearlyLogs, entry)
+		} else if entry.Traffic > set.YearlyLogs[i].Traffic {
+			set.YearlyLogs[i] = entry
+		}
+	}
+	sort.Slice(set.YearlyLogs, func(i, j int) bool { return set.YearlyLogs[i].Year < set.YearlyLogs[j].Year })
+
+	return nil
+}
+
+// JSON 将汇总结果转换为 JSONB 日志字段
+func (set *TrafficLogSet) JSON() (daily, monthly, yearly datatypes.JSON) {
+	daily, _ = json.Marshal(set.DailyLogs)
+	monthly, _ = json.Marshal(set.MonthlyLogs)
+	yearly, _ = json.Marshal(set.YearlyLogs)
+	return
+}
@@ -0,0 +1,73 @@
+package controllers
+
+import (
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/gin-gonic/gin"
+	helper "github.com/xvv6u577/logv2fs/helpers"
+	"gopkg.in/yaml.v2"
+)
+
+// vergeHandler 查找用户和节点后按 config/template_verge.yaml 生成 Clash Verge 配置，非正常状态的用户返回 config/error.yaml
+func vergeHandler(load func(c *gin.Context) (subscriptionTarget, bool)) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		target, ok := load(c)
+		if !ok {
+			return
+		}
+
+		templateFile := "/config/template_verge.yaml"
+		if target.Status != "plain" {
+			templateFile = "/config/error.yaml"
+		}
+
+		var singboxYAML = SingboxYAML{}
+		yamlFile, err := os.ReadFile(helper.CurrentPath() + templateFile)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			log.Printf("error: %v", err)
+			return
+		}
+		if err := yaml.Unmarshal(yamlFile, &singboxYAML); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			log.Printf("error: %v", err)
+			return
+		}
+
+		if target.Status != "plain" {
+			c.YAML(http.StatusOK, singboxYAML)
+			return
+		}
+
+		// append nodes to proxies and to every select / url-test group.
+		for _, node := range target.Nodes {
+			proxy, ok := clashMetaProxy(node, target.UUID, target.UserID)
+			if !ok {
+				continue
+			}
+
+			for i, group := range singboxYAML.ProxyGroups {
+				if group.Type == "select" || group.Type == "url-test" {
+					singboxYAML.ProxyGroups[i].Proxies = append(singboxYAML.ProxyGroups[i].Proxies, node.Remark)
+				}
+			}
+			singboxYAML.Proxies = append(singboxYAML.Proxies, proxy)
+		}
+
+		// if DIRECT type is not at the end of singboxYAML.ProxyGroups at select type, set it to the end.
+		for i, group := range singboxYAML.ProxyGroups {
+			if group.Type == "select" {
+				for j, p := range group.Proxies {
+					if p == "DIRECT" {
+						singboxYAML.ProxyGroups[i].Proxies = append(singboxYAML.ProxyGroups[i].Proxies[:j], singboxYAML.ProxyGroups[i].Proxies[j+1:]...)
+						singboxYAML.ProxyGroups[i].Proxies = append(singboxYAML.ProxyGroups[i].Proxies, "DIRECT")
+					}
+				}
+			}
+		}
+
+		c.YAML(http.StatusOK, singboxYAML)
+	}
+}
@@ -0,0 +1,315 @@
+package cron
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron"
+	"github.com/xvv6u577/logv2fs/database"
+	helper "github.com/xvv6u577/logv2fs/helpers"
+	"github.com/xvv6u577/logv2fs/model"
+	"github.com/xvv6u577/logv2fs/notify"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const (
+	// CertAlertExpiring 证书即将过期
+	CertAlertExpiring = "expiring"
+	// CertAlertExpired 证书已过期
+	CertAlertExpired = "expired"
+	// CertAlertInvalid 证书链或域名校验失败
+	CertAlertInvalid = "invalid"
+	// CertAlertUnreachable 域名无法完成 TLS 握手
+	CertAlertUnreachable = "unreachable"
+)
+
+var (
+	// 证书检查周期，默认每 6 小时一次
+	certExpirySpec = os.Getenv("CERT_EXPIRY_SPEC")
+	// 证书剩余天数不超过该值时告警，默认 14 天
+	certExpiryWarnDays = os.Getenv("CERT_EXPIRY_WARN_DAYS")
+	expiryCheckDomains = database.GetCollection(model.ExpiryCheckDomainInfo{})
+
+	// 同时检查的域名数量
+	certCheckConcurrency = 8
+	certCheckTimeout     = 20 * time.Second
+
+	notifier Notifier
+)
+
+// Alert 需要通知的事件
+type Alert = notify.Event
+
+// Notifier 告警发送方式，默认使用 notify 包按环境变量配置的渠道
+type Notifier interface {
+	Notify(alert Alert) error
+}
+
+// SetNotifier 替换告警发送方式，传入 nil 时恢复默认
+func SetNotifier(n Notifier) {
+	notifier = n
+}
+
+func currentNotifier() Notifier {
+	if notifier == nil {
+		return notify.Default()
+	}
+	return notifier
+}
+
+// CertExpiryWarnDays 返回证书过期告警的提前天数
+func CertExpiryWarnDays() int {
+	days, err := strconv.Atoi(certExpiryWarnDays)
+	if err != nil || days < 0 {
+		return 14
+	}
+	return days
+}
+
+// CertCheckResult 单个域名的证书检查结果
+type CertCheckResult struct {
+	Domain       string
+	Remark       string
+	ExpiredDate  string
+	DaysToExpire int
+	Reachable    bool
+	VerifyError  string // 证书链或域名校验失败的原因，校验通过时为空
+	LastError    string
+	CheckedAt    time.Time
+}
+
+// CheckCert 检查单个域名的证书；握手失败时 Reachable 为 false，过期信息保持为空。
+// 证书已过期或校验失败时仍记录过期时间，校验失败的原因写入 LastError，已过期时剩余天数为负数
+func CheckCert(domain, remark string) CertCheckResult {
+	result := CertCheckResult{Domain: domain, Remark: remark, CheckedAt: time.Now()}
+
+	info, err := helper.CertExpiry(domain, certCheckTimeout)
+	if err != nil {
+		result.LastError = err.Error()
+		return result
+	}
+
+	result.Reachable = true
+	result.ExpiredDate = info.NotAfter.Local().Format("2006-01-02 15:04:05")
+	result.DaysToExpire = int(math.Floor(time.Until(info.NotAfter).Hours() / 24))
+	if info.VerifyError != nil {
+		result.VerifyError = info.VerifyError.Error()
+		result.LastError = "证书校验失败: " + result.VerifyError
+	}
+	return result
+}
+
+// CertAlertState 根据检查结果返回当前应处于的告警状态，正常时返回空字符串。
+// 已过期的证书同样无法通过校验，按 expired 处理；即将过期的证书过期后状态变为 expired，会再提醒一次
+func CertAlertState(result CertCheckResult, warnDays int) string {
+	switch {
+	case !result.Reachable:
+		return CertAlertUnreachable
+	case result.DaysToExpire < 0:
+		return CertAlertExpired
+	case result.VerifyError != "":
+		return CertAlertInvalid
+	case result.DaysToExpire <= warnDays:
+		return CertAlertExpiring
+	}
+	return ""
+}
+
+// certAlert 状态发生变化时生成告警，同一状态只提醒一次，恢复正常后重新计数
+func certAlert(result CertCheckResult, previous, current string) (Alert, bool) {
+	if current == "" || current == previous {
+		return Alert{}, false
+	}
+
+	name := result.Domain
+	if result.Remark != "" {
+		name = fmt.Sprintf("%s (%s)", result.Domain, result.Remark)
+	}
+
+	switch current {
+	case CertAlertUnreachable:
+		return Alert{
+			Kind:    notify.EventCertUnreachable,
+			Subject: "域名无法访问: " + name,
+			Message: fmt.Sprintf("TLS 握手失败: %s", result.LastError),
+		}, true
+	case CertAlertExpired:
+		return Alert{
+			Kind:    notify.EventCertExpiring,
+			Subject: "证书已过期: " + name,
+			Message: fmt.Sprintf("证书已于 %s 过期", result.ExpiredDate),
+		}, true
+	case CertAlertInvalid:
+		return Alert{
+			Kind:    notify.EventCertInvalid,
+			Subject: "证书校验失败: " + name,
+			Message: fmt.Sprintf("%s，证书将于 %s 过期", result.VerifyError, result.ExpiredDate),
+		}, true
+	}
+	return Alert{
+		Kind:    notify.EventCertExpiring,
+		Subject: "证书即将过期: " + name,
+		Message: fmt.Sprintf("证书将于 %s 过期，剩余 %d 天", result.ExpiredDate, result.DaysToExpire),
+	}, true
+}
+
+// checkCerts 并发检查一组域名，结果顺序与输入一致
+func checkCerts(domains, remarks []string) []CertCheckResult {
+	results := make([]CertCheckResult, len(domains))
+	sem := make(chan struct{}, certCheckConcurrency)
+
+	var wg sync.WaitGroup
+	for i := range domains {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			results[i] = CheckCert(domains[i], remarks[i])
+		}(i)
+	}
+	wg.Wait()
+
+	return results
+}
+
+// CheckDomainCerts 检查 expiry_check_domains 中所有域名的证书，保存结果并在状态变化时告警
+func CheckDomainCerts() error {
+	if isUsingPostgreSQL() {
+		return CheckDomainCertsPG()
+	}
+	return CheckDomainCertsMongo()
+}
+
+// CheckDomainCertsPG PostgreSQL版本的证书检查
+func CheckDomainCertsPG() error {
+	db := database.GetPostgresDB()
+	if db == nil {
+		return nil
+	}
+
+	var records []model.ExpiryCheckDomainInfoPG
+	if err := db.Where("domain <> ?", "localhost").Find(&records).Error; err != nil {
+		log.Printf("查询证书检查域名失败: %v", err)
+		return err
+	}
+
+	domains := make([]string, len(records))
+	remarks := make([]string, len(records))
+	for i, record := range records {
+		domains[i], remarks[i] = record.Domain, record.Remark
+	}
+
+	warnDays := CertExpiryWarnDays()
+	for i, result := range checkCerts(domains, remarks) {
+		state := CertAlertState(result, warnDays)
+		updates := map[string]interface{}{
+			"reachable":   result.Reachable,
+			"last_error":  result.LastError,
+			"alert_state": state,
+			"checked_at":  result.CheckedAt,
+			"updated_at":  time.Now(),
+		}
+		// 无法访问时保留上一次成功检查到的过期时间
+		if result.Reachable {
+			updates["expired_date"] = result.ExpiredDate
+			updates["days_to_expire"] = result.DaysToExpire
+		}
+
+		if err := db.Model(&model.ExpiryCheckDomainInfoPG{}).
+			Where("id = ?", records[i].ID).
+			Updates(updates).Error; err != nil {
+			log.Printf("保存域名 %s 证书检查结果失败: %v", result.Domain, err)
+			continue
+		}
+		sendCertAlert(result, records[i].AlertState, state)
+	}
+
+	return nil
+}
+
+// CheckDomainCertsMongo MongoDB版本的证书检查
+func CheckDomainCertsMongo() error {
+	var ctx, cancel = context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	cur, err := expiryCheckDomains.Find(ctx, bson.M{"domain": bson.M{"$ne": "localhost"}})
+	if err != nil {
+		log.Printf("查询证书检查域名失败: %v", err)
+		return err
+	}
+
+	var records []model.ExpiryCheckDomainInfo
+	if err := cur.All(ctx, &records); err != nil {
+		log.Printf("查询证书检查域名失败: %v", err)
+		return err
+	}
+	cancel()
+
+	domains := make([]string, len(records))
+	remarks := make([]string, len(records))
+	for i, record := range records {
+		domains[i], remarks[i] = record.Domain, record.Remark
+	}
+	results := checkCerts(domains, remarks)
+
+	// 检查本身可能耗时较长，写入使用新的超时
+	ctx, cancel = context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	warnDays := CertExpiryWarnDays()
+	for i, result := range results {
+		state := CertAlertState(result, warnDays)
+		set := bson.M{
+			"reachable":   result.Reachable,
+			"last_error":  result.LastError,
+			"alert_state": state,
+			"checked_at":  result.CheckedAt,
+		}
+		// 无法访问时保留上一次成功检查到的过期时间
+		if result.Reachable {
+			set["expired_date"] = result.ExpiredDate
+			set["days_to_expire"] = result.DaysToExpire
+		}
+
+		if _, err := expiryCheckDomains.UpdateOne(ctx, bson.M{"domain": result.Domain}, bson.M{"$set": set}); err != nil {
+			log.Printf("保存域名 %s 证书检查结果失败: %v", result.Domain, err)
+			continue
+		}
+		sendCertAlert(result, records[i].AlertState, state)
+	}
+
+	return nil
+}
+
+func sendCertAlert(result CertCheckResult, previous, current string) {
+	alert, ok := certAlert(result, previous, current)
+	if !ok {
+		return
+	}
+	if err := currentNotifier().Notify(alert); err != nil {
+		log.Printf("发送告警失败 (%s): %v", alert.Subject, err)
+	}
+}
+
+// Cron_certExpiryJobs 定期检查域名证书的过期时间
+func Cron_certExpiryJobs(c *cron.Cron) {
+	spec := certExpirySpec
+	if spec == "" {
+		spec = "0 0 */6 * * *"
+	}
+
+	if err := c.AddFunc(spec, func() {
+		if err := CheckDomainCerts(); err != nil {
+			log.Printf("证书过期检查失败: %v", err)
+		}
+	}); err != nil {
+		log.Printf("注册证书过期检查任务失败: %v", err)
+	}
+}
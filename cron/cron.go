@@ -2,6 +2,7 @@ package cron
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"os"
@@ -29,16 +30,42 @@ type (
 
 // UserTrafficRequest 定义调用 upsert_user_traffic_log 函数的请求参数
 type UserTrafficRequest struct {
-	Email     string    `json:"p_email"`
-	Timestamp time.Time `json:"p_timestamp"`
-	Traffic   int64     `json:"p_traffic"`
+	Email     string           `json:"p_email"`
+	Timestamp time.Time        `json:"p_timestamp"`
+	Traffic   int64            `json:"p_traffic"`
+	Uplink    int64            `json:"p_uplink"`
+	Downlink  int64            `json:"p_downlink"`
+	Protocols map[string]int64 `json:"p_protocols"`
+	SampleID  string           `json:"p_sample_id,omitempty"`
+	Node      string           `json:"p_node,omitempty"`
 }
 
 // NodeTrafficRequest 定义调用 upsert_node_traffic_log 函数的请求参数
 type NodeTrafficRequest struct {
-	Domain    string    `json:"p_domain"`
-	Timestamp time.Time `json:"p_timestamp"`
-	Traffic   int64     `json:"p_traffic"`
+	Domain    string           `json:"p_domain"`
+	Timestamp time.Time        `json:"p_timestamp"`
+	Traffic   int64            `json:"p_traffic"`
+	Uplink    int64            `json:"p_uplink"`
+	Downlink  int64            `json:"p_downlink"`
+	Protocols map[string]int64 `json:"p_protocols"`
+	SampleID  string           `json:"p_sample_id,omitempty"`
+}
+
+// UserTrafficBulkItem 定义 upsert_user_traffic_logs_bulk 函数中单个用户的流量
+type UserTrafficBulkItem struct {
+	Email     string           `json:"email"`
+	Node      string           `json:"node,omitempty"`
+	Timestamp time.Time        `json:"timestamp"`
+	Traffic   int64            `json:"traffic"`
+	Uplink    int64            `json:"uplink"`
+	Downlink  int64            `json:"downlink"`
+	Protocols map[string]int64 `json:"protocols,omitempty"`
+	SampleID  string           `json:"sample_id,omitempty"`
+}
+
+// UserTrafficBulkRequest 定义调用 upsert_user_traffic_logs_bulk 函数的请求参数
+type UserTrafficBulkRequest struct {
+	Samples []UserTrafficBulkItem `json:"p_samples"`
 }
 
 var (
@@ -46,6 +73,7 @@ var (
 	// MongoDB 集合
 	nodeTrafficLogs = database.GetCollection(model.NodeTrafficLogs{})
 	userTrafficLogs = database.GetCollection(model.UserTrafficLogs{})
+	trafficSamples  = database.GetCollection(model.TrafficSample{})
 )
 
 // 检查是否使用PostgreSQL
@@ -55,7 +83,8 @@ func isUsingPostgreSQL() bool {
 
 // PostgreSQL版本的用户流量记录函数
 // 优化版本：使用 Supabase RPC 调用方式执行流量记录
-func LogUserTrafficPG(email string, timestamp time.Time, traffic int64) error {
+// sampleID 非空时由存储函数去重，同一样本重复写入不会重复累加；domain 为产生流量的节点
+func LogUserTrafficPG(domain string, timestamp time.Time, traffic Traffic, sampleID string) error {
 	// 获取 Supabase 客户端
 	supaClient := database.GetSupabaseClient()
 	if supaClient == nil {
@@ -68,9 +97,14 @@ func LogUserTrafficPG(email string, timestamp time.Time, traffic int64) error {
 
 	// 准备请求参数
 	userRequest := UserTrafficRequest{
-		Email:     email,
+		Email:     traffic.Name,
 		Timestamp: timestamp,
-		Traffic:   traffic,
+		Traffic:   traffic.Total,
+		Uplink:    traffic.Uplink,
+		Downlink:  traffic.Downlink,
+		Protocols: traffic.Protocols,
+		SampleID:  sampleID,
+		Node:      domain,
 	}
 
 	// 使用 Supabase RPC 方法调用 upsert_user_traffic_log 函数
@@ -83,14 +117,15 @@ func LogUserTrafficPG(email string, timestamp time.Time, traffic int64) error {
 		return err
 	}
 
-	log.Printf("用户流量记录成功 - 用户: %s, 流量: %d, 时间: %s",
-		email, traffic, timestamp.Format("2006-01-02 15:04:05"))
+	log.Printf("用户流量记录成功 - 用户: %s, 流量: %d (上行 %d, 下行 %d), 时间: %s",
+		traffic.Name, traffic.Total, traffic.Uplink, traffic.Downlink, timestamp.Format("2006-01-02 15:04:05"))
 	return nil
 }
 
 // PostgreSQL版本的节点流量记录函数
 // 优化版本：使用 Supabase RPC 调用方式执行流量记录
-func LogNodeTrafficPG(domain string, timestamp time.Time, traffic int64) error {
+// sampleID 非空时由存储函数去重，同一样本重复写入不会重复累加
+func LogNodeTrafficPG(domain string, timestamp time.Time, traffic Traffic, sampleID string) error {
 	// 获取 Supabase 客户端
 	supaClient := database.GetSupabaseClient()
 	if supaClient == nil {
@@ -105,7 +140,11 @@ func LogNodeTrafficPG(domain string, timestamp time.Time, traffic int64) error {
 	nodeRequest := NodeTrafficRequest{
 		Domain:    domain,
 		Timestamp: timestamp,
-		Traffic:   traffic,
+		Traffic:   traffic.Total,
+		Uplink:    traffic.Uplink,
+		Downlink:  traffic.Downlink,
+		Protocols: traffic.Protocols,
+		SampleID:  sampleID,
 	}
 
 	// 使用 Supabase RPC 方法调用 upsert_node_traffic_log 函数
@@ -118,92 +157,156 @@ func LogNodeTrafficPG(domain string, timestamp time.Time, traffic int64) error {
 		return err
 	}
 
-	log.Printf("节点流量记录成功 - 节点: %s, 流量: %d, 时间: %s",
-		domain, traffic, timestamp.Format("2006-01-02 15:04:05"))
+	log.Printf("节点流量记录成功 - 节点: %s, 流量: %d (上行 %d, 下行 %d), 时间: %s",
+		domain, traffic.Total, traffic.Uplink, traffic.Downlink, timestamp.Format("2006-01-02 15:04:05"))
 	return nil
 }
 
-// traffic: {Name: "tom", Total: 100}
-func LogUserTraffic(collection *mongo.Collection, email string, timestamp time.Time, traffic int64) error {
+// PostgreSQL版本的批量用户流量记录函数
+// 一次 RPC 调用写入所有用户的流量，整个批次在同一事务中执行
+func LogUserTrafficBulkPG(samples []TrafficSample) error {
+	if len(samples) == 0 {
+		return nil
+	}
 
-	var ctx, cancel = context.WithTimeout(context.Background(), 120*time.Second)
-	defer cancel()
+	supaClient := database.GetSupabaseClient()
+	if supaClient == nil {
+		log.Printf("Supabase 客户端初始化失败")
+		return fmt.Errorf("Supabase 客户端初始化失败")
+	}
 
-	var date = timestamp.Format("20060102")
-	var month = timestamp.Format("200601")
-	var year = timestamp.Format("2006")
+	request := UserTrafficBulkRequest{Samples: make([]UserTrafficBulkItem, 0, len(samples))}
+	for _, sample := range samples {
+		request.Samples = append(request.Samples, UserTrafficBulkItem{
+			Email:     sample.Traffic.Name,
+			Node:      sample.Domain,
+			Timestamp: sample.Timestamp,
+			Traffic:   sample.Traffic.Total,
+			Uplink:    sample.Traffic.Uplink,
+			Downlink:  sample.Traffic.Downlink,
+			Protocols: sample.Traffic.Protocols,
+			SampleID:  sample.ID,
+		})
+	}
 
-	var beforeUpdate model.UserTrafficLogs
-	filter := bson.M{"email_as_id": email}
+	if err := supaClient.DB.RPC("upsert_user_traffic_logs_bulk", request).Execute(context.Background(), nil); err != nil {
+		log.Printf("批量用户流量记录 RPC 调用失败: %v", err)
+		return err
+	}
 
-	err := collection.FindOne(ctx, filter).Decode(&beforeUpdate)
-	if err != nil {
-		log.Printf("error getting user traffic logs: %v\n", err)
+	log.Printf("批量用户流量记录成功 - 用户数: %d", len(samples))
+	return nil
+}
+
+// SumTraffic 将多个用户的流量汇总为一条，用于节点流量记录
+func SumTraffic(name string, traffics []Traffic) Traffic {
+	sum := Traffic{Name: name}
+	for _, traffic := range traffics {
+		sum.Total += traffic.Total
+		sum.Uplink += traffic.Uplink
+		sum.Downlink += traffic.Downlink
+		for protocol, value := range traffic.Protocols {
+			if sum.Protocols == nil {
+				sum.Protocols = map[string]int64{}
+			}
+			sum.Protocols[protocol] += value
+		}
 	}
+	return sum
+}
+
+// buildTrafficLogUpdate 构造日/月/年流量日志的更新语句：已存在的周期累加流量，不存在的周期追加新记录
+func buildTrafficLogUpdate(dailyLogs []DailyLogEntry, monthlyLogs []MonthlyLogEntry, yearlyLogs []YearlyLogEntry,
+	timestamp time.Time, traffic Traffic) (bson.M, []interface{}) {
+
+	var date = timestamp.Format("20060102")
+	var month = timestamp.Format("200601")
+	var year = timestamp.Format("2006")
 
 	filters := []interface{}{}
-	update := bson.M{
-		"$set": bson.M{
-			"updated_at": time.Now(),
-			"used":       beforeUpdate.Used + traffic,
-		},
-		"$inc":  bson.M{},
-		"$push": bson.M{},
+	inc := bson.M{}
+	push := bson.M{}
+
+	// incPeriod 对数组中匹配 identifier 的元素累加流量和拆分数据
+	incPeriod := func(field, identifier string) {
+		prefix := field + ".$[" + identifier + "]."
+		inc[prefix+"traffic"] = traffic.Total
+		inc[prefix+"uplink"] = traffic.Uplink
+		inc[prefix+"downlink"] = traffic.Downlink
+		for protocol, value := range traffic.Protocols {
+			inc[prefix+"protocols."+protocol] = value
+		}
 	}
 
 	// check if date exists in daily_logs
 	var found bool
-	for _, daily := range beforeUpdate.DailyLogs {
+	for _, daily := range dailyLogs {
 		if daily.Date == date {
 			found = true
 			break
 		}
 	}
 	if !found {
-		update["$push"].(bson.M)["daily_logs"] = bson.M{
-			"date":    date,
-			"traffic": traffic,
-		}
-
+		push["daily_logs"] = DailyLogEntry{Date: date, Traffic: traffic.Total, TrafficBreakdown: traffic.TrafficBreakdown}
 	} else {
-		update["$inc"].(bson.M)["daily_logs.$[daily].traffic"] = traffic
+		incPeriod("daily_logs", "daily")
 		filters = append(filters, bson.M{"daily.date": date})
 	}
 
 	// check if month exists in monthly_logs
-	for _, monthly := range beforeUpdate.MonthlyLogs {
+	found = false
+	for _, monthly := range monthlyLogs {
 		if monthly.Month == month {
 			found = true
 			break
 		}
 	}
 	if !found {
-		update["$push"].(bson.M)["monthly_logs"] = bson.M{
-			"month":   month,
-			"traffic": traffic,
-		}
+		push["monthly_logs"] = MonthlyLogEntry{Month: month, Traffic: traffic.Total, TrafficBreakdown: traffic.TrafficBreakdown}
 	} else {
-		update["$inc"].(bson.M)["monthly_logs.$[monthly].traffic"] = traffic
+		incPeriod("monthly_logs", "monthly")
 		filters = append(filters, bson.M{"monthly.month": month})
 	}
 
 	// check if year exists in yearly_logs
-	for _, yearly := range beforeUpdate.YearlyLogs {
+	found = false
+	for _, yearly := range yearlyLogs {
 		if yearly.Year == year {
 			found = true
 			break
 		}
 	}
 	if !found {
-		update["$push"].(bson.M)["yearly_logs"] = bson.M{
-			"year":    year,
-			"traffic": traffic,
-		}
+		push["yearly_logs"] = YearlyLogEntry{Year: year, Traffic: traffic.Total, TrafficBreakdown: traffic.TrafficBreakdown}
 	} else {
-		update["$inc"].(bson.M)["yearly_logs.$[yearly].traffic"] = traffic
+		incPeriod("yearly_logs", "yearly")
 		filters = append(filters, bson.M{"yearly.year": year})
 	}
 
+	return bson.M{"$inc": inc, "$push": push}, filters
+}
+
+// traffic: {Name: "tom", Total: 100, Uplink: 40, Downlink: 60, Protocols: {"reality": 100}}
+func LogUserTraffic(collection *mongo.Collection, timestamp time.Time, traffic Traffic) error {
+
+	var ctx, cancel = context.WithTimeout(context.Background(), 120*time.Second)
+	defer cancel()
+
+	var beforeUpdate model.UserTrafficLogs
+	filter := bson.M{"email_as_id": traffic.Name}
+
+	err := collection.FindOne(ctx, filter).Decode(&beforeUpdate)
+	if err != nil {
+		log.Printf("error getting user traffic logs: %v\n", err)
+	}
+
+	update, filters := buildTrafficLogUpdate(beforeUpdate.DailyLogs, beforeUpdate.MonthlyLogs, beforeUpdate.YearlyLogs, timestamp, traffic)
+	// 多个节点同时写入同一用户，used 使用 $inc 累加，不能按读到的值覆盖
+	update["$inc"].(bson.M)["used"] = traffic.Total
+	update["$set"] = bson.M{
+		"updated_at": time.Now(),
+	}
+
 	arrayFilters := options.ArrayFilters{
 		Filters: filters,
 	}
@@ -219,15 +322,11 @@ func LogUserTraffic(collection *mongo.Collection, email string, timestamp time.T
 
 }
 
-func LogNodeTraffic(collection *mongo.Collection, domain string, timestamp time.Time, traffic int64) error {
+func LogNodeTraffic(collection *mongo.Collection, domain string, timestamp time.Time, traffic Traffic) error {
 
 	var ctx, cancel = context.WithTimeout(context.Background(), 120*time.Second)
 	defer cancel()
 
-	var date = timestamp.Format("20060102")
-	var month = timestamp.Format("200601")
-	var year = timestamp.Format("2006")
-
 	var beforeUpdate model.NodeTrafficLogs
 	filter := bson.M{"domain_as_id": domain}
 
@@ -236,84 +335,147 @@ func LogNodeTraffic(collection *mongo.Collection, domain string, timestamp time.
 		log.Printf("error getting node traffic logs: %v\n", err)
 	}
 
-	filters := []interface{}{}
-	update := bson.M{
-		"$set": bson.M{
-			"updated_at": time.Now(),
-		},
-		"$inc":  bson.M{},
-		"$push": bson.M{},
+	update, filters := buildTrafficLogUpdate(beforeUpdate.DailyLogs, beforeUpdate.MonthlyLogs, beforeUpdate.YearlyLogs, timestamp, traffic)
+	update["$set"] = bson.M{
+		"updated_at": time.Now(),
 	}
 
-	// check if date exists in daily_logs
-	var found bool
-	for _, daily := range beforeUpdate.DailyLogs {
-		if daily.Date == date {
-			found = true
-			break
-		}
+	arrayFilters := options.ArrayFilters{
+		Filters: filters,
 	}
-	if !found {
-		update["$push"].(bson.M)["daily_logs"] = bson.M{
-			"date":    date,
-			"traffic": traffic,
-		}
 
-	} else {
-		update["$inc"].(bson.M)["daily_logs.$[daily].traffic"] = traffic
-		filters = append(filters, bson.M{"daily.date": date})
+	upsert := true
+	updateOptions := options.UpdateOptions{
+		ArrayFilters: &arrayFilters,
+		Upsert:       &upsert,
 	}
 
-	// check if month exists in monthly_logs
-	for _, monthly := range beforeUpdate.MonthlyLogs {
-		if monthly.Month == month {
-			found = true
-			break
-		}
+	_, err = collection.UpdateOne(ctx, filter, update, &updateOptions)
+	return err
+
+}
+
+// LogUserTrafficBulk MongoDB版本的批量用户流量记录，一次查询和一次 BulkWrite 完成所有用户。
+// 按顺序执行，返回成功写入的样本数，出错时之后的样本均未写入。
+func LogUserTrafficBulk(collection *mongo.Collection, samples []TrafficSample) (int, error) {
+	if len(samples) == 0 {
+		return 0, nil
 	}
-	if !found {
-		update["$push"].(bson.M)["monthly_logs"] = bson.M{
-			"month":   month,
-			"traffic": traffic,
-		}
-	} else {
-		update["$inc"].(bson.M)["monthly_logs.$[monthly].traffic"] = traffic
-		filters = append(filters, bson.M{"monthly.month": month})
+
+	var ctx, cancel = context.WithTimeout(context.Background(), 120*time.Second)
+	defer cancel()
+
+	names := make([]string, 0, len(samples))
+	for _, sample := range samples {
+		names = append(names, sample.Traffic.Name)
 	}
 
-	// check if year exists in yearly_logs
-	for _, yearly := range beforeUpdate.YearlyLogs {
-		if yearly.Year == year {
-			found = true
-			break
-		}
+	projections := bson.D{
+		{Key: "email_as_id", Value: 1},
+		{Key: "daily_logs.date", Value: 1},
+		{Key: "monthly_logs.month", Value: 1},
+		{Key: "yearly_logs.year", Value: 1},
 	}
-	if !found {
-		update["$push"].(bson.M)["yearly_logs"] = bson.M{
-			"year":    year,
-			"traffic": traffic,
+	cur, err := collection.Find(ctx, bson.M{"email_as_id": bson.M{"$in": names}}, options.Find().SetProjection(projections))
+	if err != nil {
+		return 0, err
+	}
+
+	var existing []model.UserTrafficLogs
+	if err := cur.All(ctx, &existing); err != nil {
+		return 0, err
+	}
+
+	users := make(map[string]*model.UserTrafficLogs, len(existing))
+	for i := range existing {
+		users[existing[i].Email_As_Id] = &existing[i]
+	}
+
+	models := make([]mongo.WriteModel, 0, len(samples))
+	for _, sample := range samples {
+		user, ok := users[sample.Traffic.Name]
+		if !ok {
+			user = &model.UserTrafficLogs{Email_As_Id: sample.Traffic.Name}
+			users[sample.Traffic.Name] = user
 		}
-	} else {
-		update["$inc"].(bson.M)["yearly_logs.$[yearly].traffic"] = traffic
-		filters = append(filters, bson.M{"yearly.year": year})
+
+		update, filters := buildTrafficLogUpdate(user.DailyLogs, user.MonthlyLogs, user.YearlyLogs, sample.Timestamp, sample.Traffic)
+		update["$inc"].(bson.M)["used"] = sample.Traffic.Total
+		update["$set"] = bson.M{"updated_at": time.Now()}
+
+		// 同一批次中同一用户可能有多个样本，记下本次新增的周期，后续样本改为累加
+		user.DailyLogs = append(user.DailyLogs, DailyLogEntry{Date: sample.Timestamp.Format("20060102")})
+		user.MonthlyLogs = append(user.MonthlyLogs, MonthlyLogEntry{Month: sample.Timestamp.Format("200601")})
+		user.YearlyLogs = append(user.YearlyLogs, YearlyLogEntry{Year: sample.Timestamp.Format("2006")})
+
+		models = append(models, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"email_as_id": sample.Traffic.Name}).
+			SetUpdate(update).
+			SetArrayFilters(options.ArrayFilters{Filters: filters}).
+			SetUpsert(true))
 	}
 
-	arrayFilters := options.ArrayFilters{
-		Filters: filters,
+	_, err = collection.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(true))
+	if err != nil {
+		var bulkErr mongo.BulkWriteException
+		if errors.As(err, &bulkErr) && len(bulkErr.WriteErrors) > 0 {
+			logTrafficSamples(ctx, samples[:bulkErr.WriteErrors[0].Index])
+			return bulkErr.WriteErrors[0].Index, err
+		}
+		return 0, err
 	}
 
-	upsert := true
-	updateOptions := options.UpdateOptions{
-		ArrayFilters: &arrayFilters,
-		Upsert:       &upsert,
+	logTrafficSamples(ctx, samples)
+	return len(samples), nil
+}
+
+// hourStart 返回 t 所在小时的起点（按 t 的时区计算）
+func hourStart(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, t.Location())
+}
+
+// logTrafficSamples MongoDB版本的按小时流量时间序列写入，与 PostgreSQL 的 traffic_samples 对应。
+// 每个协议一条记录，剩余流量和上下行记在 protocol 为空的记录上。
+// 时间序列是用户流量日志的派生数据，写入失败只记录日志。
+func logTrafficSamples(ctx context.Context, samples []TrafficSample) {
+	if len(samples) == 0 {
+		return
 	}
 
-	_, err = collection.UpdateOne(ctx, filter, update, &updateOptions)
-	return err
+	models := make([]mongo.WriteModel, 0, len(samples))
+	add := func(sample TrafficSample, protocol string, inc bson.M) {
+		models = append(models, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{
+				"user_email":   sample.Traffic.Name,
+				"node":         sample.Domain,
+				"protocol":     protocol,
+				"bucket_start": hourStart(sample.Timestamp),
+			}).
+			SetUpdate(bson.M{"$inc": inc}).
+			SetUpsert(true))
+	}
+	for _, sample := range samples {
+		rest := sample.Traffic.Total
+		for protocol, value := range sample.Traffic.Protocols {
+			if protocol == "" {
+				continue
+			}
+			rest -= value
+			add(sample, protocol, bson.M{"traffic": value})
+		}
+		add(sample, "", bson.M{
+			"traffic":  rest,
+			"uplink":   sample.Traffic.Uplink,
+			"downlink": sample.Traffic.Downlink,
+		})
+	}
 
+	if _, err := trafficSamples.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false)); err != nil {
+		log.Printf("写入流量时间序列失败: %v", err)
+	}
 }
 
-func Cron_loggingJobs(c *cron.Cron, instance *box.Box) {
+func Cron_loggingJobs(c *cron.Cron, instance *box.Box, manager *thirdparty.UserManager) {
 
 	// cron job by 12 hours - 支持MongoDB和PostgreSQL两种数据库
 	// c.AddFunc("0 0 */12 * * *", func() {
@@ -321,6 +483,13 @@ func Cron_loggingJobs(c *cron.Cron, instance *box.Box) {
 	c.AddFunc("0 */15 * * * *", func() {
 		// 15 mins - 支持MongoDB和PostgreSQL两种数据库
 
+		// 先重放之前写入失败的样本
+		if replayed, failed, err := ReplaySpool(true); err != nil {
+			log.Printf("重放本地流量缓存失败: %v\n", err)
+		} else if replayed > 0 || failed > 0 {
+			log.Printf("重放本地流量缓存: 成功 %d, 失败 %d", replayed, failed)
+		}
+
 		timesteamp := time.Now().Local()
 		usageData, err := thirdparty.UsageDataOfAll(instance)
 		if err != nil {
@@ -333,42 +502,40 @@ func Cron_loggingJobs(c *cron.Cron, instance *box.Box) {
 			return
 		}
 
-		// 根据环境变量决定使用哪种数据库
-		usePostgreSQL := isUsingPostgreSQL()
-
-		if usePostgreSQL {
-			log.Printf("使用PostgreSQL记录流量数据...")
-			// 使用 Supabase RPC 调用方式记录流量
-			for _, perUser := range usageData {
-
-				// 记录用户流量
-				if err := LogUserTrafficPG(perUser.Name, timesteamp, perUser.Total); err != nil {
-					log.Printf("PostgreSQL用户流量记录失败: %v\n", err)
-				}
+		// 用户流量批量写入，节点流量在本地汇总后写入一次
+		// 写入失败的样本会先缓存到本地文件，之后的任务中重放
+		samples := make([]TrafficSample, 0, len(usageData)+1)
+		for _, perUser := range usageData {
+			// perUser = traffic: {Name: "tom", Total: 100}
+			samples = append(samples, NewTrafficSample(SampleKindUser, currentDomain, timesteamp, perUser))
+		}
+		samples = append(samples, NewTrafficSample(SampleKindNode, currentDomain, timesteamp, SumTraffic(currentDomain, usageData)))
+		if RecordSamples(samples) {
+			markTrafficFlushed(time.Now())
+		}
+		log.Printf("流量记录完成: %v 用户=%d", timesteamp.Format("20060102 15:04:05"), len(usageData))
 
-				// 记录节点流量
-				if err := LogNodeTrafficPG(currentDomain, timesteamp, perUser.Total); err != nil {
-					log.Printf("PostgreSQL节点流量记录失败: %v\n", err)
-				}
-			}
-			log.Printf("PostgreSQL流量记录完成: %v 用户=%d", timesteamp.Format("20060102 15:04:05"), len(usageData))
-		} else {
-			log.Printf("使用MongoDB记录流量数据...")
-			// 使用原有的MongoDB逻辑
-			for _, perUser := range usageData {
-
-				// perUser = traffic: {Name: "tom", Total: 100}
-				if err := LogUserTraffic(userTrafficLogs, perUser.Name, timesteamp, perUser.Total); err != nil {
-					log.Printf("MongoDB用户流量记录失败: %v\n", err)
-				}
-
-				if err := LogNodeTraffic(nodeTrafficLogs, currentDomain, timesteamp, perUser.Total); err != nil {
-					log.Printf("MongoDB节点流量记录失败: %v\n", err)
-				}
+		// 流量写入后检查配额，超额用户立即从本节点移除，其他节点在下一次同步时移除
+		overdue, err := EnforceQuotas()
+		if err != nil {
+			log.Printf("检查流量配额失败: %v\n", err)
+		}
+		for _, email := range overdue {
+			if err := manager.RemoveUser(email); err != nil {
+				log.Printf("移除超额用户 %s 失败: %v\n", email, err)
 			}
-			log.Printf("MongoDB流量记录完成: %v 用户=%d", timesteamp.Format("20060102 15:04:05"), len(usageData))
 		}
+		if len(overdue) > 0 {
+			ReportNodeUserSet(manager)
+		}
+
+	})
 
+	// 每天清理过期的样本去重记录
+	c.AddFunc("0 30 3 * * *", func() {
+		if err := PruneSampleAcks(time.Now().AddDate(0, 0, -sampleAckRetentionDays)); err != nil {
+			log.Printf("清理流量样本去重记录失败: %v\n", err)
+		}
 	})
 
 }
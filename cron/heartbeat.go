@@ -0,0 +1,162 @@
+package cron
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/robfig/cron"
+	C "github.com/sagernet/sing-box/constant"
+	"github.com/xvv6u577/logv2fs/database"
+	"github.com/xvv6u577/logv2fs/model"
+	thirdparty "github.com/xvv6u577/logv2fs/pkg"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	// 节点心跳周期，默认每分钟一次
+	nodeHeartbeatSpec = os.Getenv("NODE_HEARTBEAT_SPEC")
+	nodeHeartbeats    = database.GetCollection(model.NodeHeartbeat{})
+
+	nodeStartedAt = time.Now()
+	// 最近一次流量全部写入数据库的时间（UnixNano），0 表示还没有写入过
+	lastTrafficFlush atomic.Int64
+)
+
+// NodeBuildInfo 节点进程启动后不再变化的信息
+type NodeBuildInfo struct {
+	Version  string
+	Inbounds []model.NodeInbound
+}
+
+// markTrafficFlushed 记录流量写入成功的时间
+func markTrafficFlushed(t time.Time) {
+	lastTrafficFlush.Store(t.UnixNano())
+}
+
+// LastTrafficFlush 返回最近一次流量全部写入数据库的时间，没有写入过时返回 nil
+func LastTrafficFlush() *time.Time {
+	nanos := lastTrafficFlush.Load()
+	if nanos == 0 {
+		return nil
+	}
+	t := time.Unix(0, nanos)
+	return &t
+}
+
+// NewNodeHeartbeat 生成当前节点的心跳
+func NewNodeHeartbeat(manager *thirdparty.UserManager, info NodeBuildInfo) model.NodeHeartbeat {
+	hostname, _ := os.Hostname()
+
+	pending, err := PendingSamples()
+	if err != nil {
+		log.Printf("读取本地流量缓存失败: %v", err)
+	}
+
+	var oldest *time.Time
+	for i := range pending {
+		if oldest == nil || pending[i].Timestamp.Before(*oldest) {
+			oldest = &pending[i].Timestamp
+		}
+	}
+
+	now := time.Now()
+	return model.NodeHeartbeat{
+		Domain_As_Id:   currentDomain,
+		Hostname:       hostname,
+		Version:        info.Version,
+		CoreVersion:    C.Version,
+		StartedAt:      nodeStartedAt,
+		UptimeSeconds:  int64(now.Sub(nodeStartedAt).Seconds()),
+		UserCount:      manager.Snapshot().UserCount,
+		Inbounds:       info.Inbounds,
+		LastFlushAt:    LastTrafficFlush(),
+		PendingSamples: len(pending),
+		OldestPending:  oldest,
+	}
+}
+
+// SendNodeHeartbeat 将节点的运行状态写入数据库，reported_at 使用数据库时间，供控制面判断节点是否在线
+func SendNodeHeartbeat(manager *thirdparty.UserManager, info NodeBuildInfo) error {
+	heartbeat := NewNodeHeartbeat(manager, info)
+
+	var err error
+	if isUsingPostgreSQL() {
+		err = LogNodeHeartbeatPG(heartbeat)
+	} else {
+		err = LogNodeHeartbeat(nodeHeartbeats, heartbeat)
+	}
+	if err != nil {
+		log.Printf("上报节点心跳失败: %v", err)
+	}
+	return err
+}
+
+// LogNodeHeartbeatPG PostgreSQL版本的节点心跳上报，reported_at 由数据库的 NOW() 写入
+func LogNodeHeartbeatPG(heartbeat model.NodeHeartbeat) error {
+	inbounds, err := json.Marshal(heartbeat.Inbounds)
+	if err != nil {
+		return err
+	}
+
+	updates := clause.AssignmentColumns([]string{"hostname", "version", "core_version", "started_at", "uptime_seconds",
+		"user_count", "inbounds", "last_flush_at", "pending_samples", "oldest_pending"})
+	updates = append(updates, clause.Assignment{Column: clause.Column{Name: "reported_at"}, Value: gorm.Expr("NOW()")})
+
+	return database.GetPostgresDB().Model(&model.NodeHeartbeatPG{}).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "domain_as_id"}},
+		DoUpdates: updates,
+	}).Create(map[string]interface{}{
+		"domain_as_id":    heartbeat.Domain_As_Id,
+		"hostname":        heartbeat.Hostname,
+		"version":         heartbeat.Version,
+		"core_version":    heartbeat.CoreVersion,
+		"started_at":      heartbeat.StartedAt,
+		"uptime_seconds":  heartbeat.UptimeSeconds,
+		"user_count":      heartbeat.UserCount,
+		"inbounds":        datatypes.JSON(inbounds),
+		"last_flush_at":   heartbeat.LastFlushAt,
+		"pending_samples": heartbeat.PendingSamples,
+		"oldest_pending":  heartbeat.OldestPending,
+		"reported_at":     gorm.Expr("NOW()"),
+	}).Error
+}
+
+// LogNodeHeartbeat MongoDB版本的节点心跳上报，reported_at 由 $currentDate 写入数据库时间
+func LogNodeHeartbeat(collection *mongo.Collection, heartbeat model.NodeHeartbeat) error {
+	var ctx, cancel = context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// ReportedAt 为零值时不写入 $set，避免与 $currentDate 冲突
+	heartbeat.ReportedAt = time.Time{}
+	_, err := collection.UpdateOne(ctx,
+		bson.M{"domain_as_id": heartbeat.Domain_As_Id},
+		bson.M{"$set": heartbeat, "$currentDate": bson.M{"reported_at": true}},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+// Cron_heartbeatJobs 启动时立即上报一次心跳，之后定期上报
+func Cron_heartbeatJobs(c *cron.Cron, manager *thirdparty.UserManager, info NodeBuildInfo) {
+	spec := nodeHeartbeatSpec
+	if spec == "" {
+		spec = "0 * * * * *"
+	}
+
+	go SendNodeHeartbeat(manager, info)
+
+	if err := c.AddFunc(spec, func() {
+		SendNodeHeartbeat(manager, info)
+	}); err != nil {
+		log.Printf("注册节点心跳任务失败: %v", err)
+	}
+}
@@ -0,0 +1,433 @@
+package cron
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron"
+	"github.com/sagernet/quic-go"
+	"github.com/xvv6u577/logv2fs/database"
+	"github.com/xvv6u577/logv2fs/model"
+	"github.com/xvv6u577/logv2fs/notify"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 节点探测方式
+const (
+	ProbeTCP  = "tcp"
+	ProbeTLS  = "tls"
+	ProbeQUIC = "quic"
+)
+
+var (
+	// 节点探测周期，默认每 5 分钟一次
+	nodeHealthSpec = os.Getenv("NODE_HEALTH_SPEC")
+	// 连续失败多少次后标记为不可用，默认 3 次
+	nodeHealthFailures = os.Getenv("NODE_HEALTH_FAILURES")
+	// 探测记录保留天数，默认 7 天
+	nodeHealthRetentionDays = os.Getenv("NODE_HEALTH_RETENTION_DAYS")
+
+	subscriptionNodes = database.GetCollection(model.SubscriptionNode{})
+	nodeHealthCol     = database.GetCollection(model.NodeHealth{})
+	nodeHealthSamples = database.GetCollection(model.NodeHealthSample{})
+
+	// 同时探测的节点数量
+	nodeProbeConcurrency = 16
+	nodeProbeTimeout     = 5 * time.Second
+)
+
+// NodeHealthFailures 返回标记节点不可用所需的连续失败次数
+func NodeHealthFailures() int {
+	failures, err := strconv.Atoi(nodeHealthFailures)
+	if err != nil || failures < 1 {
+		return 3
+	}
+	return failures
+}
+
+// NodeHealthRetentionDays 返回探测记录的保留天数
+func NodeHealthRetentionDays() int {
+	days, err := strconv.Atoi(nodeHealthRetentionDays)
+	if err != nil || days < 1 {
+		return 7
+	}
+	return days
+}
+
+// NodeProbeTarget 返回节点的探测方式和地址。
+// Hysteria2 和 TUIC 走 QUIC，ss2022 只检查 TCP 端口，vlessCDN 经 CDN 访问所以探测域名，其余类型对 IP 做 TLS 握手
+func NodeProbeTarget(node model.SubscriptionNode) (probe, addr string) {
+	host := node.IP
+	if host == "" {
+		host = node.Domain
+	}
+
+	switch node.Type {
+	case "hysteria2", "tuic":
+		probe = ProbeQUIC
+	case "ss2022":
+		probe = ProbeTCP
+	case "vlessCDN":
+		probe, host = ProbeTLS, node.Domain
+	default:
+		probe = ProbeTLS
+	}
+	return probe, net.JoinHostPort(host, node.SERVER_PORT)
+}
+
+// ProbeNode 探测一次节点，延迟为完成握手所用的时间
+func ProbeNode(ctx context.Context, node model.SubscriptionNode) model.NodeHealthSample {
+	probe, addr := NodeProbeTarget(node)
+	sample := model.NodeHealthSample{NodeID: node.ID, Remark: node.Remark, Probe: probe, CheckedAt: time.Now()}
+
+	ctx, cancel := context.WithTimeout(ctx, nodeProbeTimeout)
+	defer cancel()
+
+	var err error
+	start := time.Now()
+	switch probe {
+	case ProbeQUIC:
+		err = probeQUIC(ctx, addr, node.TLSServerName())
+	case ProbeTLS:
+		err = probeTLS(ctx, addr, node.TLSServerName())
+	default:
+		err = probeTCP(ctx, addr)
+	}
+	if err != nil {
+		sample.Error = err.Error()
+		return sample
+	}
+
+	sample.OK = true
+	sample.LatencyMs = time.Since(start).Milliseconds()
+	return sample
+}
+
+func probeTCP(ctx context.Context, addr string) error {
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// probeTLS 完成一次 TLS 握手。Reality 节点回落到伪装站点，证书不一定匹配，所以不验证证书
+func probeTLS(ctx context.Context, addr, serverName string) error {
+	dialer := &tls.Dialer{Config: &tls.Config{ServerName: serverName, InsecureSkipVerify: true}}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// probeQUIC 完成一次 QUIC 握手，Hysteria2 和 TUIC 都接受 h3 ALPN
+func probeQUIC(ctx context.Context, addr, serverName string) error {
+	tlsConf := &tls.Config{ServerName: serverName, InsecureSkipVerify: true, NextProtos: []string{"h3"}}
+	conn, err := quic.DialAddr(ctx, addr, tlsConf, &quic.Config{HandshakeIdleTimeout: nodeProbeTimeout})
+	if err != nil {
+		return err
+	}
+	return conn.CloseWithError(0, "")
+}
+
+// probeNodes 并发探测一组节点，结果顺序与输入一致
+func probeNodes(nodes []model.SubscriptionNode) []model.NodeHealthSample {
+	samples := make([]model.NodeHealthSample, len(nodes))
+	sem := make(chan struct{}, nodeProbeConcurrency)
+
+	var wg sync.WaitGroup
+	for i := range nodes {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			samples[i] = ProbeNode(context.Background(), nodes[i])
+		}(i)
+	}
+	wg.Wait()
+
+	return samples
+}
+
+// NextNodeHealth 根据上一次的状态和本次探测结果计算新的状态。
+// 没有记录的节点视为可用；连续失败达到 maxFailures 次后标记为不可用，一次成功即恢复
+func NextNodeHealth(prev model.NodeHealth, found bool, sample model.NodeHealthSample, maxFailures int) model.NodeHealth {
+	if !found {
+		prev = model.NodeHealth{Healthy: true, ChangedAt: sample.CheckedAt}
+	}
+
+	next := prev
+	next.NodeID = sample.NodeID
+	next.Remark = sample.Remark
+	next.Probe = sample.Probe
+	next.CheckedAt = sample.CheckedAt
+
+	if sample.OK {
+		next.LatencyMs = sample.LatencyMs
+		next.LastError = ""
+		next.Failures = 0
+		if !prev.Healthy {
+			next.Healthy = true
+			next.ChangedAt = sample.CheckedAt
+		}
+		return next
+	}
+
+	next.LastError = sample.Error
+	next.Failures++
+	if prev.Healthy && next.Failures >= maxFailures {
+		next.Healthy = false
+		next.ChangedAt = sample.CheckedAt
+	}
+	return next
+}
+
+// sendNodeHealthAlert 节点变为不可用时告警，恢复时只记录日志
+func sendNodeHealthAlert(prev, next model.NodeHealth) {
+	if prev.Healthy == next.Healthy {
+		return
+	}
+	if next.Healthy {
+		log.Printf("节点 %s 已恢复，延迟 %dms", next.Remark, next.LatencyMs)
+		return
+	}
+
+	alert := Alert{
+		Kind:    notify.EventNodeUnreachable,
+		Subject: "节点无法连接: " + next.Remark,
+		Message: fmt.Sprintf("%s 探测连续失败 %d 次: %s", next.Probe, next.Failures, next.LastError),
+	}
+	if err := currentNotifier().Notify(alert); err != nil {
+		log.Printf("发送告警失败 (%s): %v", alert.Subject, err)
+	}
+}
+
+// CheckNodeHealth 探测 subscription_nodes 中的所有节点，保存状态和探测记录
+func CheckNodeHealth() error {
+	if isUsingPostgreSQL() {
+		return CheckNodeHealthPG()
+	}
+	return CheckNodeHealthMongo()
+}
+
+// CheckNodeHealthPG PostgreSQL版本的节点探测
+func CheckNodeHealthPG() error {
+	db := database.GetPostgresDB()
+	if db == nil {
+		return nil
+	}
+
+	// 与 MongoDB 版本相同，work 类型不是订阅节点，不探测
+	var records []model.SubscriptionNodePG
+	if err := db.Where("type <> ?", "work").Find(&records).Error; err != nil {
+		log.Printf("查询节点失败: %v", err)
+		return err
+	}
+
+	nodes := make([]model.SubscriptionNode, len(records))
+	for i, record := range records {
+		nodes[i] = model.SubscriptionNode{
+			ID:          record.ID.String(),
+			Type:        record.Type,
+			Remark:      record.Remark,
+			Domain:      record.Domain,
+			IP:          record.IP,
+			SNI:         record.SNI,
+			SERVER_PORT: record.ServerPort,
+		}
+	}
+
+	var current []model.NodeHealthPG
+	if err := db.Find(&current).Error; err != nil {
+		log.Printf("查询节点健康状态失败: %v", err)
+		return err
+	}
+	previous := make(map[string]model.NodeHealth, len(current))
+	for _, h := range current {
+		previous[h.NodeID] = model.NodeHealth{
+			NodeID: h.NodeID, Remark: h.Remark, Healthy: h.Healthy, Probe: h.Probe, LatencyMs: h.LatencyMs,
+			LastError: h.LastError, Failures: h.Failures, CheckedAt: h.CheckedAt, ChangedAt: h.ChangedAt,
+		}
+	}
+
+	samples := probeNodes(nodes)
+	maxFailures := NodeHealthFailures()
+
+	sampleRecords := make([]model.NodeHealthSamplePG, 0, len(samples))
+	for _, sample := range samples {
+		sampleRecords = append(sampleRecords, model.NodeHealthSamplePG{
+			NodeID: sample.NodeID, Remark: sample.Remark, Probe: sample.Probe, OK: sample.OK,
+			LatencyMs: sample.LatencyMs, Error: sample.Error, CheckedAt: sample.CheckedAt,
+		})
+
+		prev, found := previous[sample.NodeID]
+		next := NextNodeHealth(prev, found, sample, maxFailures)
+
+		if err := db.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "node_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"remark", "healthy", "probe", "latency_ms", "last_error", "failures", "checked_at", "changed_at"}),
+		}).Create(&model.NodeHealthPG{
+			NodeID: next.NodeID, Remark: next.Remark, Healthy: next.Healthy, Probe: next.Probe, LatencyMs: next.LatencyMs,
+			LastError: next.LastError, Failures: next.Failures, CheckedAt: next.CheckedAt, ChangedAt: next.ChangedAt,
+		}).Error; err != nil {
+			log.Printf("保存节点 %s 健康状态失败: %v", sample.Remark, err)
+			continue
+		}
+		if found {
+			sendNodeHealthAlert(prev, next)
+		}
+	}
+
+	if len(sampleRecords) > 0 {
+		if err := db.Create(&sampleRecords).Error; err != nil {
+			log.Printf("保存节点探测记录失败: %v", err)
+		}
+	}
+
+	return pruneNodeHealthPG(db, probedNodeIDs(nodes))
+}
+
+// probedNodeIDs 返回本次探测的所有节点 ID。保存状态失败的节点也在其中，避免其状态被当作已删除节点清理
+func probedNodeIDs(nodes []model.SubscriptionNode) []string {
+	ids := make([]string, 0, len(nodes))
+	for _, node := range nodes {
+		ids = append(ids, node.ID)
+	}
+	return ids
+}
+
+// pruneNodeHealthPG 删除已删除节点的状态和过期的探测记录
+func pruneNodeHealthPG(db *gorm.DB, nodeIDs []string) error {
+	query := db.Model(&model.NodeHealthPG{})
+	if len(nodeIDs) > 0 {
+		query = query.Where("node_id NOT IN ?", nodeIDs)
+	} else {
+		query = query.Where("1 = 1")
+	}
+	if err := query.Delete(&model.NodeHealthPG{}).Error; err != nil {
+		log.Printf("清理节点健康状态失败: %v", err)
+		return err
+	}
+
+	cutoff := time.Now().AddDate(0, 0, -NodeHealthRetentionDays())
+	if err := db.Where("checked_at < ?", cutoff).Delete(&model.NodeHealthSamplePG{}).Error; err != nil {
+		log.Printf("清理节点探测记录失败: %v", err)
+		return err
+	}
+	return nil
+}
+
+// CheckNodeHealthMongo MongoDB版本的节点探测，没有 node_id 的节点会被跳过，在管理后台打开节点列表后会自动补齐
+func CheckNodeHealthMongo() error {
+	var ctx, cancel = context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	cur, err := subscriptionNodes.Find(ctx, bson.M{"type": bson.M{"$ne": "work"}})
+	if err != nil {
+		log.Printf("查询节点失败: %v", err)
+		return err
+	}
+	var all []model.SubscriptionNode
+	if err := cur.All(ctx, &all); err != nil {
+		log.Printf("查询节点失败: %v", err)
+		return err
+	}
+
+	var nodes []model.SubscriptionNode
+	for _, node := range all {
+		if node.ID == "" {
+			log.Printf("节点 %s 没有 node_id，跳过探测", node.Remark)
+			continue
+		}
+		nodes = append(nodes, node)
+	}
+
+	cur, err = nodeHealthCol.Find(ctx, bson.M{})
+	if err != nil {
+		log.Printf("查询节点健康状态失败: %v", err)
+		return err
+	}
+	var current []model.NodeHealth
+	if err := cur.All(ctx, &current); err != nil {
+		log.Printf("查询节点健康状态失败: %v", err)
+		return err
+	}
+	cancel()
+
+	previous := make(map[string]model.NodeHealth, len(current))
+	for _, h := range current {
+		previous[h.NodeID] = h
+	}
+
+	samples := probeNodes(nodes)
+	maxFailures := NodeHealthFailures()
+
+	// 探测本身可能耗时较长，写入使用新的超时
+	ctx, cancel = context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	docs := make([]interface{}, 0, len(samples))
+	for _, sample := range samples {
+		docs = append(docs, sample)
+
+		prev, found := previous[sample.NodeID]
+		next := NextNodeHealth(prev, found, sample, maxFailures)
+
+		if _, err := nodeHealthCol.UpdateOne(ctx,
+			bson.M{"node_id": next.NodeID},
+			bson.M{"$set": next},
+			options.Update().SetUpsert(true),
+		); err != nil {
+			log.Printf("保存节点 %s 健康状态失败: %v", sample.Remark, err)
+			continue
+		}
+		if found {
+			sendNodeHealthAlert(prev, next)
+		}
+	}
+
+	if len(docs) > 0 {
+		if _, err := nodeHealthSamples.InsertMany(ctx, docs); err != nil {
+			log.Printf("保存节点探测记录失败: %v", err)
+		}
+	}
+
+	if _, err := nodeHealthCol.DeleteMany(ctx, bson.M{"node_id": bson.M{"$nin": probedNodeIDs(nodes)}}); err != nil {
+		log.Printf("清理节点健康状态失败: %v", err)
+		return err
+	}
+	cutoff := time.Now().AddDate(0, 0, -NodeHealthRetentionDays())
+	if _, err := nodeHealthSamples.DeleteMany(ctx, bson.M{"checked_at": bson.M{"$lt": cutoff}}); err != nil {
+		log.Printf("清理节点探测记录失败: %v", err)
+		return err
+	}
+	return nil
+}
+
+// Cron_nodeHealthJobs 定期探测节点的可用性
+func Cron_nodeHealthJobs(c *cron.Cron) {
+	spec := nodeHealthSpec
+	if spec == "" {
+		spec = "0 */5 * * * *"
+	}
+
+	if err := c.AddFunc(spec, func() {
+		if err := CheckNodeHealth(); err != nil {
+			log.Printf("节点健康探测失败: %v", err)
+		}
+	}); err != nil {
+		log.Printf("注册节点健康探测任务失败: %v", err)
+	}
+}
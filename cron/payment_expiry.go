@@ -0,0 +1,193 @@
+package cron
+
+import (
+	"context"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/robfig/cron"
+	"github.com/xvv6u577/logv2fs/database"
+	"github.com/xvv6u577/logv2fs/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	// 缴费到期后的宽限天数，默认 3 天
+	paymentGraceDays = os.Getenv("PAYMENT_GRACE_DAYS")
+	// 缴费到期检查周期，默认每小时一次
+	paymentExpirySpec = os.Getenv("PAYMENT_EXPIRY_SPEC")
+	paymentRecords    = database.GetCollection(model.PaymentRecord{})
+)
+
+// PaymentGraceDays 返回缴费到期后的宽限天数
+func PaymentGraceDays() int {
+	days, err := strconv.Atoi(paymentGraceDays)
+	if err != nil || days < 0 {
+		return 3
+	}
+	return days
+}
+
+// PaymentCovers 判断缴费周期是否覆盖 t，结束日期当天仍在服务期内
+func PaymentCovers(startDate, endDate, t time.Time) bool {
+	return !t.Before(startDate) && t.Before(endDate.AddDate(0, 0, 1))
+}
+
+// paymentExpiryCutoff 最后一次缴费的结束日期早于该时间的用户视为到期
+func paymentExpiryCutoff(now time.Time) time.Time {
+	return now.AddDate(0, 0, -(PaymentGraceDays() + 1))
+}
+
+// ExpireUnpaidUsers 将最近一次缴费周期已结束且超过宽限期的用户设为 overdue，原因记为 payment。
+// 没有任何缴费记录的用户不受影响。返回本次被设为 overdue 的用户。
+func ExpireUnpaidUsers() ([]string, error) {
+	if isUsingPostgreSQL() {
+		return ExpireUnpaidUsersPG()
+	}
+	return ExpireUnpaidUsersMongo()
+}
+
+// ExpireUnpaidUsersPG PostgreSQL版本的缴费到期检查
+func ExpireUnpaidUsersPG() ([]string, error) {
+	db := database.GetPostgresDB()
+	if db == nil {
+		return nil, nil
+	}
+
+	var emails []string
+	query := `
+		SELECT u.email_as_id
+		FROM user_traffic_logs u
+		JOIN (
+			SELECT user_email_as_id, MAX(end_date) AS last_end_date
+			FROM payment_records
+			GROUP BY user_email_as_id
+		) p ON p.user_email_as_id = u.email_as_id
+		WHERE u.status = 'plain' AND u.role <> 'admin' AND p.last_end_date < ?
+	`
+	if err := db.Raw(query, paymentExpiryCutoff(time.Now())).Scan(&emails).Error; err != nil {
+		log.Printf("查询缴费到期用户失败: %v", err)
+		return nil, err
+	}
+
+	if len(emails) == 0 {
+		return nil, nil
+	}
+
+	if err := db.Model(&UserTrafficLogsPG{}).
+		Where("email_as_id IN ? AND status = ?", emails, "plain").
+		Updates(map[string]interface{}{"status": "overdue", "overdue_reason": model.OverdueReasonPayment, "updated_at": time.Now()}).Error; err != nil {
+		log.Printf("设置缴费到期用户为 overdue 失败: %v", err)
+		return nil, err
+	}
+
+	return emails, nil
+}
+
+// ExpireUnpaidUsersMongo MongoDB版本的缴费到期检查
+func ExpireUnpaidUsersMongo() ([]string, error) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	pipeline := []bson.M{
+		{"$group": bson.M{"_id": "$user_email_as_id", "last_end_date": bson.M{"$max": "$end_date"}}},
+		{"$match": bson.M{"last_end_date": bson.M{"$lt": paymentExpiryCutoff(time.Now())}}},
+	}
+
+	cur, err := paymentRecords.Aggregate(ctx, pipeline)
+	if err != nil {
+		log.Printf("查询缴费到期用户失败: %v", err)
+		return nil, err
+	}
+
+	var results []struct {
+		Email string `bson:"_id"`
+	}
+	if err := cur.All(ctx, &results); err != nil {
+		log.Printf("查询缴费到期用户失败: %v", err)
+		return nil, err
+	}
+
+	var expired []string
+	for _, result := range results {
+		updateResult, err := userTrafficLogs.UpdateOne(ctx,
+			bson.M{"email_as_id": result.Email, "status": "plain", "role": bson.M{"$ne": "admin"}},
+			bson.M{"$set": bson.M{"status": "overdue", "overdue_reason": model.OverdueReasonPayment, "updated_at": time.Now()}},
+		)
+		if err != nil {
+			log.Printf("设置用户 %s 为 overdue 失败: %v", result.Email, err)
+			continue
+		}
+		if updateResult.ModifiedCount > 0 {
+			expired = append(expired, result.Email)
+		}
+	}
+
+	return expired, nil
+}
+
+// PaymentLapsed 判断用户最近一次缴费周期是否已结束且超过宽限期，没有缴费记录的用户返回 false
+func PaymentLapsed(email string) (bool, error) {
+	if isUsingPostgreSQL() {
+		return PaymentLapsedPG(email)
+	}
+	return PaymentLapsedMongo(email)
+}
+
+// PaymentLapsedPG PostgreSQL版本的单个用户缴费到期判断
+func PaymentLapsedPG(email string) (bool, error) {
+	var lastEndDate *time.Time
+	if err := database.GetPostgresDB().Model(&model.PaymentRecordPG{}).
+		Select("MAX(end_date)").
+		Where("user_email_as_id = ?", email).
+		Scan(&lastEndDate).Error; err != nil {
+		return false, err
+	}
+	return lastEndDate != nil && lastEndDate.Before(paymentExpiryCutoff(time.Now())), nil
+}
+
+// PaymentLapsedMongo MongoDB版本的单个用户缴费到期判断
+func PaymentLapsedMongo(email string) (bool, error) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var last model.PaymentRecord
+	err := paymentRecords.FindOne(ctx,
+		bson.M{"user_email_as_id": email},
+		options.FindOne().SetSort(bson.D{{Key: "end_date", Value: -1}}),
+	).Decode(&last)
+	if err == mongo.ErrNoDocuments {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return last.EndDate.Before(paymentExpiryCutoff(time.Now())), nil
+}
+
+// Cron_paymentExpiryJobs 定期检查缴费到期的用户，onExpired 用于通知节点移除这些用户
+func Cron_paymentExpiryJobs(c *cron.Cron, onExpired func(emails []string)) {
+	spec := paymentExpirySpec
+	if spec == "" {
+		spec = "0 0 * * * *"
+	}
+
+	if err := c.AddFunc(spec, func() {
+		expired, err := ExpireUnpaidUsers()
+		if err != nil {
+			return
+		}
+		if len(expired) > 0 {
+			log.Printf("缴费到期用户已设为 overdue (宽限 %d 天): %v", PaymentGraceDays(), expired)
+			if onExpired != nil {
+				onExpired(expired)
+			}
+		}
+	}); err != nil {
+		log.Printf("注册缴费到期任务失败: %v", err)
+	}
+}
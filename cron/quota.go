@@ -0,0 +1,177 @@
+package cron
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/xvv6u577/logv2fs/database"
+	helper "github.com/xvv6u577/logv2fs/helpers"
+	"github.com/xvv6u577/logv2fs/model"
+	"github.com/xvv6u577/logv2fs/notify"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// QuotaWarnLevels 流量预警阈值（百分比），从高到低排列
+var QuotaWarnLevels = []int{95, 80}
+
+// QuotaUsage 用户的流量配额使用情况
+type QuotaUsage struct {
+	EmailAsId   string
+	Used        int64
+	Credit      int64
+	QuotaWarned int
+}
+
+// QuotaWarnLevel 返回用户当前达到的最高预警阈值，未达到任何阈值时返回 0。
+// credit 为 0 表示不限流量。
+func QuotaWarnLevel(used, credit int64) int {
+	if credit <= 0 {
+		return 0
+	}
+	for _, level := range QuotaWarnLevels {
+		if used*100 >= credit*int64(level) {
+			return level
+		}
+	}
+	return 0
+}
+
+// QuotaExceeded 判断用户是否已用完流量
+func QuotaExceeded(used, credit int64) bool {
+	return credit > 0 && used >= credit
+}
+
+// EnforceQuotas 检查所有活跃用户的流量配额：
+// 超出配额的用户状态改为 overdue，达到 80% / 95% 的用户记录预警。
+// 返回本次被设为 overdue 的用户。
+func EnforceQuotas() ([]string, error) {
+	if isUsingPostgreSQL() {
+		return EnforceQuotasPG()
+	}
+	return EnforceQuotasMongo(userTrafficLogs)
+}
+
+// EnforceQuotasPG PostgreSQL版本的流量配额检查
+func EnforceQuotasPG() ([]string, error) {
+	db := database.GetPostgresDB()
+	if db == nil {
+		return nil, nil
+	}
+
+	var usages []QuotaUsage
+	if err := db.Model(&UserTrafficLogsPG{}).
+		Select("email_as_id, used, credit, quota_warned").
+		Where("status = ? AND role <> ? AND credit > 0", "plain", "admin").
+		Scan(&usages).Error; err != nil {
+		log.Printf("查询用户流量配额失败: %v", err)
+		return nil, err
+	}
+
+	var overdue []string
+	for _, usage := range usages {
+		if QuotaExceeded(usage.Used, usage.Credit) {
+			if err := db.Model(&UserTrafficLogsPG{}).
+				Where("email_as_id = ? AND status = ?", usage.EmailAsId, "plain").
+				Updates(map[string]interface{}{"status": "overdue", "overdue_reason": model.OverdueReasonQuota, "updated_at": time.Now()}).Error; err != nil {
+				log.Printf("设置用户 %s 为 overdue 失败: %v", usage.EmailAsId, err)
+				continue
+			}
+			log.Printf("用户 %s 流量已用完 (%d/%d)，状态设为 overdue", usage.EmailAsId, usage.Used, usage.Credit)
+			overdue = append(overdue, usage.EmailAsId)
+			continue
+		}
+
+		level := QuotaWarnLevel(usage.Used, usage.Credit)
+		if level > usage.QuotaWarned {
+			// 多个节点同时检查时只有一个能更新预警等级，只由它发送通知
+			result := db.Model(&UserTrafficLogsPG{}).
+				Where("email_as_id = ? AND quota_warned < ?", usage.EmailAsId, level).
+				Update("quota_warned", level)
+			if result.Error != nil {
+				log.Printf("记录用户 %s 流量预警失败: %v", usage.EmailAsId, result.Error)
+				continue
+			}
+			if result.RowsAffected > 0 {
+				warnQuota(usage, level)
+			}
+		}
+	}
+
+	return overdue, nil
+}
+
+// EnforceQuotasMongo MongoDB版本的流量配额检查
+func EnforceQuotasMongo(collection *mongo.Collection) ([]string, error) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	filter := bson.M{"status": "plain", "role": bson.M{"$ne": "admin"}, "credit": bson.M{"$gt": 0}}
+	projections := bson.D{
+		{Key: "email_as_id", Value: 1},
+		{Key: "used", Value: 1},
+		{Key: "credit", Value: 1},
+		{Key: "quota_warned", Value: 1},
+	}
+
+	cur, err := collection.Find(ctx, filter, options.Find().SetProjection(projections))
+	if err != nil {
+		log.Printf("查询用户流量配额失败: %v", err)
+		return nil, err
+	}
+
+	var users []model.UserTrafficLogs
+	if err := cur.All(ctx, &users); err != nil {
+		log.Printf("查询用户流量配额失败: %v", err)
+		return nil, err
+	}
+
+	var overdue []string
+	for _, user := range users {
+		usage := QuotaUsage{EmailAsId: user.Email_As_Id, Used: user.Used, Credit: user.Credit, QuotaWarned: user.QuotaWarned}
+
+		if QuotaExceeded(usage.Used, usage.Credit) {
+			if _, err := collection.UpdateOne(ctx,
+				bson.M{"email_as_id": usage.EmailAsId, "status": "plain"},
+				bson.M{"$set": bson.M{"status": "overdue", "overdue_reason": model.OverdueReasonQuota, "updated_at": time.Now()}},
+			); err != nil {
+				log.Printf("设置用户 %s 为 overdue 失败: %v", usage.EmailAsId, err)
+				continue
+			}
+			log.Printf("用户 %s 流量已用完 (%d/%d)，状态设为 overdue", usage.EmailAsId, usage.Used, usage.Credit)
+			overdue = append(overdue, usage.EmailAsId)
+			continue
+		}
+
+		level := QuotaWarnLevel(usage.Used, usage.Credit)
+		if level > usage.QuotaWarned {
+			// 多个节点同时检查时只有一个能更新预警等级，只由它发送通知
+			result, err := collection.UpdateOne(ctx,
+				bson.M{"email_as_id": usage.EmailAsId, "quota_warned": bson.M{"$lt": level}},
+				bson.M{"$set": bson.M{"quota_warned": level}},
+			)
+			if err != nil {
+				log.Printf("记录用户 %s 流量预警失败: %v", usage.EmailAsId, err)
+				continue
+			}
+			if result.ModifiedCount > 0 {
+				warnQuota(usage, level)
+			}
+		}
+	}
+
+	return overdue, nil
+}
+
+// warnQuota 发送流量预警，默认同时通知用户和管理员
+func warnQuota(usage QuotaUsage, level int) {
+	go notify.Send(notify.Event{
+		Kind:    notify.EventQuotaWarning,
+		Subject: fmt.Sprintf("流量已使用 %d%%: %s", level, usage.EmailAsId),
+		Message: fmt.Sprintf("已使用 %s，共 %s", helper.FormatBytes(usage.Used), helper.FormatBytes(usage.Credit)),
+		User:    usage.EmailAsId,
+	})
+}
@@ -0,0 +1,448 @@
+package cron
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/xvv6u577/logv2fs/database"
+	"github.com/xvv6u577/logv2fs/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	SampleKindUser = "user"
+	SampleKindNode = "node"
+
+	// 去重记录至少保留的天数，节点缓存中仍有对应样本时继续保留，见 sampleAckCutoff
+	sampleAckRetentionDays = 7
+	// 心跳时间与去重记录写入时间之间的余量，覆盖写入超时和节点间的时钟误差
+	sampleAckCutoffMargin = time.Hour
+)
+
+var (
+	// 本地流量缓存文件，默认 ./logs/traffic_spool.jsonl
+	trafficSpoolFile = os.Getenv("TRAFFIC_SPOOL_FILE")
+	sampleAcks       = database.GetCollection(model.TrafficSampleAck{})
+	// 保护缓存文件的读写
+	spoolMutex sync.Mutex
+)
+
+// TrafficSample 一次待写入数据库的流量样本
+type TrafficSample struct {
+	ID        string    `json:"id"`
+	Kind      string    `json:"kind"`
+	Domain    string    `json:"domain,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+	Traffic   Traffic   `json:"traffic"`
+	SpooledAt time.Time `json:"spooled_at"`
+}
+
+// SpoolEntry 缓存文件中的一行：写入失败的样本，或某个样本已写入成功的确认
+type SpoolEntry struct {
+	Sample *TrafficSample `json:"sample,omitempty"`
+	Ack    string         `json:"ack,omitempty"`
+}
+
+// NewTrafficSample 创建流量样本，ID 由类型、节点、用户和时间戳确定，重复写入时据此去重
+func NewTrafficSample(kind, domain string, timestamp time.Time, traffic Traffic) TrafficSample {
+	return TrafficSample{
+		ID:        fmt.Sprintf("%s/%s/%s/%d", kind, domain, traffic.Name, timestamp.UnixNano()),
+		Kind:      kind,
+		Domain:    domain,
+		Timestamp: timestamp,
+		Traffic:   traffic,
+	}
+}
+
+// SpoolFile 返回本地流量缓存文件路径
+func SpoolFile() string {
+	if trafficSpoolFile != "" {
+		return trafficSpoolFile
+	}
+	return "./logs/traffic_spool.jsonl"
+}
+
+// WriteSample 将样本写入数据库，已写入过的样本会被跳过
+func WriteSample(sample TrafficSample) error {
+	if isUsingPostgreSQL() {
+		switch sample.Kind {
+		case SampleKindUser:
+			return LogUserTrafficPG(sample.Domain, sample.Timestamp, sample.Traffic, sample.ID)
+		case SampleKindNode:
+			return LogNodeTrafficPG(sample.Domain, sample.Timestamp, sample.Traffic, sample.ID)
+		}
+		return fmt.Errorf("未知的流量样本类型: %s", sample.Kind)
+	}
+	return writeSampleMongo(sample)
+}
+
+// writeSampleMongo MongoDB版本的样本写入，先插入去重记录，写入失败时再删除
+func writeSampleMongo(sample TrafficSample) error {
+	var ctx, cancel = context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, err := sampleAcks.InsertOne(ctx, model.TrafficSampleAck{SampleID: sample.ID, AppliedAt: time.Now()})
+	if mongo.IsDuplicateKeyError(err) {
+		log.Printf("流量样本 %s 已写入，跳过", sample.ID)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	switch sample.Kind {
+	case SampleKindUser:
+		err = LogUserTraffic(userTrafficLogs, sample.Timestamp, sample.Traffic)
+	case SampleKindNode:
+		err = LogNodeTraffic(nodeTrafficLogs, sample.Domain, sample.Timestamp, sample.Traffic)
+	default:
+		err = fmt.Errorf("未知的流量样本类型: %s", sample.Kind)
+	}
+
+	if err != nil {
+		if _, delErr := sampleAcks.DeleteOne(ctx, bson.M{"_id": sample.ID}); delErr != nil {
+			log.Printf("删除流量样本 %s 的去重记录失败: %v", sample.ID, delErr)
+		}
+	}
+	return err
+}
+
+// WriteSamples 批量写入样本：用户样本一次写入，其他样本逐条写入。返回写入失败的样本。
+func WriteSamples(samples []TrafficSample) []TrafficSample {
+	var userSamples, failed []TrafficSample
+	for _, sample := range samples {
+		if sample.Kind == SampleKindUser {
+			userSamples = append(userSamples, sample)
+			continue
+		}
+		if err := WriteSample(sample); err != nil {
+			log.Printf("流量样本 %s 写入失败: %v", sample.ID, err)
+			failed = append(failed, sample)
+		}
+	}
+
+	if len(userSamples) == 0 {
+		return failed
+	}
+
+	if isUsingPostgreSQL() {
+		if err := LogUserTrafficBulkPG(userSamples); err != nil {
+			return append(failed, userSamples...)
+		}
+		return failed
+	}
+	return append(failed, writeUserSamplesMongo(userSamples)...)
+}
+
+// writeUserSamplesMongo MongoDB版本的批量用户样本写入，跳过已写入的样本，返回写入失败的样本
+func writeUserSamplesMongo(samples []TrafficSample) []TrafficSample {
+	var ctx, cancel = context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	acks := make([]interface{}, 0, len(samples))
+	for _, sample := range samples {
+		acks = append(acks, model.TrafficSampleAck{SampleID: sample.ID, AppliedAt: time.Now()})
+	}
+
+	// 插入去重记录，主键冲突的样本已经写入过
+	duplicated := map[int]bool{}
+	if _, err := sampleAcks.InsertMany(ctx, acks, options.InsertMany().SetOrdered(false)); err != nil {
+		var bulkErr mongo.BulkWriteException
+		if !errors.As(err, &bulkErr) {
+			log.Printf("插入流量样本去重记录失败: %v", err)
+			return samples
+		}
+		for _, writeErr := range bulkErr.WriteErrors {
+			if !mongo.IsDuplicateKeyError(writeErr) {
+				log.Printf("插入流量样本去重记录失败: %v", err)
+				deleteSampleAcks(samples)
+				return samples
+			}
+			duplicated[writeErr.Index] = true
+		}
+	}
+
+	pending := make([]TrafficSample, 0, len(samples))
+	for i, sample := range samples {
+		if duplicated[i] {
+			log.Printf("流量样本 %s 已写入，跳过", sample.ID)
+			continue
+		}
+		pending = append(pending, sample)
+	}
+
+	applied, err := LogUserTrafficBulk(userTrafficLogs, pending)
+	if err != nil {
+		log.Printf("批量用户流量记录失败 (已写入 %d/%d): %v", applied, len(pending), err)
+		deleteSampleAcks(pending[applied:])
+		return pending[applied:]
+	}
+	return nil
+}
+
+// deleteSampleAcks 删除未写入成功的样本的去重记录
+func deleteSampleAcks(samples []TrafficSample) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	ids := make([]string, 0, len(samples))
+	for _, sample := range samples {
+		ids = append(ids, sample.ID)
+	}
+	if _, err := sampleAcks.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}}); err != nil {
+		log.Printf("删除流量样本去重记录失败: %v", err)
+	}
+}
+
+// RecordSamples 批量写入样本，失败的样本缓存到本地文件等待重放。全部写入成功时返回 true
+func RecordSamples(samples []TrafficSample) bool {
+	failed := WriteSamples(samples)
+	for _, sample := range failed {
+		log.Printf("流量样本 %s 写入失败，缓存到本地", sample.ID)
+		if err := SpoolSample(sample); err != nil {
+			log.Printf("缓存流量样本 %s 失败: %v", sample.ID, err)
+		}
+	}
+	return len(failed) == 0
+}
+
+// SpoolSample 将样本追加到本地缓存文件
+func SpoolSample(sample TrafficSample) error {
+	sample.SpooledAt = time.Now()
+	return appendSpoolEntry(SpoolEntry{Sample: &sample})
+}
+
+// AckSample 在本地缓存文件中记录样本已写入成功
+func AckSample(id string) error {
+	return appendSpoolEntry(SpoolEntry{Ack: id})
+}
+
+func appendSpoolEntry(entry SpoolEntry) error {
+	spoolMutex.Lock()
+	defer spoolMutex.Unlock()
+
+	path := SpoolFile()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	return file.Sync()
+}
+
+// PendingSamples 返回本地缓存中尚未确认写入的样本，按缓存顺序排列
+func PendingSamples() ([]TrafficSample, error) {
+	spoolMutex.Lock()
+	defer spoolMutex.Unlock()
+	return readPendingSamples()
+}
+
+func readPendingSamples() ([]TrafficSample, error) {
+	file, err := os.Open(SpoolFile())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var samples []TrafficSample
+	index := map[string]int{}
+	acked := map[string]bool{}
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var entry SpoolEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			// 进程中断可能留下不完整的最后一行，跳过即可
+			log.Printf("跳过流量缓存第 %d 行: %v", line, err)
+			continue
+		}
+		if entry.Ack != "" {
+			acked[entry.Ack] = true
+			continue
+		}
+		if entry.Sample == nil {
+			continue
+		}
+		if _, ok := index[entry.Sample.ID]; ok {
+			continue
+		}
+		index[entry.Sample.ID] = len(samples)
+		samples = append(samples, *entry.Sample)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	pending := samples[:0]
+	for _, sample := range samples {
+		if !acked[sample.ID] {
+			pending = append(pending, sample)
+		}
+	}
+	return pending, nil
+}
+
+// ReplaySpool 重放本地缓存中的样本，成功的样本记录确认。
+// compact 为 true 时用剩余未写入的样本重写缓存文件，只应由节点进程自身调用。
+func ReplaySpool(compact bool) (replayed, failed int, err error) {
+	samples, err := PendingSamples()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	failedIDs := map[string]bool{}
+	for _, sample := range WriteSamples(samples) {
+		log.Printf("重放流量样本 %s 失败", sample.ID)
+		failedIDs[sample.ID] = true
+	}
+
+	for _, sample := range samples {
+		if failedIDs[sample.ID] {
+			failed++
+			continue
+		}
+		if err := AckSample(sample.ID); err != nil {
+			log.Printf("记录流量样本 %s 确认失败: %v", sample.ID, err)
+		}
+		replayed++
+	}
+
+	if compact {
+		if err := CompactSpool(); err != nil {
+			return replayed, failed, err
+		}
+	}
+	return replayed, failed, nil
+}
+
+// CompactSpool 只保留未确认的样本重写缓存文件，没有剩余样本时删除文件
+func CompactSpool() error {
+	spoolMutex.Lock()
+	defer spoolMutex.Unlock()
+
+	pending, err := readPendingSamples()
+	if err != nil {
+		return err
+	}
+
+	path := SpoolFile()
+	if len(pending) == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	tmpPath := path + ".tmp"
+	file, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(file)
+	for i := range pending {
+		if err := encoder.Encode(SpoolEntry{Sample: &pending[i]}); err != nil {
+			file.Close()
+			os.Remove(tmpPath)
+			return err
+		}
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// sampleAckCutoff 计算可以清理的去重记录的时间上限。
+// 节点缓存中已写入但未确认的样本，其去重记录不早于该样本的时间；缓存是在上一次心跳之后才写入的样本，
+// 其去重记录不早于上一次心跳。所以上限取 before、各节点最早的缓存样本和最近一次心跳中最早的时间，
+// 节点缓存中仍有的样本不会因为去重记录被清理而重复累加。
+func sampleAckCutoff(before time.Time, heartbeats []model.NodeHeartbeat) time.Time {
+	for _, heartbeat := range heartbeats {
+		bound := heartbeat.ReportedAt
+		if heartbeat.OldestPending != nil && heartbeat.OldestPending.Before(bound) {
+			bound = *heartbeat.OldestPending
+		}
+		if bound = bound.Add(-sampleAckCutoffMargin); bound.Before(before) {
+			before = bound
+		}
+	}
+	return before
+}
+
+// PruneSampleAcks 删除 before 之前的样本去重记录，节点缓存中可能仍有的样本的去重记录会保留
+func PruneSampleAcks(before time.Time) error {
+	if isUsingPostgreSQL() {
+		db := database.GetPostgresDB()
+		if db == nil {
+			return nil
+		}
+
+		var records []model.NodeHeartbeatPG
+		if err := db.Select("domain_as_id", "oldest_pending", "reported_at").Find(&records).Error; err != nil {
+			return err
+		}
+		heartbeats := make([]model.NodeHeartbeat, 0, len(records))
+		for _, record := range records {
+			heartbeats = append(heartbeats, model.NodeHeartbeat{
+				Domain_As_Id:  record.DomainAsId,
+				OldestPending: record.OldestPending,
+				ReportedAt:    record.ReportedAt,
+			})
+		}
+
+		return db.Where("applied_at < ?", sampleAckCutoff(before, heartbeats)).Delete(&model.TrafficSampleAckPG{}).Error
+	}
+
+	var ctx, cancel = context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	var heartbeats []model.NodeHeartbeat
+	cursor, err := nodeHeartbeats.Find(ctx, bson.M{},
+		options.Find().SetProjection(bson.M{"domain_as_id": 1, "oldest_pending": 1, "reported_at": 1}))
+	if err != nil {
+		return err
+	}
+	if err := cursor.All(ctx, &heartbeats); err != nil {
+		return err
+	}
+
+	_, err = sampleAcks.DeleteMany(ctx, bson.M{"applied_at": bson.M{"$lt": sampleAckCutoff(before, heartbeats)}})
+	return err
+}
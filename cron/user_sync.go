@@ -0,0 +1,112 @@
+package cron
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"time"
+
+	"github.com/robfig/cron"
+	"github.com/xvv6u577/logv2fs/database"
+	"github.com/xvv6u577/logv2fs/model"
+	thirdparty "github.com/xvv6u577/logv2fs/pkg"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	// 用户同步周期，默认每30秒对比一次数据库中的活跃用户
+	userSyncSpec = os.Getenv("USER_SYNC_SPEC")
+	nodeUserSets = database.GetCollection(model.NodeUserSet{})
+)
+
+// SyncNodeUsers 从数据库读取活跃用户并热更新到运行中的 sing-box 实例，然后上报当前用户集合
+func SyncNodeUsers(manager *thirdparty.UserManager) error {
+	users, err := thirdparty.LoadActiveUsers()
+	if err != nil {
+		log.Printf("读取活跃用户失败: %v", err)
+		return err
+	}
+
+	added, removed, err := manager.Sync(users)
+	if err != nil {
+		log.Printf("热更新节点用户失败: %v", err)
+		return err
+	}
+
+	if len(added) > 0 || len(removed) > 0 {
+		log.Printf("节点用户已更新 - 新增: %v, 移除: %v", added, removed)
+	}
+
+	return ReportNodeUserSet(manager)
+}
+
+// ReportNodeUserSet 将节点当前的用户集合写入数据库，供控制面查询
+func ReportNodeUserSet(manager *thirdparty.UserManager) error {
+	snapshot := manager.Snapshot()
+
+	var err error
+	if isUsingPostgreSQL() {
+		err = LogNodeUserSetPG(currentDomain, snapshot)
+	} else {
+		err = LogNodeUserSet(nodeUserSets, currentDomain, snapshot)
+	}
+	if err != nil {
+		log.Printf("上报节点用户集合失败: %v", err)
+	}
+	return err
+}
+
+// LogNodeUserSetPG PostgreSQL版本的节点用户集合上报
+func LogNodeUserSetPG(domain string, snapshot thirdparty.UserSetSnapshot) error {
+	users, err := json.Marshal(snapshot.Users)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO "node_user_sets" (domain_as_id, users, user_count, checksum, synced_at, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, NOW(), NOW())
+		ON CONFLICT (domain_as_id)
+		DO UPDATE SET users = EXCLUDED.users, user_count = EXCLUDED.user_count,
+			checksum = EXCLUDED.checksum, synced_at = EXCLUDED.synced_at, updated_at = NOW()
+	`
+
+	return database.GetPostgresDB().Exec(query, domain, string(users), snapshot.UserCount, snapshot.Checksum, snapshot.SyncedAt).Error
+}
+
+// LogNodeUserSet MongoDB版本的节点用户集合上报
+func LogNodeUserSet(collection *mongo.Collection, domain string, snapshot thirdparty.UserSetSnapshot) error {
+	var ctx, cancel = context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	update := bson.M{
+		"$set": bson.M{
+			"domain_as_id": domain,
+			"users":        snapshot.Users,
+			"user_count":   snapshot.UserCount,
+			"checksum":     snapshot.Checksum,
+			"synced_at":    snapshot.SyncedAt,
+			"updated_at":   time.Now(),
+		},
+	}
+
+	_, err := collection.UpdateOne(ctx, bson.M{"domain_as_id": domain}, update, options.Update().SetUpsert(true))
+	return err
+}
+
+// Cron_userSyncJobs 定期将数据库中的用户变更同步到运行中的节点
+func Cron_userSyncJobs(c *cron.Cron, manager *thirdparty.UserManager) {
+	spec := userSyncSpec
+	if spec == "" {
+		spec = "*/30 * * * * *"
+	}
+
+	if err := c.AddFunc(spec, func() {
+		SyncNodeUsers(manager)
+	}); err != nil {
+		log.Printf("注册用户同步任务失败: %v", err)
+	}
+}
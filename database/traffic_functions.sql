@@ -1,35 +1,163 @@
 -- PostgreSQL存储函数：优化流量记录的upsert操作
 -- 这些函数在数据库端执行JSON数组的条件更新，减少网络传输和提高性能
+-- 每条日志记录包含 traffic（总量）、uplink、downlink 以及按协议拆分的 protocols
+
+-- 先删除旧版本函数，以免与带默认值的新版本产生重载歧义
+DROP FUNCTION IF EXISTS upsert_user_traffic_log(VARCHAR, TIMESTAMP, BIGINT);
+DROP FUNCTION IF EXISTS upsert_node_traffic_log(VARCHAR, TIMESTAMP, BIGINT);
+DROP FUNCTION IF EXISTS upsert_user_traffic_log(VARCHAR, TIMESTAMP, BIGINT, BIGINT, BIGINT, JSONB);
+DROP FUNCTION IF EXISTS upsert_node_traffic_log(VARCHAR, TIMESTAMP, BIGINT, BIGINT, BIGINT, JSONB);
+DROP FUNCTION IF EXISTS upsert_user_traffic_log(VARCHAR, TIMESTAMP, BIGINT, BIGINT, BIGINT, JSONB, TEXT);
+
+-- 已写入的流量样本，节点重放本地缓存时据此去重
+CREATE TABLE IF NOT EXISTS traffic_sample_acks (
+    sample_id TEXT PRIMARY KEY,
+    applied_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_traffic_sample_acks_applied_at ON traffic_sample_acks(applied_at);
+
+-- 按小时汇总的流量时间序列，汇总视图由 ./logv2fs migrate traffic-samples 创建。
+-- protocol 不为空的记录只有该协议的 traffic；protocol 为空的记录保存未区分协议的流量以及全部上下行
+CREATE TABLE IF NOT EXISTS traffic_samples (
+    user_email VARCHAR(255) NOT NULL DEFAULT '',
+    node VARCHAR(255) NOT NULL DEFAULT '',
+    protocol VARCHAR(32) NOT NULL DEFAULT '',
+    bucket_start TIMESTAMP NOT NULL,
+    traffic BIGINT NOT NULL DEFAULT 0,
+    uplink BIGINT NOT NULL DEFAULT 0,
+    downlink BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (user_email, node, protocol, bucket_start)
+);
+CREATE INDEX IF NOT EXISTS idx_traffic_samples_node_bucket ON traffic_samples(node, bucket_start);
+
+-- 旧版本的表没有 protocol 列，补上该列并把主键改为包含 protocol
+ALTER TABLE traffic_samples ADD COLUMN IF NOT EXISTS protocol VARCHAR(32) NOT NULL DEFAULT '';
+DO $$
+BEGIN
+    IF NOT EXISTS (
+        SELECT 1 FROM pg_index i
+        JOIN pg_attribute a ON a.attrelid = i.indrelid AND a.attnum = ANY(i.indkey)
+        WHERE i.indrelid = 'traffic_samples'::regclass AND i.indisprimary AND a.attname = 'protocol'
+    ) THEN
+        ALTER TABLE traffic_samples DROP CONSTRAINT IF EXISTS traffic_samples_pkey;
+        ALTER TABLE traffic_samples ADD PRIMARY KEY (user_email, node, protocol, bucket_start);
+    END IF;
+END;
+$$;
+
+-- 将一次流量累加到单条日志记录上
+-- 功能：traffic、uplink、downlink 相加，protocols 按键相加
+CREATE OR REPLACE FUNCTION add_traffic_to_log_entry(
+    p_entry JSONB,
+    p_traffic BIGINT,
+    p_uplink BIGINT,
+    p_downlink BIGINT,
+    p_protocols JSONB
+) RETURNS JSONB
+LANGUAGE sql
+IMMUTABLE
+AS $$
+    SELECT p_entry || jsonb_build_object(
+        'traffic', COALESCE((p_entry->>'traffic')::bigint, 0) + p_traffic,
+        'uplink', COALESCE((p_entry->>'uplink')::bigint, 0) + p_uplink,
+        'downlink', COALESCE((p_entry->>'downlink')::bigint, 0) + p_downlink,
+        'protocols', COALESCE(p_entry->'protocols', '{}'::jsonb) || COALESCE((
+            SELECT jsonb_object_agg(key, COALESCE((p_entry->'protocols'->>key)::bigint, 0) + value::bigint)
+            FROM jsonb_each_text(COALESCE(p_protocols, '{}'::jsonb))
+        ), '{}'::jsonb)
+    );
+$$;
+
+-- 在日志数组中累加某个周期的流量
+-- 功能：p_key 为 date/month/year，存在对应周期时累加，否则追加新记录
+CREATE OR REPLACE FUNCTION upsert_traffic_log_entry(
+    p_logs JSONB,
+    p_key TEXT,
+    p_period TEXT,
+    p_traffic BIGINT,
+    p_uplink BIGINT,
+    p_downlink BIGINT,
+    p_protocols JSONB
+) RETURNS JSONB
+LANGUAGE sql
+IMMUTABLE
+AS $$
+    SELECT CASE
+        WHEN EXISTS (
+            SELECT 1 FROM jsonb_array_elements(COALESCE(p_logs, '[]'::jsonb)) AS elem
+            WHERE elem->>p_key = p_period
+        ) THEN (
+            SELECT jsonb_agg(
+                CASE
+                    WHEN elem->>p_key = p_period
+                    THEN add_traffic_to_log_entry(elem, p_traffic, p_uplink, p_downlink, p_protocols)
+                    ELSE elem
+                END
+                ORDER BY ord
+            )
+            FROM jsonb_array_elements(p_logs) WITH ORDINALITY AS t(elem, ord)
+        )
+        ELSE COALESCE(p_logs, '[]'::jsonb) || jsonb_build_array(
+            add_traffic_to_log_entry(jsonb_build_object(p_key, p_period), p_traffic, p_uplink, p_downlink, p_protocols)
+        )
+    END;
+$$;
 
 -- 用户流量记录upsert函数
--- 功能：插入或更新用户流量记录，同时处理daily_logs、monthly_logs、yearly_logs的条件更新
+-- 功能：插入或更新用户流量记录，同时处理daily_logs、monthly_logs、yearly_logs的条件更新，
+-- 并累加到 traffic_samples 中 (用户, 节点, 小时) 对应的记录
 CREATE OR REPLACE FUNCTION upsert_user_traffic_log(
     p_email VARCHAR,
     p_timestamp TIMESTAMP,
-    p_traffic BIGINT
-) RETURNS VOID 
-LANGUAGE plpgsql 
+    p_traffic BIGINT,
+    p_uplink BIGINT DEFAULT 0,
+    p_downlink BIGINT DEFAULT 0,
+    p_protocols JSONB DEFAULT '{}'::jsonb,
+    p_sample_id TEXT DEFAULT NULL,
+    p_node VARCHAR DEFAULT ''
+) RETURNS VOID
+LANGUAGE plpgsql
 SECURITY DEFINER
 AS $$
 DECLARE
     v_date VARCHAR := TO_CHAR(p_timestamp, 'YYYYMMDD');
     v_month VARCHAR := TO_CHAR(p_timestamp, 'YYYYMM');
     v_year VARCHAR := TO_CHAR(p_timestamp, 'YYYY');
-    
-    v_daily_logs JSONB;
-    v_monthly_logs JSONB;
-    v_yearly_logs JSONB;
-    v_daily_exists BOOLEAN;
-    v_monthly_exists BOOLEAN;
-    v_yearly_exists BOOLEAN;
 BEGIN
+    -- 同一样本只写入一次，重放时直接返回
+    IF p_sample_id IS NOT NULL THEN
+        INSERT INTO traffic_sample_acks (sample_id, applied_at) VALUES (p_sample_id, NOW())
+        ON CONFLICT (sample_id) DO NOTHING;
+        IF NOT FOUND THEN
+            RETURN;
+        END IF;
+    END IF;
+
+    -- 每个协议一条记录，剩余流量和上下行记在 protocol 为空的记录上
+    INSERT INTO traffic_samples (user_email, node, protocol, bucket_start, traffic, uplink, downlink)
+    SELECT p_email, COALESCE(p_node, ''), s.protocol, date_trunc('hour', p_timestamp), s.traffic, s.uplink, s.downlink
+    FROM (
+        SELECT key AS protocol, value::bigint AS traffic, 0::bigint AS uplink, 0::bigint AS downlink
+        FROM jsonb_each_text(COALESCE(p_protocols, '{}'::jsonb))
+        WHERE key <> ''
+        UNION ALL
+        SELECT '', p_traffic - COALESCE((
+            SELECT SUM(value::bigint) FROM jsonb_each_text(COALESCE(p_protocols, '{}'::jsonb)) WHERE key <> ''
+        ), 0)::bigint, p_uplink, p_downlink
+    ) AS s
+    ON CONFLICT (user_email, node, protocol, bucket_start)
+    DO UPDATE SET
+        traffic = traffic_samples.traffic + EXCLUDED.traffic,
+        uplink = traffic_samples.uplink + EXCLUDED.uplink,
+        downlink = traffic_samples.downlink + EXCLUDED.downlink;
+
     -- 使用WITH子句处理复杂的JSON更新逻辑
     WITH current_data AS (
-        SELECT 
+        SELECT
             COALESCE(daily_logs, '[]'::jsonb) as daily_logs,
             COALESCE(monthly_logs, '[]'::jsonb) as monthly_logs,
             COALESCE(yearly_logs, '[]'::jsonb) as yearly_logs
-        FROM user_traffic_logs 
+        FROM user_traffic_logs
         WHERE email_as_id = p_email
         UNION ALL
         SELECT '[]'::jsonb, '[]'::jsonb, '[]'::jsonb
@@ -37,60 +165,10 @@ BEGIN
         LIMIT 1
     ),
     updated_logs AS (
-        SELECT 
-            -- 更新或添加daily_logs
-            CASE 
-                WHEN EXISTS (
-                    SELECT 1 FROM jsonb_array_elements(daily_logs) AS elem 
-                    WHERE elem->>'date' = v_date
-                ) THEN (
-                    SELECT jsonb_agg(
-                        CASE 
-                            WHEN elem->>'date' = v_date 
-                            THEN jsonb_set(elem, '{traffic}', ((elem->>'traffic')::bigint + p_traffic)::text::jsonb)
-                            ELSE elem
-                        END
-                    )
-                    FROM jsonb_array_elements(daily_logs) AS elem
-                )
-                ELSE daily_logs || jsonb_build_object('date', v_date, 'traffic', p_traffic)::jsonb
-            END as new_daily_logs,
-            
-            -- 更新或添加monthly_logs
-            CASE 
-                WHEN EXISTS (
-                    SELECT 1 FROM jsonb_array_elements(monthly_logs) AS elem 
-                    WHERE elem->>'month' = v_month
-                ) THEN (
-                    SELECT jsonb_agg(
-                        CASE 
-                            WHEN elem->>'month' = v_month 
-                            THEN jsonb_set(elem, '{traffic}', ((elem->>'traffic')::bigint + p_traffic)::text::jsonb)
-                            ELSE elem
-                        END
-                    )
-                    FROM jsonb_array_elements(monthly_logs) AS elem
-                )
-                ELSE monthly_logs || jsonb_build_object('month', v_month, 'traffic', p_traffic)::jsonb
-            END as new_monthly_logs,
-            
-            -- 更新或添加yearly_logs
-            CASE 
-                WHEN EXISTS (
-                    SELECT 1 FROM jsonb_array_elements(yearly_logs) AS elem 
-                    WHERE elem->>'year' = v_year
-                ) THEN (
-                    SELECT jsonb_agg(
-                        CASE 
-                            WHEN elem->>'year' = v_year 
-                            THEN jsonb_set(elem, '{traffic}', ((elem->>'traffic')::bigint + p_traffic)::text::jsonb)
-                            ELSE elem
-                        END
-                    )
-                    FROM jsonb_array_elements(yearly_logs) AS elem
-                )
-                ELSE yearly_logs || jsonb_build_object('year', v_year, 'traffic', p_traffic)::jsonb
-            END as new_yearly_logs
+        SELECT
+            upsert_traffic_log_entry(daily_logs, 'date', v_date, p_traffic, p_uplink, p_downlink, p_protocols) as new_daily_logs,
+            upsert_traffic_log_entry(monthly_logs, 'month', v_month, p_traffic, p_uplink, p_downlink, p_protocols) as new_monthly_logs,
+            upsert_traffic_log_entry(yearly_logs, 'year', v_year, p_traffic, p_uplink, p_downlink, p_protocols) as new_yearly_logs
         FROM current_data
     )
     -- 执行upsert操作
@@ -98,11 +176,11 @@ BEGIN
         email_as_id, name, password, uuid, user_id, status, role, used, credit, created_at, updated_at,
         hourly_logs, daily_logs, monthly_logs, yearly_logs
     )
-    SELECT 
+    SELECT
         p_email, p_email, 'default_traffic_user', gen_random_uuid()::text, gen_random_uuid()::text, 'plain', 'normal', p_traffic, 0, p_timestamp, p_timestamp,
         '[]'::jsonb, new_daily_logs, new_monthly_logs, new_yearly_logs
     FROM updated_logs
-    ON CONFLICT (email_as_id) 
+    ON CONFLICT (email_as_id)
     DO UPDATE SET
         used = user_traffic_logs.used + p_traffic,
         updated_at = p_timestamp,
@@ -117,9 +195,13 @@ $$;
 CREATE OR REPLACE FUNCTION upsert_node_traffic_log(
     p_domain VARCHAR,
     p_timestamp TIMESTAMP,
-    p_traffic BIGINT
-) RETURNS VOID 
-LANGUAGE plpgsql 
+    p_traffic BIGINT,
+    p_uplink BIGINT DEFAULT 0,
+    p_downlink BIGINT DEFAULT 0,
+    p_protocols JSONB DEFAULT '{}'::jsonb,
+    p_sample_id TEXT DEFAULT NULL
+) RETURNS VOID
+LANGUAGE plpgsql
 SECURITY DEFINER
 AS $$
 DECLARE
@@ -127,13 +209,22 @@ DECLARE
     v_month VARCHAR := TO_CHAR(p_timestamp, 'YYYYMM');
     v_year VARCHAR := TO_CHAR(p_timestamp, 'YYYY');
 BEGIN
+    -- 同一样本只写入一次，重放时直接返回
+    IF p_sample_id IS NOT NULL THEN
+        INSERT INTO traffic_sample_acks (sample_id, applied_at) VALUES (p_sample_id, NOW())
+        ON CONFLICT (sample_id) DO NOTHING;
+        IF NOT FOUND THEN
+            RETURN;
+        END IF;
+    END IF;
+
     -- 使用WITH子句处理复杂的JSON更新逻辑
     WITH current_data AS (
-        SELECT 
+        SELECT
             COALESCE(daily_logs, '[]'::jsonb) as daily_logs,
             COALESCE(monthly_logs, '[]'::jsonb) as monthly_logs,
             COALESCE(yearly_logs, '[]'::jsonb) as yearly_logs
-        FROM node_traffic_logs 
+        FROM node_traffic_logs
         WHERE domain_as_id = p_domain
         UNION ALL
         SELECT '[]'::jsonb, '[]'::jsonb, '[]'::jsonb
@@ -141,60 +232,10 @@ BEGIN
         LIMIT 1
     ),
     updated_logs AS (
-        SELECT 
-            -- 更新或添加daily_logs
-            CASE 
-                WHEN EXISTS (
-                    SELECT 1 FROM jsonb_array_elements(daily_logs) AS elem 
-                    WHERE elem->>'date' = v_date
-                ) THEN (
-                    SELECT jsonb_agg(
-                        CASE 
-                            WHEN elem->>'date' = v_date 
-                            THEN jsonb_set(elem, '{traffic}', ((elem->>'traffic')::bigint + p_traffic)::text::jsonb)
-                            ELSE elem
-                        END
-                    )
-                    FROM jsonb_array_elements(daily_logs) AS elem
-                )
-                ELSE daily_logs || jsonb_build_object('date', v_date, 'traffic', p_traffic)::jsonb
-            END as new_daily_logs,
-            
-            -- 更新或添加monthly_logs
-            CASE 
-                WHEN EXISTS (
-                    SELECT 1 FROM jsonb_array_elements(monthly_logs) AS elem 
-                    WHERE elem->>'month' = v_month
-                ) THEN (
-                    SELECT jsonb_agg(
-                        CASE 
-                            WHEN elem->>'month' = v_month 
-                            THEN jsonb_set(elem, '{traffic}', ((elem->>'traffic')::bigint + p_traffic)::text::jsonb)
-                            ELSE elem
-                        END
-                    )
-                    FROM jsonb_array_elements(monthly_logs) AS elem
-                )
-                ELSE monthly_logs || jsonb_build_object('month', v_month, 'traffic', p_traffic)::jsonb
-            END as new_monthly_logs,
-            
-            -- 更新或添加yearly_logs
-            CASE 
-                WHEN EXISTS (
-                    SELECT 1 FROM jsonb_array_elements(yearly_logs) AS elem 
-                    WHERE elem->>'year' = v_year
-                ) THEN (
-                    SELECT jsonb_agg(
-                        CASE 
-                            WHEN elem->>'year' = v_year 
-                            THEN jsonb_set(elem, '{traffic}', ((elem->>'traffic')::bigint + p_traffic)::text::jsonb)
-                            ELSE elem
-                        END
-                    )
-                    FROM jsonb_array_elements(yearly_logs) AS elem
-                )
-                ELSE yearly_logs || jsonb_build_object('year', v_year, 'traffic', p_traffic)::jsonb
-            END as new_yearly_logs
+        SELECT
+            upsert_traffic_log_entry(daily_logs, 'date', v_date, p_traffic, p_uplink, p_downlink, p_protocols) as new_daily_logs,
+            upsert_traffic_log_entry(monthly_logs, 'month', v_month, p_traffic, p_uplink, p_downlink, p_protocols) as new_monthly_logs,
+            upsert_traffic_log_entry(yearly_logs, 'year', v_year, p_traffic, p_uplink, p_downlink, p_protocols) as new_yearly_logs
         FROM current_data
     )
     -- 执行upsert操作
@@ -202,11 +243,11 @@ BEGIN
         domain_as_id, remark, status, created_at, updated_at,
         hourly_logs, daily_logs, monthly_logs, yearly_logs
     )
-    SELECT 
+    SELECT
         p_domain, p_domain, 'active', p_timestamp, p_timestamp,
         '[]'::jsonb, new_daily_logs, new_monthly_logs, new_yearly_logs
     FROM updated_logs
-    ON CONFLICT (domain_as_id) 
+    ON CONFLICT (domain_as_id)
     DO UPDATE SET
         updated_at = p_timestamp,
         daily_logs = EXCLUDED.daily_logs,
@@ -215,6 +256,34 @@ BEGIN
 END;
 $$;
 
+-- 批量用户流量记录函数
+-- 功能：一次调用写入多个用户的流量，p_samples 为 JSON 数组，每个元素包含
+-- email、node、timestamp、traffic、uplink、downlink、protocols、sample_id，整个批次在同一事务中执行
+CREATE OR REPLACE FUNCTION upsert_user_traffic_logs_bulk(
+    p_samples JSONB
+) RETURNS VOID
+LANGUAGE plpgsql
+SECURITY DEFINER
+AS $$
+DECLARE
+    v_sample JSONB;
+BEGIN
+    FOR v_sample IN SELECT * FROM jsonb_array_elements(COALESCE(p_samples, '[]'::jsonb))
+    LOOP
+        PERFORM upsert_user_traffic_log(
+            (v_sample->>'email')::VARCHAR,
+            (v_sample->>'timestamp')::TIMESTAMP,
+            COALESCE((v_sample->>'traffic')::BIGINT, 0),
+            COALESCE((v_sample->>'uplink')::BIGINT, 0),
+            COALESCE((v_sample->>'downlink')::BIGINT, 0),
+            COALESCE(v_sample->'protocols', '{}'::jsonb),
+            v_sample->>'sample_id',
+            COALESCE(v_sample->>'node', '')::VARCHAR
+        );
+    END LOOP;
+END;
+$$;
+
 -- 创建索引以优化JSON查询性能（如果尚不存在）
 -- 注意：在生产环境中，应该根据实际查询模式优化索引
 CREATE INDEX IF NOT EXISTS idx_user_traffic_logs_email ON user_traffic_logs(email_as_id);
@@ -226,4 +295,4 @@ CREATE INDEX IF NOT EXISTS idx_node_traffic_logs_domain ON node_traffic_logs(dom
 -- CREATE INDEX IF NOT EXISTS idx_user_traffic_yearly_logs ON user_traffic_logs USING GIN (yearly_logs);
 -- CREATE INDEX IF NOT EXISTS idx_node_traffic_daily_logs ON node_traffic_logs USING GIN (daily_logs);
 -- CREATE INDEX IF NOT EXISTS idx_node_traffic_monthly_logs ON node_traffic_logs USING GIN (monthly_logs);
--- CREATE INDEX IF NOT EXISTS idx_node_traffic_yearly_logs ON node_traffic_logs USING GIN (yearly_logs); 
\ No newline at end of file
+-- CREATE INDEX IF NOT EXISTS idx_node_traffic_yearly_logs ON node_traffic_logs USING GIN (yearly_logs);
@@ -8,6 +8,9 @@ import axios from "axios";
 const AddNode = () => {
 	const [nodes, setNodes] = useState([]);
 	const [enableOpenai, setEnableOpenai] = useState(false);
+	const [verifyCert, setVerifyCert] = useState(false);
+	const [realityKey, setRealityKey] = useState(null);
+	const [versions, setVersions] = useState([]);
 	
 	const initialState = {
 		type: "reality",
@@ -17,11 +20,16 @@ const AddNode = () => {
 		uuid: "",
 		path: "",
 		sni: "",
+		password: "",
+		public_key: "",
+		short_id: "",
+		fingerprint: "",
 		server_port: "",
+		region: "",
 	};
 	
 	const [formData, setFormData] = useState(initialState);
-	const { type, remark, domain, uuid, path, sni, ip, server_port } = formData;
+	const { type, remark, domain, uuid, path, sni, password, public_key, short_id, fingerprint, ip, server_port, region } = formData;
 
 	const dispatch = useDispatch();
 	const loginState = useSelector((state) => state.login);
@@ -43,11 +51,15 @@ const AddNode = () => {
 		badgeReality: "bg-blue-900 text-blue-300",
 		badgeHysteria: "bg-purple-900 text-purple-300",
 		badgeVless: "bg-green-900 text-green-300",
+		badgeTuic: "bg-yellow-900 text-yellow-300",
+		badgeTrojan: "bg-red-900 text-red-300",
+		badgeShadowsocks: "bg-indigo-900 text-indigo-300",
 	};
 
 	const clearState = () => {
 		setFormData({ ...initialState });
 		setEnableOpenai(false);
+		setVerifyCert(false);
 	};
 
 	const onChange = (e) => {
@@ -61,6 +73,9 @@ const AddNode = () => {
 			case "reality": return "Reality";
 			case "hysteria2": return "Hysteria2";
 			case "vlessCDN": return "VLessCDN";
+			case "tuic": return "TUIC";
+			case "trojan": return "Trojan";
+			case "ss2022": return "SS2022";
 			default: return type;
 		}
 	};
@@ -70,6 +85,9 @@ const AddNode = () => {
 			case "reality": return styles.badgeReality;
 			case "hysteria2": return styles.badgeHysteria;
 			case "vlessCDN": return styles.badgeVless;
+			case "tuic": return styles.badgeTuic;
+			case "trojan": return styles.badgeTrojan;
+			case "ss2022": return styles.badgeShadowsocks;
 			default: return styles.badgeReality;
 		}
 	};
@@ -87,6 +105,37 @@ const AddNode = () => {
 			});
 	}, [rerenderSignal, loginState.token, dispatch]);
 
+	useEffect(() => {
+		axios
+			.get(process.env.REACT_APP_API_HOST + "node-versions", {
+				headers: { token: loginState.token },
+			})
+			.then((response) => {
+				setVersions(response.data || []);
+			})
+			.catch((err) => {
+				dispatch(alert({ show: true, content: err.toString() }));
+			});
+	}, [rerenderSignal, loginState.token, dispatch]);
+
+	// 将节点列表恢复到历史版本
+	const handleRollback = (version) => {
+		if (!window.confirm(`确定将节点列表恢复到版本 ${version}？`)) {
+			return;
+		}
+		axios
+			.post(process.env.REACT_APP_API_HOST + `node-versions/${version}/rollback`, {}, {
+				headers: { token: loginState.token },
+			})
+			.then((response) => {
+				dispatch(success({ show: true, content: response.data.message }));
+				dispatch(doRerender({ rerender: !rerenderSignal.rerender }));
+			})
+			.catch((err) => {
+				dispatch(alert({ show: true, content: err.response ? err.response.data.error : err.toString() }));
+			});
+	};
+
 	const handleAddNode = (e) => {
 		e.preventDefault();
 		axios({
@@ -101,7 +150,7 @@ const AddNode = () => {
 				clearState();
 			})
 			.catch((err) => {
-				dispatch(alert({ show: true, content: err.toString() }));
+				dispatch(alert({ show: true, content: err.response ? err.response.data.error : err.toString() }));
 			});
 	};
 
@@ -116,9 +165,15 @@ const AddNode = () => {
 					ip,
 					server_port,
 					enable_openai: enableOpenai,
+					verify_cert: verifyCert,
 					uuid,
 					path,
-					sni
+					sni,
+					password,
+					public_key,
+					short_id,
+					fingerprint,
+					region
 				}
 			]));
 			clearState();
@@ -132,16 +187,57 @@ const AddNode = () => {
 	};
 
 	const handleCopyNode = (nodeToCopy) => {
-		const { enable_openai, ...nodeData } = nodeToCopy;
+		const { enable_openai, verify_cert, ...nodeData } = nodeToCopy;
 
 		setFormData({ ...initialState, ...nodeData });
 		setEnableOpenai(enable_openai || false);
+		setVerifyCert(verify_cert || false);
 
 		window.scrollTo({ top: 0, behavior: "smooth" });
 		
 		dispatch(success({ show: true, content: `已复制节点 ${nodeToCopy.remark} 的信息到表单` }));
 	};
 
+	// 为 reality 节点生成新的 x25519 密钥对，私钥只显示一次
+	const handleGenerateRealityKey = (node) => {
+		axios
+			.post(process.env.REACT_APP_API_HOST + "reality-key/" + encodeURIComponent(node.remark), {}, {
+				headers: { token: loginState.token },
+			})
+			.then((response) => {
+				setRealityKey({ remark: node.remark, ...response.data });
+				setNodes((prevState) => prevState.map((n) => (
+					n.remark === node.remark ? { ...n, pending_public_key: response.data.public_key, pending_short_id: response.data.short_id } : n
+				)));
+				dispatch(success({ show: true, content: `已为节点 ${node.remark} 生成新密钥，请更新服务端配置后启用` }));
+			})
+			.catch((err) => {
+				dispatch(alert({ show: true, content: err.toString() }));
+			});
+	};
+
+	// 节点换上新私钥后启用新公钥，启用前订阅仍使用原来的公钥
+	const handleActivateRealityKey = () => {
+		axios
+			.post(process.env.REACT_APP_API_HOST + "reality-key/" + encodeURIComponent(realityKey.remark) + "/activate", {
+				public_key: realityKey.public_key,
+			}, {
+				headers: { token: loginState.token },
+			})
+			.then((response) => {
+				setNodes((prevState) => prevState.map((n) => (
+					n.remark === realityKey.remark
+						? { ...n, public_key: response.data.public_key, short_id: response.data.short_id, pending_public_key: "", pending_short_id: "" }
+						: n
+				)));
+				dispatch(success({ show: true, content: `节点 ${realityKey.remark} 已启用新密钥` }));
+				setRealityKey(null);
+			})
+			.catch((err) => {
+				dispatch(alert({ show: true, content: err.response?.data?.error || err.toString() }));
+			});
+	};
+
 	useEffect(() => {
 		if (message.show === true) {
 			setTimeout(() => {
@@ -154,6 +250,17 @@ const AddNode = () => {
 	const NodeCard = ({ node, index }) => (
 		<div className={`${styles.card} p-6 relative`}>
 			<div className="absolute top-4 right-4 flex items-center space-x-2">
+				{node.type === "reality" && (
+					<button
+						onClick={() => handleGenerateRealityKey(node)}
+						className="p-1 rounded-full text-gray-400 hover:bg-gray-700 hover:text-yellow-400 transition-all duration-200"
+						title="生成 Reality 密钥"
+					>
+						<svg xmlns="http://www.w3.org/2000/svg" className="h-5 w-5" fill="none" viewBox="0 0 24 24" stroke="currentColor" strokeWidth={2}>
+							<path strokeLinecap="round" strokeLinejoin="round" d="M15 7a2 2 0 012 2m4 0a6 6 0 01-7.743 5.743L11 17H9v2H7v2H4a1 1 0 01-1-1v-2.586a1 1 0 01.293-.707l5.964-5.964A6 6 0 1121 9z" />
+						</svg>
+					</button>
+				)}
 				<button
 					onClick={() => handleCopyNode(node)}
 					className="p-1 rounded-full text-gray-400 hover:bg-gray-700 hover:text-blue-400 transition-all duration-200"
@@ -220,6 +327,31 @@ const AddNode = () => {
 						<span className="text-white font-mono">{node.sni}</span>
 					</div>
 				)}
+				{node.type === "reality" && (
+					<div className="col-span-2">
+						<span className="text-gray-400">公钥: </span>
+						<span className="text-white font-mono text-xs">
+							{node.public_key ? `${node.public_key.substring(0, 12)}...` : "None"}
+						</span>
+						{node.pending_public_key && (
+							<span className="ml-2 text-yellow-400 text-xs">有待启用的新密钥</span>
+						)}
+					</div>
+				)}
+				{node.type === "ss2022" && (
+					<div className="col-span-2">
+						<span className="text-gray-400">密钥: </span>
+						<span className="text-white font-mono text-xs">
+							{node.password ? `${node.password.substring(0, 8)}...` : "None"}
+						</span>
+					</div>
+				)}
+				{node.region && (
+					<div className="col-span-2">
+						<span className="text-gray-400">地区: </span>
+						<span className="text-white">{node.region}</span>
+					</div>
+				)}
 			</div>
 		</div>
 	);
@@ -259,6 +391,9 @@ const AddNode = () => {
 								<option value="reality">Reality</option>
 								<option value="hysteria2">Hysteria2</option>
 								<option value="vlessCDN">VLessCDN</option>
+								<option value="tuic">TUIC</option>
+								<option value="trojan">Trojan</option>
+								<option value="ss2022">SS2022</option>
 							</select>
 						</div>
 
@@ -356,6 +491,84 @@ const AddNode = () => {
 							/>
 						</div>
 
+						{type === "reality" && (
+							<>
+								<div>
+									<label className={styles.label}>公钥</label>
+									<input
+										type="text"
+										name="public_key"
+										onChange={onChange}
+										value={public_key}
+										className={styles.input}
+										placeholder="留空时使用环境变量 PUBLIC_KEY"
+									/>
+								</div>
+								<div>
+									<label className={styles.label}>Short ID</label>
+									<input
+										type="text"
+										name="short_id"
+										onChange={onChange}
+										value={short_id}
+										className={styles.input}
+										placeholder="多个用逗号分隔，订阅使用第一个"
+									/>
+								</div>
+								<div>
+									<label className={styles.label}>指纹</label>
+									<input
+										type="text"
+										name="fingerprint"
+										onChange={onChange}
+										value={fingerprint}
+										className={styles.input}
+										placeholder="chrome"
+									/>
+								</div>
+							</>
+						)}
+
+						{(type === "hysteria2" || type === "tuic") && (
+							<div>
+								<label className="flex items-center space-x-3 cursor-pointer">
+									<input
+										type="checkbox"
+										onChange={(e) => setVerifyCert(e.target.checked)}
+										checked={verifyCert}
+										className="w-4 h-4 text-blue-600 bg-gray-700 border-gray-600 rounded focus:ring-blue-500 focus:ring-2"
+									/>
+									<span className={styles.label + " mb-0"}>验证证书（非自签名证书时勾选）</span>
+								</label>
+							</div>
+						)}
+
+						{type === "ss2022" && (
+							<div>
+								<label className={styles.label}>服务端密钥</label>
+								<input
+									type="text"
+									name="password"
+									onChange={onChange}
+									value={password}
+									className={styles.input}
+									placeholder="openssl rand -base64 16 或 32 生成"
+								/>
+							</div>
+						)}
+
+						<div>
+							<label className={styles.label}>地区</label>
+							<input
+								type="text"
+								name="region"
+								onChange={onChange}
+								value={region}
+								className={styles.input}
+								placeholder="留空时从备注推断，如 HK"
+							/>
+						</div>
+
 						<div>
 							<label className="flex items-center space-x-3 cursor-pointer">
 								<input
@@ -389,6 +602,35 @@ const AddNode = () => {
 				</div>
 			</div>
 
+			{/* Reality 密钥 */}
+			{realityKey && (
+				<div className={`${styles.card} p-6 mb-8`}>
+					<div className="flex items-center justify-between mb-4">
+						<h2 className="text-xl font-semibold text-white">节点 {realityKey.remark} 的 Reality 密钥</h2>
+						<button
+							type="button"
+							onClick={() => setRealityKey(null)}
+							className={`${styles.button} ${styles.buttonSecondary}`}
+						>
+							关闭
+						</button>
+					</div>
+					<p className="text-gray-400 mb-4">私钥不会保存，请将以下 inbound 配置更新到节点并重启 sing-box，确认节点可用后再启用新密钥。启用前订阅仍使用原来的公钥。</p>
+					<pre className="bg-gray-900 text-green-300 text-xs font-mono p-4 rounded-lg overflow-x-auto">
+						{JSON.stringify(realityKey.server_config, null, 2)}
+					</pre>
+					<div className="mt-4 flex justify-end">
+						<button
+							type="button"
+							onClick={handleActivateRealityKey}
+							className={`${styles.button} ${styles.buttonPrimary}`}
+						>
+							启用新密钥
+						</button>
+					</div>
+				</div>
+			)}
+
 			{/* 节点列表 */}
 			<div className="mb-8">
 				<div className="flex items-center justify-between mb-6">
@@ -428,6 +670,34 @@ const AddNode = () => {
 					</form>
 				</div>
 			)}
+
+			{/* 历史版本 */}
+			{versions.length > 0 && (
+				<div className="mt-8">
+					<h2 className="text-xl font-semibold text-white mb-4">历史版本</h2>
+					<div className={`${styles.card} divide-y divide-gray-700`}>
+						{versions.map((version) => (
+							<div key={version.version} className="flex items-center justify-between px-6 py-3">
+								<div className="text-sm">
+									<span className="text-white font-mono mr-3">v{version.version}</span>
+									<span className="text-gray-300 mr-3">{version.action}</span>
+									<span className="text-gray-400 mr-3">{version.node_count} 个节点</span>
+									<span className="text-gray-500">{version.operator} · {new Date(version.created_at).toLocaleString()}</span>
+								</div>
+								{version.version !== versions[0].version && (
+									<button
+										type="button"
+										onClick={() => handleRollback(version.version)}
+										className={`${styles.button} ${styles.buttonDanger}`}
+									>
+										回滚到此版本
+									</button>
+								)}
+							</div>
+						))}
+					</div>
+				</div>
+			)}
 		</div>
 	);
 };
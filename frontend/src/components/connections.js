@@ -0,0 +1,179 @@
+import { useCallback, useEffect, useState } from "react";
+import { useSelector, useDispatch } from "react-redux";
+import axios from "axios";
+import { alert, success } from "../store/message";
+import { formatBytes } from "../service/service";
+
+// 各节点的活动连接，可以按用户筛选并关闭连接
+function Connections() {
+	const [nodes, setNodes] = useState([]);
+	const [ipSummary, setIpSummary] = useState({ limit: 0, users: [] });
+	const [filter, setFilter] = useState("");
+	const [loading, setLoading] = useState(false);
+
+	const dispatch = useDispatch();
+	const loginState = useSelector((state) => state.login);
+
+	const loadConnections = useCallback(() => {
+		setLoading(true);
+		const params = filter ? { name: filter } : {};
+
+		Promise.all([
+			axios.get(process.env.REACT_APP_API_HOST + "node-agent/connections", {
+				headers: { token: loginState.token },
+				params,
+			}),
+			axios.get(process.env.REACT_APP_API_HOST + "node-agent/connection-ips", {
+				headers: { token: loginState.token },
+				params,
+			}),
+		])
+			.then(([connectionsResponse, ipsResponse]) => {
+				setNodes(connectionsResponse.data || []);
+				setIpSummary(ipsResponse.data || { limit: 0, users: [] });
+				setLoading(false);
+			})
+			.catch((err) => {
+				setLoading(false);
+				dispatch(alert({ show: true, content: err.response?.data?.error || err.toString() }));
+			});
+	}, [filter, loginState.token, dispatch]);
+
+	useEffect(() => {
+		loadConnections();
+		// 只在首次打开时加载，之后手动刷新
+		// eslint-disable-next-line react-hooks/exhaustive-deps
+	}, []);
+
+	const closeConnection = (domain, id) => {
+		axios
+			.delete(process.env.REACT_APP_API_HOST + `node-agent/connections/${domain}/${id}`, {
+				headers: { token: loginState.token },
+			})
+			.then((response) => {
+				dispatch(success({ show: true, content: response.data.message }));
+				loadConnections();
+			})
+			.catch((err) => {
+				dispatch(alert({ show: true, content: err.response?.data?.error || err.toString() }));
+			});
+	};
+
+	const formatStart = (start) => (start ? new Date(start * 1000).toLocaleString() : "-");
+
+	return (
+		<div>
+			<div className="bg-gray-800 rounded-lg shadow-lg p-6 mb-6">
+				<div className="flex flex-col md:flex-row gap-4">
+					<input
+						type="text"
+						placeholder="按用户筛选（email_as_id）"
+						value={filter}
+						onChange={(e) => setFilter(e.target.value.trim())}
+						className="flex-1 px-4 py-2 bg-gray-700 border border-gray-600 rounded-lg text-white placeholder-gray-400 focus:ring-2 focus:ring-blue-500 focus:border-transparent"
+					/>
+					<button
+						onClick={loadConnections}
+						disabled={loading}
+						className="px-4 py-2 rounded-lg font-medium text-sm bg-blue-600 hover:bg-blue-700 text-white disabled:opacity-50"
+					>
+						{loading ? "加载中..." : "刷新"}
+					</button>
+				</div>
+			</div>
+
+			{/* 同时在线 IP 统计 */}
+			<div className="bg-gray-800 rounded-lg shadow-lg p-6 mb-6">
+				<h3 className="text-lg font-semibold text-white mb-4">
+					同时在线 IP（超过 {ipSummary.limit} 个标记为疑似共享）
+				</h3>
+				{(ipSummary.users?.length || 0) === 0 ? (
+					<p className="text-gray-400">暂无活动连接</p>
+				) : (
+					<div className="overflow-x-auto">
+						<table className="w-full text-sm text-left text-gray-300">
+							<thead className="text-gray-400 border-b border-gray-700">
+								<tr>
+									<th className="py-2 pr-4">用户</th>
+									<th className="py-2 pr-4">IP 数</th>
+									<th className="py-2 pr-4">连接数</th>
+									<th className="py-2 pr-4">IP</th>
+									<th className="py-2 pr-4">节点</th>
+								</tr>
+							</thead>
+							<tbody>
+								{ipSummary.users.map((user) => (
+									<tr key={user.user} className="border-b border-gray-700">
+										<td className="py-2 pr-4">
+											{user.user}
+											{user.shared && (
+												<span className="ml-2 px-2 py-1 rounded-full text-xs font-medium bg-red-900 text-red-300">疑似共享</span>
+											)}
+										</td>
+										<td className="py-2 pr-4">{user.ip_count}</td>
+										<td className="py-2 pr-4">{user.connections}</td>
+										<td className="py-2 pr-4 break-all">{user.ips?.join(", ")}</td>
+										<td className="py-2 pr-4">{user.nodes?.join(", ")}</td>
+									</tr>
+								))}
+							</tbody>
+						</table>
+					</div>
+				)}
+			</div>
+
+			{/* 各节点的连接 */}
+			{nodes.map((node) => (
+				<div key={node.domain} className="bg-gray-800 rounded-lg shadow-lg p-6 mb-6">
+					<h3 className="text-lg font-semibold text-white mb-4">
+						{node.domain}
+						<span className="ml-2 text-sm text-gray-400">
+							{node.error ? node.error : `${node.data?.connections?.length || 0} 个连接`}
+						</span>
+					</h3>
+					{(node.data?.connections?.length || 0) > 0 && (
+						<div className="overflow-x-auto">
+							<table className="w-full text-sm text-left text-gray-300">
+								<thead className="text-gray-400 border-b border-gray-700">
+									<tr>
+										<th className="py-2 pr-4">用户</th>
+										<th className="py-2 pr-4">协议</th>
+										<th className="py-2 pr-4">来源</th>
+										<th className="py-2 pr-4">目标</th>
+										<th className="py-2 pr-4">开始时间</th>
+										<th className="py-2 pr-4">上传 / 下载</th>
+										<th className="py-2 pr-4"></th>
+									</tr>
+								</thead>
+								<tbody>
+									{node.data.connections.map((conn) => (
+										<tr key={conn.id} className="border-b border-gray-700">
+											<td className="py-2 pr-4">{conn.user}</td>
+											<td className="py-2 pr-4">{conn.protocol}/{conn.network}</td>
+											<td className="py-2 pr-4">{conn.source}</td>
+											<td className="py-2 pr-4 break-all">{conn.destination}</td>
+											<td className="py-2 pr-4">{formatStart(conn.start)}</td>
+											<td className="py-2 pr-4">
+												{formatBytes(conn.upload || 0)} / {formatBytes(conn.download || 0)}
+											</td>
+											<td className="py-2 pr-4">
+												<button
+													onClick={() => closeConnection(node.domain, conn.id)}
+													className="px-3 py-1 rounded-lg text-xs bg-red-600 hover:bg-red-700 text-white"
+												>
+													断开
+												</button>
+											</td>
+										</tr>
+									))}
+								</tbody>
+							</table>
+						</div>
+					)}
+				</div>
+			))}
+		</div>
+	);
+}
+
+export default Connections;
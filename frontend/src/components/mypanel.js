@@ -6,6 +6,7 @@ import Alert from "./alert";
 
 function Mypanel() {
 	const [user, setUser] = useState({});
+	const [telegramLink, setTelegramLink] = useState(null);
 
 	const dispatch = useDispatch();
 	const loginState = useSelector((state) => state.login);
@@ -58,6 +59,53 @@ function Mypanel() {
 		});
 	};
 
+	// 重置订阅令牌，旧链接立即失效
+	const rotateSubscriptionToken = () => {
+		if (!window.confirm("重置后旧的订阅链接将失效，需要在客户端重新导入，确定继续？")) return;
+		axios
+			.put(process.env.REACT_APP_API_HOST + "subscription-token/" + user.email_as_id, {}, {
+				headers: { token: loginState.token },
+			})
+			.then((response) => {
+				setUser({ ...user, subscription_token: response.data.subscription_token });
+				dispatch(success({ show: true, content: response.data.message }));
+			})
+			.catch((err) => {
+				dispatch(alert({ show: true, content: err.toString() }));
+			});
+	};
+
+	// 生成 Telegram 一次性绑定码，10 分钟内有效
+	const createTelegramLinkCode = () => {
+		axios
+			.post(process.env.REACT_APP_API_HOST + "telegram-link/" + user.email_as_id, {}, {
+				headers: { token: loginState.token },
+			})
+			.then((response) => {
+				setTelegramLink(response.data);
+			})
+			.catch((err) => {
+				dispatch(alert({ show: true, content: err.response?.data?.error || err.toString() }));
+			});
+	};
+
+	// 解除 Telegram 绑定
+	const unlinkTelegram = () => {
+		if (!window.confirm("解除绑定后将不再收到 Telegram 通知，确定继续？")) return;
+		axios
+			.delete(process.env.REACT_APP_API_HOST + "telegram-link/" + user.email_as_id, {
+				headers: { token: loginState.token },
+			})
+			.then((response) => {
+				setUser({ ...user, telegram_chat_id: null });
+				setTelegramLink(null);
+				dispatch(success({ show: true, content: response.data.message }));
+			})
+			.catch((err) => {
+				dispatch(alert({ show: true, content: err.response?.data?.error || err.toString() }));
+			});
+	};
+
 	useEffect(() => {
 		if (message.show === true) {
 			setTimeout(() => {
@@ -202,16 +250,40 @@ function Mypanel() {
 
 				{/* 订阅链接 */}
 				<div className={`${styles.card} p-6`}>
-					<h3 className="text-xl font-bold text-white mb-6">订阅链接</h3>
+					<div className="flex items-center justify-between mb-6">
+						<h3 className="text-xl font-bold text-white">订阅链接</h3>
+						<button
+							onClick={rotateSubscriptionToken}
+							className={`${styles.button} bg-gray-600 hover:bg-gray-500 text-white focus:ring-gray-500 text-xs`}
+							title="生成新的订阅链接，旧链接立即失效"
+						>
+							重置链接
+						</button>
+					</div>
 					<div className="space-y-4">
-						{/* Shadowrocket/Surge 订阅 */}
+						{/* 通用订阅 */}
 						<div className="flex items-center justify-between p-3 bg-gray-700 rounded-lg">
 							<div>
-								<span className="text-white font-medium">Shadowrocket/Surge</span>
+								<span className="text-white font-medium">通用订阅</span>
+								<p className="text-gray-400 text-sm">自动识别客户端，推荐使用</p>
+							</div>
+							<button
+								onClick={() => copyToClipboard(process.env.REACT_APP_FILE_AND_SUB_URL + "/sub/" + (user.subscription_token || user.email_as_id))}
+								className={`${styles.button} ${styles.buttonPrimary} text-xs`}
+								title="复制订阅链接"
+							>
+								复制链接
+							</button>
+						</div>
+
+						{/* Shadowrocket 订阅 */}
+						<div className="flex items-center justify-between p-3 bg-gray-700 rounded-lg">
+							<div>
+								<span className="text-white font-medium">Shadowrocket</span>
 								<p className="text-gray-400 text-sm">适用于 iOS 客户端</p>
 							</div>
 							<button
-								onClick={() => copyToClipboard(process.env.REACT_APP_FILE_AND_SUB_URL + "/static/" + user.email_as_id)}
+								onClick={() => copyToClipboard(process.env.REACT_APP_FILE_AND_SUB_URL + "/static/" + (user.subscription_token || user.email_as_id))}
 								className={`${styles.button} ${styles.buttonPrimary} text-xs`}
 								title="复制订阅链接"
 							>
@@ -226,7 +298,22 @@ function Mypanel() {
 								<p className="text-gray-400 text-sm">适用于 Verge 客户端</p>
 							</div>
 							<button
-								onClick={() => copyToClipboard(process.env.REACT_APP_FILE_AND_SUB_URL + "/verge/" + user.email_as_id)}
+								onClick={() => copyToClipboard(process.env.REACT_APP_FILE_AND_SUB_URL + "/verge/" + (user.subscription_token || user.email_as_id))}
+								className={`${styles.button} ${styles.buttonPrimary} text-xs`}
+								title="复制订阅链接"
+							>
+								复制链接
+							</button>
+						</div>
+
+						{/* Clash Meta 订阅 */}
+						<div className="flex items-center justify-between p-3 bg-gray-700 rounded-lg">
+							<div>
+								<span className="text-white font-medium">Clash Meta</span>
+								<p className="text-gray-400 text-sm">适用于 Mihomo 内核的客户端</p>
+							</div>
+							<button
+								onClick={() => copyToClipboard(process.env.REACT_APP_FILE_AND_SUB_URL + "/clash/" + (user.subscription_token || user.email_as_id))}
 								className={`${styles.button} ${styles.buttonPrimary} text-xs`}
 								title="复制订阅链接"
 							>
@@ -234,6 +321,27 @@ function Mypanel() {
 							</button>
 						</div>
 
+						{/* Surge、Quantumult X、Loon 订阅 */}
+						{[
+							{ path: "surge", name: "Surge", desc: "Surge 5 托管配置，仅包含 Hysteria2 节点" },
+							{ path: "quanx", name: "Quantumult X", desc: "节点资源，仅包含 CDN 节点" },
+							{ path: "loon", name: "Loon", desc: "节点订阅" },
+						].map((client) => (
+							<div key={client.path} className="flex items-center justify-between p-3 bg-gray-700 rounded-lg">
+								<div>
+									<span className="text-white font-medium">{client.name}</span>
+									<p className="text-gray-400 text-sm">{client.desc}</p>
+								</div>
+								<button
+									onClick={() => copyToClipboard(process.env.REACT_APP_FILE_AND_SUB_URL + "/" + client.path + "/" + (user.subscription_token || user.email_as_id))}
+									className={`${styles.button} ${styles.buttonPrimary} text-xs`}
+									title="复制订阅链接"
+								>
+									复制链接
+								</button>
+							</div>
+						))}
+
 						{/* Sing-box 订阅 */}
 						<div className="flex items-center justify-between p-3 bg-gray-700 rounded-lg">
 							<div>
@@ -241,13 +349,46 @@ function Mypanel() {
 								<p className="text-gray-400 text-sm">适用于 Sing-box 客户端</p>
 							</div>
 							<button
-								onClick={() => copyToClipboard(process.env.REACT_APP_FILE_AND_SUB_URL + "/singbox/" + user.email_as_id)}
+								onClick={() => copyToClipboard(process.env.REACT_APP_FILE_AND_SUB_URL + "/singbox/" + (user.subscription_token || user.email_as_id))}
 								className={`${styles.button} ${styles.buttonPrimary} text-xs`}
 								title="复制订阅链接"
 							>
 								复制链接
 							</button>
 						</div>
+
+						{/* Telegram 绑定 */}
+						<div className="p-3 bg-gray-700 rounded-lg">
+							<div className="flex items-center justify-between">
+								<div>
+									<span className="text-white font-medium">Telegram</span>
+									<p className="text-gray-400 text-sm">
+										{user.telegram_chat_id ? "已绑定，可通过 bot 查询流量和到期时间" : "绑定后可通过 bot 查询流量、订阅链接和缴费记录"}
+									</p>
+								</div>
+								{user.telegram_chat_id ? (
+									<button onClick={unlinkTelegram} className={`${styles.button} bg-gray-600 hover:bg-gray-500 text-white text-xs`}>
+										解除绑定
+									</button>
+								) : (
+									<button onClick={createTelegramLinkCode} className={`${styles.button} ${styles.buttonPrimary} text-xs`}>
+										获取绑定码
+									</button>
+								)}
+							</div>
+							{telegramLink && !user.telegram_chat_id && (
+								<div className="mt-3 text-sm text-gray-300">
+									<p>
+										向 bot 发送 <span className="font-mono text-green-400">/link {telegramLink.code}</span>，10 分钟内有效
+									</p>
+									{telegramLink.bot_url && (
+										<a href={telegramLink.bot_url} target="_blank" rel="noreferrer" className="text-blue-400 hover:underline">
+											在 Telegram 中打开
+										</a>
+									)}
+								</div>
+							)}
+						</div>
 					</div>
 				</div>
 			</div>
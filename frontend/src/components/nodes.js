@@ -6,6 +6,7 @@ import Alert from "./alert";
 import { doRerender } from "../store/rerender";
 import { formatBytes } from "../service/service";
 import websocketService from "../service/websocket";
+import Connections from "./connections";
 
 function Nodes() {
 	const [singboxNodes, setSingboxNodes] = useState([]);
@@ -13,7 +14,7 @@ function Nodes() {
 	const [loading, setLoading] = useState(true); // 添加加载状态
 	const [newDomain, setNewDomain] = useState("");
 	const [newRemark, setNewRemark] = useState("");
-	const [activeSection, setActiveSection] = useState("nodes"); // 'nodes' or 'domains' 
+	const [activeSection, setActiveSection] = useState("nodes"); // 'nodes'、'domains' 或 'connections' 
 	const [selectedNode, setSelectedNode] = useState(null); // 用于控制模态框显示的节点
 	const [wsStatus, setWsStatus] = useState('disconnected'); // WebSocket 连接状态
 	const [customDates, setCustomDates] = useState({}); // 存储每个节点的自定义日期
@@ -620,6 +621,16 @@ function Nodes() {
 				>
 					域名监控 ({monitoredDomains?.length || 0})
 				</button>
+				<button
+					onClick={() => setActiveSection("connections")}
+					className={`px-6 py-2 rounded-lg font-medium transition-colors ${
+						activeSection === "connections" 
+							? "bg-blue-600 text-white" 
+							: "bg-gray-700 text-gray-300 hover:bg-gray-600"
+					}`}
+				>
+					活动连接
+				</button>
 			</div>
 
 			{/* 节点管理部分 */}
@@ -656,6 +667,9 @@ function Nodes() {
 			)}
 
 			{/* 域名监控部分 */}
+			{/* 活动连接部分 */}
+			{activeSection === "connections" && <Connections />}
+
 			{activeSection === "domains" && (
 				<div>
 					{/* 添加域名表单 */}
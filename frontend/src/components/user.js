@@ -782,7 +782,7 @@ const User = () => {
 											<p className="text-gray-400 text-sm mt-1">适用于 iOS Shadowrocket 和 Surge</p>
 										</div>
 										<button
-											onClick={() => copyToClipboard(process.env.REACT_APP_FILE_AND_SUB_URL + "/static/" + modalUser.email_as_id)}
+											onClick={() => copyToClipboard(process.env.REACT_APP_FILE_AND_SUB_URL + "/static/" + (modalUser.subscription_token || modalUser.email_as_id))}
 											className={`${styles.button} ${styles.buttonPrimary} text-xs`}
 											title="复制订阅链接"
 										>
@@ -797,7 +797,7 @@ const User = () => {
 											<p className="text-gray-400 text-sm mt-1">适用于 Verge 客户端</p>
 										</div>
 										<button
-											onClick={() => copyToClipboard(process.env.REACT_APP_FILE_AND_SUB_URL + "/verge/" + modalUser.email_as_id)}
+											onClick={() => copyToClipboard(process.env.REACT_APP_FILE_AND_SUB_URL + "/verge/" + (modalUser.subscription_token || modalUser.email_as_id))}
 											className={`${styles.button} ${styles.buttonPrimary} text-xs`}
 											title="复制订阅链接"
 										>
@@ -812,7 +812,7 @@ const User = () => {
 											<p className="text-gray-400 text-sm mt-1">适用于 Sing-box 客户端</p>
 										</div>
 										<button
-											onClick={() => copyToClipboard(process.env.REACT_APP_FILE_AND_SUB_URL + "/singbox/" + modalUser.email_as_id)}
+											onClick={() => copyToClipboard(process.env.REACT_APP_FILE_AND_SUB_URL + "/singbox/" + (modalUser.subscription_token || modalUser.email_as_id))}
 											className={`${styles.button} ${styles.buttonPrimary} text-xs`}
 											title="复制订阅链接"
 										>
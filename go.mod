@@ -20,8 +20,9 @@ require (
 	github.com/lib/pq v1.10.9
 	github.com/mrz1836/go-sanitize v1.1.5
 	github.com/robfig/cron v1.2.0
+	github.com/sagernet/quic-go v0.40.1-beta.2
 	github.com/sagernet/sing v0.3.0
-	github.com/sagernet/sing-box v1.8.1
+	github.com/sagernet/sing-box v1.8.1 // 用户热更新依赖该版本的未导出字段，升级前检查 pkg/usermanager.go 中的 singBoxVersion
 	github.com/spf13/cobra v1.8.0
 	golang.org/x/crypto v0.31.0
 	google.golang.org/protobuf v1.32.0
@@ -93,7 +94,6 @@ require (
 	github.com/sagernet/cloudflare-tls v0.0.0-20231208171750-a4483c1b7cd1 // indirect
 	github.com/sagernet/gvisor v0.0.0-20231209105102-8d27a30e436e // indirect
 	github.com/sagernet/netlink v0.0.0-20220905062125-8043b4a9aa97 // indirect
-	github.com/sagernet/quic-go v0.40.1-beta.2 // indirect
 	github.com/sagernet/reality v0.0.0-20230406110435-ee17307e7691 // indirect
 	github.com/sagernet/sing-dns v0.1.12 // indirect
 	github.com/sagernet/sing-mux v0.2.0 // indirect
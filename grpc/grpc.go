@@ -1 +1,430 @@
 package grpc
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	box "github.com/sagernet/sing-box"
+	_cron "github.com/xvv6u577/logv2fs/cron"
+	thirdparty "github.com/xvv6u577/logv2fs/pkg"
+	pb "github.com/xvv6u577/logv2fs/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	// 节点 gRPC 监听地址，默认 0.0.0.0:50051
+	GRPC_LISTEN_ADDRESS = os.Getenv("GRPC_LISTEN_ADDRESS")
+	// 控制面连接节点时使用的端口，默认 50051
+	GRPC_PORT = os.Getenv("GRPC_PORT")
+	// 共享令牌，控制面在 metadata 中以 authorization: Bearer <token> 携带
+	GRPC_AUTH_TOKEN = os.Getenv("GRPC_AUTH_TOKEN")
+	// TLS 证书与私钥，节点作为服务端证书，控制面作为客户端证书
+	GRPC_TLS_CERT = os.Getenv("GRPC_TLS_CERT")
+	GRPC_TLS_KEY  = os.Getenv("GRPC_TLS_KEY")
+	// 用于校验对端证书的 CA，设置后节点要求客户端证书（mTLS）
+	GRPC_TLS_CA = os.Getenv("GRPC_TLS_CA")
+)
+
+const (
+	defaultListenAddress = "0.0.0.0:50051"
+	defaultPort          = "50051"
+	callTimeout          = 10 * time.Second
+)
+
+// Server 节点上运行的用户管理服务
+type Server struct {
+	pb.UnimplementedManageV2RayUserBygRPCServer
+	manager  *thirdparty.UserManager
+	instance *box.Box
+	// 活动连接统计，Clash API 不可用时为 nil
+	connections *thirdparty.ConnectionTracker
+}
+
+// NewServer 创建节点用户管理服务
+func NewServer(manager *thirdparty.UserManager, instance *box.Box, connections *thirdparty.ConnectionTracker) *Server {
+	return &Server{manager: manager, instance: instance, connections: connections}
+}
+
+// AddUser 添加或更新用户，name 为用户的 email_as_id
+func (s *Server) AddUser(ctx context.Context, in *pb.GRPCRequest) (*pb.GRPCReply, error) {
+	if in.GetName() == "" || in.GetUuid() == "" {
+		return nil, status.Error(codes.InvalidArgument, "name and uuid are required")
+	}
+
+	if err := s.manager.AddUser(thirdparty.ProvisionedUser{
+		EmailAsId: in.GetName(),
+		UUID:      in.GetUuid(),
+		UserID:    in.GetUserId(),
+		Protocols: in.GetProtocols(),
+	}); err != nil {
+		log.Printf("gRPC 添加用户 %s 失败: %v", in.GetName(), err)
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	log.Printf("gRPC 添加用户 %s 成功", in.GetName())
+	go _cron.ReportNodeUserSet(s.manager)
+	return &pb.GRPCReply{SuccesOrNot: "success"}, nil
+}
+
+// DeleteUser 移除用户
+func (s *Server) DeleteUser(ctx context.Context, in *pb.GRPCRequest) (*pb.GRPCReply, error) {
+	if in.GetName() == "" {
+		return nil, status.Error(codes.InvalidArgument, "name is required")
+	}
+
+	if err := s.manager.RemoveUser(in.GetName()); err != nil {
+		log.Printf("gRPC 移除用户 %s 失败: %v", in.GetName(), err)
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	log.Printf("gRPC 移除用户 %s 成功", in.GetName())
+	go _cron.ReportNodeUserSet(s.manager)
+	return &pb.GRPCReply{SuccesOrNot: "success"}, nil
+}
+
+// ListUsers 返回节点当前加载的用户
+func (s *Server) ListUsers(ctx context.Context, in *pb.ListUsersRequest) (*pb.ListUsersReply, error) {
+	snapshot := s.manager.Snapshot()
+	reply := &pb.ListUsersReply{
+		UserCount: int32(snapshot.UserCount),
+		Checksum:  snapshot.Checksum,
+		SyncedAt:  snapshot.SyncedAt.Unix(),
+	}
+	for _, user := range s.manager.Users() {
+		reply.Users = append(reply.Users, &pb.NodeUser{
+			Name:   user.EmailAsId,
+			Uuid:   user.UUID,
+			UserId: user.UserID,
+		})
+	}
+	return reply, nil
+}
+
+// QueryTraffic 查询自上次记录以来的实时流量，不会清零计数器
+func (s *Server) QueryTraffic(ctx context.Context, in *pb.TrafficRequest) (*pb.TrafficReply, error) {
+	usage, err := thirdparty.UsageData(s.instance, false)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	reply := &pb.TrafficReply{}
+	for _, traffic := range usage {
+		if in.GetName() != "" && traffic.Name != in.GetName() {
+			continue
+		}
+		reply.Traffic = append(reply.Traffic, &pb.UserTraffic{
+			Name:      traffic.Name,
+			Total:     traffic.Total,
+			Uplink:    traffic.Uplink,
+			Downlink:  traffic.Downlink,
+			Protocols: traffic.Protocols,
+		})
+	}
+	return reply, nil
+}
+
+// ReloadConfig 从数据库重新加载活跃用户
+func (s *Server) ReloadConfig(ctx context.Context, in *pb.ReloadRequest) (*pb.ReloadReply, error) {
+	users, err := thirdparty.LoadActiveUsers()
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	added, removed, err := s.manager.Sync(users)
+	if err != nil {
+		log.Printf("gRPC 重新加载用户失败: %v", err)
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	log.Printf("gRPC 重新加载用户完成 - 新增: %v, 移除: %v", added, removed)
+	go _cron.ReportNodeUserSet(s.manager)
+	return &pb.ReloadReply{Added: added, Removed: removed, SuccesOrNot: "success"}, nil
+}
+
+// ListConnections 返回节点上的活动连接，name 非空时只返回该用户的连接
+func (s *Server) ListConnections(ctx context.Context, in *pb.ConnectionsRequest) (*pb.ConnectionsReply, error) {
+	if s.connections == nil {
+		return nil, status.Error(codes.Unavailable, "connection tracking is not enabled")
+	}
+
+	connections, err := s.connections.Connections(in.GetName())
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	reply := &pb.ConnectionsReply{}
+	for _, conn := range connections {
+		reply.Connections = append(reply.Connections, &pb.Connection{
+			Id:          conn.ID,
+			User:        conn.User,
+			Protocol:    conn.Protocol,
+			Inbound:     conn.Inbound,
+			Network:     conn.Network,
+			Source:      conn.Source,
+			Destination: conn.Destination,
+			Start:       conn.Start.Unix(),
+			Upload:      conn.Upload,
+			Download:    conn.Download,
+		})
+	}
+	return reply, nil
+}
+
+// CloseConnection 关闭指定的连接
+func (s *Server) CloseConnection(ctx context.Context, in *pb.CloseConnectionRequest) (*pb.GRPCReply, error) {
+	if s.connections == nil {
+		return nil, status.Error(codes.Unavailable, "connection tracking is not enabled")
+	}
+	if in.GetId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "id is required")
+	}
+
+	if !s.connections.CloseConnection(in.GetId()) {
+		return nil, status.Error(codes.NotFound, "connection not found")
+	}
+
+	log.Printf("gRPC 关闭连接 %s", in.GetId())
+	return &pb.GRPCReply{SuccesOrNot: "success"}, nil
+}
+
+// Serve 在节点上启动 gRPC 服务。
+// 必须配置 TLS 证书，并至少配置共享令牌或 mTLS 其中一种认证方式，否则不启动；
+// 令牌可以增删所有节点的用户，不允许通过明文连接传输。
+func Serve(manager *thirdparty.UserManager, instance *box.Box, connections *thirdparty.ConnectionTracker) (*grpc.Server, error) {
+	address := GRPC_LISTEN_ADDRESS
+	if address == "" {
+		address = defaultListenAddress
+	}
+
+	if GRPC_TLS_CERT == "" || GRPC_TLS_KEY == "" {
+		return nil, fmt.Errorf("gRPC 服务未配置 TLS: 请设置 GRPC_TLS_CERT/GRPC_TLS_KEY")
+	}
+	tlsConfig, err := serverTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	mutualTLS := tlsConfig.ClientAuth == tls.RequireAndVerifyClientCert
+	opts := []grpc.ServerOption{grpc.Creds(credentials.NewTLS(tlsConfig))}
+
+	if GRPC_AUTH_TOKEN == "" && !mutualTLS {
+		return nil, fmt.Errorf("gRPC 服务未配置认证: 请设置 GRPC_AUTH_TOKEN 或 GRPC_TLS_CA")
+	}
+	if GRPC_AUTH_TOKEN != "" {
+		opts = append(opts, grpc.UnaryInterceptor(tokenInterceptor))
+	}
+
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	server := grpc.NewServer(opts...)
+	pb.RegisterManageV2RayUserBygRPCServer(server, NewServer(manager, instance, connections))
+
+	go func() {
+		if err := server.Serve(listener); err != nil {
+			log.Printf("gRPC 服务退出: %v", err)
+		}
+	}()
+
+	log.Printf("gRPC 服务已启动: %s (mTLS: %v, token: %v)", address, mutualTLS, GRPC_AUTH_TOKEN != "")
+	return server, nil
+}
+
+// tokenInterceptor 校验请求中的共享令牌
+func tokenInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "missing metadata")
+	}
+
+	values := md.Get("authorization")
+	if len(values) == 0 || subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(values[0], "Bearer ")), []byte(GRPC_AUTH_TOKEN)) != 1 {
+		log.Printf("gRPC 认证失败: %s", info.FullMethod)
+		return nil, status.Error(codes.Unauthenticated, "invalid token")
+	}
+
+	return handler(ctx, req)
+}
+
+// serverTLSConfig 节点端 TLS 配置，设置 CA 时校验客户端证书
+func serverTLSConfig() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(GRPC_TLS_CERT, GRPC_TLS_KEY)
+	if err != nil {
+		return nil, fmt.Errorf("加载 gRPC 证书失败: %v", err)
+	}
+
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if GRPC_TLS_CA != "" {
+		pool, err := loadCertPool(GRPC_TLS_CA)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return tlsConfig, nil
+}
+
+// clientTLSConfig 控制面 TLS 配置，必须设置 CA 用于校验节点证书
+func clientTLSConfig() (*tls.Config, error) {
+	if GRPC_TLS_CA == "" {
+		return nil, fmt.Errorf("gRPC 客户端未配置 TLS: 请设置 GRPC_TLS_CA")
+	}
+
+	pool, err := loadCertPool(GRPC_TLS_CA)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		RootCAs:    pool,
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if GRPC_TLS_CERT != "" && GRPC_TLS_KEY != "" {
+		cert, err := tls.LoadX509KeyPair(GRPC_TLS_CERT, GRPC_TLS_KEY)
+		if err != nil {
+			return nil, fmt.Errorf("加载 gRPC 客户端证书失败: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+func loadCertPool(path string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取 CA 证书失败: %v", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("解析 CA 证书失败: %s", path)
+	}
+	return pool, nil
+}
+
+// tokenCredentials 在每次调用时附加共享令牌，只能通过 TLS 连接发送
+type tokenCredentials struct {
+	token string
+}
+
+func (t tokenCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + t.token}, nil
+}
+
+func (t tokenCredentials) RequireTransportSecurity() bool {
+	return true
+}
+
+// Enabled 控制面是否配置了节点 gRPC 认证，未配置时节点只依赖定时同步
+func Enabled() bool {
+	return GRPC_AUTH_TOKEN != "" || GRPC_TLS_CA != ""
+}
+
+// Dial 连接节点的 gRPC 服务
+func Dial(domain string) (*grpc.ClientConn, error) {
+	port := GRPC_PORT
+	if port == "" {
+		port = defaultPort
+	}
+
+	tlsConfig, err := clientTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	opts := []grpc.DialOption{grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig))}
+	if GRPC_AUTH_TOKEN != "" {
+		opts = append(opts, grpc.WithPerRPCCredentials(tokenCredentials{token: GRPC_AUTH_TOKEN}))
+	}
+
+	return grpc.Dial(net.JoinHostPort(domain, port), opts...)
+}
+
+// NodeResult 单个节点的调用结果
+type NodeResult struct {
+	Domain string `json:"domain"`
+	Error  string `json:"error,omitempty"`
+}
+
+// CallNodes 并发调用所有节点，返回每个节点的结果
+func CallNodes(domains []string, call func(ctx context.Context, domain string, client pb.ManageV2RayUserBygRPCClient) error) []NodeResult {
+	results := make([]NodeResult, len(domains))
+	var wg sync.WaitGroup
+
+	for i, domain := range domains {
+		wg.Add(1)
+		go func(i int, domain string) {
+			defer wg.Done()
+			results[i].Domain = domain
+
+			conn, err := Dial(domain)
+			if err != nil {
+				results[i].Error = err.Error()
+				log.Printf("连接节点 %s 失败: %v", domain, err)
+				return
+			}
+			defer conn.Close()
+
+			ctx, cancel := context.WithTimeout(context.Background(), callTimeout)
+			defer cancel()
+
+			if err := call(ctx, domain, pb.NewManageV2RayUserBygRPCClient(conn)); err != nil {
+				results[i].Error = err.Error()
+				log.Printf("调用节点 %s 失败: %v", domain, err)
+			}
+		}(i, domain)
+	}
+
+	wg.Wait()
+	return results
+}
+
+// AddUserToNodes 在节点上添加用户，protocols 为每个节点上套餐允许的节点类型，为空表示不限制
+func AddUserToNodes(protocols map[string][]string, user thirdparty.ProvisionedUser) []NodeResult {
+	domains := make([]string, 0, len(protocols))
+	for domain := range protocols {
+		domains = append(domains, domain)
+	}
+	sort.Strings(domains)
+
+	return CallNodes(domains, func(ctx context.Context, domain string, client pb.ManageV2RayUserBygRPCClient) error {
+		_, err := client.AddUser(ctx, &pb.GRPCRequest{
+			Name:      user.EmailAsId,
+			Uuid:      user.UUID,
+			UserId:    user.UserID,
+			Protocols: protocols[domain],
+		})
+		return err
+	})
+}
+
+// DeleteUserFromNodes 在所有节点上移除用户
+func DeleteUserFromNodes(domains []string, email string) []NodeResult {
+	return CallNodes(domains, func(ctx context.Context, domain string, client pb.ManageV2RayUserBygRPCClient) error {
+		_, err := client.DeleteUser(ctx, &pb.GRPCRequest{Name: email})
+		return err
+	})
+}
@@ -0,0 +1,69 @@
+package helper
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+)
+
+// Shadowsocks 2022 多用户支持的加密方式
+const (
+	SS2022MethodAES128 = "2022-blake3-aes-128-gcm"
+	SS2022MethodAES256 = "2022-blake3-aes-256-gcm"
+)
+
+// SS2022Method 按服务端密钥长度判断加密方式：16 字节为 aes-128，32 字节为 aes-256
+func SS2022Method(serverKey string) (string, error) {
+	key, err := base64.StdEncoding.DecodeString(serverKey)
+	if err != nil {
+		return "", fmt.Errorf("Shadowsocks 2022 服务端密钥不是 base64: %v", err)
+	}
+	switch len(key) {
+	case 16:
+		return SS2022MethodAES128, nil
+	case 32:
+		return SS2022MethodAES256, nil
+	}
+	return "", fmt.Errorf("Shadowsocks 2022 服务端密钥长度应为 16 或 32 字节，实际为 %d", len(key))
+}
+
+// SS2022UserKey 由用户 UUID 派生 Shadowsocks 2022 用户密钥，长度与加密方式一致。
+// 节点和订阅使用同一算法，无需在数据库中保存额外的密码。
+func SS2022UserKey(uuid, method string) string {
+	size := 32
+	if method == SS2022MethodAES128 {
+		size = 16
+	}
+	sum := sha256.Sum256([]byte("ss2022:" + uuid))
+	return base64.StdEncoding.EncodeToString(sum[:size])
+}
+
+// SS2022Password 返回客户端使用的加密方式和密码，密码格式为 "服务端密钥:用户密钥"
+func SS2022Password(serverKey, uuid string) (method string, password string, err error) {
+	method, err = SS2022Method(serverKey)
+	if err != nil {
+		return "", "", err
+	}
+	return method, serverKey + ":" + SS2022UserKey(uuid, method), nil
+}
+
+// GenerateRealityKeyPair 生成 Reality 使用的 x25519 密钥对，编码与 sing-box generate reality-keypair 相同
+func GenerateRealityKeyPair() (privateKey string, publicKey string, err error) {
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(key.Bytes()), base64.RawURLEncoding.EncodeToString(key.PublicKey().Bytes()), nil
+}
+
+// GenerateShortID 生成 8 字节的 Reality short id
+func GenerateShortID() (string, error) {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
@@ -2,6 +2,12 @@ package helper
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
 	"log"
 	"net"
 	"net/http"
@@ -58,6 +64,49 @@ func IsDomainReachable(domain string) bool {
 	return false
 }
 
+// CertInfo TLS 握手得到的证书信息
+type CertInfo struct {
+	NotAfter    time.Time
+	VerifyError error // 证书链或域名校验失败的原因（包括证书已过期），校验通过时为 nil
+}
+
+// CertExpiry 连接 domain:443 完成 TLS 握手，返回证书的过期时间和校验结果。
+// 握手时不校验证书，已过期或不受信任的证书也能读到过期时间，证书链和域名在握手后单独校验
+func CertExpiry(domain string, timeout time.Duration) (CertInfo, error) {
+	conf := &tls.Config{ServerName: domain, InsecureSkipVerify: true}
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: timeout}, "tcp", net.JoinHostPort(domain, "443"), conf)
+	if err != nil {
+		return CertInfo{}, err
+	}
+	defer conn.Close()
+
+	certs := conn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return CertInfo{}, errors.New("no peer certificate")
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, verifyErr := certs[0].Verify(x509.VerifyOptions{DNSName: domain, Intermediates: intermediates})
+	return CertInfo{NotAfter: certs[0].NotAfter, VerifyError: verifyErr}, nil
+}
+
+// FormatBytes 把字节数格式化为 KiB / MiB / GiB 等可读形式
+func FormatBytes(b int64) string {
+	const unit = 1024
+	if b < unit {
+		return fmt.Sprintf("%d B", b)
+	}
+	div, exp := int64(unit), 0
+	for n := b / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.2f %ciB", float64(b)/float64(div), "KMGTPE"[exp])
+}
+
 // IsIPv6 检测字符串是否为IPv6地址
 func IsIPv6(ip string) bool {
 	// 解析IP地址
@@ -80,3 +129,27 @@ func FormatIPForURL(ip string) string {
 
 	return ip
 }
+
+// GenerateSubscriptionToken 生成随机的订阅令牌（32 位 URL 安全字符）
+func GenerateSubscriptionToken() (string, error) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// linkCodeAlphabet 一次性绑定码使用的字符，去掉了容易混淆的 0/O、1/I
+const linkCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// GenerateLinkCode 生成指定长度的一次性绑定码
+func GenerateLinkCode(length int) (string, error) {
+	buf := make([]byte, length)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	for i, b := range buf {
+		buf[i] = linkCodeAlphabet[int(b)%len(linkCodeAlphabet)]
+	}
+	return string(buf), nil
+}
@@ -1,6 +1,7 @@
 package model
 
 import (
+	"strings"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -13,22 +14,10 @@ type NodeTrafficLogs struct {
 	Status       string             `json:"status" bson:"status" validate:"required,eq=active|eq=inactive"` // status: "active", "inactive"
 	CreatedAt    time.Time          `json:"created_at" bson:"created_at"`
 	UpdatedAt    time.Time          `json:"updated_at" bson:"updated_at"`
-	HourlyLogs   []struct {
-		Timestamp time.Time `json:"timestamp" bson:"timestamp"`
-		Traffic   int64     `json:"traffic" bson:"traffic"`
-	} `json:"hourly_logs" bson:"hourly_logs"`
-	DailyLogs []struct {
-		Date    string `json:"date" bson:"date"`
-		Traffic int64  `json:"traffic" bson:"traffic"`
-	} `json:"daily_logs" bson:"daily_logs"`
-	MonthlyLogs []struct {
-		Month   string `json:"month" bson:"month"`
-		Traffic int64  `json:"traffic" bson:"traffic"`
-	} `json:"monthly_logs" bson:"monthly_logs"`
-	YearlyLogs []struct {
-		Year    string `json:"year" bson:"year"`
-		Traffic int64  `json:"traffic" bson:"traffic"`
-	} `json:"yearly_logs" bson:"yearly_logs"`
+	HourlyLogs   []TrafficLogEntry  `json:"hourly_logs" bson:"hourly_logs"`
+	DailyLogs    []DailyLogEntry    `json:"daily_logs" bson:"daily_logs"`
+	MonthlyLogs  []MonthlyLogEntry  `json:"monthly_logs" bson:"monthly_logs"`
+	YearlyLogs   []YearlyLogEntry   `json:"yearly_logs" bson:"yearly_logs"`
 }
 
 // CollectionName 返回MongoDB集合名称
@@ -42,20 +31,98 @@ type NodeAtPeriod struct {
 	UserTrafficAtPeriod map[string]int64 `json:"user_traffic_at_period" bson:"user_traffic_at_period"`
 }
 
-// Domain type: "work", "vmesstls", "vmessws", "reality", "hysteria2", "vlessCDN"
+// Domain type: "work", "vmesstls", "vmessws", "reality", "hysteria2", "vlessCDN", "tuic", "trojan", "ss2022"
+// ss2022 节点的 PASSWORD 为服务端密钥（base64），加密方式由密钥长度决定
 type SubscriptionNode struct {
-	Type         string `json:"type" bason:"type"`
-	Remark       string `json:"remark" bson:"remark"`
-	Domain       string `json:"domain" bson:"domain" validate:"required,min=2,max=100"`
-	IP           string `json:"ip" bason:"ip"`
-	SNI          string `json:"sni" bson:"sni"`
-	UUID         string `json:"uuid" bson:"uuid"`
-	PATH         string `json:"path" bson:"path"`
-	SERVER_PORT  string `json:"server_port" bson:"server_port"`
-	PASSWORD     string `json:"password" bson:"password"`
-	PUBLIC_KEY   string `json:"public_key" bson:"public_key"`
-	SHORT_ID     string `json:"short_id" bson:"short_id"`
-	EnableOpenai bool   `json:"enable_openai" bson:"enable_openai"`
+	ID          string `json:"id" bson:"node_id,omitempty"` // 节点 ID，增删改和排序都按 ID 进行
+	Position    int    `json:"position" bson:"position"`    // 节点在订阅中的顺序，从小到大
+	Type        string `json:"type" bason:"type"`
+	Remark      string `json:"remark" bson:"remark"`
+	Domain      string `json:"domain" bson:"domain" validate:"required,min=2,max=100"`
+	IP          string `json:"ip" bason:"ip"`
+	SNI         string `json:"sni" bson:"sni"`
+	UUID        string `json:"uuid" bson:"uuid"`
+	PATH        string `json:"path" bson:"path"`
+	SERVER_PORT string `json:"server_port" bson:"server_port"`
+	PASSWORD    string `json:"password" bson:"password"`
+	PUBLIC_KEY  string `json:"public_key" bson:"public_key"`
+	SHORT_ID    string `json:"short_id" bson:"short_id"` // Reality short id，可填多个并用逗号分隔，订阅使用第一个
+	// 新生成、尚未启用的 Reality 公钥和 short id，节点换上新私钥后再启用，启用前订阅仍使用 PUBLIC_KEY
+	PendingPublicKey string `json:"pending_public_key,omitempty" bson:"pending_public_key,omitempty"`
+	PendingShortID   string `json:"pending_short_id,omitempty" bson:"pending_short_id,omitempty"`
+	EnableOpenai     bool   `json:"enable_openai" bson:"enable_openai"`
+	Region           string `json:"region" bson:"region"`           // 节点地区，用于生成分地区的代理组，为空时从备注推断
+	Fingerprint      string `json:"fingerprint" bson:"fingerprint"` // Reality 客户端 uTLS 指纹，为空时为 chrome
+	VerifyCert       bool   `json:"verify_cert" bson:"verify_cert"` // Hysteria2 和 TUIC 是否验证证书，默认不验证以支持自签名证书
+}
+
+// NodeInventoryVersion 节点列表的历史版本，每次修改节点后保存完整的节点列表，用于回滚
+type NodeInventoryVersion struct {
+	Version   int                `json:"version" bson:"version"`
+	Action    string             `json:"action" bson:"action"`     // 修改说明，例如 "create HK-01"
+	Operator  string             `json:"operator" bson:"operator"` // 操作的管理员
+	NodeCount int                `json:"node_count" bson:"node_count"`
+	Nodes     []SubscriptionNode `json:"nodes,omitempty" bson:"nodes"`
+	CreatedAt time.Time          `json:"created_at" bson:"created_at"`
+}
+
+// CollectionName 返回MongoDB集合名称
+func (NodeInventoryVersion) CollectionName() string {
+	return "NODE_INVENTORY_VERSIONS"
+}
+
+// 节点未设置 SNI 时使用的默认值
+const (
+	DefaultRealitySNI    = "itunes.apple.com"
+	DefaultSelfSignedSNI = "bing.com"
+	DefaultFingerprint   = "chrome"
+)
+
+// TLSServerName 返回客户端使用的 SNI：优先使用节点设置的 SNI，
+// 否则 Reality 为 itunes.apple.com，Hysteria2 和 TUIC 为 bing.com，其余类型为节点域名
+func (node SubscriptionNode) TLSServerName() string {
+	if node.SNI != "" {
+		return node.SNI
+	}
+	switch node.Type {
+	case "reality":
+		return DefaultRealitySNI
+	case "hysteria2", "tuic":
+		return DefaultSelfSignedSNI
+	}
+	return node.Domain
+}
+
+// ClientFingerprint 返回 Reality 客户端指纹
+func (node SubscriptionNode) ClientFingerprint() string {
+	if node.Fingerprint != "" {
+		return node.Fingerprint
+	}
+	return DefaultFingerprint
+}
+
+// ShortIDs 返回节点的所有 Reality short id
+func (node SubscriptionNode) ShortIDs() []string {
+	var ids []string
+	for _, id := range strings.Split(node.SHORT_ID, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// ClientShortID 返回订阅中使用的 short id，即第一个
+func (node SubscriptionNode) ClientShortID() string {
+	if ids := node.ShortIDs(); len(ids) > 0 {
+		return ids[0]
+	}
+	return ""
+}
+
+// SkipCertVerify 判断客户端是否跳过证书验证，只有 Hysteria2 和 TUIC 节点可以跳过
+func (node SubscriptionNode) SkipCertVerify() bool {
+	return (node.Type == "hysteria2" || node.Type == "tuic") && !node.VerifyCert
 }
 
 // CollectionName 返回MongoDB集合名称
@@ -63,14 +130,135 @@ func (SubscriptionNode) CollectionName() string {
 	return "subscription_nodes"
 }
 
+// KeepPendingRealityKeys 待启用的 Reality 密钥只由生成和启用密钥接口修改：
+// nodes 中已有的节点按 ID 沿用 current 中的值，新节点清空
+func KeepPendingRealityKeys(current, nodes []SubscriptionNode) {
+	pending := make(map[string]SubscriptionNode, len(current))
+	for _, node := range current {
+		pending[node.ID] = node
+	}
+	for i := range nodes {
+		old := pending[nodes[i].ID]
+		nodes[i].PendingPublicKey, nodes[i].PendingShortID = old.PendingPublicKey, old.PendingShortID
+	}
+}
+
+// DiffSubscriptionNodes 按 ID 比较修改前后的节点列表，返回新增或有变化的节点和需要删除的节点 ID
+func DiffSubscriptionNodes(before, after []SubscriptionNode) ([]SubscriptionNode, []string) {
+	existing := make(map[string]SubscriptionNode, len(before))
+	for _, node := range before {
+		existing[node.ID] = node
+	}
+
+	changed := []SubscriptionNode{}
+	kept := make(map[string]bool, len(after))
+	for _, node := range after {
+		kept[node.ID] = true
+		if old, ok := existing[node.ID]; !ok || old != node {
+			changed = append(changed, node)
+		}
+	}
+
+	removed := []string{}
+	for _, node := range before {
+		if !kept[node.ID] {
+			removed = append(removed, node.ID)
+		}
+	}
+	return changed, removed
+}
+
 type ExpiryCheckDomainInfo struct {
 	Domain       string `json:"domain" bson:"domain"`
 	Remark       string `json:"remark" bson:"remark"`
 	ExpiredDate  string `json:"expired_date" bson:"expired_date"`
 	DaysToExpire int    `json:"days_to_expire" bson:"days_to_expire"`
+	// 以下字段由定时证书检查任务写入
+	Reachable  bool      `json:"reachable" bson:"reachable"`
+	LastError  string    `json:"last_error" bson:"last_error"`
+	AlertState string    `json:"alert_state" bson:"alert_state"` // 已发出的告警：expiring / expired / invalid / unreachable，正常时为空
+	CheckedAt  time.Time `json:"checked_at" bson:"checked_at"`
 }
 
 // CollectionName 返回MongoDB集合名称
 func (ExpiryCheckDomainInfo) CollectionName() string {
 	return "expiry_check_domains"
 }
+
+// NodeUserSet 节点当前正在服务的用户集合，由 singbox 节点定期上报
+type NodeUserSet struct {
+	ID           primitive.ObjectID `json:"_id" bson:"_id"`
+	Domain_As_Id string             `json:"domain_as_id" bson:"domain_as_id"`
+	Users        []string           `json:"users" bson:"users"`
+	UserCount    int                `json:"user_count" bson:"user_count"`
+	Checksum     string             `json:"checksum" bson:"checksum"`
+	SyncedAt     time.Time          `json:"synced_at" bson:"synced_at"`
+	UpdatedAt    time.Time          `json:"updated_at" bson:"updated_at"`
+}
+
+// CollectionName 返回MongoDB集合名称
+func (NodeUserSet) CollectionName() string {
+	return "NODE_USER_SETS"
+}
+
+// NodeHealth 节点当前的健康状态，由控制服务器定期探测节点的 IP:端口 得到
+type NodeHealth struct {
+	NodeID    string    `json:"node_id" bson:"node_id"`
+	Remark    string    `json:"remark" bson:"remark"`
+	Healthy   bool      `json:"healthy" bson:"healthy"`
+	Probe     string    `json:"probe" bson:"probe"`           // 探测方式：tcp / tls / quic
+	LatencyMs int64     `json:"latency_ms" bson:"latency_ms"` // 最近一次成功探测的延迟
+	LastError string    `json:"last_error" bson:"last_error"`
+	Failures  int       `json:"failures" bson:"failures"` // 连续失败次数
+	CheckedAt time.Time `json:"checked_at" bson:"checked_at"`
+	ChangedAt time.Time `json:"changed_at" bson:"changed_at"` // 最近一次健康状态变化的时间
+}
+
+// CollectionName 返回MongoDB集合名称
+func (NodeHealth) CollectionName() string {
+	return "NODE_HEALTH"
+}
+
+// NodeHealthSample 单次探测结果，保留一段时间用于查看可用率和延迟变化
+type NodeHealthSample struct {
+	NodeID    string    `json:"node_id" bson:"node_id"`
+	Remark    string    `json:"remark" bson:"remark"`
+	Probe     string    `json:"probe" bson:"probe"`
+	OK        bool      `json:"ok" bson:"ok"`
+	LatencyMs int64     `json:"latency_ms" bson:"latency_ms"`
+	Error     string    `json:"error,omitempty" bson:"error,omitempty"`
+	CheckedAt time.Time `json:"checked_at" bson:"checked_at"`
+}
+
+// CollectionName 返回MongoDB集合名称
+func (NodeHealthSample) CollectionName() string {
+	return "NODE_HEALTH_SAMPLES"
+}
+
+// NodeInbound 节点上运行的 inbound
+type NodeInbound struct {
+	Tag  string `json:"tag" bson:"tag"`
+	Type string `json:"type" bson:"type"`
+	Port uint16 `json:"port" bson:"port"`
+}
+
+// NodeHeartbeat 运行 sing-box 的节点定期上报的运行状态，按 CURRENT_DOMAIN 区分节点
+type NodeHeartbeat struct {
+	Domain_As_Id   string        `json:"domain_as_id" bson:"domain_as_id"`
+	Hostname       string        `json:"hostname" bson:"hostname"`
+	Version        string        `json:"version" bson:"version"`           // logv2fs 构建版本
+	CoreVersion    string        `json:"core_version" bson:"core_version"` // sing-box 版本
+	StartedAt      time.Time     `json:"started_at" bson:"started_at"`
+	UptimeSeconds  int64         `json:"uptime_seconds" bson:"uptime_seconds"`
+	UserCount      int           `json:"user_count" bson:"user_count"`
+	Inbounds       []NodeInbound `json:"inbounds" bson:"inbounds"`
+	LastFlushAt    *time.Time    `json:"last_flush_at" bson:"last_flush_at"`       // 最近一次流量全部写入数据库的时间，未写入过时为空
+	PendingSamples int           `json:"pending_samples" bson:"pending_samples"`   // 本地缓存中等待重放的流量样本
+	OldestPending  *time.Time    `json:"oldest_pending" bson:"oldest_pending"`     // 本地缓存中最早的样本时间，缓存为空时为空
+	ReportedAt     time.Time     `json:"reported_at" bson:"reported_at,omitempty"` // 写入时使用数据库时间，不受节点时钟影响
+}
+
+// CollectionName 返回MongoDB集合名称
+func (NodeHeartbeat) CollectionName() string {
+	return "NODE_HEARTBEATS"
+}
@@ -0,0 +1,46 @@
+package model
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiffSubscriptionNodes(t *testing.T) {
+	before := []SubscriptionNode{
+		{ID: "a", Remark: "HK-01", Position: 0},
+		{ID: "b", Remark: "HK-02", Position: 1},
+		{ID: "c", Remark: "JP-01", Position: 2},
+	}
+	after := []SubscriptionNode{
+		{ID: "a", Remark: "HK-01", Position: 0},
+		{ID: "c", Remark: "JP-01", Position: 1},
+		{ID: "d", Remark: "US-01", Position: 2},
+	}
+
+	changed, removed := DiffSubscriptionNodes(before, after)
+	if want := []SubscriptionNode{after[1], after[2]}; !reflect.DeepEqual(changed, want) {
+		t.Errorf("changed = %v, want %v", changed, want)
+	}
+	if want := []string{"b"}; !reflect.DeepEqual(removed, want) {
+		t.Errorf("removed = %v, want %v", removed, want)
+	}
+}
+
+func TestKeepPendingRealityKeys(t *testing.T) {
+	current := []SubscriptionNode{
+		{ID: "a", Remark: "HK-01", PendingPublicKey: "new-key", PendingShortID: "0123"},
+		{ID: "b", Remark: "HK-02"},
+	}
+	nodes := []SubscriptionNode{
+		{ID: "c", Remark: "JP-01", PendingPublicKey: "forged"},
+		{ID: "a", Remark: "HK-01"},
+	}
+
+	KeepPendingRealityKeys(current, nodes)
+	if nodes[0].PendingPublicKey != "" {
+		t.Errorf("新节点不应带有待启用的密钥: %q", nodes[0].PendingPublicKey)
+	}
+	if nodes[1].PendingPublicKey != "new-key" || nodes[1].PendingShortID != "0123" {
+		t.Errorf("已有节点应沿用待启用的密钥: %+v", nodes[1])
+	}
+}
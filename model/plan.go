@@ -0,0 +1,158 @@
+package model
+
+import (
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// NodePlan 节点套餐，限定用户在订阅中看到的节点以及可以使用的节点。
+// 三个条件同时满足的节点属于套餐，某个条件为空表示不限制，例如只填 Types 为 ["vlessCDN"] 即只含 CDN 节点。
+type NodePlan struct {
+	Name        string    `json:"name" bson:"name" validate:"required,min=1,max=50"`
+	Description string    `json:"description" bson:"description"`
+	Types       []string  `json:"types" bson:"types"`     // 节点类型：reality、hysteria2、vlessCDN、tuic、trojan、ss2022
+	Regions     []string  `json:"regions" bson:"regions"` // 节点地区，不区分大小写
+	Nodes       []string  `json:"nodes" bson:"nodes"`     // 节点备注
+	CreatedAt   time.Time `json:"created_at" bson:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at" bson:"updated_at"`
+
+	// 用户引用的套餐已不存在时为 true，此时不允许任何节点
+	missing bool
+}
+
+// CollectionName 返回MongoDB集合名称
+func (NodePlan) CollectionName() string {
+	return "NODE_PLANS"
+}
+
+// NodePlanPG PostgreSQL版本的节点套餐
+type NodePlanPG struct {
+	ID          uuid.UUID      `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	Name        string         `json:"name" gorm:"type:varchar(50);uniqueIndex;not null"`
+	Description string         `json:"description" gorm:"type:text"`
+	Types       pq.StringArray `json:"types" gorm:"type:text[]"`
+	Regions     pq.StringArray `json:"regions" gorm:"type:text[]"`
+	Nodes       pq.StringArray `json:"nodes" gorm:"type:text[]"`
+	CreatedAt   time.Time      `json:"created_at"`
+	UpdatedAt   time.Time      `json:"updated_at"`
+}
+
+// 为PostgreSQL表设置表名
+func (NodePlanPG) TableName() string {
+	return "node_plans"
+}
+
+// ToNodePlan 转换为与数据库无关的套餐
+func (p NodePlanPG) ToNodePlan() NodePlan {
+	return NodePlan{
+		Name:        p.Name,
+		Description: p.Description,
+		Types:       p.Types,
+		Regions:     p.Regions,
+		Nodes:       p.Nodes,
+		CreatedAt:   p.CreatedAt,
+		UpdatedAt:   p.UpdatedAt,
+	}
+}
+
+// RegionName 返回节点地区，未设置时取备注中第一个分隔符或数字之前的部分，例如 "HK-01" 为 "HK"
+func (node SubscriptionNode) RegionName() string {
+	if region := strings.TrimSpace(node.Region); region != "" {
+		return region
+	}
+
+	remark := strings.TrimSpace(node.Remark)
+	index := strings.IndexFunc(remark, func(r rune) bool {
+		return r == '-' || r == '_' || r == '|' || unicode.IsSpace(r) || unicode.IsDigit(r)
+	})
+	if index > 0 {
+		return remark[:index]
+	}
+	return remark
+}
+
+// Allows 判断节点是否属于套餐，p 为 nil 表示用户没有套餐，可以使用所有节点
+func (p *NodePlan) Allows(node SubscriptionNode) bool {
+	if p == nil {
+		return true
+	}
+	if p.missing {
+		return false
+	}
+	if len(p.Types) > 0 && !containsFold(p.Types, node.Type) {
+		return false
+	}
+	if len(p.Regions) > 0 && !containsFold(p.Regions, node.RegionName()) {
+		return false
+	}
+	if len(p.Nodes) > 0 && !containsFold(p.Nodes, node.Remark) {
+		return false
+	}
+	return true
+}
+
+// AllowsDomain 判断用户能否使用该域名上的节点：同一域名下任一节点属于套餐即可
+func (p *NodePlan) AllowsDomain(domain string, nodes []SubscriptionNode) bool {
+	return p == nil || len(p.DomainTypes(domain, nodes)) > 0
+}
+
+// DomainTypes 返回该域名上属于套餐的节点类型，按名称排序。
+// 节点按类型分别开放给用户，例如套餐只含 hysteria2 时，同一台机器上的 reality 不应加载该用户。
+// p 为 nil 表示不限制，返回 nil
+func (p *NodePlan) DomainTypes(domain string, nodes []SubscriptionNode) []string {
+	if p == nil {
+		return nil
+	}
+	types := []string{}
+	for _, node := range nodes {
+		if node.Domain == domain && p.Allows(node) && !containsFold(types, node.Type) {
+			types = append(types, node.Type)
+		}
+	}
+	sort.Strings(types)
+	return types
+}
+
+// FilterNodes 返回套餐中的节点
+func (p *NodePlan) FilterNodes(nodes []SubscriptionNode) []SubscriptionNode {
+	if p == nil {
+		return nodes
+	}
+	result := make([]SubscriptionNode, 0, len(nodes))
+	for _, node := range nodes {
+		if p.Allows(node) {
+			result = append(result, node)
+		}
+	}
+	return result
+}
+
+// NodePlans 按名称索引的套餐
+type NodePlans map[string]NodePlan
+
+// Lookup 返回用户的套餐。name 为空表示不限制节点，返回 nil；
+// 套餐已被删除时返回不允许任何节点的套餐，避免用户因此看到全部节点。
+func (plans NodePlans) Lookup(name string) *NodePlan {
+	if name == "" {
+		return nil
+	}
+	if plan, ok := plans[name]; ok {
+		return &plan
+	}
+	return &NodePlan{Name: name, missing: true}
+}
+
+// containsFold 不区分大小写判断 values 中是否包含 value
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(strings.TrimSpace(v), value) {
+			return true
+		}
+	}
+	return false
+}
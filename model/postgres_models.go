@@ -11,27 +11,37 @@ import (
 
 // PostgreSQL版本的用户流量日志模型 - 混合设计
 type UserTrafficLogsPG struct {
-	ID           uuid.UUID `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
-	EmailAsId    string    `json:"email_as_id" gorm:"uniqueIndex;not null"`
-	Password     string    `json:"password" gorm:"not null"`
-	UUID         string    `json:"uuid" gorm:"index"`
-	Role         string    `json:"role" gorm:"type:varchar(20);check:role IN ('admin','normal');not null"`
-	Status       string    `json:"status" gorm:"type:varchar(20);check:status IN ('plain','deleted','overdue');not null"`
-	Name         string    `json:"name"`
-	Remark       string    `json:"remark" gorm:"type:text"` // 用户备注
-	Token        *string   `json:"token"`
-	RefreshToken *string   `json:"refresh_token"`
-	UserID       string    `json:"user_id" gorm:"index"`
-	Used         int64     `json:"used" gorm:"default:0"`
-	Credit       int64     `json:"credit" gorm:"default:0"`
-	CreatedAt    time.Time `json:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at"`
+	ID            uuid.UUID `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	EmailAsId     string    `json:"email_as_id" gorm:"uniqueIndex;not null"`
+	Password      string    `json:"password" gorm:"not null"`
+	UUID          string    `json:"uuid" gorm:"index"`
+	Role          string    `json:"role" gorm:"type:varchar(20);check:role IN ('admin','normal');not null"`
+	Status        string    `json:"status" gorm:"type:varchar(20);check:status IN ('plain','deleted','overdue');not null"`
+	Name          string    `json:"name"`
+	Remark        string    `json:"remark" gorm:"type:text"` // 用户备注
+	Token         *string   `json:"token"`
+	RefreshToken  *string   `json:"refresh_token"`
+	UserID        string    `json:"user_id" gorm:"index"`
+	Used          int64     `json:"used" gorm:"default:0"`
+	Credit        int64     `json:"credit" gorm:"default:0"`
+	QuotaWarned   int       `json:"quota_warned" gorm:"default:0"`                     // 已发送的流量预警百分比：0、80、95
+	OverdueReason string    `json:"overdue_reason" gorm:"type:varchar(20);default:''"` // 状态为 overdue 的原因：quota、payment
+	Plan          string    `json:"plan" gorm:"type:varchar(50);default:'';index"`     // 节点套餐名称，为空时可以使用所有节点
+	// 订阅链接使用的随机令牌，重置后旧链接失效
+	SubscriptionToken *string   `json:"subscription_token" gorm:"uniqueIndex"`
+	CreatedAt         time.Time `json:"created_at"`
+	UpdatedAt         time.Time `json:"updated_at"`
 
 	// 时间序列数据使用JSONB存储 - 这是混合设计的核心
 	HourlyLogs  datatypes.JSON `json:"hourly_logs" gorm:"type:jsonb"`
 	DailyLogs   datatypes.JSON `json:"daily_logs" gorm:"type:jsonb"`
 	MonthlyLogs datatypes.JSON `json:"monthly_logs" gorm:"type:jsonb"`
 	YearlyLogs  datatypes.JSON `json:"yearly_logs" gorm:"type:jsonb"`
+
+	// 绑定的 Telegram chat，以及绑定时使用的一次性绑定码
+	TelegramChatID        *string    `json:"telegram_chat_id" gorm:"uniqueIndex"`
+	TelegramLinkCode      *string    `json:"-" gorm:"uniqueIndex"`
+	TelegramLinkExpiresAt *time.Time `json:"-"`
 }
 
 // 为PostgreSQL表设置表名
@@ -60,25 +70,37 @@ func (NodeTrafficLogsPG) TableName() string {
 	return "node_traffic_logs"
 }
 
-// 时间序列数据的结构定义 - 用于JSONB字段
+// TrafficBreakdown 按方向和协议拆分的流量，Traffic 为两个方向之和。
+// Protocols 的键为用户所在 inbound 的协议，例如 reality、hysteria2。
+type TrafficBreakdown struct {
+	Uplink    int64            `json:"uplink" bson:"uplink"`
+	Downlink  int64            `json:"downlink" bson:"downlink"`
+	Protocols map[string]int64 `json:"protocols,omitempty" bson:"protocols,omitempty"`
+}
+
+// 时间序列数据的结构定义 - 用于JSONB字段，MongoDB 版本共用
 type TrafficLogEntry struct {
-	Timestamp time.Time `json:"timestamp"`
-	Traffic   int64     `json:"traffic"`
+	Timestamp        time.Time `json:"timestamp" bson:"timestamp"`
+	Traffic          int64     `json:"traffic" bson:"traffic"`
+	TrafficBreakdown `bson:",inline"`
 }
 
 type DailyLogEntry struct {
-	Date    string `json:"date"`
-	Traffic int64  `json:"traffic"`
+	Date             string `json:"date" bson:"date"`
+	Traffic          int64  `json:"traffic" bson:"traffic"`
+	TrafficBreakdown `bson:",inline"`
 }
 
 type MonthlyLogEntry struct {
-	Month   string `json:"month"`
-	Traffic int64  `json:"traffic"`
+	Month            string `json:"month" bson:"month"`
+	Traffic          int64  `json:"traffic" bson:"traffic"`
+	TrafficBreakdown `bson:",inline"`
 }
 
 type YearlyLogEntry struct {
-	Year    string `json:"year"`
-	Traffic int64  `json:"traffic"`
+	Year             string `json:"year" bson:"year"`
+	Traffic          int64  `json:"traffic" bson:"traffic"`
+	TrafficBreakdown `bson:",inline"`
 }
 
 // JSONB字段的辅助类型
@@ -199,6 +221,10 @@ type ExpiryCheckDomainInfoPG struct {
 	Remark       string    `json:"remark"`
 	ExpiredDate  string    `json:"expired_date"`
 	DaysToExpire int       `json:"days_to_expire"`
+	Reachable    bool      `json:"reachable"`
+	LastError    string    `json:"last_error" gorm:"type:text"`
+	AlertState   string    `json:"alert_state" gorm:"type:varchar(20)"`
+	CheckedAt    time.Time `json:"checked_at"`
 	CreatedAt    time.Time `json:"created_at"`
 	UpdatedAt    time.Time `json:"updated_at"`
 }
@@ -210,24 +236,151 @@ func (ExpiryCheckDomainInfoPG) TableName() string {
 
 // PostgreSQL版本的订阅节点模型
 type SubscriptionNodePG struct {
-	ID           uuid.UUID `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
-	Type         string    `json:"type" gorm:"type:varchar(50);check:type IN ('reality','hysteria2','vlessCDN');not null"`
-	Remark       string    `json:"remark" gorm:"uniqueIndex;not null"`
-	Domain       string    `json:"domain" gorm:"not null;index"`
-	IP           string    `json:"ip" gorm:"type:text"`
-	SNI          string    `json:"sni"`
-	UUID         string    `json:"uuid" gorm:"index"`
-	Path         string    `json:"path"`
-	ServerPort   string    `json:"server_port"`
-	Password     string    `json:"password"`
-	PublicKey    string    `json:"public_key"`
-	ShortID      string    `json:"short_id"`
-	EnableOpenai bool      `json:"enable_openai" gorm:"default:false"`
-	CreatedAt    time.Time `json:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at"`
+	ID         uuid.UUID `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	Type       string    `json:"type" gorm:"type:varchar(50);check:type IN ('reality','hysteria2','vlessCDN','tuic','trojan','ss2022');not null"`
+	Remark     string    `json:"remark" gorm:"uniqueIndex;not null"`
+	Domain     string    `json:"domain" gorm:"not null;index"`
+	IP         string    `json:"ip" gorm:"type:text"`
+	SNI        string    `json:"sni"`
+	UUID       string    `json:"uuid" gorm:"index"`
+	Path       string    `json:"path"`
+	ServerPort string    `json:"server_port"`
+	Password   string    `json:"password"`
+	PublicKey  string    `json:"public_key"`
+	ShortID    string    `json:"short_id"`
+	// 新生成、尚未启用的 Reality 公钥和 short id
+	PendingPublicKey string    `json:"pending_public_key" gorm:"default:''"`
+	PendingShortID   string    `json:"pending_short_id" gorm:"default:''"`
+	EnableOpenai     bool      `json:"enable_openai" gorm:"default:false"`
+	Region           string    `json:"region" gorm:"type:varchar(50);default:''"` // 节点地区，为空时从备注推断
+	Position         int       `json:"position" gorm:"default:0;index"`           // 节点在订阅中的顺序
+	Fingerprint      string    `json:"fingerprint" gorm:"type:varchar(50);default:''"`
+	VerifyCert       bool      `json:"verify_cert" gorm:"default:false"`
+	CreatedAt        time.Time `json:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at"`
 }
 
 // 为PostgreSQL表设置表名
 func (SubscriptionNodePG) TableName() string {
 	return "subscription_nodes"
 }
+
+// PostgreSQL版本的节点列表历史版本，nodes 为完整的节点列表
+type NodeInventoryVersionPG struct {
+	ID        uuid.UUID      `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	Version   int            `json:"version" gorm:"uniqueIndex;not null"`
+	Action    string         `json:"action" gorm:"type:text"`
+	Operator  string         `json:"operator" gorm:"type:varchar(100)"`
+	NodeCount int            `json:"node_count"`
+	Nodes     datatypes.JSON `json:"nodes" gorm:"type:jsonb"`
+	CreatedAt time.Time      `json:"created_at"`
+}
+
+// 为PostgreSQL表设置表名
+func (NodeInventoryVersionPG) TableName() string {
+	return "node_inventory_versions"
+}
+
+// PostgreSQL版本的节点用户集合模型 - 记录每个节点当前加载的用户
+type NodeUserSetPG struct {
+	ID         uuid.UUID      `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	DomainAsId string         `json:"domain_as_id" gorm:"uniqueIndex;not null"`
+	Users      datatypes.JSON `json:"users" gorm:"type:jsonb"`
+	UserCount  int            `json:"user_count"`
+	Checksum   string         `json:"checksum"`
+	SyncedAt   time.Time      `json:"synced_at"`
+	CreatedAt  time.Time      `json:"created_at"`
+	UpdatedAt  time.Time      `json:"updated_at"`
+}
+
+// 为PostgreSQL表设置表名
+func (NodeUserSetPG) TableName() string {
+	return "node_user_sets"
+}
+
+// PostgreSQL版本的节点健康状态模型 - 每个节点一条记录
+type NodeHealthPG struct {
+	ID        uuid.UUID `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	NodeID    string    `json:"node_id" gorm:"uniqueIndex;not null"`
+	Remark    string    `json:"remark"`
+	Healthy   bool      `json:"healthy" gorm:"default:true;index"`
+	Probe     string    `json:"probe" gorm:"type:varchar(10)"`
+	LatencyMs int64     `json:"latency_ms"`
+	LastError string    `json:"last_error" gorm:"type:text"`
+	Failures  int       `json:"failures"`
+	CheckedAt time.Time `json:"checked_at"`
+	ChangedAt time.Time `json:"changed_at"`
+}
+
+// 为PostgreSQL表设置表名
+func (NodeHealthPG) TableName() string {
+	return "node_health"
+}
+
+// PostgreSQL版本的节点探测记录模型
+type NodeHealthSamplePG struct {
+	ID        uint      `json:"-" gorm:"primaryKey"`
+	NodeID    string    `json:"node_id" gorm:"index:idx_node_health_samples_node_time;not null"`
+	Remark    string    `json:"remark"`
+	Probe     string    `json:"probe" gorm:"type:varchar(10)"`
+	OK        bool      `json:"ok"`
+	LatencyMs int64     `json:"latency_ms"`
+	Error     string    `json:"error,omitempty" gorm:"type:text"`
+	CheckedAt time.Time `json:"checked_at" gorm:"index:idx_node_health_samples_node_time;index"`
+}
+
+// 为PostgreSQL表设置表名
+func (NodeHealthSamplePG) TableName() string {
+	return "node_health_samples"
+}
+
+// PostgreSQL版本的节点心跳模型 - 每个运行 sing-box 的节点一条记录
+type NodeHeartbeatPG struct {
+	ID             uuid.UUID      `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	DomainAsId     string         `json:"domain_as_id" gorm:"uniqueIndex;not null"`
+	Hostname       string         `json:"hostname"`
+	Version        string         `json:"version"`
+	CoreVersion    string         `json:"core_version"`
+	StartedAt      time.Time      `json:"started_at"`
+	UptimeSeconds  int64          `json:"uptime_seconds"`
+	UserCount      int            `json:"user_count"`
+	Inbounds       datatypes.JSON `json:"inbounds" gorm:"type:jsonb"`
+	LastFlushAt    *time.Time     `json:"last_flush_at"`
+	PendingSamples int            `json:"pending_samples"`
+	OldestPending  *time.Time     `json:"oldest_pending"`
+	ReportedAt     time.Time      `json:"reported_at" gorm:"index"`
+}
+
+// 为PostgreSQL表设置表名
+func (NodeHeartbeatPG) TableName() string {
+	return "node_heartbeats"
+}
+
+// TrafficSampleAckPG PostgreSQL版本的已写入流量样本，由 upsert 存储函数在同一事务中写入
+type TrafficSampleAckPG struct {
+	SampleID  string    `json:"sample_id" gorm:"primaryKey"`
+	AppliedAt time.Time `json:"applied_at" gorm:"not null;default:now();index"`
+}
+
+// 为PostgreSQL表设置表名
+func (TrafficSampleAckPG) TableName() string {
+	return "traffic_sample_acks"
+}
+
+// TrafficSamplePG 按小时汇总的流量时间序列，主键为 (用户, 节点, 协议, 时间段起点)。
+// 从 JSONB 日志回填的历史数据没有节点或用户维度，对应字段为空字符串。
+// Protocol 不为空的记录只有该协议的流量，上下行记在 Protocol 为空的记录上。
+type TrafficSamplePG struct {
+	UserEmail   string    `json:"user_email" gorm:"primaryKey;type:varchar(255);default:''"`
+	Node        string    `json:"node" gorm:"primaryKey;type:varchar(255);default:'';index:idx_traffic_samples_node_bucket,priority:1"`
+	Protocol    string    `json:"protocol" gorm:"primaryKey;type:varchar(32);default:''"`
+	BucketStart time.Time `json:"bucket_start" gorm:"primaryKey;type:timestamp;index:idx_traffic_samples_node_bucket,priority:2"`
+	Traffic     int64     `json:"traffic" gorm:"not null;default:0"`
+	Uplink      int64     `json:"uplink" gorm:"not null;default:0"`
+	Downlink    int64     `json:"downlink" gorm:"not null;default:0"`
+}
+
+// 为PostgreSQL表设置表名
+func (TrafficSamplePG) TableName() string {
+	return "traffic_samples"
+}
@@ -1,75 +1,5 @@
 package model
 
-type RealityJSON struct {
-	Tag            string `json:"tag"`
-	Type           string `json:"type"`
-	UUID           string `json:"uuid"`
-	ServerPort     int    `json:"server_port"`
-	Flow           string `json:"flow"`
-	PacketEncoding string `json:"packet_encoding"`
-	Server         string `json:"server"`
-	TLS            struct {
-		Enabled    bool   `json:"enabled"`
-		ServerName string `json:"server_name"`
-		Utls       struct {
-			Enabled     bool   `json:"enabled"`
-			Fingerprint string `json:"fingerprint"`
-		} `json:"utls"`
-		Reality struct {
-			Enabled   bool   `json:"enabled"`
-			PublicKey string `json:"public_key"`
-			ShortID   string `json:"short_id"`
-		} `json:"reality"`
-	} `json:"tls"`
-}
-
-type Hysteria2JSON struct {
-	Tag        string `json:"tag"`
-	Type       string `json:"type"`
-	Server     string `json:"server"`
-	ServerPort int    `json:"server_port"`
-	UpMbps     int    `json:"up_mbps"`
-	DownMbps   int    `json:"down_mbps"`
-	Password   string `json:"password"`
-	TLS        struct {
-		Enabled    bool     `json:"enabled"`
-		ServerName string   `json:"server_name"`
-		Insecure   bool     `json:"insecure"`
-		Alpn       []string `json:"alpn"`
-	} `json:"tls"`
-}
-
-type CFVlessJSON struct {
-	Tag        string `json:"tag"`
-	Type       string `json:"type"`
-	Server     string `json:"server"`
-	ServerPort int    `json:"server_port"`
-	UUID       string `json:"uuid"`
-	Flow       string `json:"flow"`
-	TLS        struct {
-		Enabled    bool   `json:"enabled"`
-		ServerName string `json:"server_name"`
-		Insecure   bool   `json:"insecure"`
-		Utls       struct {
-			Enabled     bool   `json:"enabled"`
-			Fingerprint string `json:"fingerprint"`
-		} `json:"utls"`
-	} `json:"tls"`
-	Multiplex struct {
-		Enabled    bool   `json:"enabled"`
-		Protocol   string `json:"protocol"`
-		MaxStreams int    `json:"max_streams"`
-	} `json:"multiplex"`
-	PacketEncoding string `json:"packet_encoding"`
-	Transport      struct {
-		Type    string `json:"type"`
-		Path    string `json:"path"`
-		Headers struct {
-			Host string `json:"Host"`
-		} `json:"headers"`
-	} `json:"transport"`
-}
-
 type SingboxJSON struct {
 	Log struct {
 		Disabled  bool   `json:"disabled"`
@@ -131,6 +61,41 @@ type CFVlessYAML struct {
 	} `yaml:"ws-opts"`
 }
 
+type TUICYAML struct {
+	Name                 string   `yaml:"name"`
+	Type                 string   `yaml:"type"`
+	Server               string   `yaml:"server"`
+	Port                 int      `yaml:"port"`
+	UUID                 string   `yaml:"uuid"`
+	Password             string   `yaml:"password"`
+	Sni                  string   `yaml:"sni"`
+	SkipCertVerify       bool     `yaml:"skip-cert-verify"`
+	Alpn                 []string `yaml:"alpn"`
+	CongestionController string   `yaml:"congestion-controller"`
+	UDPRelayMode         string   `yaml:"udp-relay-mode"`
+}
+
+type TrojanYAML struct {
+	Name           string `yaml:"name"`
+	Type           string `yaml:"type"`
+	Server         string `yaml:"server"`
+	Port           int    `yaml:"port"`
+	Password       string `yaml:"password"`
+	Sni            string `yaml:"sni"`
+	SkipCertVerify bool   `yaml:"skip-cert-verify"`
+	UDP            bool   `yaml:"udp"`
+}
+
+type ShadowsocksYAML struct {
+	Name     string `yaml:"name"`
+	Type     string `yaml:"type"`
+	Server   string `yaml:"server"`
+	Port     int    `yaml:"port"`
+	Cipher   string `yaml:"cipher"`
+	Password string `yaml:"password"`
+	UDP      bool   `yaml:"udp"`
+}
+
 type SingboxYAML struct {
 	Port                    int           `yaml:"port"`
 	AllowLan                bool          `yaml:"allow-lan"`
@@ -152,6 +117,60 @@ type SingboxYAML struct {
 	Rules []string `yaml:"rules"`
 }
 
+// ClashMetaYAML Clash Meta (mihomo) 订阅配置，proxies、proxy-groups 和 rule-providers 由服务端生成
+type ClashMetaYAML struct {
+	MixedPort               int                          `yaml:"mixed-port"`
+	AllowLan                bool                         `yaml:"allow-lan"`
+	Mode                    string                       `yaml:"mode"`
+	LogLevel                string                       `yaml:"log-level"`
+	UnifiedDelay            bool                         `yaml:"unified-delay"`
+	TCPConcurrent           bool                         `yaml:"tcp-concurrent"`
+	FindProcessMode         string                       `yaml:"find-process-mode,omitempty"`
+	GlobalClientFingerprint string                       `yaml:"global-client-fingerprint"`
+	Ipv6                    bool                         `yaml:"ipv6"`
+	ExternalController      string                       `yaml:"external-controller,omitempty"`
+	Profile                 interface{}                  `yaml:"profile,omitempty"`
+	Sniffer                 interface{}                  `yaml:"sniffer,omitempty"`
+	DNS                     interface{}                  `yaml:"dns"`
+	Proxies                 []interface{}                `yaml:"proxies"`
+	ProxyGroups             []ClashProxyGroup            `yaml:"proxy-groups"`
+	RuleProviders           map[string]ClashRuleProvider `yaml:"rule-providers,omitempty"`
+	Rules                   []string                     `yaml:"rules"`
+}
+
+// ClashProxyGroup Clash 代理组，select、url-test、fallback、load-balance 共用
+type ClashProxyGroup struct {
+	Name      string   `yaml:"name"`
+	Type      string   `yaml:"type"`
+	Proxies   []string `yaml:"proxies"`
+	URL       string   `yaml:"url,omitempty"`
+	Interval  int      `yaml:"interval,omitempty"`
+	Tolerance int      `yaml:"tolerance,omitempty"`
+	Lazy      bool     `yaml:"lazy,omitempty"`
+	Strategy  string   `yaml:"strategy,omitempty"`
+}
+
+// ClashRuleProvider Clash Meta 配置中的 rule-providers 条目
+type ClashRuleProvider struct {
+	Type     string `yaml:"type"`
+	Behavior string `yaml:"behavior"`
+	Format   string `yaml:"format,omitempty"`
+	URL      string `yaml:"url"`
+	Path     string `yaml:"path"`
+	Interval int    `yaml:"interval"`
+}
+
+// ClashRuleProviderSetting 规则集配置文件中的一项，Policy 为命中后使用的策略
+type ClashRuleProviderSetting struct {
+	Name      string `yaml:"name"`
+	Behavior  string `yaml:"behavior"`
+	Format    string `yaml:"format"`
+	URL       string `yaml:"url"`
+	Interval  int    `yaml:"interval"`
+	Policy    string `yaml:"policy"`
+	NoResolve bool   `yaml:"no-resolve"`
+}
+
 type Vmess struct {
 	Name           string `yaml:"name"`
 	Server         string `yaml:"server"`
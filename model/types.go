@@ -20,26 +20,30 @@ type UserTrafficLogs struct {
 	User_id       string             `json:"user_id" bson:"user_id"`
 	Used          int64              `json:"used" bson:"used"`
 	Credit        int64              `json:"credit" bson:"credit"`
-	CreatedAt     time.Time          `json:"created_at" bson:"created_at"`
-	UpdatedAt     time.Time          `json:"updated_at" bson:"updated_at"`
-	HourlyLogs    []struct {
-		Timestamp time.Time `json:"timestamp" bson:"timestamp"`
-		Traffic   int64     `json:"traffic" bson:"traffic"`
-	} `json:"hourly_logs" bson:"hourly_logs"`
-	DailyLogs []struct {
-		Date    string `json:"date" bson:"date"`
-		Traffic int64  `json:"traffic" bson:"traffic"`
-	} `json:"daily_logs" bson:"daily_logs"`
-	MonthlyLogs []struct {
-		Month   string `json:"month" bson:"month"`
-		Traffic int64  `json:"traffic" bson:"traffic"`
-	} `json:"monthly_logs" bson:"monthly_logs"`
-	YearlyLogs []struct {
-		Year    string `json:"year" bson:"year"`
-		Traffic int64  `json:"traffic" bson:"traffic"`
-	} `json:"yearly_logs" bson:"yearly_logs"`
+	QuotaWarned   int                `json:"quota_warned" bson:"quota_warned"`     // 已发送的流量预警百分比：0、80、95
+	OverdueReason string             `json:"overdue_reason" bson:"overdue_reason"` // 状态为 overdue 的原因：quota、payment
+	Plan          string             `json:"plan" bson:"plan"`                     // 节点套餐名称，为空时可以使用所有节点
+	// 订阅链接使用的随机令牌，重置后旧链接失效
+	SubscriptionToken string            `json:"subscription_token" bson:"subscription_token,omitempty"`
+	CreatedAt         time.Time         `json:"created_at" bson:"created_at"`
+	UpdatedAt         time.Time         `json:"updated_at" bson:"updated_at"`
+	HourlyLogs        []TrafficLogEntry `json:"hourly_logs" bson:"hourly_logs"`
+	DailyLogs         []DailyLogEntry   `json:"daily_logs" bson:"daily_logs"`
+	MonthlyLogs       []MonthlyLogEntry `json:"monthly_logs" bson:"monthly_logs"`
+	YearlyLogs        []YearlyLogEntry  `json:"yearly_logs" bson:"yearly_logs"`
+
+	// 绑定的 Telegram chat，以及绑定时使用的一次性绑定码
+	TelegramChatID        string    `json:"telegram_chat_id" bson:"telegram_chat_id,omitempty"`
+	TelegramLinkCode      string    `json:"-" bson:"telegram_link_code,omitempty"`
+	TelegramLinkExpiresAt time.Time `json:"-" bson:"telegram_link_expires_at,omitempty"`
 }
 
+// 用户被设为 overdue 的原因，只有对应的条件解除后才会自动恢复
+const (
+	OverdueReasonQuota   = "quota"   // 流量超额
+	OverdueReasonPayment = "payment" // 缴费到期
+)
+
 // CollectionName 返回MongoDB集合名称
 func (UserTrafficLogs) CollectionName() string {
 	return "USER_TRAFFIC_LOGS"
@@ -66,8 +70,9 @@ type TrafficAtPeriod struct {
 }
 
 type Traffic struct {
-	Name  string `json:"name" bson:"name"`
-	Total int64  `json:"total" bson:"total"`
+	Name             string `json:"name" bson:"name"`
+	Total            int64  `json:"total" bson:"total"`
+	TrafficBreakdown `bson:",inline"`
 }
 
 type Node struct {
@@ -87,3 +92,30 @@ type Node struct {
 	Alpn        string `default:"h2" json:"alpn"`
 	FingerPrint string `default:"chrome" json:"fp"`
 }
+
+// TrafficSampleAck 已写入数据库的流量样本，用于重放本地缓存时去重
+type TrafficSampleAck struct {
+	SampleID  string    `json:"sample_id" bson:"_id"`
+	AppliedAt time.Time `json:"applied_at" bson:"applied_at"`
+}
+
+// CollectionName 返回MongoDB集合名称
+func (TrafficSampleAck) CollectionName() string {
+	return "TRAFFIC_SAMPLE_ACKS"
+}
+
+// TrafficSample MongoDB版本的按小时流量时间序列，(user_email, node, protocol, bucket_start) 唯一
+type TrafficSample struct {
+	UserEmail   string    `json:"user_email" bson:"user_email"`
+	Node        string    `json:"node" bson:"node"`
+	Protocol    string    `json:"protocol" bson:"protocol"`
+	BucketStart time.Time `json:"bucket_start" bson:"bucket_start"`
+	Traffic     int64     `json:"traffic" bson:"traffic"`
+	Uplink      int64     `json:"uplink" bson:"uplink"`
+	Downlink    int64     `json:"downlink" bson:"downlink"`
+}
+
+// CollectionName 返回MongoDB集合名称
+func (TrafficSample) CollectionName() string {
+	return "TRAFFIC_SAMPLES"
+}
@@ -0,0 +1,270 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 事件类型
+const (
+	EventCertExpiring    = "cert_expiring"
+	EventCertUnreachable = "cert_unreachable"
+	EventCertInvalid     = "cert_invalid"
+	EventNodeUnreachable = "node_unreachable"
+	EventQuotaWarning    = "quota_warning"
+	EventPaymentCreated  = "payment_created"
+)
+
+// 接收方角色
+const (
+	RoleAdmin = "admin"
+	RoleUser  = "user"
+)
+
+// Event 需要通知的事件，User 为受影响用户的 email_as_id，与具体用户无关时为空
+type Event struct {
+	Kind    string
+	Subject string
+	Message string
+	User    string
+	Time    time.Time
+}
+
+// Recipient 一次通知的接收方，各 Provider 只使用自己能送达的地址
+type Recipient struct {
+	Role            string
+	User            string
+	Emails          []string
+	TelegramChatIDs []string
+}
+
+// Provider 通知渠道
+type Provider interface {
+	Name() string
+	Send(ctx context.Context, to Recipient, event Event) error
+}
+
+// UserResolver 根据 email_as_id 查找用户的联系方式
+type UserResolver func(user string) (Recipient, error)
+
+// DefaultRules 默认路由规则：事件类型 -> 接收方角色
+var DefaultRules = map[string][]string{
+	EventCertExpiring:    {RoleAdmin},
+	EventCertUnreachable: {RoleAdmin},
+	EventCertInvalid:     {RoleAdmin},
+	EventNodeUnreachable: {RoleAdmin},
+	EventQuotaWarning:    {RoleUser, RoleAdmin},
+	EventPaymentCreated:  {RoleUser, RoleAdmin},
+}
+
+// Dispatcher 按路由规则把事件发送到所有 Provider
+type Dispatcher struct {
+	Providers []Provider
+	Rules     map[string][]string
+	Admin     Recipient
+	Resolver  UserResolver
+	Timeout   time.Duration
+}
+
+var (
+	defaultDispatcher *Dispatcher
+	defaultOnce       sync.Once
+	resolverMu        sync.RWMutex
+	userResolver      UserResolver = emailResolver
+)
+
+// Default 返回根据环境变量配置的全局 Dispatcher
+func Default() *Dispatcher {
+	defaultOnce.Do(func() {
+		defaultDispatcher = FromEnv()
+	})
+	return defaultDispatcher
+}
+
+// Send 通过全局 Dispatcher 发送事件，失败只记录日志
+func Send(event Event) {
+	if err := Default().Notify(event); err != nil {
+		log.Printf("发送通知失败 (%s): %v", event.Subject, err)
+	}
+}
+
+// SetUserResolver 替换全局的用户联系方式查找函数，传入 nil 时恢复默认
+func SetUserResolver(resolver UserResolver) {
+	if resolver == nil {
+		resolver = emailResolver
+	}
+	resolverMu.Lock()
+	userResolver = resolver
+	resolverMu.Unlock()
+}
+
+// emailResolver 默认只把形如邮箱的 email_as_id 当作用户邮箱
+func emailResolver(user string) (Recipient, error) {
+	to := Recipient{Role: RoleUser, User: user}
+	if strings.Contains(user, "@") {
+		to.Emails = []string{user}
+	}
+	return to, nil
+}
+
+// FromEnv 根据环境变量创建 Dispatcher，未配置的渠道不启用
+func FromEnv() *Dispatcher {
+	d := &Dispatcher{
+		Rules: DefaultRules,
+		Admin: Recipient{
+			Role:            RoleAdmin,
+			Emails:          splitList(os.Getenv("NOTIFY_ADMIN_EMAILS")),
+			TelegramChatIDs: splitList(os.Getenv("NOTIFY_ADMIN_TELEGRAM_CHATS")),
+		},
+		Timeout: 15 * time.Second,
+	}
+
+	if rules := os.Getenv("NOTIFY_RULES"); rules != "" {
+		parsed, err := ParseRules(rules)
+		if err != nil {
+			log.Printf("NOTIFY_RULES 配置无效，使用默认规则: %v", err)
+		} else {
+			d.Rules = parsed
+		}
+	}
+
+	if url := os.Getenv("NOTIFY_WEBHOOK_URL"); url != "" {
+		d.Providers = append(d.Providers, &Webhook{URL: url, Secret: os.Getenv("NOTIFY_WEBHOOK_SECRET")})
+	}
+	if host := os.Getenv("SMTP_HOST"); host != "" {
+		d.Providers = append(d.Providers, &SMTP{
+			Host:     host,
+			Port:     envOr("SMTP_PORT", "587"),
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     os.Getenv("SMTP_FROM"),
+		})
+	}
+	if token := os.Getenv("TELEGRAM_BOT_TOKEN"); token != "" {
+		d.Providers = append(d.Providers, &Telegram{Token: token, APIBase: os.Getenv("TELEGRAM_API_BASE")})
+	}
+
+	return d
+}
+
+// ParseRules 解析 "quota_warning=user,admin;payment_created=admin" 形式的路由规则。
+// 未出现的事件类型沿用默认规则，角色为空表示不发送。
+func ParseRules(spec string) (map[string][]string, error) {
+	rules := make(map[string][]string, len(DefaultRules))
+	for kind, roles := range DefaultRules {
+		rules[kind] = roles
+	}
+
+	for _, entry := range strings.Split(spec, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		kind, roleList, ok := strings.Cut(entry, "=")
+		kind = strings.TrimSpace(kind)
+		if !ok || kind == "" {
+			return nil, fmt.Errorf("规则格式错误: %q", entry)
+		}
+
+		roles := splitList(roleList)
+		for _, role := range roles {
+			if role != RoleAdmin && role != RoleUser {
+				return nil, fmt.Errorf("未知的接收方 %q", role)
+			}
+		}
+		rules[kind] = roles
+	}
+
+	return rules, nil
+}
+
+// Recipients 按路由规则返回事件的接收方
+func (d *Dispatcher) Recipients(event Event) ([]Recipient, error) {
+	var recipients []Recipient
+	for _, role := range d.Rules[event.Kind] {
+		switch role {
+		case RoleAdmin:
+			recipients = append(recipients, d.Admin)
+		case RoleUser:
+			if event.User == "" {
+				continue
+			}
+			to, err := d.resolve(event.User)
+			if err != nil {
+				return recipients, fmt.Errorf("查找用户 %s 联系方式失败: %w", event.User, err)
+			}
+			recipients = append(recipients, to)
+		}
+	}
+	return recipients, nil
+}
+
+func (d *Dispatcher) resolve(user string) (Recipient, error) {
+	if d.Resolver != nil {
+		return d.Resolver(user)
+	}
+	resolverMu.RLock()
+	resolver := userResolver
+	resolverMu.RUnlock()
+	return resolver(user)
+}
+
+// Notify 记录事件日志并发送到所有接收方，返回所有渠道的错误
+func (d *Dispatcher) Notify(event Event) error {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	log.Printf("⚠️ [%s] %s: %s", event.Kind, event.Subject, event.Message)
+
+	if len(d.Providers) == 0 {
+		return nil
+	}
+
+	recipients, err := d.Recipients(event)
+	errs := []error{err}
+
+	timeout := d.Timeout
+	if timeout <= 0 {
+		timeout = 15 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	for _, to := range recipients {
+		for _, provider := range d.Providers {
+			if err := provider.Send(ctx, to, event); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", provider.Name(), err))
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// formatText 纯文本格式的通知内容
+func formatText(event Event) string {
+	return event.Subject + "\n\n" + event.Message
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func envOr(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
@@ -0,0 +1,151 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseRules(t *testing.T) {
+	rules, err := ParseRules(" quota_warning = admin ; payment_created= ;node_unreachable=user,admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string][]string{
+		EventQuotaWarning:    {RoleAdmin},
+		EventPaymentCreated:  nil,
+		EventNodeUnreachable: {RoleUser, RoleAdmin},
+		EventCertExpiring:    {RoleAdmin}, // 未出现的事件沿用默认规则
+	}
+	for kind, want := range tests {
+		if got := rules[kind]; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %v, want %v", kind, got, want)
+		}
+	}
+	if !reflect.DeepEqual(DefaultRules[EventQuotaWarning], []string{RoleUser, RoleAdmin}) {
+		t.Errorf("ParseRules 不应修改默认规则: %v", DefaultRules[EventQuotaWarning])
+	}
+
+	for _, spec := range []string{"quota_warning", "=admin", "quota_warning=owner"} {
+		if _, err := ParseRules(spec); err == nil {
+			t.Errorf("%q 应返回错误", spec)
+		}
+	}
+}
+
+func TestDispatcherRecipients(t *testing.T) {
+	rules, err := ParseRules("quota_warning=user,admin;payment_created=;cert_expiring=user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	admin := Recipient{Role: RoleAdmin, Emails: []string{"admin@example.com"}, TelegramChatIDs: []string{"1001"}}
+	d := &Dispatcher{
+		Rules: rules,
+		Admin: admin,
+		Resolver: func(user string) (Recipient, error) {
+			if user == "missing" {
+				return Recipient{}, errors.New("not found")
+			}
+			return Recipient{Role: RoleUser, User: user, TelegramChatIDs: []string{"2002"}}, nil
+		},
+	}
+
+	tests := []struct {
+		name  string
+		event Event
+		want  []Recipient
+	}{
+		{"user and admin", Event{Kind: EventQuotaWarning, User: "alice"},
+			[]Recipient{{Role: RoleUser, User: "alice", TelegramChatIDs: []string{"2002"}}, admin}},
+		{"no user", Event{Kind: EventQuotaWarning}, []Recipient{admin}},
+		{"disabled", Event{Kind: EventPaymentCreated, User: "alice"}, nil},
+		{"user only", Event{Kind: EventCertExpiring, User: "bob"},
+			[]Recipient{{Role: RoleUser, User: "bob", TelegramChatIDs: []string{"2002"}}}},
+		{"default rule", Event{Kind: EventNodeUnreachable, User: "alice"}, []Recipient{admin}},
+		{"unknown kind", Event{Kind: "unknown", User: "alice"}, nil},
+	}
+	for _, tt := range tests {
+		got, err := d.Recipients(tt.event)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+
+	if _, err := d.Recipients(Event{Kind: EventQuotaWarning, User: "missing"}); err == nil {
+		t.Error("查找用户失败时应返回错误")
+	}
+}
+
+func TestDefaultResolver(t *testing.T) {
+	d := &Dispatcher{Rules: DefaultRules, Admin: Recipient{Role: RoleAdmin}}
+	got, err := d.Recipients(Event{Kind: EventCertExpiring})
+	if err != nil || len(got) != 1 || got[0].Role != RoleAdmin {
+		t.Errorf("got %+v, %v", got, err)
+	}
+
+	got, err = d.Recipients(Event{Kind: EventPaymentCreated, User: "alice@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || !reflect.DeepEqual(got[0].Emails, []string{"alice@example.com"}) {
+		t.Errorf("邮箱形式的用户应作为收件人: %+v", got)
+	}
+
+	got, err = d.Recipients(Event{Kind: EventPaymentCreated, User: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got[0].Emails) != 0 {
+		t.Errorf("非邮箱形式的用户不应有收件地址: %+v", got[0])
+	}
+}
+
+// recordingProvider 记录收到的通知，name 为 fail 时返回错误
+type recordingProvider struct {
+	name string
+	sent []Recipient
+}
+
+func (p *recordingProvider) Name() string {
+	return p.name
+}
+
+func (p *recordingProvider) Send(ctx context.Context, to Recipient, event Event) error {
+	p.sent = append(p.sent, to)
+	if p.name == "fail" {
+		return errors.New("boom")
+	}
+	return nil
+}
+
+func TestDispatcherNotify(t *testing.T) {
+	ok := &recordingProvider{name: "ok"}
+	fail := &recordingProvider{name: "fail"}
+	d := &Dispatcher{
+		Providers: []Provider{ok, fail},
+		Rules:     DefaultRules,
+		Admin:     Recipient{Role: RoleAdmin},
+		Resolver: func(user string) (Recipient, error) {
+			return Recipient{Role: RoleUser, User: user}, nil
+		},
+	}
+
+	err := d.Notify(Event{Kind: EventQuotaWarning, User: "alice", Subject: "流量预警"})
+	if err == nil || !strings.Contains(err.Error(), "fail: boom") {
+		t.Errorf("应返回失败渠道的错误: %v", err)
+	}
+	// 一个渠道失败不影响其他渠道和接收方
+	if len(ok.sent) != 2 || ok.sent[0].User != "alice" || ok.sent[1].Role != RoleAdmin {
+		t.Errorf("ok 渠道收到 %+v", ok.sent)
+	}
+	if len(fail.sent) != 2 {
+		t.Errorf("fail 渠道收到 %+v", fail.sent)
+	}
+}
@@ -0,0 +1,69 @@
+// Package recipient 根据 email_as_id 查找用户的通知地址，只依赖数据库，
+// 节点进程和控制面都可以通过 notify.SetUserResolver(recipient.Resolve) 使用
+package recipient
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/xvv6u577/logv2fs/database"
+	"github.com/xvv6u577/logv2fs/model"
+	"github.com/xvv6u577/logv2fs/notify"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var userTrafficLogs = database.GetCollection(model.UserTrafficLogs{})
+
+// Resolve 返回用户的通知地址：形如邮箱的 email_as_id 和绑定的 Telegram chat
+func Resolve(user string) (notify.Recipient, error) {
+	to := notify.Recipient{Role: notify.RoleUser, User: user}
+	if strings.Contains(user, "@") {
+		to.Emails = []string{user}
+	}
+
+	chatID, err := telegramChatID(user)
+	if err != nil {
+		return to, err
+	}
+	if chatID != "" {
+		to.TelegramChatIDs = []string{chatID}
+	}
+	return to, nil
+}
+
+// telegramChatID 返回用户绑定的 chat，未绑定时为空
+func telegramChatID(user string) (string, error) {
+	if database.IsUsingPostgres() {
+		return telegramChatIDPG(user)
+	}
+
+	var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var found struct {
+		TelegramChatID string `bson:"telegram_chat_id"`
+	}
+	opts := options.FindOne().SetProjection(bson.D{{Key: "telegram_chat_id", Value: 1}})
+	err := userTrafficLogs.FindOne(ctx, bson.M{"email_as_id": user}, opts).Decode(&found)
+	if err == mongo.ErrNoDocuments {
+		return "", nil
+	}
+	return found.TelegramChatID, err
+}
+
+// telegramChatIDPG 返回用户绑定的 chat，未绑定时为空 - PostgreSQL版本
+func telegramChatIDPG(user string) (string, error) {
+	var chatIDs []*string
+	if err := database.GetPostgresDB().Model(&model.UserTrafficLogsPG{}).
+		Where("email_as_id = ?", user).
+		Pluck("telegram_chat_id", &chatIDs).Error; err != nil {
+		return "", err
+	}
+	if len(chatIDs) == 0 || chatIDs[0] == nil {
+		return "", nil
+	}
+	return *chatIDs[0], nil
+}
@@ -0,0 +1,152 @@
+package notify
+
+import (
+	"context"
+	"net"
+	"net/textproto"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeSMTP 只支持不加密、不认证的最小 SMTP 会话，记录收到的邮件
+type fakeSMTP struct {
+	ln       net.Listener
+	from     string
+	rcpts    []string
+	data     string
+	rejectTo string // 拒绝该收件人
+	done     chan struct{}
+}
+
+func newFakeSMTP(t *testing.T) *fakeSMTP {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeSMTP{ln: ln, done: make(chan struct{})}
+	t.Cleanup(func() { ln.Close() })
+	go s.serve()
+	return s
+}
+
+func (s *fakeSMTP) hostPort() (string, string) {
+	host, port, _ := net.SplitHostPort(s.ln.Addr().String())
+	return host, port
+}
+
+func (s *fakeSMTP) serve() {
+	defer close(s.done)
+	conn, err := s.ln.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	tp := textproto.NewConn(conn)
+
+	tp.PrintfLine("220 fake ESMTP")
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch cmd {
+		case "EHLO", "HELO":
+			tp.PrintfLine("250-fake")
+			tp.PrintfLine("250 8BITMIME")
+		case "MAIL":
+			s.from = between(line, "<", ">")
+			tp.PrintfLine("250 OK")
+		case "RCPT":
+			rcpt := between(line, "<", ">")
+			if rcpt == s.rejectTo {
+				tp.PrintfLine("550 mailbox unavailable")
+				continue
+			}
+			s.rcpts = append(s.rcpts, rcpt)
+			tp.PrintfLine("250 OK")
+		case "DATA":
+			tp.PrintfLine("354 go ahead")
+			data, err := tp.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			s.data = string(data)
+			tp.PrintfLine("250 OK")
+		case "QUIT":
+			tp.PrintfLine("221 bye")
+			return
+		default:
+			tp.PrintfLine("250 OK")
+		}
+	}
+}
+
+func between(s, start, end string) string {
+	if i := strings.Index(s, start); i >= 0 {
+		s = s[i+len(start):]
+	}
+	if i := strings.Index(s, end); i >= 0 {
+		s = s[:i]
+	}
+	return s
+}
+
+func TestSMTPSend(t *testing.T) {
+	srv := newFakeSMTP(t)
+	host, port := srv.hostPort()
+
+	s := &SMTP{Host: host, Port: port, From: "noreply@example.com"}
+	to := Recipient{Role: RoleAdmin, Emails: []string{"a@example.com", "b@example.com"}}
+	event := Event{Subject: "证书即将过期", Message: "第一行\n第二行", Time: time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.Send(ctx, to, event); err != nil {
+		t.Fatal(err)
+	}
+	<-srv.done
+
+	if srv.from != "noreply@example.com" {
+		t.Errorf("from = %q", srv.from)
+	}
+	if !reflect.DeepEqual(srv.rcpts, to.Emails) {
+		t.Errorf("rcpts = %v", srv.rcpts)
+	}
+	for _, want := range []string{
+		"To: a@example.com, b@example.com\n",
+		"Subject: =?utf-8?q?",
+		"Content-Type: text/plain; charset=UTF-8\n",
+		"\n\n第一行\n第二行\n",
+	} {
+		if !strings.Contains(srv.data, want) {
+			t.Errorf("邮件缺少 %q:\n%s", want, srv.data)
+		}
+	}
+}
+
+func TestSMTPSendRejected(t *testing.T) {
+	srv := newFakeSMTP(t)
+	srv.rejectTo = "b@example.com"
+	host, port := srv.hostPort()
+
+	s := &SMTP{Host: host, Port: port, From: "noreply@example.com"}
+	to := Recipient{Role: RoleAdmin, Emails: []string{"a@example.com", "b@example.com"}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := s.Send(ctx, to, Event{Subject: "test"})
+	if err == nil || !strings.Contains(err.Error(), "550") {
+		t.Errorf("收件人被拒绝时应返回错误: %v", err)
+	}
+}
+
+func TestSMTPSendNoEmails(t *testing.T) {
+	s := &SMTP{Host: "127.0.0.1", Port: "1"}
+	if err := s.Send(context.Background(), Recipient{TelegramChatIDs: []string{"1001"}}, Event{}); err != nil {
+		t.Errorf("没有收件地址时不应发送: %v", err)
+	}
+}
@@ -0,0 +1,94 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// DefaultTelegramAPIBase Telegram Bot API 地址
+const DefaultTelegramAPIBase = "https://api.telegram.org"
+
+// Telegram 通过 Bot API 的 sendMessage 发送到接收方的每个 chat id
+type Telegram struct {
+	Token string
+	// APIBase 为空时使用 DefaultTelegramAPIBase，可指向本地模拟服务
+	APIBase string
+	Client  *http.Client
+}
+
+type telegramResponse struct {
+	OK          bool   `json:"ok"`
+	Description string `json:"description"`
+}
+
+func (t *Telegram) Name() string {
+	return "telegram"
+}
+
+func (t *Telegram) Send(ctx context.Context, to Recipient, event Event) error {
+	for _, chatID := range to.TelegramChatIDs {
+		if err := t.SendMessage(ctx, chatID, formatText(event)); err != nil {
+			return fmt.Errorf("chat %s: %w", chatID, err)
+		}
+	}
+	return nil
+}
+
+// SendMessage 向指定 chat 发送纯文本消息
+func (t *Telegram) SendMessage(ctx context.Context, chatID, text string) error {
+	var result telegramResponse
+	return t.Call(ctx, "sendMessage", map[string]interface{}{
+		"chat_id": chatID,
+		"text":    text,
+	}, &result)
+}
+
+// Call 调用 Bot API 方法，result 需包含 ok/description 字段以判断调用是否成功
+func (t *Telegram) Call(ctx context.Context, method string, params interface{}, result interface{}) error {
+	body, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+
+	base := t.APIBase
+	if base == "" {
+		base = DefaultTelegramAPIBase
+	}
+	url := fmt.Sprintf("%s/bot%s/%s", strings.TrimRight(base, "/"), t.Token, method)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := t.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var status telegramResponse
+	raw := json.RawMessage{}
+	if err := json.NewDecoder(resp.Body).Decode(&raw); err != nil {
+		return fmt.Errorf("解析 %s 响应失败 (状态码 %d): %w", method, resp.StatusCode, err)
+	}
+	if err := json.Unmarshal(raw, &status); err != nil {
+		return err
+	}
+	if !status.OK {
+		return fmt.Errorf("%s 失败: %s", method, status.Description)
+	}
+	if result != nil {
+		return json.Unmarshal(raw, result)
+	}
+	return nil
+}
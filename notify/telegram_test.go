@@ -0,0 +1,75 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTelegramSend(t *testing.T) {
+	var chats []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/botTOKEN/sendMessage" {
+			t.Errorf("path = %s", r.URL.Path)
+		}
+		var params struct {
+			ChatID string `json:"chat_id"`
+			Text   string `json:"text"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+			t.Error(err)
+		}
+		if params.Text != "流量预警\n\n已使用 80%" {
+			t.Errorf("text = %q", params.Text)
+		}
+		chats = append(chats, params.ChatID)
+		w.Write([]byte(`{"ok":true,"result":{"message_id":1}}`))
+	}))
+	defer srv.Close()
+
+	tg := &Telegram{Token: "TOKEN", APIBase: srv.URL + "/"}
+	to := Recipient{Role: RoleUser, TelegramChatIDs: []string{"1001", "1002"}}
+	if err := tg.Send(context.Background(), to, Event{Subject: "流量预警", Message: "已使用 80%"}); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(chats, []string{"1001", "1002"}) {
+		t.Errorf("chats = %v", chats)
+	}
+}
+
+func TestTelegramSendErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		want   string
+	}{
+		{"ok false", http.StatusBadRequest, `{"ok":false,"error_code":400,"description":"Bad Request: chat not found"}`, "chat not found"},
+		{"ok false with 200", http.StatusOK, `{"ok":false,"description":"Forbidden: bot was blocked by the user"}`, "bot was blocked"},
+		{"non-2xx without json", http.StatusBadGateway, `<html>bad gateway</html>`, "状态码 502"},
+	}
+	for _, tt := range tests {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(tt.status)
+			w.Write([]byte(tt.body))
+		}))
+
+		tg := &Telegram{Token: "TOKEN", APIBase: srv.URL}
+		err := tg.Send(context.Background(), Recipient{TelegramChatIDs: []string{"1001"}}, Event{Subject: "test"})
+		if err == nil || !strings.Contains(err.Error(), tt.want) || !strings.Contains(err.Error(), "chat 1001") {
+			t.Errorf("%s: got %v, want error containing %q", tt.name, err, tt.want)
+		}
+		srv.Close()
+	}
+}
+
+func TestTelegramSendNoChats(t *testing.T) {
+	tg := &Telegram{Token: "TOKEN", APIBase: "http://127.0.0.1:1"}
+	if err := tg.Send(context.Background(), Recipient{Emails: []string{"a@example.com"}}, Event{}); err != nil {
+		t.Errorf("没有 chat id 时不应发送: %v", err)
+	}
+}
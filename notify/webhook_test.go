@@ -0,0 +1,76 @@
+package notify
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWebhookSend(t *testing.T) {
+	var body []byte
+	var signature string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("unexpected request: %s %s", r.Method, r.Header.Get("Content-Type"))
+		}
+		body, _ = io.ReadAll(r.Body)
+		signature = r.Header.Get("X-Logv2fs-Signature")
+	}))
+	defer srv.Close()
+
+	event := Event{Kind: EventQuotaWarning, Subject: "流量预警", Message: "已使用 80%", User: "alice",
+		Time: time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)}
+	w := &Webhook{URL: srv.URL, Secret: "s3cret"}
+	if err := w.Send(context.Background(), Recipient{Role: RoleUser}, event); err != nil {
+		t.Fatal(err)
+	}
+
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	mac.Write(body)
+	if want := hex.EncodeToString(mac.Sum(nil)); signature != want {
+		t.Errorf("signature = %q, want %q", signature, want)
+	}
+
+	var payload webhookPayload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		t.Fatal(err)
+	}
+	want := webhookPayload{Kind: event.Kind, Subject: event.Subject, Message: event.Message, User: "alice", Role: RoleUser, Time: event.Time}
+	if !payload.Time.Equal(want.Time) {
+		t.Errorf("time = %v, want %v", payload.Time, want.Time)
+	}
+	payload.Time = want.Time
+	if payload != want {
+		t.Errorf("payload = %+v, want %+v", payload, want)
+	}
+
+	// 没有配置 Secret 时不带签名头
+	w.Secret = ""
+	if err := w.Send(context.Background(), Recipient{Role: RoleAdmin}, event); err != nil {
+		t.Fatal(err)
+	}
+	if signature != "" {
+		t.Errorf("未配置 Secret 时不应有签名头: %q", signature)
+	}
+}
+
+func TestWebhookSendStatus(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "bad gateway", http.StatusBadGateway)
+	}))
+	defer srv.Close()
+
+	w := &Webhook{URL: srv.URL}
+	err := w.Send(context.Background(), Recipient{Role: RoleAdmin}, Event{Kind: EventCertExpiring})
+	if err == nil || !strings.Contains(err.Error(), "502") {
+		t.Errorf("非 2xx 状态码应返回错误: %v", err)
+	}
+}
@@ -0,0 +1,179 @@
+package thirdparty
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	box "github.com/sagernet/sing-box"
+	"github.com/sagernet/sing-box/adapter"
+	"github.com/sagernet/sing-box/experimental/clashapi/trafficontrol"
+	"github.com/sagernet/sing-box/option"
+	M "github.com/sagernet/sing/common/metadata"
+	N "github.com/sagernet/sing/common/network"
+)
+
+// ConnectionInfo 节点上的一条活动连接
+type ConnectionInfo struct {
+	ID          string    `json:"id"`
+	User        string    `json:"user"`
+	Protocol    string    `json:"protocol"` // 用户所在的协议，与节点类型一致
+	Inbound     string    `json:"inbound"`
+	Network     string    `json:"network"`
+	Source      string    `json:"source"`
+	Destination string    `json:"destination"`
+	Start       time.Time `json:"start"`
+	Upload      int64     `json:"upload"`
+	Download    int64     `json:"download"`
+}
+
+// ConnectionTracker 包装 sing-box 的 Clash API 服务，在路由连接时记录连接所属的用户。
+// Clash API 的连接列表只有地址和流量，没有 inbound 用户，所以按连接 ID 另外保存。
+type ConnectionTracker struct {
+	adapter.ClashServer
+	manager *trafficontrol.Manager
+
+	mu          sync.Mutex
+	connections map[string]ConnectionInfo
+}
+
+// EnableConnectionTracking 配置中没有 clash_api 时添加一个不监听端口的配置，使 sing-box 统计活动连接。
+// 节点需要使用 with_clash_api 构建
+func EnableConnectionTracking(opt option.Options) option.Options {
+	if opt.Experimental == nil {
+		opt.Experimental = &option.ExperimentalOptions{}
+	}
+	if opt.Experimental.ClashAPI == nil {
+		opt.Experimental.ClashAPI = &option.ClashAPIOptions{}
+	}
+	return opt
+}
+
+// NewConnectionTracker 替换实例的 Clash API 服务，需要在 instance.Start 之前调用
+func NewConnectionTracker(instance *box.Box) (*ConnectionTracker, error) {
+	server := instance.Router().ClashServer()
+	if server == nil {
+		return nil, errors.New("clash api is not enabled")
+	}
+	provider, ok := server.(interface {
+		TrafficManager() *trafficontrol.Manager
+	})
+	if !ok {
+		return nil, fmt.Errorf("unexpected clash server type %T", server)
+	}
+
+	tracker := &ConnectionTracker{
+		ClashServer: server,
+		manager:     provider.TrafficManager(),
+		connections: map[string]ConnectionInfo{},
+	}
+	instance.Router().SetClashServer(tracker)
+	return tracker, nil
+}
+
+func (t *ConnectionTracker) RoutedConnection(ctx context.Context, conn net.Conn, metadata adapter.InboundContext, matchedRule adapter.Rule) (net.Conn, adapter.Tracker) {
+	conn, tracker := t.ClashServer.RoutedConnection(ctx, conn, metadata, matchedRule)
+	return conn, t.track(tracker, metadata)
+}
+
+func (t *ConnectionTracker) RoutedPacketConnection(ctx context.Context, conn N.PacketConn, metadata adapter.InboundContext, matchedRule adapter.Rule) (N.PacketConn, adapter.Tracker) {
+	conn, tracker := t.ClashServer.RoutedPacketConnection(ctx, conn, metadata, matchedRule)
+	return conn, t.track(tracker, metadata)
+}
+
+// track 保存连接的用户和地址，返回的 Tracker 在连接结束时删除记录
+func (t *ConnectionTracker) track(tracker adapter.Tracker, metadata adapter.InboundContext) adapter.Tracker {
+	identified, ok := tracker.(interface{ ID() string })
+	if !ok {
+		return tracker
+	}
+
+	destination := metadata.Destination
+	if metadata.Domain != "" {
+		destination = M.ParseSocksaddrHostPort(metadata.Domain, metadata.Destination.Port)
+	}
+	user, protocol := SplitStatsUserName(metadata.User)
+
+	id := identified.ID()
+	t.mu.Lock()
+	t.connections[id] = ConnectionInfo{
+		ID:          id,
+		User:        user,
+		Protocol:    protocol,
+		Inbound:     metadata.Inbound,
+		Network:     metadata.Network,
+		Source:      metadata.Source.String(),
+		Destination: destination.String(),
+		Start:       time.Now(),
+	}
+	t.mu.Unlock()
+
+	return &trackedConnection{Tracker: tracker, id: id, owner: t}
+}
+
+func (t *ConnectionTracker) forget(id string) {
+	t.mu.Lock()
+	delete(t.connections, id)
+	t.mu.Unlock()
+}
+
+type trackedConnection struct {
+	adapter.Tracker
+	id    string
+	owner *ConnectionTracker
+}
+
+func (c *trackedConnection) Leave() {
+	c.owner.forget(c.id)
+	c.Tracker.Leave()
+}
+
+// connectionTraffic Clash API 连接列表中的流量字段
+type connectionTraffic struct {
+	ID       string `json:"id"`
+	Upload   int64  `json:"upload"`
+	Download int64  `json:"download"`
+}
+
+// Connections 返回活动的用户连接，按建立时间排序；user 非空时只返回该用户的连接
+func (t *ConnectionTracker) Connections(user string) ([]ConnectionInfo, error) {
+	data, err := json.Marshal(t.manager.Snapshot().Connections)
+	if err != nil {
+		return nil, err
+	}
+	var traffic []connectionTraffic
+	if err := json.Unmarshal(data, &traffic); err != nil {
+		return nil, err
+	}
+
+	t.mu.Lock()
+	connections := make([]ConnectionInfo, 0, len(traffic))
+	for _, item := range traffic {
+		info, ok := t.connections[item.ID]
+		if !ok || info.User == "" || (user != "" && info.User != user) {
+			continue
+		}
+		info.Upload, info.Download = item.Upload, item.Download
+		connections = append(connections, info)
+	}
+	t.mu.Unlock()
+
+	sort.Slice(connections, func(i, j int) bool { return connections[i].Start.Before(connections[j].Start) })
+	return connections, nil
+}
+
+// CloseConnection 关闭指定的连接，连接不存在时返回 false
+func (t *ConnectionTracker) CloseConnection(id string) bool {
+	for _, conn := range t.manager.Snapshot().Connections {
+		if conn.ID() == id {
+			conn.Close()
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,120 @@
+package thirdparty
+
+import (
+	"context"
+	"log"
+
+	"github.com/xvv6u577/logv2fs/database"
+	"github.com/xvv6u577/logv2fs/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"gorm.io/gorm"
+)
+
+var (
+	nodePlansCol = database.GetCollection(model.NodePlan{})
+	subNodesCol  = database.GetCollection(model.SubscriptionNode{})
+)
+
+// planFilter 判断某个套餐的用户能否使用当前节点，返回可以使用的节点类型，为空表示不限制
+type planFilter func(planName string) ([]string, bool)
+
+// allowUnplannedOnly 只允许没有套餐的用户，有套餐的用户一律不加载
+func allowUnplannedOnly(planName string) ([]string, bool) { return nil, planName == "" }
+
+// newPlanFilter 根据套餐和 subscription_nodes 中当前域名的节点生成过滤函数。
+// 用户只能使用当前域名上属于其套餐的节点类型对应的 inbound，没有这类节点的用户不加载。
+// 与订阅中的 NodePlans.Lookup 一致，引用了不存在的套餐的用户不加载；没有任何套餐时这类用户同样不加载。
+// 存在套餐但未设置 CURRENT_DOMAIN 或数据库中没有当前域名的节点时，只加载没有套餐的用户。
+func newPlanFilter(plans model.NodePlans, nodes []model.SubscriptionNode) planFilter {
+	if len(plans) == 0 {
+		return allowUnplannedOnly
+	}
+	if CURRENT_DOMAIN == "" || len(nodes) == 0 {
+		log.Printf("未找到当前节点 %q 的订阅节点信息，有套餐的用户不会加载到本节点", CURRENT_DOMAIN)
+		return allowUnplannedOnly
+	}
+
+	type result struct {
+		protocols []string
+		ok        bool
+	}
+	allowed := make(map[string]result)
+	return func(planName string) ([]string, bool) {
+		if r, ok := allowed[planName]; ok {
+			return r.protocols, r.ok
+		}
+		// 没有套餐的用户不限制；套餐不存在时 DomainTypes 为空，用户不加载
+		plan := plans.Lookup(planName)
+		if plan == nil {
+			allowed[planName] = result{ok: true}
+			return nil, true
+		}
+
+		// 只保留本节点能提供的类型，例如 vlessCDN 不由 sing-box 提供
+		protocols := []string{}
+		for _, protocol := range plan.DomainTypes(CURRENT_DOMAIN, nodes) {
+			for _, p := range userProtocols {
+				if p == protocol {
+					protocols = append(protocols, protocol)
+				}
+			}
+		}
+		allowed[planName] = result{protocols: protocols, ok: len(protocols) > 0}
+		return allowed[planName].protocols, allowed[planName].ok
+	}
+}
+
+// loadPlanFilterFromPostgreSQL 从 PostgreSQL 读取套餐和当前节点 - PostgreSQL版本
+func loadPlanFilterFromPostgreSQL(db *gorm.DB) (planFilter, error) {
+	var pgPlans []model.NodePlanPG
+	if err := db.Find(&pgPlans).Error; err != nil {
+		return nil, err
+	}
+	plans := make(model.NodePlans, len(pgPlans))
+	for _, plan := range pgPlans {
+		plans[plan.Name] = plan.ToNodePlan()
+	}
+
+	var pgNodes []model.SubscriptionNodePG
+	if err := db.Where("domain = ?", CURRENT_DOMAIN).Find(&pgNodes).Error; err != nil {
+		return nil, err
+	}
+	nodes := make([]model.SubscriptionNode, 0, len(pgNodes))
+	for _, node := range pgNodes {
+		nodes = append(nodes, model.SubscriptionNode{
+			Type:   node.Type,
+			Remark: node.Remark,
+			Domain: node.Domain,
+			Region: node.Region,
+		})
+	}
+
+	return newPlanFilter(plans, nodes), nil
+}
+
+// loadPlanFilterFromMongoDB 从 MongoDB 读取套餐和当前节点
+func loadPlanFilterFromMongoDB() (planFilter, error) {
+	cur, err := nodePlansCol.Find(context.Background(), bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	var planList []model.NodePlan
+	if err := cur.All(context.Background(), &planList); err != nil {
+		return nil, err
+	}
+	plans := make(model.NodePlans, len(planList))
+	for _, plan := range planList {
+		plans[plan.Name] = plan
+	}
+
+	cur, err = subNodesCol.Find(context.Background(), bson.M{"domain": CURRENT_DOMAIN})
+	if err != nil {
+		return nil, err
+	}
+	var nodes []model.SubscriptionNode
+	if err := cur.All(context.Background(), &nodes); err != nil {
+		return nil, err
+	}
+
+	return newPlanFilter(plans, nodes), nil
+}
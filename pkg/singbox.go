@@ -6,13 +6,14 @@ import (
 	"os"
 	"regexp"
 	"strings"
-	"sync"
 
+	"github.com/google/uuid"
 	box "github.com/sagernet/sing-box"
 	"github.com/sagernet/sing-box/experimental/v2rayapi"
 	"github.com/sagernet/sing-box/option"
 	"github.com/sagernet/sing/common/json"
 	"github.com/xvv6u577/logv2fs/database"
+	helper "github.com/xvv6u577/logv2fs/helpers"
 	"github.com/xvv6u577/logv2fs/model"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo/options"
@@ -33,169 +34,252 @@ var (
 // UpdateOptionsFromDB 根据配置从数据库更新 sing-box 选项
 // 支持 MongoDB 和 PostgreSQL 两种数据库
 func UpdateOptionsFromDB(opt option.Options) (option.Options, error) {
+	users, err := LoadActiveUsers()
+	if err != nil {
+		return opt, err
+	}
+
+	if len(users) == 0 {
+		log.Println("数据库中没有找到活跃用户")
+		return opt, nil
+	}
+
+	opt = ApplyUsersToOptions(opt, users)
+	log.Printf("成功从数据库加载了 %d 个用户的配置", len(users))
+
+	return opt, nil
+}
+
+// ApplyUsersToOptions 将用户写入配置中的 VLESS、Hysteria2、TUIC、Trojan 和 Shadowsocks 2022 inbound，
+// 返回的配置使用新的 inbound 列表，不修改 opt 中原有的 inbound
+func ApplyUsersToOptions(opt option.Options, users []ProvisionedUser) option.Options {
+	if opt.Experimental != nil && opt.Experimental.V2RayAPI != nil && opt.Experimental.V2RayAPI.Stats != nil {
+		for _, user := range users {
+			opt.Experimental.V2RayAPI.Stats.Users = append(opt.Experimental.V2RayAPI.Stats.Users, StatsUserNames(user.EmailAsId)...)
+		}
+	}
+
+	inbounds := make([]option.Inbound, 0, len(opt.Inbounds))
+	for _, in := range opt.Inbounds {
+		inbounds = append(inbounds, inboundWithUsers(in, users))
+	}
+	opt.Inbounds = inbounds
+
+	return opt
+}
+
+// inboundProtocol 返回 inbound 对应的节点类型，与 ProvisionedUser.Protocols 和套餐的 Types 一致
+func inboundProtocol(in option.Inbound) string {
+	switch in.Type {
+	case "vless":
+		return "reality"
+	case "hysteria2", "tuic", "trojan":
+		return in.Type
+	case "shadowsocks":
+		if strings.HasPrefix(in.ShadowsocksOptions.Method, "2022-") {
+			return "ss2022"
+		}
+	}
+	return ""
+}
+
+// inboundWithUsers 返回写入用户后的 inbound 配置，配置文件中已有的用户保留在前面。
+// 只写入套餐允许使用该 inbound 类型的用户
+func inboundWithUsers(in option.Inbound, all []ProvisionedUser) option.Inbound {
+	protocol := inboundProtocol(in)
+	users := make([]ProvisionedUser, 0, len(all))
+	for _, user := range all {
+		if user.allowsProtocol(protocol) {
+			users = append(users, user)
+		}
+	}
+
+	// 如果需要支持 vmess，可以增加 case "vmess"，按相同方式写入 VMessOptions.Users
+	switch in.Type {
+	case "vless":
+		vlessUsers := append([]option.VLESSUser(nil), in.VLESSOptions.Users...)
+		for _, user := range users {
+			vlessUsers = append(vlessUsers, option.VLESSUser{
+				Name: user.EmailAsId + "-reality",
+				UUID: user.UUID,
+				Flow: "xtls-rprx-vision",
+			})
+		}
+		in.VLESSOptions.Users = vlessUsers
+
+	case "hysteria2":
+		hysteria2Users := append([]option.Hysteria2User(nil), in.Hysteria2Options.Users...)
+		for _, user := range users {
+			hysteria2Users = append(hysteria2Users, option.Hysteria2User{
+				Name:     user.EmailAsId + "-hysteria2",
+				Password: user.UserID,
+			})
+		}
+		in.Hysteria2Options.Users = hysteria2Users
+
+	case "tuic":
+		tuicUsers := append([]option.TUICUser(nil), in.TUICOptions.Users...)
+		for _, user := range users {
+			// UUID 无效时 sing-box 无法创建 inbound，跳过该用户
+			if _, err := uuid.Parse(user.UUID); err != nil {
+				log.Printf("用户 %s 的 UUID 无效，跳过 TUIC: %v", user.EmailAsId, err)
+				continue
+			}
+			tuicUsers = append(tuicUsers, option.TUICUser{
+				Name:     user.EmailAsId + "-tuic",
+				UUID:     user.UUID,
+				Password: user.UserID,
+			})
+		}
+		in.TUICOptions.Users = tuicUsers
+
+	case "trojan":
+		trojanUsers := append([]option.TrojanUser(nil), in.TrojanOptions.Users...)
+		for _, user := range users {
+			trojanUsers = append(trojanUsers, option.TrojanUser{
+				Name:     user.EmailAsId + "-trojan",
+				Password: user.UserID,
+			})
+		}
+		in.TrojanOptions.Users = trojanUsers
+
+	case "shadowsocks":
+		// 只有 Shadowsocks 2022 支持按用户派生密钥
+		method := in.ShadowsocksOptions.Method
+		if !strings.HasPrefix(method, "2022-") {
+			break
+		}
+		ssUsers := append([]option.ShadowsocksUser(nil), in.ShadowsocksOptions.Users...)
+		for _, user := range users {
+			ssUsers = append(ssUsers, option.ShadowsocksUser{
+				Name:     user.EmailAsId + "-ss2022",
+				Password: helper.SS2022UserKey(user.UUID, method),
+			})
+		}
+		// 没有用户时 sing-box 会创建只用服务端密钥认证的单用户 inbound，
+		// 服务端密钥在所有用户的订阅中都能看到，这里放一个随机密钥的占位用户保持多用户模式
+		if len(ssUsers) == 0 {
+			ssUsers = append(ssUsers, option.ShadowsocksUser{
+				Password: helper.SS2022UserKey(uuid.NewString(), method),
+			})
+		}
+		in.ShadowsocksOptions.Users = ssUsers
+	}
+
+	return in
+}
+
+// LoadActiveUsers 从数据库读取所有状态为 plain 且套餐包含当前节点的用户，以及套餐允许使用的节点类型
+// 支持 MongoDB 和 PostgreSQL 两种数据库
+func LoadActiveUsers() ([]ProvisionedUser, error) {
 	// 根据环境变量决定使用哪种数据库
 	if database.IsUsingPostgres() {
-		log.Println("使用 PostgreSQL 从数据库更新 sing-box 配置...")
-		return updateOptionsFromPostgreSQL(opt)
-	} else {
-		log.Println("使用 MongoDB 从数据库更新 sing-box 配置...")
-		return updateOptionsFromMongoDB(opt)
+		return loadActiveUsersFromPostgreSQL()
 	}
+	return loadActiveUsersFromMongoDB()
 }
 
-// updateOptionsFromPostgreSQL 从 PostgreSQL 数据库更新配置
-func updateOptionsFromPostgreSQL(opt option.Options) (option.Options, error) {
+// loadActiveUsersFromPostgreSQL 从 PostgreSQL 数据库读取活跃用户
+func loadActiveUsersFromPostgreSQL() ([]ProvisionedUser, error) {
 	db := database.GetPostgresDB()
 	if db == nil {
 		log.Printf("PostgreSQL 数据库连接不可用，尝试回退到 MongoDB")
-		return updateOptionsFromMongoDB(opt)
+		return loadActiveUsersFromMongoDB()
+	}
+
+	allowed, err := loadPlanFilterFromPostgreSQL(db)
+	if err != nil {
+		log.Printf("查询 PostgreSQL 节点套餐时出错: %v\n", err)
+		return nil, err
 	}
 
 	// 查询活跃用户的关键信息
 	var pgUsers []UserTrafficLogsPG
-	if err := db.Select("email_as_id, status, uuid, user_id").
+	if err := db.Select("email_as_id, status, uuid, user_id, plan").
 		Where("status = ?", "plain").
 		Find(&pgUsers).Error; err != nil {
 		log.Printf("查询 PostgreSQL 用户信息时出错: %v\n", err)
-		return opt, err
+		return nil, err
 	}
 
-	if len(pgUsers) > 0 {
-		var wg sync.WaitGroup
-
-		for _, user := range pgUsers {
-			wg.Add(1)
-			go func(user UserTrafficLogsPG) {
-				defer wg.Done()
-
-				// 为每个用户添加 VlessUser 和 Hysteria2User 到 opt.Inbounds
-				for inbound := range opt.Inbounds {
-
-					var usersToAppend = []string{user.EmailAsId + "-reality", user.EmailAsId + "-hysteria2"}
-					opt.Experimental.V2RayAPI.Stats.Users = append(opt.Experimental.V2RayAPI.Stats.Users, usersToAppend...)
-
-					if opt.Inbounds[inbound].Type == "vless" {
-						opt.Inbounds[inbound].VLESSOptions.Users = append(opt.Inbounds[inbound].VLESSOptions.Users, option.VLESSUser{
-							Name: user.EmailAsId + "-reality",
-							UUID: user.UUID,
-							Flow: "xtls-rprx-vision",
-						})
-					}
-
-					if opt.Inbounds[inbound].Type == "hysteria2" {
-						opt.Inbounds[inbound].Hysteria2Options.Users = append(opt.Inbounds[inbound].Hysteria2Options.Users, option.Hysteria2User{
-							Name:     user.EmailAsId + "-hysteria2",
-							Password: user.UserID,
-						})
-					}
-
-					// 如果需要支持 vmess，可以取消注释以下代码
-					// if opt.Inbounds[inbound].Type == "vmess" {
-					// 	opt.Inbounds[inbound].VMessOptions.Users = append(opt.Inbounds[inbound].VMessOptions.Users, option.VMessUser{
-					// 		Name:    user.EmailAsId + "-vmess",
-					// 		UUID:    user.UUID,
-					// 		AlterId: 0,
-					// 	})
-					// }
-				}
-
-			}(user)
+	users := make([]ProvisionedUser, 0, len(pgUsers))
+	for _, user := range pgUsers {
+		protocols, ok := allowed(user.Plan)
+		if !ok {
+			continue
 		}
-
-		wg.Wait()
-		log.Printf("成功从 PostgreSQL 加载了 %d 个用户的配置", len(pgUsers))
-	} else {
-		log.Println("PostgreSQL 中没有找到活跃用户")
+		users = append(users, ProvisionedUser{
+			EmailAsId: user.EmailAsId,
+			UUID:      user.UUID,
+			UserID:    user.UserID,
+			Protocols: protocols,
+		})
 	}
 
-	return opt, nil
+	return users, nil
 }
 
-// updateOptionsFromMongoDB 从 MongoDB 数据库更新配置（原有逻辑）
-func updateOptionsFromMongoDB(opt option.Options) (option.Options, error) {
+// loadActiveUsersFromMongoDB 从 MongoDB 数据库读取活跃用户
+func loadActiveUsersFromMongoDB() ([]ProvisionedUser, error) {
 	var projections = bson.D{
 		{Key: "email_as_id", Value: 1},
 		{Key: "status", Value: 1},
 		{Key: "uuid", Value: 1},
 		{Key: "user_id", Value: 1},
+		{Key: "plan", Value: 1},
+	}
+
+	allowed, err := loadPlanFilterFromMongoDB()
+	if err != nil {
+		log.Printf("error getting node plans: %v\n", err)
+		return nil, err
 	}
 
-	cur, err := userTrafficLogsCol.Find(context.Background(), bson.D{}, options.Find().SetProjection(projections))
+	cur, err := userTrafficLogsCol.Find(context.Background(), bson.M{"status": "plain"}, options.Find().SetProjection(projections))
 	if err != nil {
 		log.Printf("error getting all users portion info: %v\n", err)
-		return opt, err
+		return nil, err
 	}
 
 	var userTrafficLogsArr []*UserTrafficLogs
 	if err = cur.All(context.Background(), &userTrafficLogsArr); err != nil {
 		log.Printf("error getting all users portion info: %v\n", err)
-		return opt, err
+		return nil, err
 	}
 
-	if len(userTrafficLogsArr) > 0 {
-		var wg sync.WaitGroup
-
-		for _, user := range userTrafficLogsArr {
-			if user.Status == "plain" {
-				wg.Add(1)
-				go func(user UserTrafficLogs) {
-					defer wg.Done()
-
-					// add VlessUser and Hysteria2User to opt.Inbounds
-					for inbound := range opt.Inbounds {
-
-						var usersToAppend = []string{user.Email_As_Id + "-reality", user.Email_As_Id + "-hysteria2"}
-						opt.Experimental.V2RayAPI.Stats.Users = append(opt.Experimental.V2RayAPI.Stats.Users, usersToAppend...)
-
-						if opt.Inbounds[inbound].Type == "vless" {
-							opt.Inbounds[inbound].VLESSOptions.Users = append(opt.Inbounds[inbound].VLESSOptions.Users, option.VLESSUser{
-								Name: user.Email_As_Id + "-reality",
-								UUID: user.UUID,
-								Flow: "xtls-rprx-vision",
-							})
-						}
-
-						if opt.Inbounds[inbound].Type == "hysteria2" {
-							opt.Inbounds[inbound].Hysteria2Options.Users = append(opt.Inbounds[inbound].Hysteria2Options.Users, option.Hysteria2User{
-								Name:     user.Email_As_Id + "-hysteria2",
-								Password: user.User_id,
-							})
-						}
-
-						// if opt.Inbounds[inbound].Type == "vmess" {
-						// 	opt.Inbounds[inbound].VMessOptions.Users = append(opt.Inbounds[inbound].VMessOptions.Users, option.VMessUser{
-						// 		Name:    user.Email_As_Id + "-vmess",
-						// 		UUID:    user.UUID,
-						// 		AlterId: 0,
-						// 	})
-						// }
-					}
-
-				}(*user)
-			}
+	users := make([]ProvisionedUser, 0, len(userTrafficLogsArr))
+	for _, user := range userTrafficLogsArr {
+		protocols, ok := allowed(user.Plan)
+		if !ok {
+			continue
 		}
-
-		wg.Wait()
-		log.Printf("成功从 MongoDB 加载了 %d 个用户的配置", len(userTrafficLogsArr))
-	} else {
-		log.Println("MongoDB 中没有找到活跃用户")
+		users = append(users, ProvisionedUser{
+			EmailAsId: user.Email_As_Id,
+			UUID:      user.UUID,
+			UserID:    user.User_id,
+			Protocols: protocols,
+		})
 	}
 
-	return opt, nil
+	return users, nil
 }
 
 func UsageDataOfAll(instance *box.Box) ([]Traffic, error) {
+	return UsageData(instance, true)
+}
+
+// UsageData 查询每个用户的流量，reset 为 false 时不清零计数器，供实时查询使用
+func UsageData(instance *box.Box, reset bool) ([]Traffic, error) {
 
 	statsService := instance.Router().V2RayServer().StatsService()
 
 	regEx := `(?P<tag>[\w]+)>>>(?P<name>[-\w]+)>>>traffic>>>(?P<direction>[\w]+)`
 	compRegEx := regexp.MustCompile(regEx)
 
-	var loggingData = []Traffic{}
-	var temp = map[string]int64{}
+	var temp = map[string]*Traffic{}
 
 	response, err := statsService.(v2rayapi.StatsServiceServer).QueryStats(context.Background(),
-		&v2rayapi.QueryStatsRequest{Reset_: true, Regexp: true, Patterns: []string{".*"}})
+		&v2rayapi.QueryStatsRequest{Reset_: reset, Regexp: true, Patterns: []string{".*"}})
 	if err != nil {
 		log.Printf("%s", err)
 		return nil, err
@@ -208,43 +292,67 @@ func UsageDataOfAll(instance *box.Box) ([]Traffic, error) {
 		if stat.Value == 0 {
 			continue
 		}
-		// log.Printf("%s: %d\n", stat.Name, stat.Value)
 
+		// user>>>tom-reality>>>traffic>>>uplink
 		matches := compRegEx.FindAllStringSubmatch(stat.Name, -1)
 		for _, n := range matches {
-			// log.Printf("%s: %s: %s: %s\n", n[0], n[1], n[2], n[3])
-
-			if n[1] == "user" {
-				parts := strings.Split(n[2], "-")
-				if len(parts) > 0 {
-					if value, ok := temp[parts[0]]; ok {
-						temp[parts[0]] = value + stat.Value
-					} else {
-						temp[parts[0]] = stat.Value
-					}
-				}
+			if n[1] != "user" {
+				continue
 			}
 
-			// if n[2] == "proxy" {
-			// 	if value, ok := temp["proxy"]; ok {
-			// 		temp["proxy"] = value + stat.Value
-			// 	} else {
-			// 		temp["proxy"] = stat.Value
-			// 	}
-			// }
+			name, protocol := SplitStatsUserName(n[2])
+			traffic, ok := temp[name]
+			if !ok {
+				traffic = &Traffic{Name: name}
+				traffic.Protocols = map[string]int64{}
+				temp[name] = traffic
+			}
+
+			traffic.Total += stat.Value
+			switch n[3] {
+			case "uplink":
+				traffic.Uplink += stat.Value
+			case "downlink":
+				traffic.Downlink += stat.Value
+			}
+			if protocol != "" {
+				traffic.Protocols[protocol] += stat.Value
+			}
 		}
 	}
 
-	for name, value := range temp {
-		loggingData = append(loggingData, Traffic{
-			Name:  name,
-			Total: value,
-		})
+	var loggingData = []Traffic{}
+	for _, traffic := range temp {
+		loggingData = append(loggingData, *traffic)
 	}
 
 	return loggingData, nil
 }
 
+// InboundSummaries 返回配置中的 inbound 标签、类型和监听端口，用于节点心跳上报
+func InboundSummaries(opt option.Options) []model.NodeInbound {
+	inbounds := make([]model.NodeInbound, 0, len(opt.Inbounds))
+	for i := range opt.Inbounds {
+		inbound := model.NodeInbound{Tag: opt.Inbounds[i].Tag, Type: opt.Inbounds[i].Type}
+		if raw, err := opt.Inbounds[i].RawOptions(); err == nil {
+			if listen, ok := raw.(option.ListenOptionsWrapper); ok {
+				inbound.Port = listen.TakeListenOptions().ListenPort
+			}
+		}
+		inbounds = append(inbounds, inbound)
+	}
+	return inbounds
+}
+
+// SplitStatsUserName 将统计名称 tom-reality 拆分为用户 tom 和协议 reality
+func SplitStatsUserName(statsName string) (string, string) {
+	i := strings.LastIndex(statsName, "-")
+	if i < 0 {
+		return statsName, ""
+	}
+	return statsName[:i], statsName[i+1:]
+}
+
 func InitOptionsFromConfig(config string) (option.Options, error) {
 
 	var options = option.Options{}
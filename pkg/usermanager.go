@@ -0,0 +1,466 @@
+package thirdparty
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"reflect"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unsafe"
+
+	box "github.com/sagernet/sing-box"
+	"github.com/sagernet/sing-box/adapter"
+	"github.com/sagernet/sing-box/inbound"
+	sblog "github.com/sagernet/sing-box/log"
+	"github.com/sagernet/sing-box/option"
+)
+
+// ProvisionedUser 节点上需要加载的用户凭据
+type ProvisionedUser struct {
+	EmailAsId string `json:"email_as_id"`
+	UUID      string `json:"uuid"`
+	UserID    string `json:"user_id"`
+	// 套餐允许在本节点使用的节点类型（reality、hysteria2 等），为空表示不限制
+	Protocols []string `json:"protocols,omitempty"`
+}
+
+// allowsProtocol 判断用户能否加入该协议的 inbound
+func (u ProvisionedUser) allowsProtocol(protocol string) bool {
+	if len(u.Protocols) == 0 {
+		return true
+	}
+	for _, p := range u.Protocols {
+		if p == protocol {
+			return true
+		}
+	}
+	return false
+}
+
+// sameAs 判断两个用户的凭据和可用协议是否相同
+func (u ProvisionedUser) sameAs(other ProvisionedUser) bool {
+	if u.EmailAsId != other.EmailAsId || u.UUID != other.UUID || u.UserID != other.UserID ||
+		len(u.Protocols) != len(other.Protocols) {
+		return false
+	}
+	for i := range u.Protocols {
+		if u.Protocols[i] != other.Protocols[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// UserSetSnapshot 节点当前正在服务的用户集合
+type UserSetSnapshot struct {
+	Users     []string  `json:"users"`
+	UserCount int       `json:"user_count"`
+	Checksum  string    `json:"checksum"`
+	SyncedAt  time.Time `json:"synced_at"`
+}
+
+// singBoxVersion 用户热更新依赖的 sing-box 版本。
+// 这里通过反射读写 box.Box 的 inbounds、logFactory 和 v2rayapi.StatsService 的 access、users 字段，
+// 升级 sing-box 前需要确认这些字段没有变化，再修改该版本号
+const singBoxVersion = "v1.8.1"
+
+// userProtocols 用户在各 inbound 中的名称后缀，也是流量统计中的协议名，与节点类型一致
+var userProtocols = []string{"reality", "hysteria2", "tuic", "trojan", "ss2022"}
+
+// UserManager 管理运行中的 sing-box 实例的用户集合，在不重启实例的情况下增删
+// VLESS、Hysteria2、TUIC、Trojan 和 Shadowsocks 2022 用户。
+//
+// sing-box 的 inbound 在创建后不支持并发修改用户，用户变化时按模板配置重新创建这些 inbound：
+// 新 inbound 有自己的用户列表，下标和用户一一对应，不会与正在处理的连接共享数据。
+// 基于 TCP 的 inbound（VLESS、Trojan、Shadowsocks）关闭监听后已建立的连接继续使用旧 inbound；
+// 基于 QUIC 的 inbound（Hysteria2、TUIC）会断开现有连接，由客户端重新连接。
+type UserManager struct {
+	mu        sync.Mutex
+	ctx       context.Context
+	instance  *box.Box
+	templates []option.Inbound // 不含数据库用户的 inbound 配置，与实例的 inbound 顺序一致
+	current   []option.Inbound // 当前运行的 inbound 配置
+	users     map[string]ProvisionedUser
+	syncedAt  time.Time
+	closed    bool
+}
+
+// NewUserManager 创建用户管理器。ctx 需要与创建实例时传给 box.New 的 Context 相同，
+// template 为写入用户之前的配置，initial 为启动时已经写入配置的用户
+func NewUserManager(ctx context.Context, instance *box.Box, template option.Options, initial []ProvisionedUser) *UserManager {
+	m := &UserManager{
+		ctx:       ctx,
+		instance:  instance,
+		templates: template.Inbounds,
+		current:   make([]option.Inbound, 0, len(template.Inbounds)),
+		users:     make(map[string]ProvisionedUser, len(initial)),
+		syncedAt:  time.Now(),
+	}
+	for _, in := range template.Inbounds {
+		m.current = append(m.current, inboundWithUsers(in, initial))
+	}
+	for _, user := range initial {
+		m.users[user.EmailAsId] = user
+	}
+	return m
+}
+
+// Close 停止热更新，需要在关闭实例之前调用
+func (m *UserManager) Close() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.closed = true
+}
+
+// Sync 将实例的用户集合替换为 users，返回新增和移除的用户
+func (m *UserManager) Sync(users []ProvisionedUser) (added []string, removed []string, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	desired := make(map[string]ProvisionedUser, len(users))
+	for _, user := range users {
+		desired[user.EmailAsId] = user
+	}
+
+	for email, user := range desired {
+		current, ok := m.users[email]
+		if !ok || !current.sameAs(user) {
+			added = append(added, email)
+		}
+	}
+	for email := range m.users {
+		if _, ok := desired[email]; !ok {
+			removed = append(removed, email)
+		}
+	}
+
+	if len(added) == 0 && len(removed) == 0 {
+		m.syncedAt = time.Now()
+		return nil, nil, nil
+	}
+
+	if err := m.apply(desired); err != nil {
+		return nil, nil, err
+	}
+
+	m.users = desired
+	m.syncedAt = time.Now()
+	sort.Strings(added)
+	sort.Strings(removed)
+	return added, removed, nil
+}
+
+// AddUser 向实例中添加或更新单个用户
+func (m *UserManager) AddUser(user ProvisionedUser) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	desired := make(map[string]ProvisionedUser, len(m.users)+1)
+	for email, u := range m.users {
+		desired[email] = u
+	}
+	desired[user.EmailAsId] = user
+
+	if err := m.apply(desired); err != nil {
+		return err
+	}
+	m.users = desired
+	m.syncedAt = time.Now()
+	return nil
+}
+
+// RemoveUser 从实例中移除单个用户
+func (m *UserManager) RemoveUser(email string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[email]; !ok {
+		return nil
+	}
+
+	desired := make(map[string]ProvisionedUser, len(m.users))
+	for e, u := range m.users {
+		if e != email {
+			desired[e] = u
+		}
+	}
+
+	if err := m.apply(desired); err != nil {
+		return err
+	}
+	m.users = desired
+	m.syncedAt = time.Now()
+	return nil
+}
+
+// Users 返回当前加载的用户，按邮箱排序
+func (m *UserManager) Users() []ProvisionedUser {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	users := make([]ProvisionedUser, 0, len(m.users))
+	for _, user := range m.users {
+		users = append(users, user)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].EmailAsId < users[j].EmailAsId })
+	return users
+}
+
+// Snapshot 返回当前用户集合的快照
+func (m *UserManager) Snapshot() UserSetSnapshot {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	emails := make([]string, 0, len(m.users))
+	for email := range m.users {
+		emails = append(emails, email)
+	}
+	sort.Strings(emails)
+
+	return UserSetSnapshot{
+		Users:     emails,
+		UserCount: len(emails),
+		Checksum:  UserSetChecksum(emails),
+		SyncedAt:  m.syncedAt,
+	}
+}
+
+// UserSetChecksum 计算用户集合的校验值，用于比较节点与数据库是否一致
+func UserSetChecksum(emails []string) string {
+	sorted := append([]string(nil), emails...)
+	sort.Strings(sorted)
+	sum := sha256.Sum256([]byte(strings.Join(sorted, "\n")))
+	return hex.EncodeToString(sum[:8])
+}
+
+// apply 按 desired 重新创建支持的 inbound，并更新 V2Ray 统计服务
+func (m *UserManager) apply(desired map[string]ProvisionedUser) error {
+	if m.closed {
+		return fmt.Errorf("sing-box 实例已关闭")
+	}
+	if err := checkSingBoxVersion(); err != nil {
+		return err
+	}
+
+	users := make([]ProvisionedUser, 0, len(desired))
+	for _, user := range desired {
+		users = append(users, user)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].EmailAsId < users[j].EmailAsId })
+
+	inbounds, err := boxInbounds(m.instance)
+	if err != nil {
+		return err
+	}
+	if len(inbounds) != len(m.templates) {
+		return fmt.Errorf("实例有 %d 个 inbound，配置中有 %d 个", len(inbounds), len(m.templates))
+	}
+
+	// 先创建所有新的 inbound，任一配置无效时不替换任何 inbound
+	var managed []int
+	nexts := make(map[int]option.Inbound)
+	replacements := make(map[int]adapter.Inbound)
+	for i, in := range inbounds {
+		if !managesInbound(m.templates[i]) {
+			continue
+		}
+		next := inboundWithUsers(m.templates[i], users)
+		replacement, err := m.newInbound(next)
+		if err != nil {
+			for _, j := range managed {
+				replacements[j].Close()
+			}
+			return fmt.Errorf("创建 inbound %s 失败: %v", in.Tag(), err)
+		}
+		managed = append(managed, i)
+		nexts[i], replacements[i] = next, replacement
+	}
+
+	// 再依次替换，任一 inbound 启动失败时把已替换的 inbound 恢复为 m.current 中的配置，
+	// 保证实例中所有 inbound 的用户与 m.current 一致
+	for k, i := range managed {
+		tag := inbounds[i].Tag()
+		active, err := m.swapInbound(inbounds[i], replacements[i], m.current[i])
+		inbounds[i] = active
+		if err == nil {
+			continue
+		}
+
+		for _, j := range managed[k+1:] {
+			replacements[j].Close()
+		}
+		for _, j := range managed[:k] {
+			restored, rollbackErr := m.rebuildInbound(inbounds[j], nexts[j], m.current[j])
+			inbounds[j] = restored
+			if rollbackErr != nil {
+				log.Printf("恢复 inbound %s 原用户失败: %v", restored.Tag(), rollbackErr)
+				m.current[j] = nexts[j]
+			}
+		}
+		return fmt.Errorf("更新 inbound %s 用户失败: %v", tag, err)
+	}
+	for _, i := range managed {
+		m.current[i] = nexts[i]
+	}
+
+	if err := m.updateStatsUsers(users); err != nil {
+		log.Printf("更新流量统计用户列表失败: %v", err)
+	}
+
+	return nil
+}
+
+// managesInbound 判断 inbound 的用户是否由 UserManager 管理
+func managesInbound(in option.Inbound) bool {
+	switch in.Type {
+	case "vless", "hysteria2", "tuic", "trojan":
+		return true
+	case "shadowsocks":
+		return strings.HasPrefix(in.ShadowsocksOptions.Method, "2022-")
+	}
+	return false
+}
+
+// rebuildInbound 用 next 配置创建新的 inbound 替换 current。
+// 新 inbound 启动失败时按 previous 配置恢复，返回值始终是应当保存在实例中的 inbound
+func (m *UserManager) rebuildInbound(current adapter.Inbound, previous option.Inbound, next option.Inbound) (adapter.Inbound, error) {
+	replacement, err := m.newInbound(next)
+	if err != nil {
+		return current, err
+	}
+	return m.swapInbound(current, replacement, previous)
+}
+
+// swapInbound 关闭 current 并启动已创建的 replacement。
+// replacement 启动失败时按 previous 配置恢复，返回值始终是应当保存在实例中的 inbound
+func (m *UserManager) swapInbound(current adapter.Inbound, replacement adapter.Inbound, previous option.Inbound) (adapter.Inbound, error) {
+	// 先关闭旧的监听，新 inbound 才能绑定相同端口
+	if err := current.Close(); err != nil {
+		log.Printf("关闭 inbound %s 时出错: %v", current.Tag(), err)
+	}
+	startErr := replacement.Start()
+	if startErr == nil {
+		return replacement, nil
+	}
+	replacement.Close()
+
+	restored, err := m.newInbound(previous)
+	if err == nil {
+		err = restored.Start()
+	}
+	if err != nil {
+		return current, fmt.Errorf("%v，恢复原配置也失败: %v", startErr, err)
+	}
+	return restored, startErr
+}
+
+// newInbound 使用实例的 router 和日志创建 inbound
+func (m *UserManager) newInbound(options option.Inbound) (adapter.Inbound, error) {
+	field, err := unexportedField(reflect.ValueOf(m.instance).Elem(), "logFactory")
+	if err != nil {
+		return nil, err
+	}
+	factory, ok := field.Interface().(sblog.Factory)
+	if !ok {
+		return nil, fmt.Errorf("unexpected log factory type %s", field.Type())
+	}
+
+	logger := factory.NewLogger(fmt.Sprintf("inbound/%s[%s]", options.Type, options.Tag))
+	return inbound.New(m.ctx, m.instance.Router(), logger, options, nil)
+}
+
+// checkSingBoxVersion 确认编译使用的 sing-box 与 singBoxVersion 一致，否则拒绝热更新
+func checkSingBoxVersion() error {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return nil
+	}
+	for _, dep := range info.Deps {
+		if dep.Path != "github.com/sagernet/sing-box" {
+			continue
+		}
+		version := dep.Version
+		if dep.Replace != nil {
+			version = dep.Replace.Version
+		}
+		if version != singBoxVersion {
+			return fmt.Errorf("用户热更新只支持 sing-box %s，当前为 %s", singBoxVersion, version)
+		}
+	}
+	return nil
+}
+
+// updateStatsUsers 更新 V2Ray 统计服务需要计数的用户名
+func (m *UserManager) updateStatsUsers(users []ProvisionedUser) error {
+	v2rayServer := m.instance.Router().V2RayServer()
+	if v2rayServer == nil || v2rayServer.StatsService() == nil {
+		return nil
+	}
+
+	stats := reflect.ValueOf(v2rayServer.StatsService())
+	if stats.Kind() != reflect.Pointer {
+		return fmt.Errorf("unexpected stats service type %T", v2rayServer.StatsService())
+	}
+	stats = stats.Elem()
+
+	names := make(map[string]bool, len(users)*len(userProtocols))
+	for _, user := range users {
+		for _, name := range StatsUserNames(user.EmailAsId) {
+			names[name] = true
+		}
+	}
+
+	access, err := unexportedField(stats, "access")
+	if err != nil {
+		return err
+	}
+	usersField, err := unexportedField(stats, "users")
+	if err != nil {
+		return err
+	}
+
+	mutex := (*sync.Mutex)(unsafe.Pointer(access.UnsafeAddr()))
+	mutex.Lock()
+	usersField.Set(reflect.ValueOf(names))
+	mutex.Unlock()
+
+	return nil
+}
+
+// StatsUserNames 返回用户在各 inbound 中使用的名称
+func StatsUserNames(email string) []string {
+	names := make([]string, 0, len(userProtocols))
+	for _, protocol := range userProtocols {
+		names = append(names, email+"-"+protocol)
+	}
+	return names
+}
+
+// boxInbounds 返回 box.Box 中已创建的 inbound 列表，修改其中的元素会替换实例中的 inbound
+func boxInbounds(instance *box.Box) ([]adapter.Inbound, error) {
+	field, err := unexportedField(reflect.ValueOf(instance).Elem(), "inbounds")
+	if err != nil {
+		return nil, err
+	}
+	inbounds, ok := field.Interface().([]adapter.Inbound)
+	if !ok {
+		return nil, fmt.Errorf("unexpected inbounds type %s", field.Type())
+	}
+	return inbounds, nil
+}
+
+// unexportedField 返回结构体中可读写的未导出字段。
+// sing-box 没有提供运行时修改用户的接口，这里依赖 singBoxVersion 版本的字段名。
+func unexportedField(v reflect.Value, name string) (reflect.Value, error) {
+	field := v.FieldByName(name)
+	if !field.IsValid() {
+		return reflect.Value{}, fmt.Errorf("%s has no field %q", v.Type(), name)
+	}
+	return reflect.NewAt(field.Type(), unsafe.Pointer(field.UnsafeAddr())).Elem(), nil
+}
@@ -20,6 +20,11 @@ const _ = grpc.SupportPackageIsVersion7
 type ManageV2RayUserBygRPCClient interface {
 	AddUser(ctx context.Context, in *GRPCRequest, opts ...grpc.CallOption) (*GRPCReply, error)
 	DeleteUser(ctx context.Context, in *GRPCRequest, opts ...grpc.CallOption) (*GRPCReply, error)
+	ListUsers(ctx context.Context, in *ListUsersRequest, opts ...grpc.CallOption) (*ListUsersReply, error)
+	QueryTraffic(ctx context.Context, in *TrafficRequest, opts ...grpc.CallOption) (*TrafficReply, error)
+	ReloadConfig(ctx context.Context, in *ReloadRequest, opts ...grpc.CallOption) (*ReloadReply, error)
+	ListConnections(ctx context.Context, in *ConnectionsRequest, opts ...grpc.CallOption) (*ConnectionsReply, error)
+	CloseConnection(ctx context.Context, in *CloseConnectionRequest, opts ...grpc.CallOption) (*GRPCReply, error)
 }
 
 type manageV2RayUserBygRPCClient struct {
@@ -48,12 +53,62 @@ func (c *manageV2RayUserBygRPCClient) DeleteUser(ctx context.Context, in *GRPCRe
 	return out, nil
 }
 
+func (c *manageV2RayUserBygRPCClient) ListUsers(ctx context.Context, in *ListUsersRequest, opts ...grpc.CallOption) (*ListUsersReply, error) {
+	out := new(ListUsersReply)
+	err := c.cc.Invoke(ctx, "/myproto.manageV2rayUserBygRPC/ListUsers", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *manageV2RayUserBygRPCClient) QueryTraffic(ctx context.Context, in *TrafficRequest, opts ...grpc.CallOption) (*TrafficReply, error) {
+	out := new(TrafficReply)
+	err := c.cc.Invoke(ctx, "/myproto.manageV2rayUserBygRPC/QueryTraffic", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *manageV2RayUserBygRPCClient) ReloadConfig(ctx context.Context, in *ReloadRequest, opts ...grpc.CallOption) (*ReloadReply, error) {
+	out := new(ReloadReply)
+	err := c.cc.Invoke(ctx, "/myproto.manageV2rayUserBygRPC/ReloadConfig", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *manageV2RayUserBygRPCClient) ListConnections(ctx context.Context, in *ConnectionsRequest, opts ...grpc.CallOption) (*ConnectionsReply, error) {
+	out := new(ConnectionsReply)
+	err := c.cc.Invoke(ctx, "/myproto.manageV2rayUserBygRPC/ListConnections", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *manageV2RayUserBygRPCClient) CloseConnection(ctx context.Context, in *CloseConnectionRequest, opts ...grpc.CallOption) (*GRPCReply, error) {
+	out := new(GRPCReply)
+	err := c.cc.Invoke(ctx, "/myproto.manageV2rayUserBygRPC/CloseConnection", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // ManageV2RayUserBygRPCServer is the server API for ManageV2RayUserBygRPC service.
 // All implementations must embed UnimplementedManageV2RayUserBygRPCServer
 // for forward compatibility
 type ManageV2RayUserBygRPCServer interface {
 	AddUser(context.Context, *GRPCRequest) (*GRPCReply, error)
 	DeleteUser(context.Context, *GRPCRequest) (*GRPCReply, error)
+	ListUsers(context.Context, *ListUsersRequest) (*ListUsersReply, error)
+	QueryTraffic(context.Context, *TrafficRequest) (*TrafficReply, error)
+	ReloadConfig(context.Context, *ReloadRequest) (*ReloadReply, error)
+	ListConnections(context.Context, *ConnectionsRequest) (*ConnectionsReply, error)
+	CloseConnection(context.Context, *CloseConnectionRequest) (*GRPCReply, error)
 	mustEmbedUnimplementedManageV2RayUserBygRPCServer()
 }
 
@@ -67,6 +122,21 @@ func (UnimplementedManageV2RayUserBygRPCServer) AddUser(context.Context, *GRPCRe
 func (UnimplementedManageV2RayUserBygRPCServer) DeleteUser(context.Context, *GRPCRequest) (*GRPCReply, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method DeleteUser not implemented")
 }
+func (UnimplementedManageV2RayUserBygRPCServer) ListUsers(context.Context, *ListUsersRequest) (*ListUsersReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListUsers not implemented")
+}
+func (UnimplementedManageV2RayUserBygRPCServer) QueryTraffic(context.Context, *TrafficRequest) (*TrafficReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method QueryTraffic not implemented")
+}
+func (UnimplementedManageV2RayUserBygRPCServer) ReloadConfig(context.Context, *ReloadRequest) (*ReloadReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ReloadConfig not implemented")
+}
+func (UnimplementedManageV2RayUserBygRPCServer) ListConnections(context.Context, *ConnectionsRequest) (*ConnectionsReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListConnections not implemented")
+}
+func (UnimplementedManageV2RayUserBygRPCServer) CloseConnection(context.Context, *CloseConnectionRequest) (*GRPCReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CloseConnection not implemented")
+}
 func (UnimplementedManageV2RayUserBygRPCServer) mustEmbedUnimplementedManageV2RayUserBygRPCServer() {}
 
 // UnsafeManageV2RayUserBygRPCServer may be embedded to opt out of forward compatibility for this service.
@@ -116,6 +186,96 @@ func _ManageV2RayUserBygRPC_DeleteUser_Handler(srv interface{}, ctx context.Cont
 	return interceptor(ctx, in, info, handler)
 }
 
+func _ManageV2RayUserBygRPC_ListUsers_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListUsersRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ManageV2RayUserBygRPCServer).ListUsers(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/myproto.manageV2rayUserBygRPC/ListUsers",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ManageV2RayUserBygRPCServer).ListUsers(ctx, req.(*ListUsersRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ManageV2RayUserBygRPC_QueryTraffic_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(TrafficRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ManageV2RayUserBygRPCServer).QueryTraffic(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/myproto.manageV2rayUserBygRPC/QueryTraffic",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ManageV2RayUserBygRPCServer).QueryTraffic(ctx, req.(*TrafficRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ManageV2RayUserBygRPC_ReloadConfig_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ReloadRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ManageV2RayUserBygRPCServer).ReloadConfig(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/myproto.manageV2rayUserBygRPC/ReloadConfig",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ManageV2RayUserBygRPCServer).ReloadConfig(ctx, req.(*ReloadRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ManageV2RayUserBygRPC_ListConnections_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ConnectionsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ManageV2RayUserBygRPCServer).ListConnections(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/myproto.manageV2rayUserBygRPC/ListConnections",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ManageV2RayUserBygRPCServer).ListConnections(ctx, req.(*ConnectionsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ManageV2RayUserBygRPC_CloseConnection_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CloseConnectionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ManageV2RayUserBygRPCServer).CloseConnection(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/myproto.manageV2rayUserBygRPC/CloseConnection",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ManageV2RayUserBygRPCServer).CloseConnection(ctx, req.(*CloseConnectionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // ManageV2RayUserBygRPC_ServiceDesc is the grpc.ServiceDesc for ManageV2RayUserBygRPC service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -131,6 +291,26 @@ var ManageV2RayUserBygRPC_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "DeleteUser",
 			Handler:    _ManageV2RayUserBygRPC_DeleteUser_Handler,
 		},
+		{
+			MethodName: "ListUsers",
+			Handler:    _ManageV2RayUserBygRPC_ListUsers_Handler,
+		},
+		{
+			MethodName: "QueryTraffic",
+			Handler:    _ManageV2RayUserBygRPC_QueryTraffic_Handler,
+		},
+		{
+			MethodName: "ReloadConfig",
+			Handler:    _ManageV2RayUserBygRPC_ReloadConfig_Handler,
+		},
+		{
+			MethodName: "ListConnections",
+			Handler:    _ManageV2RayUserBygRPC_ListConnections_Handler,
+		},
+		{
+			MethodName: "CloseConnection",
+			Handler:    _ManageV2RayUserBygRPC_CloseConnection_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "proto/myproto.proto",
@@ -0,0 +1,315 @@
+// Package render 根据声明式模板生成订阅配置。
+//
+// 模板说明每种节点类型输出哪些字段、加入哪些代理组，管理员修改模板文件即可调整订阅内容，
+// 无需修改代码或重新编译。
+package render
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	helper "github.com/xvv6u577/logv2fs/helpers"
+)
+
+// Template 声明式订阅模板
+type Template struct {
+	// 基础配置文件名，必须位于模板所在目录
+	Base string `json:"base"`
+	// 节点追加到基础配置中的哪个数组，例如 sing-box 的 outbounds
+	NodesKey string `json:"nodes_key"`
+	// 代理组所在的数组，默认与 NodesKey 相同
+	GroupsKey string `json:"groups_key,omitempty"`
+	// 代理组的名称字段和成员列表字段，例如 sing-box 的 tag 和 outbounds
+	GroupNameKey    string `json:"group_name_key"`
+	GroupMembersKey string `json:"group_members_key"`
+	// 每种节点类型的输出模板，字符串中的 ${变量} 会被替换
+	Outbounds map[string]map[string]interface{} `json:"outbounds"`
+	// 代理组规则，按顺序把节点名称加入代理组
+	Groups []GroupRule `json:"groups"`
+
+	dir string
+}
+
+// GroupRule 代理组规则：Types 为空表示所有节点类型，When 为节点需要满足的条件
+type GroupRule struct {
+	Tag   string   `json:"tag"`
+	Types []string `json:"types,omitempty"`
+	When  string   `json:"when,omitempty"`
+}
+
+// Node 渲染时使用的节点信息
+type Node struct {
+	Type         string
+	Remark       string
+	Server       string // 已格式化的地址，IPv6 带方括号
+	IP           string
+	Domain       string
+	Port         string
+	UUID         string
+	Path         string
+	SNI          string
+	Fingerprint  string
+	Insecure     bool // 是否跳过证书验证
+	PublicKey    string
+	ShortID      string
+	ServerKey    string // Shadowsocks 2022 服务端密钥
+	Region       string
+	EnableOpenai bool
+}
+
+// User 渲染时使用的用户凭据
+type User struct {
+	UUID     string
+	Password string
+}
+
+// nodeConditions GroupRule.When 支持的条件
+var nodeConditions = map[string]func(Node) bool{
+	"enable_openai": func(node Node) bool { return node.EnableOpenai },
+}
+
+var variablePattern = regexp.MustCompile(`\$\{(\w+)\}`)
+
+// Variables 返回模板中可用的变量，port 为整数，insecure 为布尔值，其余为字符串。
+// method 和 ss_password 为 Shadowsocks 2022 的加密方式和用户密码，节点没有服务端密钥时为空。
+func Variables(node Node, user User) map[string]interface{} {
+	port, _ := strconv.Atoi(node.Port)
+	method, ssPassword, _ := helper.SS2022Password(node.ServerKey, user.UUID)
+	return map[string]interface{}{
+		"remark":      node.Remark,
+		"type":        node.Type,
+		"server":      node.Server,
+		"ip":          node.IP,
+		"domain":      node.Domain,
+		"port":        port,
+		"node_uuid":   node.UUID,
+		"path":        node.Path,
+		"sni":         node.SNI,
+		"fingerprint": node.Fingerprint,
+		"insecure":    node.Insecure,
+		"public_key":  node.PublicKey,
+		"short_id":    node.ShortID,
+		"region":      node.Region,
+		"uuid":        user.UUID,
+		"password":    user.Password,
+		"method":      method,
+		"ss_password": ssPassword,
+	}
+}
+
+// LoadTemplate 读取并校验模板文件
+func LoadTemplate(path string) (*Template, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseTemplate(data, filepath.Dir(path))
+}
+
+// ParseTemplate 解析并校验模板，dir 为基础配置文件所在目录
+func ParseTemplate(data []byte, dir string) (*Template, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+
+	var t Template
+	if err := decoder.Decode(&t); err != nil {
+		return nil, fmt.Errorf("解析模板失败: %v", err)
+	}
+	t.dir = dir
+	if t.GroupsKey == "" {
+		t.GroupsKey = t.NodesKey
+	}
+
+	if err := t.validate(); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// validate 检查模板字段、代理组是否存在于基础配置中，并用示例节点试渲染
+func (t *Template) validate() error {
+	if t.Base == "" || t.NodesKey == "" || t.GroupNameKey == "" || t.GroupMembersKey == "" {
+		return fmt.Errorf("模板缺少 base、nodes_key、group_name_key 或 group_members_key")
+	}
+	if len(t.Outbounds) == 0 {
+		return fmt.Errorf("模板没有定义任何节点类型")
+	}
+	// base 只能是模板目录中的文件，不能通过路径读取目录外的文件
+	if filepath.Base(t.Base) != t.Base || t.Base == "." || t.Base == ".." {
+		return fmt.Errorf("模板的 base 必须是模板目录中的文件名: %s", t.Base)
+	}
+
+	doc, err := t.loadBase()
+	if err != nil {
+		return err
+	}
+
+	for _, rule := range t.Groups {
+		if _, err := t.findGroup(doc, rule.Tag); err != nil {
+			return err
+		}
+		for _, nodeType := range rule.Types {
+			if _, ok := t.Outbounds[nodeType]; !ok {
+				return fmt.Errorf("代理组 %s 引用了未定义的节点类型 %s", rule.Tag, nodeType)
+			}
+		}
+		if rule.When != "" {
+			if _, ok := nodeConditions[rule.When]; !ok {
+				return fmt.Errorf("代理组 %s 的条件 %s 不支持", rule.Tag, rule.When)
+			}
+		}
+	}
+
+	sample := Variables(Node{Port: "443"}, User{})
+	for nodeType, outbound := range t.Outbounds {
+		if _, err := substitute(outbound, sample); err != nil {
+			return fmt.Errorf("节点类型 %s: %v", nodeType, err)
+		}
+	}
+	return nil
+}
+
+// loadBase 读取基础配置
+func (t *Template) loadBase() (map[string]interface{}, error) {
+	data, err := os.ReadFile(filepath.Join(t.dir, t.Base))
+	if err != nil {
+		return nil, err
+	}
+
+	var doc map[string]interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("解析基础配置 %s 失败: %v", t.Base, err)
+	}
+	for _, key := range []string{t.NodesKey, t.GroupsKey} {
+		if _, ok := doc[key].([]interface{}); !ok {
+			return nil, fmt.Errorf("基础配置 %s 中没有数组 %s", t.Base, key)
+		}
+	}
+	return doc, nil
+}
+
+// findGroup 在基础配置中查找代理组
+func (t *Template) findGroup(doc map[string]interface{}, tag string) (map[string]interface{}, error) {
+	for _, item := range doc[t.GroupsKey].([]interface{}) {
+		if group, ok := item.(map[string]interface{}); ok && group[t.GroupNameKey] == tag {
+			return group, nil
+		}
+	}
+	return nil, fmt.Errorf("基础配置中没有代理组 %s", tag)
+}
+
+// matches 判断节点是否加入该代理组
+func (rule GroupRule) matches(node Node) bool {
+	if len(rule.Types) > 0 {
+		found := false
+		for _, nodeType := range rule.Types {
+			if nodeType == node.Type {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if rule.When != "" {
+		return nodeConditions[rule.When](node)
+	}
+	return true
+}
+
+// Render 生成配置：按节点类型渲染节点并追加到 NodesKey，再按规则把节点名称加入代理组。
+// 模板中没有定义的节点类型会被跳过。
+func (t *Template) Render(nodes []Node, user User) (map[string]interface{}, error) {
+	doc, err := t.loadBase()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, node := range nodes {
+		outbound, ok := t.Outbounds[node.Type]
+		if !ok {
+			continue
+		}
+
+		rendered, err := substitute(outbound, Variables(node, user))
+		if err != nil {
+			return nil, fmt.Errorf("节点 %s: %v", node.Remark, err)
+		}
+		// 节点和代理组可能在同一个数组中，每次都重新读取
+		doc[t.NodesKey] = append(doc[t.NodesKey].([]interface{}), rendered)
+
+		for _, rule := range t.Groups {
+			if !rule.matches(node) {
+				continue
+			}
+			group, err := t.findGroup(doc, rule.Tag)
+			if err != nil {
+				return nil, err
+			}
+			members, _ := group[t.GroupMembersKey].([]interface{})
+			group[t.GroupMembersKey] = append(members, node.Remark)
+		}
+	}
+
+	return doc, nil
+}
+
+// substitute 递归替换 ${变量}。整个字符串只有一个变量时保留变量类型，例如端口输出为整数。
+func substitute(value interface{}, vars map[string]interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			rendered, err := substitute(item, vars)
+			if err != nil {
+				return nil, err
+			}
+			result[key] = rendered
+		}
+		return result, nil
+
+	case []interface{}:
+		result := make([]interface{}, len(v))
+		for i, item := range v {
+			rendered, err := substitute(item, vars)
+			if err != nil {
+				return nil, err
+			}
+			result[i] = rendered
+		}
+		return result, nil
+
+	case string:
+		if match := variablePattern.FindStringSubmatch(v); match != nil && match[0] == v {
+			variable, ok := vars[match[1]]
+			if !ok {
+				return nil, fmt.Errorf("未知变量 %s", match[0])
+			}
+			return variable, nil
+		}
+
+		var unknown string
+		result := variablePattern.ReplaceAllStringFunc(v, func(placeholder string) string {
+			name := strings.TrimSuffix(strings.TrimPrefix(placeholder, "${"), "}")
+			variable, ok := vars[name]
+			if !ok {
+				unknown = placeholder
+				return placeholder
+			}
+			return fmt.Sprint(variable)
+		})
+		if unknown != "" {
+			return nil, fmt.Errorf("未知变量 %s", unknown)
+		}
+		return result, nil
+	}
+
+	return value, nil
+}
@@ -30,11 +30,47 @@ func AuthorizedRoutes(incomingRoutes *gin.Engine) {
 		incomingRoutes.GET("/v1/deluser/:name", controller.DeleteUserByUserNamePG())
 		incomingRoutes.PUT("/v1/disableuser/:name", controller.DisableUserPG())
 		incomingRoutes.PUT("/v1/enableuser/:name", controller.EnableUserPG())
+		incomingRoutes.PUT("/v1/credit/:name", controller.UpdateUserCreditPG())
+		incomingRoutes.PUT("/v1/subscription-token/:name", controller.RotateSubscriptionTokenPG())
+		incomingRoutes.POST("/v1/telegram-link/:name", controller.CreateTelegramLinkCodePG())
+		incomingRoutes.DELETE("/v1/telegram-link/:name", controller.UnlinkTelegramPG())
+		incomingRoutes.GET("/v1/traffic/user/:name", controller.GetUserTrafficRangePG())
+		incomingRoutes.GET("/v1/traffic/node/:domain", controller.GetNodeTrafficRangePG())
 		incomingRoutes.PUT("/v1/759b0v", controller.AddNodePG())
+		incomingRoutes.POST("/v1/reality-key/:remark", controller.GenerateRealityKeyPG())
+		incomingRoutes.POST("/v1/reality-key/:remark/activate", controller.ActivateRealityKeyPG())
 		incomingRoutes.GET("/v1/681p32", controller.GetDomainsExpiryInfoPG())
 		incomingRoutes.PUT("/v1/g7302b", controller.UpdateExpiryCheckDomainsInfoPG())
 		incomingRoutes.GET("/v1/c47kr8", controller.GetSingboxNodesPG())
 		incomingRoutes.GET("/v1/t7k033", controller.GetActiveGlobalNodesPG())
+		incomingRoutes.GET("/v1/node-users", controller.GetNodeUserSetsPG())
+		incomingRoutes.GET("/v1/node-status", controller.GetNodeStatusPG())
+		incomingRoutes.GET("/v1/node-agent/users", controller.CallNodeAgentsPG("users"))
+		incomingRoutes.GET("/v1/node-agent/traffic", controller.CallNodeAgentsPG("traffic"))
+		incomingRoutes.POST("/v1/node-agent/reload", controller.CallNodeAgentsPG("reload"))
+		incomingRoutes.GET("/v1/node-agent/connections", controller.CallNodeAgentsPG("connections"))
+		incomingRoutes.DELETE("/v1/node-agent/connections/:domain/:id", controller.CloseNodeConnectionPG())
+		incomingRoutes.GET("/v1/node-agent/connection-ips", controller.GetConnectionIPsPG())
+		incomingRoutes.GET("/v1/render-template/:format", controller.GetRenderTemplate())
+		incomingRoutes.PUT("/v1/render-template/:format", controller.UpdateRenderTemplate())
+
+		// 节点列表增删改、排序、历史版本和健康状态 - PostgreSQL版本
+		incomingRoutes.GET("/v1/nodes", controller.ListNodesPG())
+		incomingRoutes.POST("/v1/nodes", controller.CreateNodePG())
+		incomingRoutes.PUT("/v1/nodes/:id", controller.UpdateNodePG())
+		incomingRoutes.DELETE("/v1/nodes/:id", controller.DeleteNodePG())
+		incomingRoutes.PUT("/v1/node-order", controller.ReorderNodesPG())
+		incomingRoutes.GET("/v1/node-versions", controller.GetNodeVersionsPG())
+		incomingRoutes.GET("/v1/node-versions/:version", controller.GetNodeVersionPG())
+		incomingRoutes.POST("/v1/node-versions/:version/rollback", controller.RollbackNodeVersionPG())
+		incomingRoutes.GET("/v1/node-health", controller.GetNodeHealthPG())
+		incomingRoutes.GET("/v1/node-health/:id", controller.GetNodeHealthHistoryPG())
+
+		// 节点套餐相关路由 - PostgreSQL版本
+		incomingRoutes.GET("/v1/node-plans", controller.GetNodePlansPG())
+		incomingRoutes.PUT("/v1/node-plans", controller.SaveNodePlanPG())
+		incomingRoutes.DELETE("/v1/node-plans/:name", controller.DeleteNodePlanPG())
+		incomingRoutes.PUT("/v1/user-plan/:name", controller.SetUserPlanPG())
 
 		// 自定义日期管理相关路由 - PostgreSQL版本
 		incomingRoutes.PUT("/v1/custom-date", controller.SaveCustomDatePG())
@@ -56,11 +92,47 @@ func AuthorizedRoutes(incomingRoutes *gin.Engine) {
 		incomingRoutes.GET("/v1/deluser/:name", controller.DeleteUserByUserName())
 		incomingRoutes.PUT("/v1/disableuser/:name", controller.DisableUser())
 		incomingRoutes.PUT("/v1/enableuser/:name", controller.EnableUser())
+		incomingRoutes.PUT("/v1/credit/:name", controller.UpdateUserCredit())
+		incomingRoutes.PUT("/v1/subscription-token/:name", controller.RotateSubscriptionToken())
+		incomingRoutes.POST("/v1/telegram-link/:name", controller.CreateTelegramLinkCode())
+		incomingRoutes.DELETE("/v1/telegram-link/:name", controller.UnlinkTelegram())
+		incomingRoutes.GET("/v1/traffic/user/:name", controller.GetUserTrafficRange())
+		incomingRoutes.GET("/v1/traffic/node/:domain", controller.GetNodeTrafficRange())
 		incomingRoutes.PUT("/v1/759b0v", controller.AddNode())
+		incomingRoutes.POST("/v1/reality-key/:remark", controller.GenerateRealityKey())
+		incomingRoutes.POST("/v1/reality-key/:remark/activate", controller.ActivateRealityKey())
 		incomingRoutes.GET("/v1/681p32", controller.GetDomainsExpiryInfo())
 		incomingRoutes.PUT("/v1/g7302b", controller.UpdateExpiryCheckDomainsInfo())
 		incomingRoutes.GET("/v1/c47kr8", controller.GetSingboxNodes())
 		incomingRoutes.GET("/v1/t7k033", controller.GetActiveGlobalNodes())
+		incomingRoutes.GET("/v1/node-users", controller.GetNodeUserSets())
+		incomingRoutes.GET("/v1/node-status", controller.GetNodeStatus())
+		incomingRoutes.GET("/v1/node-agent/users", controller.CallNodeAgents("users"))
+		incomingRoutes.GET("/v1/node-agent/traffic", controller.CallNodeAgents("traffic"))
+		incomingRoutes.POST("/v1/node-agent/reload", controller.CallNodeAgents("reload"))
+		incomingRoutes.GET("/v1/node-agent/connections", controller.CallNodeAgents("connections"))
+		incomingRoutes.DELETE("/v1/node-agent/connections/:domain/:id", controller.CloseNodeConnection())
+		incomingRoutes.GET("/v1/node-agent/connection-ips", controller.GetConnectionIPs())
+		incomingRoutes.GET("/v1/render-template/:format", controller.GetRenderTemplate())
+		incomingRoutes.PUT("/v1/render-template/:format", controller.UpdateRenderTemplate())
+
+		// 节点列表增删改、排序、历史版本和健康状态 - MongoDB版本
+		incomingRoutes.GET("/v1/nodes", controller.ListNodes())
+		incomingRoutes.POST("/v1/nodes", controller.CreateNode())
+		incomingRoutes.PUT("/v1/nodes/:id", controller.UpdateNode())
+		incomingRoutes.DELETE("/v1/nodes/:id", controller.DeleteNode())
+		incomingRoutes.PUT("/v1/node-order", controller.ReorderNodes())
+		incomingRoutes.GET("/v1/node-versions", controller.GetNodeVersions())
+		incomingRoutes.GET("/v1/node-versions/:version", controller.GetNodeVersion())
+		incomingRoutes.POST("/v1/node-versions/:version/rollback", controller.RollbackNodeVersion())
+		incomingRoutes.GET("/v1/node-health", controller.GetNodeHealth())
+		incomingRoutes.GET("/v1/node-health/:id", controller.GetNodeHealthHistory())
+
+		// 节点套餐相关路由 - MongoDB版本
+		incomingRoutes.GET("/v1/node-plans", controller.GetNodePlans())
+		incomingRoutes.PUT("/v1/node-plans", controller.SaveNodePlan())
+		incomingRoutes.DELETE("/v1/node-plans/:name", controller.DeleteNodePlan())
+		incomingRoutes.PUT("/v1/user-plan/:name", controller.SetUserPlan())
 
 		// 自定义日期管理相关路由 - MongoDB版本
 		incomingRoutes.PUT("/v1/custom-date", controller.SaveCustomDate())
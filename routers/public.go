@@ -32,11 +32,15 @@ func PublicRoutes(incomingRoutes *gin.Engine) {
 	}
 
 	// 根据环境变量决定使用PostgreSQL还是MongoDB版本的控制器
+	// 订阅路由中的 :name 为订阅令牌，只有旧版 /static、/singbox、/verge 在 LEGACY_SUBSCRIPTION_UNTIL 之前仍接受邮箱
 	if database.IsUsingPostgres() {
 		// PostgreSQL版本的路由
 		// login
 		incomingRoutes.POST("/v1/login", controller.LoginPG())
 
+		// 统一订阅入口，根据 User-Agent 或 ?format= 选择格式
+		incomingRoutes.GET("/sub/:name", controller.ReturnSubscriptionPG())
+
 		// shadowrocket config
 		incomingRoutes.GET("/static/:name", controller.GetSubscripionURLPG())
 
@@ -45,11 +49,22 @@ func PublicRoutes(incomingRoutes *gin.Engine) {
 
 		// verge config
 		incomingRoutes.GET("/verge/:name", controller.ReturnVergeYAMLPG())
+
+		// clash meta (mihomo) config
+		incomingRoutes.GET("/clash/:name", controller.ReturnClashMetaYAMLPG())
+
+		// surge, quantumult x and loon config
+		incomingRoutes.GET("/surge/:name", controller.ReturnSurgeConfigPG())
+		incomingRoutes.GET("/quanx/:name", controller.ReturnQuantumultXConfigPG())
+		incomingRoutes.GET("/loon/:name", controller.ReturnLoonConfigPG())
 	} else {
 		// MongoDB版本的路由
 		// login
 		incomingRoutes.POST("/v1/login", controller.Login())
 
+		// 统一订阅入口，根据 User-Agent 或 ?format= 选择格式
+		incomingRoutes.GET("/sub/:name", controller.ReturnSubscription())
+
 		// shadowrocket config
 		incomingRoutes.GET("/static/:name", controller.GetSubscripionURL())
 
@@ -58,5 +73,13 @@ func PublicRoutes(incomingRoutes *gin.Engine) {
 
 		// verge config
 		incomingRoutes.GET("/verge/:name", controller.ReturnVergeYAML())
+
+		// clash meta (mihomo) config
+		incomingRoutes.GET("/clash/:name", controller.ReturnClashMetaYAML())
+
+		// surge, quantumult x and loon config
+		incomingRoutes.GET("/surge/:name", controller.ReturnSurgeConfig())
+		incomingRoutes.GET("/quanx/:name", controller.ReturnQuantumultXConfig())
+		incomingRoutes.GET("/loon/:name", controller.ReturnLoonConfig())
 	}
 }
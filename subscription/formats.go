@@ -0,0 +1,218 @@
+// Package subscription 生成 Surge、Quantumult X、Loon 和 v2rayN 等文本格式的订阅内容。
+//
+// 这里只根据节点和用户信息拼接文本，不访问数据库，也不读取模板文件。
+package subscription
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"regexp"
+	"strings"
+
+	helper "github.com/xvv6u577/logv2fs/helpers"
+	"github.com/xvv6u577/logv2fs/model"
+)
+
+// 各客户端共用的代理组名称
+const (
+	SelectGroup  = "节点选择"
+	URLTestGroup = "自动选择"
+)
+
+// 各客户端支持的节点类型，不支持的节点在生成时跳过：
+// Surge 不支持 VLESS；Quantumult X 只支持 ws/tls 的 VLESS、Trojan 和 Shadowsocks；Loon 不支持 TUIC
+var (
+	surgeNodeTypes       = []string{"hysteria2", "tuic", "trojan", "ss2022"}
+	quantumultXNodeTypes = []string{"vlessCDN", "trojan", "ss2022"}
+	loonNodeTypes        = []string{"reality", "hysteria2", "vlessCDN", "trojan", "ss2022"}
+)
+
+// surgeRuleSection 匹配 Surge 模板中的 [Rule] 段落标题
+var surgeRuleSection = regexp.MustCompile(`(?m)^\[Rule\]`)
+
+// proxyName 去掉节点备注中会破坏行格式的字符
+func proxyName(remark string) string {
+	return strings.NewReplacer(",", " ", "=", " ", "\n", " ").Replace(strings.TrimSpace(remark))
+}
+
+// supportedNodes 按客户端支持的类型过滤节点
+func supportedNodes(nodes []model.SubscriptionNode, types []string) []model.SubscriptionNode {
+	var result []model.SubscriptionNode
+	for _, node := range nodes {
+		for _, t := range types {
+			if node.Type == t {
+				result = append(result, node)
+				break
+			}
+		}
+	}
+	return result
+}
+
+// insecureFlag 返回链接参数中的跳过证书验证标志
+func insecureFlag(node model.SubscriptionNode) string {
+	if node.SkipCertVerify() {
+		return "1"
+	}
+	return "0"
+}
+
+// SS2022Credentials 由节点的服务端密钥和用户 UUID 生成 Shadowsocks 2022 的加密方式和密码，密钥无效时返回 false
+func SS2022Credentials(node model.SubscriptionNode, uuid string) (string, string, bool) {
+	method, password, err := helper.SS2022Password(node.PASSWORD, uuid)
+	if err != nil {
+		log.Printf("节点 %s 的 Shadowsocks 2022 密钥无效: %v", node.Remark, err)
+		return "", "", false
+	}
+	return method, password, true
+}
+
+// surgeProxyLine 生成 Surge 的 [Proxy] 行，无法生成时返回 false
+func surgeProxyLine(node model.SubscriptionNode, uuid, userID string) (string, bool) {
+	name := proxyName(node.Remark)
+	switch node.Type {
+	case "tuic":
+		return fmt.Sprintf("%s = tuic-v5, %s, %s, password=%s, uuid=%s, sni=%s, skip-cert-verify=%t, alpn=h3",
+			name, node.IP, node.SERVER_PORT, userID, uuid, node.TLSServerName(), node.SkipCertVerify()), true
+	case "trojan":
+		return fmt.Sprintf("%s = trojan, %s, %s, password=%s, sni=%s",
+			name, node.IP, node.SERVER_PORT, userID, node.TLSServerName()), true
+	case "ss2022":
+		method, password, ok := SS2022Credentials(node, uuid)
+		if !ok {
+			return "", false
+		}
+		return fmt.Sprintf("%s = ss, %s, %s, encrypt-method=%s, password=%s, udp-relay=true",
+			name, node.IP, node.SERVER_PORT, method, password), true
+	}
+	return fmt.Sprintf("%s = hysteria2, %s, %s, password=%s, sni=%s, skip-cert-verify=%t",
+		name, node.IP, node.SERVER_PORT, userID, node.TLSServerName(), node.SkipCertVerify()), true
+}
+
+// SurgeProfile 生成 Surge 托管配置：模板中的 [General] 和 [Rule] 加上生成的 [Proxy] 和 [Proxy Group]。
+// managedURL 为托管配置的更新链接，interval 为更新间隔（秒）
+func SurgeProfile(template, managedURL string, interval int, nodes []model.SubscriptionNode, uuid, userID string) string {
+	var proxies, names []string
+	for _, node := range supportedNodes(nodes, surgeNodeTypes) {
+		proxy, ok := surgeProxyLine(node, uuid, userID)
+		if !ok {
+			continue
+		}
+		proxies = append(proxies, proxy)
+		names = append(names, proxyName(node.Remark))
+	}
+
+	var builder strings.Builder
+	builder.WriteString("[Proxy]\n")
+	for _, proxy := range proxies {
+		builder.WriteString(proxy + "\n")
+	}
+
+	builder.WriteString("\n[Proxy Group]\n")
+	if len(names) > 0 {
+		builder.WriteString(SelectGroup + " = select, " + URLTestGroup + ", " + strings.Join(names, ", ") + ", DIRECT\n")
+		builder.WriteString(URLTestGroup + " = url-test, " + strings.Join(names, ", ") + ", url=http://www.gstatic.com/generate_204, interval=300, tolerance=50\n")
+	} else {
+		builder.WriteString(SelectGroup + " = select, DIRECT\n")
+	}
+	builder.WriteString("\n")
+
+	// 只匹配行首的 [Rule] 段落标题，模板注释中提到的 [Rule] 不算
+	profile := surgeRuleSection.ReplaceAllLiteralString(template, builder.String()+"[Rule]")
+	managed := fmt.Sprintf("#!MANAGED-CONFIG %s interval=%d strict=false\n\n", managedURL, interval)
+	return managed + profile
+}
+
+// quantumultXServerLine 生成 Quantumult X 的 server 行，无法生成时返回 false
+func quantumultXServerLine(node model.SubscriptionNode, uuid, userID string) (string, bool) {
+	name := proxyName(node.Remark)
+	switch node.Type {
+	case "trojan":
+		return fmt.Sprintf("trojan=%s:%s, password=%s, over-tls=true, tls-host=%s, tls-verification=true, fast-open=false, udp-relay=true, tag=%s",
+			helper.FormatIPForURL(node.IP), node.SERVER_PORT, userID, node.TLSServerName(), name), true
+	case "ss2022":
+		method, password, ok := SS2022Credentials(node, uuid)
+		if !ok {
+			return "", false
+		}
+		return fmt.Sprintf("shadowsocks=%s:%s, method=%s, password=%s, fast-open=false, udp-relay=true, tag=%s",
+			helper.FormatIPForURL(node.IP), node.SERVER_PORT, method, password, name), true
+	}
+	return fmt.Sprintf("vless=%s:%s, method=none, password=%s, obfs=wss, obfs-host=%s, obfs-uri=%s, tls-verification=true, fast-open=false, udp-relay=false, tag=%s",
+		helper.FormatIPForURL(node.IP), node.SERVER_PORT, node.UUID, node.Domain, node.PATH, name), true
+}
+
+// QuantumultXServers 生成 Quantumult X 的节点资源 (server_remote)
+func QuantumultXServers(nodes []model.SubscriptionNode, uuid, userID string) string {
+	var lines []string
+	for _, node := range supportedNodes(nodes, quantumultXNodeTypes) {
+		if line, ok := quantumultXServerLine(node, uuid, userID); ok {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// loonProxyLine 生成 Loon 的节点行，无法生成时返回 false
+func loonProxyLine(node model.SubscriptionNode, uuid, userID string) (string, bool) {
+	name := proxyName(node.Remark)
+	switch node.Type {
+	case "reality":
+		return fmt.Sprintf(`%s = VLESS,%s,%s,"%s",transport=tcp,flow=xtls-rprx-vision,public-key="%s",short-id=%s,udp=true,over-tls=true,sni=%s`,
+			name, node.IP, node.SERVER_PORT, uuid, node.PUBLIC_KEY, node.ClientShortID(), node.TLSServerName()), true
+	case "hysteria2":
+		return fmt.Sprintf(`%s = Hysteria2,%s,%s,"%s",sni=%s,skip-cert-verify=%t,udp=true`,
+			name, node.IP, node.SERVER_PORT, userID, node.TLSServerName(), node.SkipCertVerify()), true
+	case "trojan":
+		return fmt.Sprintf(`%s = trojan,%s,%s,"%s",over-tls=true,sni=%s,skip-cert-verify=false,udp=true`,
+			name, node.IP, node.SERVER_PORT, userID, node.TLSServerName()), true
+	case "ss2022":
+		method, password, ok := SS2022Credentials(node, uuid)
+		if !ok {
+			return "", false
+		}
+		return fmt.Sprintf(`%s = Shadowsocks,%s,%s,%s,"%s",udp=true`,
+			name, node.IP, node.SERVER_PORT, method, password), true
+	}
+	return fmt.Sprintf(`%s = VLESS,%s,%s,"%s",transport=ws,path=%s,host=%s,over-tls=true,sni=%s,skip-cert-verify=false,udp=false`,
+		name, node.IP, node.SERVER_PORT, node.UUID, node.PATH, node.Domain, node.Domain), true
+}
+
+// LoonNodes 生成 Loon 的节点订阅
+func LoonNodes(nodes []model.SubscriptionNode, uuid, userID string) string {
+	var lines []string
+	for _, node := range supportedNodes(nodes, loonNodeTypes) {
+		if line, ok := loonProxyLine(node, uuid, userID); ok {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// Base64Link 生成 v2rayN / Shadowrocket 使用的节点链接，不支持的类型返回 false
+func Base64Link(node model.SubscriptionNode, uuid, userID string) (string, bool) {
+	// 格式化IP地址以支持IPv6
+	formattedIP := helper.FormatIPForURL(node.IP)
+
+	switch node.Type {
+	case "reality":
+		return "vless://" + uuid + "@" + formattedIP + ":" + node.SERVER_PORT + "?encryption=none&flow=xtls-rprx-vision&security=reality&sni=" + node.TLSServerName() + "&fp=" + node.ClientFingerprint() + "&pbk=" + node.PUBLIC_KEY + "&sid=" + node.ClientShortID() + "&type=tcp&headerType=none#" + node.Remark, true
+	case "hysteria2":
+		return "hysteria2://" + userID + "@" + formattedIP + ":" + node.SERVER_PORT + "?insecure=" + insecureFlag(node) + "&sni=" + node.TLSServerName() + "#" + node.Remark, true
+	case "vlessCDN":
+		return "vless://" + node.UUID + "@" + formattedIP + ":" + node.SERVER_PORT + "?encryption=none&security=tls&sni=" + node.Domain + "&fp=randomized&type=ws&host=" + node.Domain + "&path=%2F%3Fed%3D2048#" + node.Remark, true
+	case "tuic":
+		return "tuic://" + uuid + ":" + url.QueryEscape(userID) + "@" + formattedIP + ":" + node.SERVER_PORT + "?congestion_control=bbr&alpn=h3&udp_relay_mode=native&allow_insecure=" + insecureFlag(node) + "&sni=" + node.TLSServerName() + "#" + node.Remark, true
+	case "trojan":
+		return "trojan://" + url.QueryEscape(userID) + "@" + formattedIP + ":" + node.SERVER_PORT + "?security=tls&type=tcp&sni=" + node.TLSServerName() + "#" + node.Remark, true
+	case "ss2022":
+		// SIP002：Shadowsocks 2022 的 userinfo 使用百分号编码而不是 base64
+		method, password, ok := SS2022Credentials(node, uuid)
+		if !ok {
+			return "", false
+		}
+		return "ss://" + url.QueryEscape(method+":"+password) + "@" + formattedIP + ":" + node.SERVER_PORT + "#" + node.Remark, true
+	}
+	return "", false
+}
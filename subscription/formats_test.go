@@ -0,0 +1,122 @@
+package subscription
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/xvv6u577/logv2fs/model"
+)
+
+var update = flag.Bool("update", false, "用当前输出更新 testdata 中的 golden 文件")
+
+const (
+	testUUID   = "0b9f3b5c-7c1e-4f7a-8f2d-6a3e9c1d2b4f"
+	testUserID = "65a1b2c3d4e5f60718293a4b"
+)
+
+// testNodes 覆盖所有节点类型，其中 hysteria2 和 trojan 使用 IPv6 地址，
+// 最后一个 Shadowsocks 2022 节点的密钥无效，所有客户端都应跳过
+var testNodes = []model.SubscriptionNode{
+	{Type: "reality", Remark: "JP-Reality", Domain: "jp.example.com", IP: "203.0.113.10", SERVER_PORT: "443",
+		PUBLIC_KEY: "jNXHt1yRo0vDuchQlIP6Z0ZvjT3KtzVI-T4E7RoLJS0", SHORT_ID: "0123456789abcdef,fedcba98"},
+	{Type: "hysteria2", Remark: "JP-Hy2-v6", Domain: "jp.example.com", IP: "2001:db8::10", SERVER_PORT: "8443"},
+	{Type: "tuic", Remark: "JP-TUIC", Domain: "jp.example.com", IP: "203.0.113.10", SERVER_PORT: "9443", VerifyCert: true, SNI: "jp.example.com"},
+	{Type: "vlessCDN", Remark: "US-CDN", Domain: "cdn.example.com", IP: "198.51.100.20", SERVER_PORT: "443",
+		UUID: "5f0c1c5e-3b6a-4a57-9d3e-2f4c8b1a7e90", PATH: "/ws?ed=2048"},
+	{Type: "trojan", Remark: "HK-Trojan-v6", Domain: "hk.example.com", IP: "2001:db8::20", SERVER_PORT: "443", SNI: "hk.example.com"},
+	{Type: "ss2022", Remark: "SG-SS", Domain: "sg.example.com", IP: "192.0.2.30", SERVER_PORT: "8388",
+		PASSWORD: "MTIzNDU2Nzg5MDEyMzQ1Ng=="},
+	{Type: "ss2022", Remark: "SG-SS-BadKey", Domain: "sg.example.com", IP: "192.0.2.31", SERVER_PORT: "8388",
+		PASSWORD: "not-a-key"},
+}
+
+func TestFormats(t *testing.T) {
+	// 使用仓库中实际的 Surge 模板
+	template, err := os.ReadFile("../config/template_surge.conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		got     string
+		golden  string
+		skipped []string
+	}{
+		{"surge", SurgeProfile(string(template), "http://sub.example.com/surge/token", 86400, testNodes, testUUID, testUserID),
+			"surge.golden", []string{"JP-Reality", "US-CDN", "SG-SS-BadKey"}},
+		{"quantumultx", QuantumultXServers(testNodes, testUUID, testUserID),
+			"quantumultx.golden", []string{"JP-Reality", "JP-Hy2-v6", "JP-TUIC", "SG-SS-BadKey"}},
+		{"loon", LoonNodes(testNodes, testUUID, testUserID),
+			"loon.golden", []string{"JP-TUIC", "SG-SS-BadKey"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, remark := range tt.skipped {
+				if strings.Contains(tt.got, remark) {
+					t.Errorf("不支持的节点 %s 出现在输出中", remark)
+				}
+			}
+
+			path := filepath.Join("testdata", tt.golden)
+			if *update {
+				if err := os.WriteFile(path, []byte(tt.got), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("读取 golden 文件: %v", err)
+			}
+			if tt.got != string(want) {
+				t.Errorf("输出与 %s 不一致\n--- got ---\n%s\n--- want ---\n%s", tt.golden, tt.got, want)
+			}
+		})
+	}
+}
+
+func TestFormatsEmpty(t *testing.T) {
+	for name, got := range map[string]string{
+		"quantumultx": QuantumultXServers(nil, testUUID, testUserID),
+		"loon":        LoonNodes(nil, testUUID, testUserID),
+	} {
+		if got != "\n" {
+			t.Errorf("%s: 没有节点时应输出空行，实际为 %q", name, got)
+		}
+	}
+}
+
+func TestProxyLines(t *testing.T) {
+	node := model.SubscriptionNode{Type: "trojan", Remark: " HK,01=a ", IP: "2001:db8::20", SERVER_PORT: "443", SNI: "hk.example.com"}
+
+	if got, want := proxyName(node.Remark), "HK 01 a"; got != want {
+		t.Errorf("proxyName = %q, want %q", got, want)
+	}
+
+	line, ok := surgeProxyLine(node, testUUID, testUserID)
+	if want := "HK 01 a = trojan, 2001:db8::20, 443, password=" + testUserID + ", sni=hk.example.com"; !ok || line != want {
+		t.Errorf("surgeProxyLine = %q, %v, want %q", line, ok, want)
+	}
+
+	// Quantumult X 的地址需要给 IPv6 加方括号
+	line, ok = quantumultXServerLine(node, testUUID, testUserID)
+	if !ok || !strings.HasPrefix(line, "trojan=[2001:db8::20]:443,") {
+		t.Errorf("quantumultXServerLine = %q, %v", line, ok)
+	}
+
+	bad := model.SubscriptionNode{Type: "ss2022", Remark: "bad", PASSWORD: "not-a-key"}
+	for name, build := range map[string]func(model.SubscriptionNode, string, string) (string, bool){
+		"surge":       surgeProxyLine,
+		"quantumultx": quantumultXServerLine,
+		"loon":        loonProxyLine,
+		"base64":      Base64Link,
+	} {
+		if line, ok := build(bad, testUUID, testUserID); ok {
+			t.Errorf("%s: 密钥无效的 Shadowsocks 2022 节点应跳过，实际为 %q", name, line)
+		}
+	}
+}